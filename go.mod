@@ -4,8 +4,10 @@ go 1.18
 
 require (
 	github.com/davecgh/go-spew v1.1.1
+	github.com/dvonthenen/websocket v1.5.1-dyv.2
 	github.com/gin-gonic/gin v1.9.0
-	github.com/sashabaranov/go-openai v1.7.0
+	github.com/sashabaranov/go-openai v1.14.2
+	go.etcd.io/bbolt v1.3.7
 	k8s.io/klog/v2 v2.90.1
 )
 
@@ -13,7 +15,6 @@ require (
 	github.com/bytedance/sonic v1.8.0 // indirect
 	github.com/chenzhuoyu/base64x v0.0.0-20221115062448-fe3a3abad311 // indirect
 	github.com/dvonthenen/symbl-go-sdk v0.1.8-0.20230407174106-4c0dae34c643 // indirect
-	github.com/dvonthenen/websocket v1.5.1-dyv.2 // indirect
 	github.com/fatih/color v1.13.0 // indirect
 	github.com/gin-contrib/sse v0.1.0 // indirect
 	github.com/go-logr/logr v1.2.0 // indirect
@@ -0,0 +1,63 @@
+// Copyright 2023 dvonthenen ChatGPT Proxy contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache License 2.0
+
+package audio
+
+import (
+	"errors"
+	"time"
+)
+
+const (
+	// DefaultMaxUploadBytes is the largest file OpenAI accepts for transcription
+	DefaultMaxUploadBytes int64 = 25 * 1024 * 1024
+
+	DefaultMaxChunkDuration time.Duration = 10 * time.Minute
+	DefaultSearchWindow     time.Duration = 30 * time.Second
+	DefaultMinSilence       time.Duration = 300 * time.Millisecond
+	DefaultSilenceThreshold float64       = 0.01
+	DefaultConcurrency      int           = 4
+
+	// raw PCM input has no header, these describe it unless overridden
+	DefaultPCMSampleRate    int = 16000
+	DefaultPCMChannels      int = 1
+	DefaultPCMBitsPerSample int = 16
+
+	// energy is measured over frames of this length
+	frameDuration time.Duration = 20 * time.Millisecond
+
+	// room left for the multipart envelope and the WAV header
+	uploadHeadroom int64 = 64 * 1024
+
+	// WAV encodings
+	formatPCM        uint16 = 1
+	formatFloat      uint16 = 3
+	formatExtensible uint16 = 0xFFFE
+	wavHeaderSize    int    = 44
+
+	// tasks reported in verbose_json
+	taskTranscribe string = "transcribe"
+	taskTranslate  string = "translate"
+
+	// content types for the rendered formats
+	contentTypeJSON string = "application/json; charset=utf-8"
+	contentTypeText string = "text/plain; charset=utf-8"
+	contentTypeVTT  string = "text/vtt; charset=utf-8"
+)
+
+var (
+	// ErrInvalidInput required input was not found
+	ErrInvalidInput = errors.New("required input was not found")
+
+	// ErrInvalidWAV the file is not a well formed WAV file
+	ErrInvalidWAV = errors.New("invalid WAV file")
+
+	// ErrUnsupportedEncoding the WAV encoding cannot be split
+	ErrUnsupportedEncoding = errors.New("unsupported WAV encoding")
+
+	// ErrTooLarge the file is over the upload limit and cannot be split
+	ErrTooLarge = errors.New("audio is over the upload limit and only WAV or PCM input can be split")
+
+	// ErrUnsupportedFormat the response format is not supported
+	ErrUnsupportedFormat = errors.New("unsupported response format")
+)
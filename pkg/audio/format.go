@@ -0,0 +1,85 @@
+// Copyright 2023 dvonthenen ChatGPT Proxy contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache License 2.0
+
+package audio
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// SupportedFormat returns true when Render can produce the response format
+func SupportedFormat(format openai.AudioResponseFormat) bool {
+	switch format {
+	case "", openai.AudioResponseFormatJSON, openai.AudioResponseFormatVerboseJSON,
+		openai.AudioResponseFormatText, openai.AudioResponseFormatSRT, openai.AudioResponseFormatVTT:
+		return true
+	}
+	return false
+}
+
+// Render formats the transcript the way OpenAI would for the response format
+func (t *Transcript) Render(format openai.AudioResponseFormat) ([]byte, string, error) {
+	switch format {
+	case "", openai.AudioResponseFormatJSON:
+		body, err := json.Marshal(struct {
+			Text string `json:"text"`
+		}{Text: t.Text})
+		return body, contentTypeJSON, err
+	case openai.AudioResponseFormatVerboseJSON:
+		body, err := json.Marshal(t)
+		return body, contentTypeJSON, err
+	case openai.AudioResponseFormatText:
+		return []byte(t.Text + "\n"), contentTypeText, nil
+	case openai.AudioResponseFormatSRT:
+		return []byte(t.SRT()), contentTypeText, nil
+	case openai.AudioResponseFormatVTT:
+		return []byte(t.VTT()), contentTypeVTT, nil
+	}
+	return nil, "", ErrUnsupportedFormat
+}
+
+// SRT renders the segments as SubRip subtitles
+func (t *Transcript) SRT() string {
+	var sb strings.Builder
+	for i, segment := range t.Segments {
+		fmt.Fprintf(&sb, "%d\n%s --> %s\n%s\n\n", i+1, timestamp(segment.Start, ","), timestamp(segment.End, ","), strings.TrimSpace(segment.Text))
+	}
+	return sb.String()
+}
+
+// VTT renders the segments as WebVTT subtitles
+func (t *Transcript) VTT() string {
+	var sb strings.Builder
+	sb.WriteString("WEBVTT\n\n")
+	for _, segment := range t.Segments {
+		fmt.Fprintf(&sb, "%s --> %s\n%s\n\n", timestamp(segment.Start, "."), timestamp(segment.End, "."), strings.TrimSpace(segment.Text))
+	}
+	return sb.String()
+}
+
+// Response converts the transcript into the go-openai type
+func (t *Transcript) Response() openai.AudioResponse {
+	resp := openai.AudioResponse{
+		Task:     t.Task,
+		Language: t.Language,
+		Duration: t.Duration,
+		Text:     t.Text,
+	}
+	for _, segment := range t.Segments {
+		resp.Segments = append(resp.Segments, segment)
+	}
+	return resp
+}
+
+// timestamp formats seconds as HH:MM:SS followed by the separator and milliseconds
+func timestamp(seconds float64, separator string) string {
+	if seconds < 0 {
+		seconds = 0
+	}
+	ms := int64(seconds*1000 + 0.5)
+	return fmt.Sprintf("%02d:%02d:%02d%s%03d", ms/3600000, ms/60000%60, ms/1000%60, separator, ms%1000)
+}
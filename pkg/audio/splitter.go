@@ -0,0 +1,117 @@
+// Copyright 2023 dvonthenen ChatGPT Proxy contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache License 2.0
+
+package audio
+
+import (
+	"time"
+
+	klog "k8s.io/klog/v2"
+)
+
+// SplitWAV splits a WAV file into chunks that fit the options
+func SplitWAV(data []byte, options TranscriberOptions) ([]Chunk, error) {
+	decoded, err := parseWAV(data)
+	if err != nil {
+		return nil, err
+	}
+	return split(decoded, withDefaults(options)), nil
+}
+
+// SplitPCM splits raw samples into chunks that fit the options
+func SplitPCM(data []byte, format PCMFormat, options TranscriberOptions) ([]Chunk, error) {
+	decoded, err := parsePCM(data, format)
+	if err != nil {
+		return nil, err
+	}
+	return split(decoded, withDefaults(options)), nil
+}
+
+// split cuts the audio at the latest pause before each chunk reaches its limit
+func split(p *pcm, options TranscriberOptions) []Chunk {
+	total := p.frames()
+
+	// the smaller of the duration and the size limit
+	maxFrames := int(int64(options.MaxChunkDuration) * int64(p.SampleRate) / int64(time.Second))
+	bySize := int((options.MaxUploadBytes - uploadHeadroom - int64(wavHeaderSize)) / int64(p.blockAlign()))
+	if bySize < maxFrames {
+		maxFrames = bySize
+	}
+	if maxFrames <= 0 {
+		maxFrames = 1
+	}
+
+	searchFrames := int(int64(options.SearchWindow) * int64(p.SampleRate) / int64(time.Second))
+
+	chunks := make([]Chunk, 0, total/maxFrames+1)
+	start := 0
+	for start < total {
+		end := total
+		if total-start > maxFrames {
+			end = p.findCut(start, start+maxFrames, searchFrames, options)
+		}
+
+		chunks = append(chunks, Chunk{
+			Index:    len(chunks),
+			Start:    p.offset(start),
+			Duration: p.offset(end - start),
+			Data:     p.encode(start, end),
+		})
+		start = end
+	}
+
+	klog.V(4).Infof("split %v of audio into %d chunks\n", p.offset(total), len(chunks))
+
+	return chunks
+}
+
+// findCut looks back from limit for the last pause of at least MinSilence and
+// returns its middle. Without one, the quietest frame in the window is used.
+func (p *pcm) findCut(start, limit, searchFrames int, options TranscriberOptions) int {
+	frameLen := int(int64(frameDuration) * int64(p.SampleRate) / int64(time.Second))
+	if frameLen <= 0 {
+		frameLen = 1
+	}
+	minRun := int((options.MinSilence + frameDuration - 1) / frameDuration)
+	if minRun <= 0 {
+		minRun = 1
+	}
+
+	windowStart := limit - searchFrames
+	if windowStart <= start {
+		windowStart = start + 1
+	}
+
+	quietest := limit
+	quietestLevel := -1.0
+	runStart := -1
+	cut := -1
+
+	for frame := windowStart; frame+frameLen <= limit; frame += frameLen {
+		level := p.rms(frame, frame+frameLen)
+
+		if quietestLevel < 0 || level <= quietestLevel {
+			quietest = frame + frameLen/2
+			quietestLevel = level
+		}
+
+		if level < options.SilenceThreshold {
+			if runStart < 0 {
+				runStart = frame
+			}
+			if (frame+frameLen-runStart)/frameLen >= minRun {
+				cut = (runStart + frame + frameLen) / 2
+			}
+		} else {
+			runStart = -1
+		}
+	}
+
+	if cut > start {
+		return cut
+	}
+	if quietest > start && quietest <= limit {
+		return quietest
+	}
+	return limit
+}
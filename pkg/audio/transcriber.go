@@ -0,0 +1,260 @@
+// Copyright 2023 dvonthenen ChatGPT Proxy contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache License 2.0
+
+package audio
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	openai "github.com/sashabaranov/go-openai"
+	klog "k8s.io/klog/v2"
+)
+
+// New creates a transcriber
+func New(client *openai.Client, options TranscriberOptions) (*Transcriber, error) {
+	if client == nil {
+		klog.V(1).Infof("client is nil\n")
+		return nil, ErrInvalidInput
+	}
+
+	options = withDefaults(options)
+
+	return &Transcriber{
+		client:  client,
+		options: &options,
+	}, nil
+}
+
+func withDefaults(options TranscriberOptions) TranscriberOptions {
+	if options.MaxUploadBytes <= 0 {
+		options.MaxUploadBytes = DefaultMaxUploadBytes
+	}
+	if options.MaxChunkDuration <= 0 {
+		options.MaxChunkDuration = DefaultMaxChunkDuration
+	}
+	if options.SearchWindow <= 0 {
+		options.SearchWindow = DefaultSearchWindow
+	}
+	if options.MinSilence <= 0 {
+		options.MinSilence = DefaultMinSilence
+	}
+	if options.SilenceThreshold <= 0 {
+		options.SilenceThreshold = DefaultSilenceThreshold
+	}
+	if options.Concurrency <= 0 {
+		options.Concurrency = DefaultConcurrency
+	}
+	if options.PCM.SampleRate <= 0 {
+		options.PCM.SampleRate = DefaultPCMSampleRate
+	}
+	if options.PCM.Channels <= 0 {
+		options.PCM.Channels = DefaultPCMChannels
+	}
+	if options.PCM.BitsPerSample <= 0 {
+		options.PCM.BitsPerSample = DefaultPCMBitsPerSample
+	}
+	return options
+}
+
+// Transcribe converts speech into text in the spoken language
+func (t *Transcriber) Transcribe(ctx context.Context, request Request) (*Transcript, error) {
+	return t.run(ctx, request, taskTranscribe)
+}
+
+// Translate converts speech into English text
+func (t *Transcriber) Translate(ctx context.Context, request Request) (*Transcript, error) {
+	return t.run(ctx, request, taskTranslate)
+}
+
+func (t *Transcriber) run(ctx context.Context, request Request, task string) (*Transcript, error) {
+	klog.V(6).Infof("audio.run ENTER\n")
+
+	if len(request.Data) == 0 {
+		klog.V(1).Infof("audio is empty\n")
+		klog.V(6).Infof("audio.run LEAVE\n")
+		return nil, ErrInvalidInput
+	}
+	if len(request.Model) == 0 {
+		request.Model = openai.Whisper1
+	}
+	if len(request.Name) == 0 {
+		request.Name = "audio"
+	}
+
+	// raw samples have to be wrapped in a WAV header even when they are small
+	var decoded *pcm
+	var err error
+	switch {
+	case request.PCM != nil:
+		decoded, err = parsePCM(request.Data, *request.PCM)
+	case isPCMName(request.Name):
+		decoded, err = parsePCM(request.Data, t.options.PCM)
+	case int64(len(request.Data)) <= t.options.MaxUploadBytes:
+		// small enough to send unchanged
+		klog.V(4).Infof("passing %s through unchanged\n", request.Name)
+		transcript, err := t.send(ctx, request, task, request.Name, request.Data)
+		if err != nil {
+			klog.V(6).Infof("audio.run LEAVE\n")
+			return nil, err
+		}
+		transcript.Chunks = 1
+		klog.V(6).Infof("audio.run LEAVE\n")
+		return transcript, nil
+	case IsWAV(request.Data):
+		decoded, err = parseWAV(request.Data)
+	default:
+		klog.V(1).Infof("%s is %d bytes and cannot be split\n", request.Name, len(request.Data))
+		klog.V(6).Infof("audio.run LEAVE\n")
+		return nil, ErrTooLarge
+	}
+	if err != nil {
+		klog.V(1).Infof("decoding %s failed. Err: %v\n", request.Name, err)
+		klog.V(6).Infof("audio.run LEAVE\n")
+		return nil, err
+	}
+
+	chunks := split(decoded, *t.options)
+	results := make([]*Transcript, len(chunks))
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	var once sync.Once
+	var firstErr error
+	slots := make(chan struct{}, t.options.Concurrency)
+
+	base := strings.TrimSuffix(request.Name, filepath.Ext(request.Name))
+	for i := range chunks {
+		wg.Add(1)
+		go func(chunk Chunk) {
+			defer wg.Done()
+
+			select {
+			case slots <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			defer func() { <-slots }()
+
+			name := fmt.Sprintf("%s-%03d.wav", base, chunk.Index)
+			result, err := t.send(ctx, request, task, name, chunk.Data)
+			if err != nil {
+				klog.V(1).Infof("chunk %d failed. Err: %v\n", chunk.Index, err)
+				once.Do(func() {
+					firstErr = err
+					cancel()
+				})
+				return
+			}
+			results[chunk.Index] = result
+		}(chunks[i])
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		klog.V(6).Infof("audio.run LEAVE\n")
+		return nil, firstErr
+	}
+
+	transcript := stitch(task, chunks, results)
+
+	klog.V(4).Infof("transcribed %s in %d chunks\n", request.Name, len(chunks))
+	klog.V(6).Infof("audio.run LEAVE\n")
+
+	return transcript, nil
+}
+
+// send makes one upstream request, always asking for segments so the result
+// can be rendered in any format
+func (t *Transcriber) send(ctx context.Context, request Request, task, name string, data []byte) (*Transcript, error) {
+	audioRequest := openai.AudioRequest{
+		Model:       request.Model,
+		FilePath:    name,
+		Reader:      bytes.NewReader(data),
+		Prompt:      request.Prompt,
+		Temperature: request.Temperature,
+		Language:    request.Language,
+		Format:      openai.AudioResponseFormatVerboseJSON,
+	}
+
+	client := t.client
+	if request.Client != nil {
+		client = request.Client
+	}
+
+	var resp openai.AudioResponse
+	var err error
+	if task == taskTranslate {
+		resp, err = client.CreateTranslation(ctx, audioRequest)
+	} else {
+		resp, err = client.CreateTranscription(ctx, audioRequest)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	transcript := &Transcript{
+		Task:     resp.Task,
+		Language: resp.Language,
+		Duration: resp.Duration,
+		Text:     resp.Text,
+		Segments: make([]Segment, 0, len(resp.Segments)),
+	}
+	if len(transcript.Task) == 0 {
+		transcript.Task = task
+	}
+	for _, segment := range resp.Segments {
+		transcript.Segments = append(transcript.Segments, segment)
+	}
+
+	return transcript, nil
+}
+
+// stitch joins the chunk results, moving each segment to its place in the
+// original audio
+func stitch(task string, chunks []Chunk, results []*Transcript) *Transcript {
+	transcript := &Transcript{
+		Task:     task,
+		Segments: make([]Segment, 0),
+		Chunks:   len(chunks),
+	}
+
+	texts := make([]string, 0, len(results))
+	for i, result := range results {
+		offset := chunks[i].Start.Seconds()
+
+		if len(transcript.Language) == 0 {
+			transcript.Language = result.Language
+		}
+		if text := strings.TrimSpace(result.Text); len(text) > 0 {
+			texts = append(texts, text)
+		}
+
+		for _, segment := range result.Segments {
+			segment.ID = len(transcript.Segments)
+			segment.Seek += int(offset * 100)
+			segment.Start += offset
+			segment.End += offset
+			transcript.Segments = append(transcript.Segments, segment)
+		}
+
+		transcript.Duration = offset + chunks[i].Duration.Seconds()
+	}
+	transcript.Text = strings.Join(texts, " ")
+
+	return transcript
+}
+
+func isPCMName(name string) bool {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".pcm", ".raw":
+		return true
+	}
+	return false
+}
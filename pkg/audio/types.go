@@ -0,0 +1,106 @@
+// Copyright 2023 dvonthenen ChatGPT Proxy contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache License 2.0
+
+package audio
+
+import (
+	"time"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// PCMFormat describes uncompressed samples
+type PCMFormat struct {
+	SampleRate    int
+	Channels      int
+	BitsPerSample int
+}
+
+// TranscriberOptions tunes how long audio is split
+type TranscriberOptions struct {
+	// MaxUploadBytes is the largest file sent upstream in one request
+	MaxUploadBytes int64
+
+	// MaxChunkDuration caps a chunk even when it would fit in MaxUploadBytes
+	MaxChunkDuration time.Duration
+
+	// SearchWindow is how far back from the end of a chunk a split point is
+	// looked for. A pause of at least MinSilence with a level under
+	// SilenceThreshold (0-1 of full scale) is preferred, otherwise the
+	// quietest point is used.
+	SearchWindow     time.Duration
+	MinSilence       time.Duration
+	SilenceThreshold float64
+
+	// Concurrency is the number of chunks transcribed at once
+	Concurrency int
+
+	// PCM describes .pcm and .raw input
+	PCM PCMFormat
+}
+
+// Request is a file to transcribe or translate
+type Request struct {
+	// Name is the file name, the extension tells OpenAI the format
+	Name string
+	Data []byte
+
+	// PCM marks Data as raw samples regardless of Name
+	PCM *PCMFormat
+
+	Model       string
+	Prompt      string
+	Language    string
+	Temperature float32
+
+	// Client overrides the transcriber's client for this request when set
+	Client *openai.Client
+}
+
+// Segment matches the segments of openai.AudioResponse
+type Segment struct {
+	ID               int     `json:"id"`
+	Seek             int     `json:"seek"`
+	Start            float64 `json:"start"`
+	End              float64 `json:"end"`
+	Text             string  `json:"text"`
+	Tokens           []int   `json:"tokens"`
+	Temperature      float64 `json:"temperature"`
+	AvgLogprob       float64 `json:"avg_logprob"`
+	CompressionRatio float64 `json:"compression_ratio"`
+	NoSpeechProb     float64 `json:"no_speech_prob"`
+	Transient        bool    `json:"transient"`
+}
+
+// Transcript is the stitched result of all chunks
+type Transcript struct {
+	Task     string    `json:"task"`
+	Language string    `json:"language"`
+	Duration float64   `json:"duration"`
+	Segments []Segment `json:"segments"`
+	Text     string    `json:"text"`
+
+	// Chunks is the number of upstream requests that were made
+	Chunks int `json:"-"`
+}
+
+// Chunk is a piece of the input encoded as a WAV file
+type Chunk struct {
+	Index    int
+	Start    time.Duration
+	Duration time.Duration
+	Data     []byte
+}
+
+// Transcriber splits long audio and transcribes the pieces in parallel
+type Transcriber struct {
+	client  *openai.Client
+	options *TranscriberOptions
+}
+
+// pcm is decoded audio
+type pcm struct {
+	PCMFormat
+	encoding uint16
+	data     []byte
+}
@@ -0,0 +1,197 @@
+// Copyright 2023 dvonthenen ChatGPT Proxy contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache License 2.0
+
+package audio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"time"
+
+	klog "k8s.io/klog/v2"
+)
+
+// IsWAV returns true when data starts with a RIFF/WAVE header
+func IsWAV(data []byte) bool {
+	return len(data) >= 12 && bytes.Equal(data[0:4], []byte("RIFF")) && bytes.Equal(data[8:12], []byte("WAVE"))
+}
+
+// parseWAV finds the fmt and data chunks of a WAV file
+func parseWAV(data []byte) (*pcm, error) {
+	if !IsWAV(data) {
+		return nil, ErrInvalidWAV
+	}
+
+	var decoded pcm
+	foundFormat := false
+	foundData := false
+
+	offset := 12
+	for offset+8 <= len(data) {
+		id := string(data[offset : offset+4])
+		size := int(binary.LittleEndian.Uint32(data[offset+4 : offset+8]))
+		offset += 8
+
+		// streamed files leave the size at its maximum
+		if size < 0 || offset+size > len(data) {
+			size = len(data) - offset
+		}
+
+		switch id {
+		case "fmt ":
+			if size < 16 {
+				klog.V(1).Infof("fmt chunk is too short\n")
+				return nil, ErrInvalidWAV
+			}
+			decoded.encoding = binary.LittleEndian.Uint16(data[offset : offset+2])
+			decoded.Channels = int(binary.LittleEndian.Uint16(data[offset+2 : offset+4]))
+			decoded.SampleRate = int(binary.LittleEndian.Uint32(data[offset+4 : offset+8]))
+			decoded.BitsPerSample = int(binary.LittleEndian.Uint16(data[offset+14 : offset+16]))
+
+			// the real encoding of WAVE_FORMAT_EXTENSIBLE is the start of the sub format GUID
+			if decoded.encoding == formatExtensible && size >= 26 {
+				decoded.encoding = binary.LittleEndian.Uint16(data[offset+24 : offset+26])
+			}
+			foundFormat = true
+		case "data":
+			decoded.data = data[offset : offset+size]
+			foundData = true
+		}
+
+		// chunks are padded to an even size
+		offset += size + size%2
+	}
+
+	if !foundFormat || !foundData {
+		klog.V(1).Infof("WAV is missing the fmt or data chunk\n")
+		return nil, ErrInvalidWAV
+	}
+
+	err := decoded.validate()
+	if err != nil {
+		return nil, err
+	}
+
+	return &decoded, nil
+}
+
+// parsePCM wraps raw samples
+func parsePCM(data []byte, format PCMFormat) (*pcm, error) {
+	decoded := &pcm{
+		PCMFormat: format,
+		encoding:  formatPCM,
+		data:      data,
+	}
+
+	err := decoded.validate()
+	if err != nil {
+		return nil, err
+	}
+
+	return decoded, nil
+}
+
+func (p *pcm) validate() error {
+	if p.SampleRate <= 0 || p.Channels <= 0 {
+		klog.V(1).Infof("invalid sample rate %d or channels %d\n", p.SampleRate, p.Channels)
+		return ErrInvalidWAV
+	}
+
+	switch {
+	case p.encoding == formatPCM && (p.BitsPerSample == 8 || p.BitsPerSample == 16 || p.BitsPerSample == 24 || p.BitsPerSample == 32):
+	case p.encoding == formatFloat && p.BitsPerSample == 32:
+	default:
+		klog.V(1).Infof("encoding %d with %d bits is not supported\n", p.encoding, p.BitsPerSample)
+		return ErrUnsupportedEncoding
+	}
+
+	// drop a trailing partial frame
+	p.data = p.data[:len(p.data)-len(p.data)%p.blockAlign()]
+
+	return nil
+}
+
+// blockAlign is the size of one sample for every channel
+func (p *pcm) blockAlign() int {
+	return p.Channels * p.BitsPerSample / 8
+}
+
+func (p *pcm) byteRate() int {
+	return p.SampleRate * p.blockAlign()
+}
+
+// frames is the number of samples per channel
+func (p *pcm) frames() int {
+	return len(p.data) / p.blockAlign()
+}
+
+// offset converts a number of frames into a duration
+func (p *pcm) offset(frames int) time.Duration {
+	return time.Duration(int64(frames) * int64(time.Second) / int64(p.SampleRate))
+}
+
+// sample returns the sample at a byte offset scaled to -1..1
+func (p *pcm) sample(offset int) float64 {
+	b := p.data[offset:]
+	switch p.BitsPerSample {
+	case 8:
+		return (float64(b[0]) - 128) / 128
+	case 16:
+		return float64(int16(binary.LittleEndian.Uint16(b))) / 32768
+	case 24:
+		v := int32(b[0]) | int32(b[1])<<8 | int32(int8(b[2]))<<16
+		return float64(v) / 8388608
+	case 32:
+		if p.encoding == formatFloat {
+			return float64(math.Float32frombits(binary.LittleEndian.Uint32(b)))
+		}
+		return float64(int32(binary.LittleEndian.Uint32(b))) / 2147483648
+	}
+	return 0
+}
+
+// rms is the level of the frames in [start, end) across all channels
+func (p *pcm) rms(start, end int) float64 {
+	bytesPerSample := p.BitsPerSample / 8
+	align := p.blockAlign()
+
+	var sum float64
+	count := 0
+	for frame := start; frame < end; frame++ {
+		for channel := 0; channel < p.Channels; channel++ {
+			v := p.sample(frame*align + channel*bytesPerSample)
+			sum += v * v
+			count++
+		}
+	}
+	if count == 0 {
+		return 0
+	}
+	return math.Sqrt(sum / float64(count))
+}
+
+// encode writes frames [start, end) as a WAV file
+func (p *pcm) encode(start, end int) []byte {
+	samples := p.data[start*p.blockAlign() : end*p.blockAlign()]
+
+	var buf bytes.Buffer
+	buf.Grow(wavHeaderSize + len(samples))
+
+	buf.WriteString("RIFF")
+	binary.Write(&buf, binary.LittleEndian, uint32(36+len(samples)))
+	buf.WriteString("WAVE")
+	buf.WriteString("fmt ")
+	binary.Write(&buf, binary.LittleEndian, uint32(16))
+	binary.Write(&buf, binary.LittleEndian, p.encoding)
+	binary.Write(&buf, binary.LittleEndian, uint16(p.Channels))
+	binary.Write(&buf, binary.LittleEndian, uint32(p.SampleRate))
+	binary.Write(&buf, binary.LittleEndian, uint32(p.byteRate()))
+	binary.Write(&buf, binary.LittleEndian, uint16(p.blockAlign()))
+	binary.Write(&buf, binary.LittleEndian, uint16(p.BitsPerSample))
+	buf.WriteString("data")
+	binary.Write(&buf, binary.LittleEndian, uint32(len(samples)))
+	buf.Write(samples)
+
+	return buf.Bytes()
+}
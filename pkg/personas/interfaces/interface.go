@@ -5,14 +5,23 @@ package interfaces
 
 import (
 	"context"
+	"fmt"
 	"io"
+	"strings"
+
+	openai "github.com/sashabaranov/go-openai"
 )
 
 // shared
 type CompletionMessage struct {
-	Role    string
-	Content string
-	Name    string
+	Role         string
+	Content      string
+	Name         string
+	FunctionCall *FunctionCall
+}
+type FunctionCall struct {
+	Name      string
+	Arguments string
 }
 type CompletionChoice struct {
 	Index   int
@@ -20,6 +29,47 @@ type CompletionChoice struct {
 	// FinishReason string
 }
 
+// retrieval
+type Citation struct {
+	Index      int // the [n] marker used in the prompt
+	DocumentID string
+	ChunkID    string
+	Title      string
+	Source     string
+	Text       string
+	Score      float32
+}
+
+// functions
+type FunctionHandler func(ctx context.Context, arguments string) (string, error)
+
+type Function struct {
+	Name        string
+	Description string
+	Parameters  interface{} // JSON schema, ie map[string]interface{} or json.RawMessage
+	Handler     FunctionHandler
+}
+
+// requests
+// RequestHook may rewrite a chat request right before a persona sends it. The
+// conversation the persona keeps is not changed.
+type RequestHook func(request *openai.ChatCompletionRequest)
+
+// structured output
+type StructuredOutputError struct {
+	Attempts int
+	Errors   []string // validation errors of the last reply
+	Reply    string   // the last reply
+}
+
+func (e *StructuredOutputError) Error() string {
+	return fmt.Sprintf("%v after %d attempts: %s", ErrStructuredOutput, e.Attempts, strings.Join(e.Errors, "; "))
+}
+
+func (e *StructuredOutputError) Is(target error) bool {
+	return target == ErrStructuredOutput
+}
+
 // rest interfaces
 type SimpleChat interface {
 	Init(level SkillType, model string) error
@@ -43,6 +93,26 @@ type AdvancedChat interface {
 	AddDirective(directives string) error
 	AddUserContext(text string) error
 	CommitResponse(index int) error
+	RegisterFunction(function Function) error
+	SetMaxFunctionDepth(depth int) error
+	QueryInto(ctx context.Context, statement string, target interface{}) error
+	SetMaxQueryAttempts(attempts int) error
+}
+
+type RetrievalChat interface {
+	Init(level SkillType, model string) error
+	GetConversation() ([]CompletionMessage, error)
+	Query(ctx context.Context, statement string) ([]CompletionChoice, []Citation, error)
+	AddDirective(directives string) error
+	SetTopK(k int) error
+}
+
+// audio interfaces
+type Transcription interface {
+	Init(model, language string) error
+	Transcribe(ctx context.Context, filename string, r io.Reader, format string) (string, error)
+	TranscribeFile(ctx context.Context, path, format string) (string, error)
+	Translate(ctx context.Context, filename string, r io.Reader, format string) (string, error)
 }
 
 // streaming interfaces
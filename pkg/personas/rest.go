@@ -4,10 +4,14 @@
 package personas
 
 import (
+	audio "github.com/dvonthenen/chat-gpeasy/pkg/audio"
 	interfaces "github.com/dvonthenen/chat-gpeasy/pkg/personas/interfaces"
 	advanced "github.com/dvonthenen/chat-gpeasy/pkg/personas/rest/advanced"
+	retrieval "github.com/dvonthenen/chat-gpeasy/pkg/personas/rest/retrieval"
 	simple "github.com/dvonthenen/chat-gpeasy/pkg/personas/rest/simple"
 	standard "github.com/dvonthenen/chat-gpeasy/pkg/personas/rest/standard"
+	transcription "github.com/dvonthenen/chat-gpeasy/pkg/personas/rest/transcription"
+	rag "github.com/dvonthenen/chat-gpeasy/pkg/rag"
 )
 
 func NewSimpleChat() (*interfaces.SimpleChat, error) {
@@ -111,3 +115,91 @@ func NewAdvancedChatWithOptions(opt *PersonaOptions) (*interfaces.AdvancedChat,
 
 	return &advanced, nil
 }
+
+func NewRetrievalChat(store *rag.Store) (*interfaces.RetrievalChat, error) {
+	client, err := newClient()
+	if err != nil {
+		return nil, err
+	}
+
+	retrievalChat, err := retrieval.New(client, store)
+	if err != nil {
+		return nil, err
+	}
+
+	var retrieval interfaces.RetrievalChat
+	retrieval = retrievalChat
+
+	return &retrieval, nil
+}
+
+func NewRetrievalChatWithOptions(opt *PersonaOptions, store *rag.Store) (*interfaces.RetrievalChat, error) {
+	client, err := newWithOptions(opt)
+	if err != nil {
+		return nil, err
+	}
+
+	retrievalChat, err := retrieval.New(client, store)
+	if err != nil {
+		return nil, err
+	}
+
+	var retrieval interfaces.RetrievalChat
+	retrieval = retrievalChat
+
+	return &retrieval, nil
+}
+
+// NewRAGStore creates a document store that embeds through the same client
+// configuration as the personas
+func NewRAGStore(options rag.StoreOptions) (*rag.Store, error) {
+	client, err := newClient()
+	if err != nil {
+		return nil, err
+	}
+
+	return rag.New(client, options)
+}
+
+func NewRAGStoreWithOptions(opt *PersonaOptions, options rag.StoreOptions) (*rag.Store, error) {
+	client, err := newWithOptions(opt)
+	if err != nil {
+		return nil, err
+	}
+
+	return rag.New(client, options)
+}
+
+func NewTranscription() (*interfaces.Transcription, error) {
+	client, err := newClient()
+	if err != nil {
+		return nil, err
+	}
+
+	transcriptionPersona, err := transcription.New(client, audio.TranscriberOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	var transcription interfaces.Transcription
+	transcription = transcriptionPersona
+
+	return &transcription, nil
+}
+
+func NewTranscriptionWithOptions(opt *PersonaOptions, options audio.TranscriberOptions) (*interfaces.Transcription, error) {
+	client, err := newWithOptions(opt)
+	if err != nil {
+		return nil, err
+	}
+
+	transcriptionPersona, err := transcription.New(client, options)
+	if err != nil {
+		return nil, err
+	}
+
+	var transcription interfaces.Transcription
+	transcription = transcriptionPersona
+
+	return &transcription, nil
+}
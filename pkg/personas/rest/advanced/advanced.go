@@ -18,8 +18,12 @@ func New(client *openai.Client) (*Persona, error) {
 		return nil, interfaces.ErrInvalidInput
 	}
 	return &Persona{
-		client:       client,
-		conversation: make([]openai.ChatCompletionMessage, 0),
+		client:           client,
+		conversation:     make([]openai.ChatCompletionMessage, 0),
+		functions:        make(map[string]interfaces.Function),
+		functionNames:    make([]string, 0),
+		maxFunctionDepth: interfaces.DefaultMaxFunctionDepth,
+		maxQueryAttempts: interfaces.DefaultMaxQueryAttempts,
 	}, nil
 }
 
@@ -126,6 +130,11 @@ func (p *Persona) EditConversation(index int, statement string) ([]interfaces.Co
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
+	if p.functionRunning {
+		klog.V(1).Infof("a function handler is running\n")
+		return nil, interfaces.ErrFunctionRunning
+	}
+
 	numOfConvo := len(p.conversation)
 
 	if index < 0 || index >= numOfConvo {
@@ -169,15 +178,10 @@ func (p *Persona) EditConversation(index int, statement string) ([]interfaces.Co
 	}
 
 	// requery
-	request := openai.ChatCompletionRequest{
-		Model:    p.model,
-		Messages: convo,
-	}
-
 	ctx := context.Background()
-	response, err := p.client.CreateChatCompletion(ctx, request)
+	request, response, convo, err := p.complete(ctx, convo)
 	if err != nil {
-		klog.V(1).Infof("CreateChatCompletion error: %v\n", err)
+		klog.V(1).Infof("complete error: %v\n", err)
 		klog.V(6).Infof("advanced.EditConversation LEAVE\n")
 		return nil, err
 	}
@@ -185,8 +189,8 @@ func (p *Persona) EditConversation(index int, statement string) ([]interfaces.Co
 	// housekeeping
 	p.appendedResponse = false
 	p.conversation = convo
-	p.request = &request
-	p.response = &response
+	p.request = request
+	p.response = response
 
 	if len(response.Choices) == 1 {
 		p.appendedResponse = true
@@ -207,6 +211,11 @@ func (p *Persona) Query(ctx context.Context, role, statement string) ([]interfac
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
+	if p.functionRunning {
+		klog.V(1).Infof("a function handler is running\n")
+		return nil, interfaces.ErrFunctionRunning
+	}
+
 	if !p.appendedResponse {
 		klog.V(1).Infof("the response hasn't been appended yet\n")
 		return nil, interfaces.ErrInvalidInput
@@ -241,14 +250,9 @@ func (p *Persona) Query(ctx context.Context, role, statement string) ([]interfac
 		Content: statement,
 	})
 
-	request := openai.ChatCompletionRequest{
-		Model:    p.model,
-		Messages: convo,
-	}
-
-	response, err := p.client.CreateChatCompletion(ctx, request)
+	request, response, convo, err := p.complete(ctx, convo)
 	if err != nil {
-		klog.V(1).Infof("CreateChatCompletion error: %v\n", err)
+		klog.V(1).Infof("complete error: %v\n", err)
 		klog.V(6).Infof("advanced.Query LEAVE\n")
 		return nil, err
 	}
@@ -256,8 +260,8 @@ func (p *Persona) Query(ctx context.Context, role, statement string) ([]interfac
 	// housekeeping
 	p.appendedResponse = false
 	p.conversation = convo
-	p.request = &request
-	p.response = &response
+	p.request = request
+	p.response = response
 
 	if len(response.Choices) == 1 {
 		p.appendedResponse = true
@@ -278,6 +282,11 @@ func (p *Persona) AddDirective(directives string) error {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
+	if p.functionRunning {
+		klog.V(1).Infof("a function handler is running\n")
+		return interfaces.ErrFunctionRunning
+	}
+
 	klog.V(5).Infof("AddDirectives: %s\n", directives)
 
 	if len(directives) == 0 {
@@ -297,6 +306,11 @@ func (p *Persona) AddUserContext(text string) error {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
+	if p.functionRunning {
+		klog.V(1).Infof("a function handler is running\n")
+		return interfaces.ErrFunctionRunning
+	}
+
 	klog.V(5).Infof("AddUserContext: %s\n", text)
 
 	if len(text) == 0 {
@@ -316,6 +330,11 @@ func (p *Persona) CommitResponse(index int) error {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
+	if p.functionRunning {
+		klog.V(1).Infof("a function handler is running\n")
+		return interfaces.ErrFunctionRunning
+	}
+
 	klog.V(6).Infof("advanced.CommitResponse ENTER\n")
 	klog.V(5).Infof("index: %d\n", index)
 
@@ -357,3 +376,22 @@ func (p *Persona) CommitResponse(index int) error {
 	klog.V(6).Infof("advanced.CommitResponse LEAVE\n")
 	return nil
 }
+
+// Last returns the request and response of the last completion, nil before the
+// first query
+func (p *Persona) Last() (*openai.ChatCompletionRequest, *openai.ChatCompletionResponse) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.request, p.response
+}
+
+// SetRequestHook sets a function that may rewrite each request before it is
+// sent, nil removes it. The hook runs while the persona is locked, so it must
+// not call the persona.
+func (p *Persona) SetRequestHook(hook interfaces.RequestHook) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.requestHook = hook
+}
@@ -0,0 +1,140 @@
+// Copyright 2023 dvonthenen ChatGPT Proxy contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache License 2.0
+
+package advanced
+
+import (
+	"context"
+	"fmt"
+
+	openai "github.com/sashabaranov/go-openai"
+	klog "k8s.io/klog/v2"
+
+	interfaces "github.com/dvonthenen/chat-gpeasy/pkg/personas/interfaces"
+	utils "github.com/dvonthenen/chat-gpeasy/pkg/personas/utils"
+)
+
+func (p *Persona) RegisterFunction(function interfaces.Function) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	klog.V(5).Infof("RegisterFunction: %s\n", function.Name)
+
+	if len(function.Name) == 0 {
+		klog.V(1).Infof("function name is empty\n")
+		return interfaces.ErrInvalidInput
+	}
+	if function.Handler == nil {
+		klog.V(1).Infof("function handler is nil\n")
+		return interfaces.ErrInvalidInput
+	}
+	if _, ok := p.functions[function.Name]; ok {
+		klog.V(1).Infof("function %s already registered\n", function.Name)
+		return interfaces.ErrFunctionExists
+	}
+
+	p.functions[function.Name] = function
+	p.functionNames = append(p.functionNames, function.Name)
+
+	return nil
+}
+
+// SetMaxFunctionDepth sets how many function calls a single query may chain.
+// A depth of 0 returns function calls to the caller without running them.
+func (p *Persona) SetMaxFunctionDepth(depth int) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if depth < 0 {
+		klog.V(1).Infof("invalid depth (%d)\n", depth)
+		return interfaces.ErrInvalidInput
+	}
+
+	p.maxFunctionDepth = depth
+
+	return nil
+}
+
+// complete sends the conversation and, while the model asks for a function, runs
+// the handler and feeds the result back. The returned conversation includes the
+// function call and result messages but not the final response.
+func (p *Persona) complete(ctx context.Context, convo []openai.ChatCompletionMessage) (*openai.ChatCompletionRequest, *openai.ChatCompletionResponse, []openai.ChatCompletionMessage, error) {
+	definitions := p.functionDefinitions()
+
+	for depth := 0; ; depth++ {
+		request := openai.ChatCompletionRequest{
+			Model:     p.model,
+			Messages:  convo,
+			Functions: definitions,
+		}
+		if p.requestHook != nil {
+			p.requestHook(&request)
+		}
+
+		response, err := p.client.CreateChatCompletion(ctx, request)
+		if err != nil {
+			klog.V(1).Infof("CreateChatCompletion error: %v\n", err)
+			return nil, nil, nil, err
+		}
+
+		if len(response.Choices) != 1 || response.Choices[0].Message.FunctionCall == nil || p.maxFunctionDepth == 0 {
+			return &request, &response, convo, nil
+		}
+		if depth >= p.maxFunctionDepth {
+			klog.V(1).Infof("function calls exceeded max depth (%d)\n", p.maxFunctionDepth)
+			return nil, nil, nil, interfaces.ErrMaxFunctionDepth
+		}
+
+		call := response.Choices[0].Message
+		convo = append(convo, call)
+		convo = append(convo, openai.ChatCompletionMessage{
+			Role:    openai.ChatMessageRoleFunction,
+			Name:    call.FunctionCall.Name,
+			Content: p.callFunction(ctx, call.FunctionCall),
+		})
+	}
+}
+
+// callFunction runs the handler. errors are returned to the model as the result
+// so it can recover or explain the failure. The caller holds p.mu, it is released
+// while the handler runs so a handler can read the persona. Anything that would
+// change the conversation fails with ErrFunctionRunning until the handler returns,
+// otherwise the query that called it would overwrite the change.
+func (p *Persona) callFunction(ctx context.Context, call *openai.FunctionCall) string {
+	klog.V(4).Infof("function call: %s(%s)\n", call.Name, call.Arguments)
+
+	function, ok := p.functions[call.Name]
+	if !ok {
+		klog.V(1).Infof("function %s not registered\n", call.Name)
+		return fmt.Sprintf("error: %v", interfaces.ErrFunctionNotFound)
+	}
+
+	result, err := func() (string, error) {
+		p.functionRunning = true
+		p.mu.Unlock()
+		defer func() {
+			p.mu.Lock()
+			p.functionRunning = false
+		}()
+		return function.Handler(ctx, call.Arguments)
+	}()
+	if err != nil {
+		klog.V(1).Infof("function %s failed. Err: %v\n", call.Name, err)
+		return fmt.Sprintf("error: %v", err)
+	}
+
+	klog.V(5).Infof("function result: %s\n", result)
+	return result
+}
+
+func (p *Persona) functionDefinitions() []openai.FunctionDefinition {
+	if len(p.functionNames) == 0 {
+		return nil
+	}
+
+	definitions := make([]openai.FunctionDefinition, 0, len(p.functionNames))
+	for _, name := range p.functionNames {
+		definitions = append(definitions, *utils.ConvertFunction(p.functions[name]))
+	}
+	return definitions
+}
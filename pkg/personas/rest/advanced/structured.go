@@ -0,0 +1,158 @@
+// Copyright 2023 dvonthenen ChatGPT Proxy contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache License 2.0
+
+package advanced
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+
+	openai "github.com/sashabaranov/go-openai"
+	klog "k8s.io/klog/v2"
+
+	interfaces "github.com/dvonthenen/chat-gpeasy/pkg/personas/interfaces"
+	schema "github.com/dvonthenen/chat-gpeasy/pkg/personas/schema"
+)
+
+// QueryInto asks the question and decodes the reply into target, a pointer to
+// a struct, slice or map. The model is given the JSON schema of the target's
+// type. A reply that does not match it is sent back with the validation errors,
+// up to SetMaxQueryAttempts times. The exchange is kept in the conversation.
+func (p *Persona) QueryInto(ctx context.Context, statement string, target interface{}) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.functionRunning {
+		klog.V(1).Infof("a function handler is running\n")
+		return interfaces.ErrFunctionRunning
+	}
+
+	if !p.appendedResponse {
+		klog.V(1).Infof("the response hasn't been appended yet\n")
+		return interfaces.ErrInvalidInput
+	}
+	if len(statement) == 0 {
+		klog.V(1).Infof("statement is empty\n")
+		return interfaces.ErrInvalidInput
+	}
+	if value := reflect.ValueOf(target); value.Kind() != reflect.Ptr || value.IsNil() {
+		klog.V(1).Infof("target must be a non-nil pointer\n")
+		return interfaces.ErrInvalidInput
+	}
+
+	klog.V(6).Infof("advanced.QueryInto ENTER\n")
+	klog.V(5).Infof("statement: %s\n", statement)
+
+	s, err := schema.Generate(target)
+	if err != nil {
+		klog.V(1).Infof("schema.Generate failed. Err: %v\n", err)
+		klog.V(6).Infof("advanced.QueryInto LEAVE\n")
+		return err
+	}
+	if s.Type != schema.TypeObject && s.Type != schema.TypeArray {
+		klog.V(1).Infof("target must be a struct, slice or map\n")
+		klog.V(6).Infof("advanced.QueryInto LEAVE\n")
+		return interfaces.ErrInvalidInput
+	}
+	definition, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		klog.V(1).Infof("json.MarshalIndent failed. Err: %v\n", err)
+		klog.V(6).Infof("advanced.QueryInto LEAVE\n")
+		return err
+	}
+
+	convo := make([]openai.ChatCompletionMessage, 0)
+	convo = append(convo, p.conversation...)
+	convo = append(convo, openai.ChatCompletionMessage{
+		Role:    openai.ChatMessageRoleUser,
+		Content: statement + "\n\n" + fmt.Sprintf(interfaces.StructuredOutputPrompt, definition),
+	})
+
+	var reply string
+	var problems []string
+	for attempt := 1; attempt <= p.maxQueryAttempts; attempt++ {
+		request, response, updated, err := p.complete(ctx, convo)
+		if err != nil {
+			klog.V(1).Infof("complete error: %v\n", err)
+			klog.V(6).Infof("advanced.QueryInto LEAVE\n")
+			return err
+		}
+		if len(response.Choices) == 0 {
+			klog.V(1).Infof("no choices generated\n")
+			klog.V(6).Infof("advanced.QueryInto LEAVE\n")
+			return interfaces.ErrEmptyChoices
+		}
+
+		p.request = request
+		p.response = response
+
+		message := response.Choices[0].Message
+		reply = message.Content
+		problems = decodeInto(s, reply, target)
+		convo = append(updated, message)
+
+		if len(problems) == 0 {
+			// housekeeping
+			p.conversation = convo
+			p.appendedResponse = true
+
+			klog.V(4).Infof("advanced.QueryInto Succeeded after %d attempts\n", attempt)
+			klog.V(6).Infof("advanced.QueryInto LEAVE\n")
+			return nil
+		}
+
+		klog.V(3).Infof("reply %d does not match the schema: %v\n", attempt, problems)
+		convo = append(convo, openai.ChatCompletionMessage{
+			Role:    openai.ChatMessageRoleUser,
+			Content: fmt.Sprintf(interfaces.StructuredRetryPrompt, "- "+strings.Join(problems, "\n- ")),
+		})
+	}
+
+	klog.V(1).Infof("no reply matched the schema after %d attempts\n", p.maxQueryAttempts)
+	klog.V(6).Infof("advanced.QueryInto LEAVE\n")
+	return &interfaces.StructuredOutputError{
+		Attempts: p.maxQueryAttempts,
+		Errors:   problems,
+		Reply:    reply,
+	}
+}
+
+// SetMaxQueryAttempts sets how many replies QueryInto asks for before giving up
+func (p *Persona) SetMaxQueryAttempts(attempts int) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if attempts < 1 {
+		klog.V(1).Infof("invalid attempts (%d)\n", attempts)
+		return interfaces.ErrInvalidInput
+	}
+
+	p.maxQueryAttempts = attempts
+
+	return nil
+}
+
+// decodeInto extracts the JSON in a reply, validates it and decodes it into
+// target. It returns the problems to tell the model, none on success.
+func decodeInto(s *schema.Schema, reply string, target interface{}) []string {
+	raw, err := s.Extract(reply)
+	if err != nil {
+		return []string{"the reply does not contain a JSON " + s.Type}
+	}
+
+	var value interface{}
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return []string{err.Error()}
+	}
+	if problems := s.Validate(value); len(problems) > 0 {
+		return problems
+	}
+
+	if err := json.Unmarshal(raw, target); err != nil {
+		return []string{err.Error()}
+	}
+	return nil
+}
@@ -19,12 +19,24 @@ type Persona struct {
 	model string
 	level interfaces.SkillType
 
+	// functions
+	functions        map[string]interfaces.Function
+	functionNames    []string
+	maxFunctionDepth int
+	functionRunning  bool
+
+	// structured output
+	maxQueryAttempts int
+
 	// last query
 	appendedResponse bool
 	conversation     []openai.ChatCompletionMessage
 	request          *openai.ChatCompletionRequest
 	response         *openai.ChatCompletionResponse
 
+	// rewrites requests before they are sent
+	requestHook interfaces.RequestHook
+
 	// housekeeping
 	mu sync.Mutex
 }
@@ -0,0 +1,200 @@
+// Copyright 2023 dvonthenen ChatGPT Proxy contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache License 2.0
+
+package retrieval
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	openai "github.com/sashabaranov/go-openai"
+	klog "k8s.io/klog/v2"
+
+	interfaces "github.com/dvonthenen/chat-gpeasy/pkg/personas/interfaces"
+	utils "github.com/dvonthenen/chat-gpeasy/pkg/personas/utils"
+	rag "github.com/dvonthenen/chat-gpeasy/pkg/rag"
+)
+
+func New(client *openai.Client, store *rag.Store) (*Persona, error) {
+	if client == nil || store == nil {
+		return nil, interfaces.ErrInvalidInput
+	}
+	return &Persona{
+		client:       client,
+		store:        store,
+		topK:         rag.DefaultTopK,
+		conversation: make([]openai.ChatCompletionMessage, 0),
+	}, nil
+}
+
+func (p *Persona) Init(level interfaces.SkillType, model string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(model) == 0 {
+		model = openai.GPT3Dot5Turbo
+	}
+	p.model = model
+	p.level = level
+
+	p.conversation = make([]openai.ChatCompletionMessage, 0)
+	switch p.level {
+	case interfaces.SkillTypeExpert:
+		p.conversation = append(p.conversation, openai.ChatCompletionMessage{
+			Role:    openai.ChatMessageRoleSystem,
+			Content: "You are an expert in your field. Only answer from the sources you are given.",
+		})
+	default:
+		p.conversation = append(p.conversation, openai.ChatCompletionMessage{
+			Role:    openai.ChatMessageRoleSystem,
+			Content: "You are a helpful assistant. Only answer from the sources you are given.",
+		})
+	}
+
+	return nil
+}
+
+func (p *Persona) GetConversation() ([]interfaces.CompletionMessage, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.conversation == nil {
+		return nil, interfaces.ErrInvalidInput
+	}
+
+	return *utils.ConvertChatCompletionMessages(p.conversation), nil
+}
+
+func (p *Persona) SetTopK(k int) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if k <= 0 {
+		klog.V(1).Infof("invalid k (%d)\n", k)
+		return interfaces.ErrInvalidInput
+	}
+	p.topK = k
+
+	return nil
+}
+
+func (p *Persona) AddDirective(directives string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	klog.V(5).Infof("AddDirectives: %s\n", directives)
+
+	if len(directives) == 0 {
+		klog.V(1).Infof("directives is empty\n")
+		return interfaces.ErrInvalidInput
+	}
+
+	p.conversation = append(p.conversation, openai.ChatCompletionMessage{
+		Role:    openai.ChatMessageRoleSystem,
+		Content: directives,
+	})
+
+	return nil
+}
+
+// Query retrieves the chunks most relevant to the statement and sends them as
+// numbered sources with the question. The sources are only sent for this
+// question; the conversation keeps the question and the answer.
+func (p *Persona) Query(ctx context.Context, statement string) ([]interfaces.CompletionChoice, []interfaces.Citation, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(statement) == 0 {
+		klog.V(1).Infof("statement is empty\n")
+		return nil, nil, interfaces.ErrInvalidInput
+	}
+	if len(p.model) == 0 {
+		klog.V(1).Infof("Init has not been called\n")
+		return nil, nil, interfaces.ErrInvalidInput
+	}
+
+	klog.V(6).Infof("retrieval.Query ENTER\n")
+	klog.V(5).Infof("statement: %s\n", statement)
+
+	matches, err := p.store.Retrieve(ctx, statement, p.topK)
+	if err != nil {
+		klog.V(1).Infof("Retrieve error: %v\n", err)
+		klog.V(6).Infof("retrieval.Query LEAVE\n")
+		return nil, nil, err
+	}
+
+	citations := make([]interfaces.Citation, 0, len(matches))
+	for i, match := range matches {
+		citations = append(citations, interfaces.Citation{
+			Index:      i + 1,
+			DocumentID: match.DocumentID,
+			ChunkID:    match.ID,
+			Title:      match.Title,
+			Source:     match.Source,
+			Text:       match.Text,
+			Score:      match.Score,
+		})
+	}
+
+	convo := make([]openai.ChatCompletionMessage, 0, len(p.conversation)+2)
+	convo = append(convo, p.conversation...)
+	if len(citations) > 0 {
+		convo = append(convo, openai.ChatCompletionMessage{
+			Role:    openai.ChatMessageRoleSystem,
+			Content: sourcesPrompt(citations),
+		})
+	}
+	question := openai.ChatCompletionMessage{
+		Role:    openai.ChatMessageRoleUser,
+		Content: statement,
+	}
+	convo = append(convo, question)
+
+	request := openai.ChatCompletionRequest{
+		Model:    p.model,
+		Messages: convo,
+	}
+
+	response, err := p.client.CreateChatCompletion(ctx, request)
+	if err != nil {
+		klog.V(1).Infof("CreateChatCompletion error: %v\n", err)
+		klog.V(6).Infof("retrieval.Query LEAVE\n")
+		return nil, nil, err
+	}
+
+	// housekeeping
+	p.conversation = append(p.conversation, question)
+	p.request = &request
+	p.response = &response
+
+	if len(response.Choices) > 0 {
+		p.conversation = append(p.conversation, response.Choices[0].Message)
+	}
+
+	klog.V(4).Infof("retrieval.Query Succeeded\n")
+	klog.V(6).Infof("retrieval.Query LEAVE\n")
+
+	return *utils.ConvertChatCompletionChoices(response.Choices), citations, nil
+}
+
+func sourcesPrompt(citations []interfaces.Citation) string {
+	var sb strings.Builder
+	sb.WriteString(interfaces.RetrievalPrompt)
+	sb.WriteString("\n")
+
+	for _, citation := range citations {
+		sb.WriteString(fmt.Sprintf("\n[%d]", citation.Index))
+		if len(citation.Title) > 0 {
+			sb.WriteString(" " + citation.Title)
+		}
+		if len(citation.Source) > 0 && citation.Source != citation.Title {
+			sb.WriteString(" (" + citation.Source + ")")
+		}
+		sb.WriteString("\n")
+		sb.WriteString(citation.Text)
+		sb.WriteString("\n")
+	}
+
+	return sb.String()
+}
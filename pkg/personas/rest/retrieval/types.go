@@ -0,0 +1,34 @@
+// Copyright 2023 dvonthenen ChatGPT Proxy contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache License 2.0
+
+package retrieval
+
+import (
+	"sync"
+
+	openai "github.com/sashabaranov/go-openai"
+
+	interfaces "github.com/dvonthenen/chat-gpeasy/pkg/personas/interfaces"
+	rag "github.com/dvonthenen/chat-gpeasy/pkg/rag"
+)
+
+type Persona struct {
+	// openai
+	client *openai.Client
+
+	// retrieval
+	store *rag.Store
+	topK  int
+
+	// options
+	model string
+	level interfaces.SkillType
+
+	// last query
+	conversation []openai.ChatCompletionMessage
+	request      *openai.ChatCompletionRequest
+	response     *openai.ChatCompletionResponse
+
+	// housekeeping
+	mu sync.Mutex
+}
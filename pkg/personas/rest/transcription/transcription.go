@@ -0,0 +1,115 @@
+// Copyright 2023 dvonthenen ChatGPT Proxy contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache License 2.0
+
+package transcription
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+
+	openai "github.com/sashabaranov/go-openai"
+	klog "k8s.io/klog/v2"
+
+	audio "github.com/dvonthenen/chat-gpeasy/pkg/audio"
+	interfaces "github.com/dvonthenen/chat-gpeasy/pkg/personas/interfaces"
+)
+
+func New(client *openai.Client, options audio.TranscriberOptions) (*Persona, error) {
+	if client == nil {
+		return nil, interfaces.ErrInvalidInput
+	}
+
+	transcriber, err := audio.New(client, options)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Persona{
+		transcriber: transcriber,
+		model:       openai.Whisper1,
+	}, nil
+}
+
+// Init sets the model and the spoken language. An empty language lets the
+// model detect it.
+func (p *Persona) Init(model, language string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(model) == 0 {
+		model = openai.Whisper1
+	}
+	p.model = model
+	p.language = language
+
+	return nil
+}
+
+// Transcribe returns the text in format (json, text, srt, verbose_json or vtt).
+// WAV and PCM input over the upload limit is split at pauses.
+func (p *Persona) Transcribe(ctx context.Context, filename string, r io.Reader, format string) (string, error) {
+	return p.run(ctx, filename, r, format, false)
+}
+
+func (p *Persona) TranscribeFile(ctx context.Context, path, format string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		klog.V(1).Infof("os.Open failed. Err: %v\n", err)
+		return "", err
+	}
+	defer file.Close()
+
+	return p.run(ctx, filepath.Base(path), file, format, false)
+}
+
+// Translate returns English text in format
+func (p *Persona) Translate(ctx context.Context, filename string, r io.Reader, format string) (string, error) {
+	return p.run(ctx, filename, r, format, true)
+}
+
+func (p *Persona) run(ctx context.Context, filename string, r io.Reader, format string, translate bool) (string, error) {
+	if r == nil {
+		klog.V(1).Infof("reader is nil\n")
+		return "", interfaces.ErrInvalidInput
+	}
+	if !audio.SupportedFormat(openai.AudioResponseFormat(format)) {
+		klog.V(1).Infof("unsupported format: %s\n", format)
+		return "", audio.ErrUnsupportedFormat
+	}
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		klog.V(1).Infof("io.ReadAll failed. Err: %v\n", err)
+		return "", err
+	}
+
+	p.mu.Lock()
+	request := audio.Request{
+		Name:     filename,
+		Data:     data,
+		Model:    p.model,
+		Language: p.language,
+	}
+	p.mu.Unlock()
+
+	var transcript *audio.Transcript
+	if translate {
+		transcript, err = p.transcriber.Translate(ctx, request)
+	} else {
+		transcript, err = p.transcriber.Transcribe(ctx, request)
+	}
+	if err != nil {
+		klog.V(1).Infof("transcriber failed. Err: %v\n", err)
+		return "", err
+	}
+
+	body, _, err := transcript.Render(openai.AudioResponseFormat(format))
+	if err != nil {
+		klog.V(1).Infof("transcript.Render failed. Err: %v\n", err)
+		return "", err
+	}
+
+	return string(body), nil
+}
@@ -0,0 +1,22 @@
+// Copyright 2023 dvonthenen ChatGPT Proxy contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache License 2.0
+
+package transcription
+
+import (
+	"sync"
+
+	audio "github.com/dvonthenen/chat-gpeasy/pkg/audio"
+)
+
+type Persona struct {
+	// long audio
+	transcriber *audio.Transcriber
+
+	// options
+	model    string
+	language string
+
+	// housekeeping
+	mu sync.Mutex
+}
@@ -0,0 +1,40 @@
+// Copyright 2023 dvonthenen ChatGPT Proxy contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache License 2.0
+
+package schema
+
+import (
+	"errors"
+)
+
+const (
+	// JSON schema types
+	TypeObject  string = "object"
+	TypeArray   string = "array"
+	TypeString  string = "string"
+	TypeInteger string = "integer"
+	TypeNumber  string = "number"
+	TypeBoolean string = "boolean"
+
+	// struct tags read when generating a schema
+	TagJSON        string = "json"
+	TagDescription string = "description"
+	TagSchema      string = "jsonschema"
+
+	// MaxErrors is the most validation errors reported for a value
+	MaxErrors int = 20
+)
+
+var (
+	// ErrInvalidInput required input was not found
+	ErrInvalidInput = errors.New("required input was not found")
+
+	// ErrUnsupportedType the Go type has no JSON schema, ie a channel or func
+	ErrUnsupportedType = errors.New("unsupported type")
+
+	// ErrInvalidTag a jsonschema tag could not be parsed
+	ErrInvalidTag = errors.New("invalid jsonschema tag")
+
+	// ErrNoJSON the text does not contain a JSON value
+	ErrNoJSON = errors.New("no JSON value found")
+)
@@ -0,0 +1,48 @@
+// Copyright 2023 dvonthenen ChatGPT Proxy contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache License 2.0
+
+package schema
+
+import (
+	"encoding/json"
+	"regexp"
+	"strings"
+)
+
+// fenced code blocks, models often wrap JSON in one
+var fencedBlock = regexp.MustCompile("(?s)```[a-zA-Z]*\\s*\\n(.*?)```")
+
+// Extract returns the first JSON value in a reply that could match the schema:
+// an object for object schemas, an array for array schemas. Fenced code blocks
+// are searched before the rest of the text.
+func (s *Schema) Extract(text string) (json.RawMessage, error) {
+	starts := "{["
+	switch s.Type {
+	case TypeObject:
+		starts = "{"
+	case TypeArray:
+		starts = "["
+	}
+
+	var candidates []string
+	for _, block := range fencedBlock.FindAllStringSubmatch(text, -1) {
+		candidates = append(candidates, block[1])
+	}
+	candidates = append(candidates, text)
+
+	for _, candidate := range candidates {
+		for i := 0; i < len(candidate); i++ {
+			if !strings.ContainsRune(starts, rune(candidate[i])) {
+				continue
+			}
+
+			var raw json.RawMessage
+			decoder := json.NewDecoder(strings.NewReader(candidate[i:]))
+			if err := decoder.Decode(&raw); err == nil {
+				return raw, nil
+			}
+		}
+	}
+
+	return nil, ErrNoJSON
+}
@@ -0,0 +1,251 @@
+// Copyright 2023 dvonthenen ChatGPT Proxy contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache License 2.0
+
+package schema
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	klog "k8s.io/klog/v2"
+)
+
+var (
+	timeType = reflect.TypeOf(time.Time{})
+	rawType  = reflect.TypeOf(json.RawMessage{})
+)
+
+// Generate returns the schema of the value's type, usually a pointer to the
+// struct a reply is decoded into.
+//
+// Property names follow the json tag. Fields are required unless tagged
+// omitempty. A description tag describes the field to the model and a
+// jsonschema tag adds constraints, ie `jsonschema:"enum=low|high"` or
+// `jsonschema:"minimum=1,maximum=5,optional"`. The keys are required,
+// optional, enum, format, minimum, maximum, minLength, maxLength, minItems
+// and maxItems.
+func Generate(v interface{}) (*Schema, error) {
+	if v == nil {
+		return nil, ErrInvalidInput
+	}
+	return generate(reflect.TypeOf(v), make(map[reflect.Type]bool))
+}
+
+func generate(t reflect.Type, seen map[reflect.Type]bool) (*Schema, error) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch t {
+	case timeType:
+		return &Schema{Type: TypeString, Format: "date-time"}, nil
+	case rawType:
+		return &Schema{}, nil
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return &Schema{Type: TypeBoolean}, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return &Schema{Type: TypeInteger}, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		zero := float64(0)
+		return &Schema{Type: TypeInteger, Minimum: &zero}, nil
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: TypeNumber}, nil
+	case reflect.String:
+		return &Schema{Type: TypeString}, nil
+	case reflect.Interface:
+		return &Schema{}, nil
+	case reflect.Slice, reflect.Array:
+		// encoding/json writes []byte as a base64 string
+		if t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8 {
+			return &Schema{Type: TypeString}, nil
+		}
+		items, err := generate(t.Elem(), seen)
+		if err != nil {
+			return nil, err
+		}
+		return &Schema{Type: TypeArray, Items: items}, nil
+	case reflect.Map:
+		switch t.Key().Kind() {
+		case reflect.String, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		default:
+			return nil, fmt.Errorf("%w: %s", ErrUnsupportedType, t)
+		}
+		values, err := generate(t.Elem(), seen)
+		if err != nil {
+			return nil, err
+		}
+		return &Schema{Type: TypeObject, AdditionalProperties: values}, nil
+	case reflect.Struct:
+		// a recursive type is described down to the first repeat
+		if seen[t] {
+			return &Schema{Type: TypeObject}, nil
+		}
+		seen[t] = true
+		defer delete(seen, t)
+
+		s := &Schema{
+			Type:                 TypeObject,
+			Properties:           make(map[string]*Schema),
+			AdditionalProperties: false,
+		}
+		if err := s.addFields(t, seen); err != nil {
+			return nil, err
+		}
+		return s, nil
+	}
+
+	return nil, fmt.Errorf("%w: %s", ErrUnsupportedType, t)
+}
+
+// addFields adds the fields of a struct, and of the structs it embeds, as
+// properties
+func (s *Schema) addFields(t reflect.Type, seen map[reflect.Type]bool) error {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+
+		tag := field.Tag.Get(TagJSON)
+		if tag == "-" {
+			continue
+		}
+		name, options := parseJSONTag(tag)
+
+		if field.Anonymous && len(name) == 0 {
+			embedded := field.Type
+			if embedded.Kind() == reflect.Ptr {
+				embedded = embedded.Elem()
+			}
+			if embedded.Kind() == reflect.Struct {
+				if err := s.addFields(embedded, seen); err != nil {
+					return err
+				}
+				continue
+			}
+		}
+		if len(field.PkgPath) > 0 {
+			continue
+		}
+		if len(name) == 0 {
+			name = field.Name
+		}
+
+		property, err := generate(field.Type, seen)
+		if err != nil {
+			klog.V(1).Infof("field %s has no schema. Err: %v\n", field.Name, err)
+			return err
+		}
+		if options["string"] && (property.Type == TypeInteger || property.Type == TypeNumber || property.Type == TypeBoolean) {
+			property.Type = TypeString
+			property.Minimum = nil
+		}
+		property.Description = field.Tag.Get(TagDescription)
+
+		required := !options["omitempty"]
+		if err := property.applyTag(field.Tag.Get(TagSchema), &required); err != nil {
+			klog.V(1).Infof("field %s has an invalid tag. Err: %v\n", field.Name, err)
+			return fmt.Errorf("%s: %w", field.Name, err)
+		}
+
+		s.Properties[name] = property
+		if required {
+			s.Required = append(s.Required, name)
+		}
+	}
+
+	return nil
+}
+
+// applyTag adds the constraints of a jsonschema tag
+func (s *Schema) applyTag(tag string, required *bool) error {
+	if len(tag) == 0 {
+		return nil
+	}
+
+	for _, item := range strings.Split(tag, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(item), "=")
+
+		var err error
+		switch key {
+		case "required":
+			*required = true
+		case "optional":
+			*required = false
+		case "format":
+			s.Format = value
+		case "enum":
+			for _, option := range strings.Split(value, "|") {
+				var converted interface{}
+				converted, err = s.convert(option)
+				if err != nil {
+					break
+				}
+				s.Enum = append(s.Enum, converted)
+			}
+		case "minimum":
+			s.Minimum, err = parseFloat(value)
+		case "maximum":
+			s.Maximum, err = parseFloat(value)
+		case "minLength":
+			s.MinLength, err = parseInt(value)
+		case "maxLength":
+			s.MaxLength, err = parseInt(value)
+		case "minItems":
+			s.MinItems, err = parseInt(value)
+		case "maxItems":
+			s.MaxItems, err = parseInt(value)
+		default:
+			return fmt.Errorf("%w: unknown key %q", ErrInvalidTag, key)
+		}
+		if err != nil {
+			return fmt.Errorf("%w: %s: %v", ErrInvalidTag, key, err)
+		}
+	}
+
+	return nil
+}
+
+// convert parses an enum option as a value of the schema's type
+func (s *Schema) convert(option string) (interface{}, error) {
+	switch s.Type {
+	case TypeInteger:
+		return strconv.ParseInt(option, 10, 64)
+	case TypeNumber:
+		return strconv.ParseFloat(option, 64)
+	case TypeBoolean:
+		return strconv.ParseBool(option)
+	}
+	return option, nil
+}
+
+// parseJSONTag returns the name and options of a json struct tag
+func parseJSONTag(tag string) (string, map[string]bool) {
+	parts := strings.Split(tag, ",")
+	options := make(map[string]bool)
+	for _, option := range parts[1:] {
+		options[option] = true
+	}
+	return parts[0], options
+}
+
+func parseFloat(value string) (*float64, error) {
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return nil, err
+	}
+	return &f, nil
+}
+
+func parseInt(value string) (*int, error) {
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		return nil, err
+	}
+	return &i, nil
+}
@@ -0,0 +1,169 @@
+// Copyright 2023 dvonthenen ChatGPT Proxy contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache License 2.0
+
+package schema
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+	"unicode/utf8"
+)
+
+// Validate checks a value decoded by encoding/json into an interface{} against
+// the schema. It returns one message per problem, at most MaxErrors, and none
+// when the value is valid.
+func (s *Schema) Validate(value interface{}) []string {
+	var errs []string
+	s.validate("$", value, &errs)
+	return errs
+}
+
+func (s *Schema) validate(path string, value interface{}, errs *[]string) {
+	if len(*errs) >= MaxErrors {
+		return
+	}
+	report := func(format string, args ...interface{}) {
+		if len(*errs) < MaxErrors {
+			*errs = append(*errs, path+": "+fmt.Sprintf(format, args...))
+		}
+	}
+
+	if value == nil {
+		if len(s.Type) > 0 {
+			report("expected %s, got null", s.Type)
+		}
+		return
+	}
+
+	switch s.Type {
+	case TypeObject:
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			report("expected object, got %s", typeName(value))
+			return
+		}
+		s.validateObject(path, object, errs)
+	case TypeArray:
+		array, ok := value.([]interface{})
+		if !ok {
+			report("expected array, got %s", typeName(value))
+			return
+		}
+		if s.MinItems != nil && len(array) < *s.MinItems {
+			report("expected at least %d items, got %d", *s.MinItems, len(array))
+		}
+		if s.MaxItems != nil && len(array) > *s.MaxItems {
+			report("expected at most %d items, got %d", *s.MaxItems, len(array))
+		}
+		if s.Items != nil {
+			for i, item := range array {
+				s.Items.validate(fmt.Sprintf("%s[%d]", path, i), item, errs)
+			}
+		}
+	case TypeString:
+		str, ok := value.(string)
+		if !ok {
+			report("expected string, got %s", typeName(value))
+			return
+		}
+		length := utf8.RuneCountInString(str)
+		if s.MinLength != nil && length < *s.MinLength {
+			report("expected at least %d characters, got %d", *s.MinLength, length)
+		}
+		if s.MaxLength != nil && length > *s.MaxLength {
+			report("expected at most %d characters, got %d", *s.MaxLength, length)
+		}
+		if s.Format == "date-time" {
+			if _, err := time.Parse(time.RFC3339, str); err != nil {
+				report("expected an RFC 3339 date-time, got %q", str)
+			}
+		}
+	case TypeInteger, TypeNumber:
+		number, ok := value.(float64)
+		if !ok {
+			report("expected %s, got %s", s.Type, typeName(value))
+			return
+		}
+		if s.Type == TypeInteger && number != math.Trunc(number) {
+			report("expected integer, got %v", number)
+		}
+		if s.Minimum != nil && number < *s.Minimum {
+			report("expected at least %v, got %v", *s.Minimum, number)
+		}
+		if s.Maximum != nil && number > *s.Maximum {
+			report("expected at most %v, got %v", *s.Maximum, number)
+		}
+	case TypeBoolean:
+		if _, ok := value.(bool); !ok {
+			report("expected boolean, got %s", typeName(value))
+			return
+		}
+	}
+
+	if len(s.Enum) > 0 {
+		for _, option := range s.Enum {
+			if fmt.Sprint(option) == fmt.Sprint(value) {
+				return
+			}
+		}
+		report("expected one of %v, got %v", s.Enum, value)
+	}
+}
+
+func (s *Schema) validateObject(path string, object map[string]interface{}, errs *[]string) {
+	required := make(map[string]bool)
+	for _, name := range s.Required {
+		required[name] = true
+		if _, ok := object[name]; !ok && len(*errs) < MaxErrors {
+			*errs = append(*errs, fmt.Sprintf("%s.%s: required property is missing", path, name))
+		}
+	}
+
+	names := make([]string, 0, len(object))
+	for name := range object {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		value := object[name]
+		if property, ok := s.Properties[name]; ok {
+			// optional properties may be null
+			if value == nil && !required[name] {
+				continue
+			}
+			property.validate(path+"."+name, value, errs)
+			continue
+		}
+
+		switch additional := s.AdditionalProperties.(type) {
+		case bool:
+			if !additional && len(*errs) < MaxErrors {
+				*errs = append(*errs, fmt.Sprintf("%s.%s: unexpected property", path, name))
+			}
+		case *Schema:
+			additional.validate(path+"."+name, value, errs)
+		}
+	}
+}
+
+// typeName is the JSON type of a decoded value
+func typeName(value interface{}) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case map[string]interface{}:
+		return TypeObject
+	case []interface{}:
+		return TypeArray
+	case string:
+		return TypeString
+	case float64:
+		return TypeNumber
+	case bool:
+		return TypeBoolean
+	}
+	return fmt.Sprintf("%T", value)
+}
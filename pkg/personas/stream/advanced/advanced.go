@@ -178,6 +178,9 @@ func (p *Persona) EditConversation(index int, statement string) (*interfaces.Str
 		Model:    p.model,
 		Messages: convo,
 	}
+	if p.requestHook != nil {
+		p.requestHook(&request)
+	}
 
 	ctx := context.Background()
 	stream, err := p.client.CreateChatCompletionStream(ctx, request)
@@ -194,10 +197,11 @@ func (p *Persona) EditConversation(index int, statement string) (*interfaces.Str
 	var cb CommitCallback
 	cb = p
 
-	scc := StreamingChatCompletion{
-		stopChan: make(chan struct{}),
+	scc := &StreamingChatCompletion{
 		stream:   stream,
 		callback: &cb,
+		stopChan: make(chan struct{}),
+		doneChan: make(chan struct{}),
 	}
 
 	var streamingcompletion interfaces.StreamingCompletion
@@ -241,11 +245,16 @@ func (p *Persona) Query(ctx context.Context, statement string) (*interfaces.Stre
 		Content: statement,
 	})
 
-	stream, err := p.client.CreateChatCompletionStream(ctx, openai.ChatCompletionRequest{
-		Model:    openai.GPT3Dot5Turbo,
+	request := openai.ChatCompletionRequest{
+		Model:    p.model,
 		Messages: convo,
 		Stream:   true,
-	})
+	}
+	if p.requestHook != nil {
+		p.requestHook(&request)
+	}
+
+	stream, err := p.client.CreateChatCompletionStream(ctx, request)
 	if err != nil {
 		klog.V(1).Infof("CreateChatCompletionStream failed. Err: %v\n", err)
 		return nil, err
@@ -258,10 +267,11 @@ func (p *Persona) Query(ctx context.Context, statement string) (*interfaces.Stre
 	var cb CommitCallback
 	cb = p
 
-	scc := StreamingChatCompletion{
-		stopChan: make(chan struct{}),
+	scc := &StreamingChatCompletion{
 		stream:   stream,
 		callback: &cb,
+		stopChan: make(chan struct{}),
+		doneChan: make(chan struct{}),
 	}
 
 	var streamingcompletion interfaces.StreamingCompletion
@@ -340,3 +350,13 @@ func (p *Persona) CommitResponse(response string) error {
 	klog.V(6).Infof("advanced.CommitResponse LEAVE\n")
 	return nil
 }
+
+// SetRequestHook sets a function that may rewrite each request before it is
+// sent, nil removes it. The hook runs while the persona is locked, so it must
+// not call the persona.
+func (p *Persona) SetRequestHook(hook interfaces.RequestHook) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.requestHook = hook
+}
@@ -9,56 +9,88 @@ import (
 	klog "k8s.io/klog/v2"
 )
 
-func (scc StreamingChatCompletion) Stream(w io.Writer) error {
-	scc.sb.Reset()
+func (scc *StreamingChatCompletion) Stream(w io.Writer) error {
+	if !scc.begin() {
+		klog.V(1).Infof("stream was already streamed or closed\n")
+		return nil
+	}
+	defer scc.finish()
 
 	for {
-		exit := false
 		select {
 		case <-scc.stopChan:
 			return nil
 		default:
-			response, err := scc.stream.Recv()
-			if err == io.EOF {
-				klog.V(7).Infof("sc.stream.Recv() finished successfully\n")
-				exit = true
-				break
-			}
-			if err != nil {
-				klog.V(1).Infof("sc.stream.Recv() failed. Err: %v\n", err)
-				return err
-			}
+		}
 
-			if len(response.Choices) == 0 {
-				continue
+		response, err := scc.stream.Recv()
+		if err == io.EOF {
+			klog.V(7).Infof("sc.stream.Recv() finished successfully\n")
+			break
+		}
+		if err != nil {
+			select {
+			case <-scc.stopChan:
+				klog.V(3).Infof("stream closed while receiving\n")
+				return nil
+			default:
 			}
+			klog.V(1).Infof("sc.stream.Recv() failed. Err: %v\n", err)
+			return err
+		}
 
-			sentence := response.Choices[0].Delta.Content
-			klog.V(7).Infof("sentence to pass to w.Write: %s\n", sentence)
-			scc.sb.WriteString(sentence)
-
-			byteCount, err := w.Write([]byte(sentence))
-			if err != nil {
-				klog.V(1).Infof("w.Write failed. Err: %v\n", err)
-				return err
-			}
-			klog.V(7).Infof("io.Writer succeeded. Bytes written: %d\n", byteCount)
+		if len(response.Choices) == 0 {
+			continue
 		}
 
-		if exit {
-			break
+		sentence := response.Choices[0].Delta.Content
+		klog.V(7).Infof("sentence to pass to w.Write: %s\n", sentence)
+		scc.sb.WriteString(sentence)
+
+		byteCount, err := w.Write([]byte(sentence))
+		if err != nil {
+			klog.V(1).Infof("w.Write failed. Err: %v\n", err)
+			return err
 		}
+		klog.V(7).Infof("io.Writer succeeded. Bytes written: %d\n", byteCount)
 	}
 
 	klog.V(5).Infof("Stream result: %s\n", scc.sb.String())
-	(*scc.callback).CommitResponse(scc.sb.String())
-	close(scc.stopChan) // stop the stream explicit so close() can return
 
 	return nil
 }
 
-func (scc StreamingChatCompletion) Close() error {
+// Close stops the stream. It can be called from another goroutine while Stream
+// is running, the part of the response received so far is then committed.
+func (scc *StreamingChatCompletion) Close() error {
+	scc.stopOnce.Do(func() {
+		close(scc.stopChan)
+	})
 	scc.stream.Close()
-	<-scc.stopChan
+
+	// never streamed, commit the empty response so the conversation can go on
+	if scc.begin() {
+		scc.finish()
+	}
+	<-scc.doneChan
+
 	return nil
 }
+
+// begin claims the stream, it returns false when it was streamed or closed before
+func (scc *StreamingChatCompletion) begin() bool {
+	scc.mu.Lock()
+	defer scc.mu.Unlock()
+
+	if scc.started {
+		return false
+	}
+	scc.started = true
+	return true
+}
+
+// finish commits the response and releases Close
+func (scc *StreamingChatCompletion) finish() {
+	(*scc.callback).CommitResponse(scc.sb.String())
+	close(scc.doneChan)
+}
@@ -19,8 +19,14 @@ type CommitCallback interface {
 type StreamingChatCompletion struct {
 	sb       strings.Builder
 	stream   *openai.ChatCompletionStream
-	stopChan chan struct{}
 	callback *CommitCallback
+
+	// housekeeping
+	stopChan chan struct{} // closed by Close
+	doneChan chan struct{} // closed once the response is committed
+	stopOnce sync.Once
+	started  bool
+	mu       sync.Mutex
 }
 
 type Persona struct {
@@ -37,6 +43,9 @@ type Persona struct {
 	request          *openai.ChatCompletionRequest
 	response         *openai.ChatCompletionResponse
 
+	// rewrites requests before they are sent
+	requestHook interfaces.RequestHook
+
 	// housekeeping
 	mu sync.Mutex
 }
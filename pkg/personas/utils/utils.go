@@ -16,6 +16,12 @@ func ConvertCompletionMessage(message interfaces.CompletionMessage) *openai.Chat
 		Content: message.Content,
 		Name:    message.Name,
 	}
+	if message.FunctionCall != nil {
+		converted.FunctionCall = &openai.FunctionCall{
+			Name:      message.FunctionCall.Name,
+			Arguments: message.FunctionCall.Arguments,
+		}
+	}
 	return &converted
 }
 
@@ -34,6 +40,12 @@ func ConvertChatCompletionMessage(message openai.ChatCompletionMessage) *interfa
 		Content: message.Content,
 		Name:    message.Name,
 	}
+	if message.FunctionCall != nil {
+		cm.FunctionCall = &interfaces.FunctionCall{
+			Name:      message.FunctionCall.Name,
+			Arguments: message.FunctionCall.Arguments,
+		}
+	}
 	return &cm
 }
 
@@ -80,3 +92,13 @@ func ConvertChatCompletionChoices(choices []openai.ChatCompletionChoice) *[]inte
 	}
 	return &converted
 }
+
+// Function: Chat-GPeasy to OpenAI
+func ConvertFunction(function interfaces.Function) *openai.FunctionDefinition {
+	converted := openai.FunctionDefinition{
+		Name:        function.Name,
+		Description: function.Description,
+		Parameters:  function.Parameters,
+	}
+	return &converted
+}
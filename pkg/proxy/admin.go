@@ -0,0 +1,60 @@
+// Copyright 2023 dvonthenen ChatGPT Proxy contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache License 2.0
+
+package proxy
+
+import (
+	"crypto/subtle"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	klog "k8s.io/klog/v2"
+)
+
+// handleAdmin registers an admin route, only admins get past requireAdmin
+func (p *ChatGPTProxy) handleAdmin(router *gin.Engine, method, path string, handler gin.HandlerFunc) {
+	p.adminRoutes[method+" "+path] = true
+	router.Handle(method, path, p.requireAdmin, handler)
+}
+
+// requireAdmin is the middleware of the admin routes. Every request is refused
+// until AdminOptions names admin keys or policies.
+func (p *ChatGPTProxy) requireAdmin(c *gin.Context) {
+	admin := p.options.Admin
+	if admin == nil || (len(admin.Keys) == 0 && len(admin.AuthPolicies) == 0) {
+		klog.V(3).Infof("admin route %s refused, admin access is not configured\n", c.FullPath())
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"message": ErrAdminNotConfigured.Error()})
+		return
+	}
+
+	if p.isAdminKey(bearerToken(c)) {
+		c.Next()
+		return
+	}
+	if caller := identity(c); caller != nil {
+		for _, name := range admin.AuthPolicies {
+			for _, matched := range caller.Policies {
+				if name == matched {
+					c.Next()
+					return
+				}
+			}
+		}
+	}
+
+	klog.V(3).Infof("admin route %s refused for %s\n", c.FullPath(), callerUser(c))
+	c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"message": ErrAdminRequired.Error()})
+}
+
+// isAdminKey returns true when the token is one of the admin keys
+func (p *ChatGPTProxy) isAdminKey(token string) bool {
+	if p.options.Admin == nil || len(token) == 0 {
+		return false
+	}
+	for _, key := range p.options.Admin.Keys {
+		if len(key) > 0 && subtle.ConstantTimeCompare([]byte(token), []byte(key)) == 1 {
+			return true
+		}
+	}
+	return false
+}
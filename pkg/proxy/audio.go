@@ -0,0 +1,95 @@
+// Copyright 2023 dvonthenen ChatGPT Proxy contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache License 2.0
+
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	openai "github.com/sashabaranov/go-openai"
+	klog "k8s.io/klog/v2"
+
+	audio "github.com/dvonthenen/chat-gpeasy/pkg/audio"
+)
+
+// bindAudioRequest reads the OpenAI multipart form. A JSON body naming a file
+// on the proxy host is still accepted.
+func bindAudioRequest(c *gin.Context) (*audio.Request, *openai.AudioRequest, error) {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, defaultMaxAudioBytes)
+
+	var audioRequest openai.AudioRequest
+	var data []byte
+
+	if strings.HasPrefix(c.ContentType(), gin.MIMEMultipartPOSTForm) {
+		header, err := c.FormFile("file")
+		if err != nil {
+			klog.V(1).Infof("FormFile failed. Err: %v\n", err)
+			return nil, nil, err
+		}
+
+		file, err := header.Open()
+		if err != nil {
+			klog.V(1).Infof("header.Open failed. Err: %v\n", err)
+			return nil, nil, err
+		}
+		defer file.Close()
+
+		data, err = io.ReadAll(file)
+		if err != nil {
+			klog.V(1).Infof("io.ReadAll failed. Err: %v\n", err)
+			return nil, nil, err
+		}
+
+		audioRequest.FilePath = header.Filename
+		audioRequest.Model = c.PostForm("model")
+		audioRequest.Prompt = c.PostForm("prompt")
+		audioRequest.Language = c.PostForm("language")
+		audioRequest.Format = openai.AudioResponseFormat(c.PostForm("response_format"))
+		if value := c.PostForm("temperature"); len(value) > 0 {
+			temperature, err := strconv.ParseFloat(value, 32)
+			if err != nil {
+				klog.V(1).Infof("invalid temperature: %s\n", value)
+				return nil, nil, err
+			}
+			audioRequest.Temperature = float32(temperature)
+		}
+	} else {
+		if err := c.BindJSON(&audioRequest); err != nil {
+			klog.V(1).Infof("BindJSON failed. Err: %v\n", err)
+			return nil, nil, err
+		}
+
+		var err error
+		data, err = os.ReadFile(audioRequest.FilePath)
+		if err != nil {
+			klog.V(1).Infof("os.ReadFile failed. Err: %v\n", err)
+			return nil, nil, err
+		}
+		audioRequest.FilePath = filepath.Base(audioRequest.FilePath)
+	}
+
+	if !audio.SupportedFormat(audioRequest.Format) {
+		klog.V(1).Infof("unsupported response format: %s\n", audioRequest.Format)
+		return nil, nil, audio.ErrUnsupportedFormat
+	}
+	if len(audioRequest.FilePath) == 0 {
+		audioRequest.FilePath = defaultAudioFilename
+	}
+
+	request := &audio.Request{
+		Name:        audioRequest.FilePath,
+		Data:        data,
+		Model:       audioRequest.Model,
+		Prompt:      audioRequest.Prompt,
+		Language:    audioRequest.Language,
+		Temperature: audioRequest.Temperature,
+	}
+
+	return request, &audioRequest, nil
+}
@@ -0,0 +1,130 @@
+// Copyright 2023 dvonthenen ChatGPT Proxy contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache License 2.0
+
+package proxy
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	openai "github.com/sashabaranov/go-openai"
+	klog "k8s.io/klog/v2"
+
+	auth "github.com/dvonthenen/chat-gpeasy/pkg/proxy/auth"
+	interfaces "github.com/dvonthenen/chat-gpeasy/pkg/proxy/interfaces"
+)
+
+// authenticate is the middleware that validates the caller's bearer JWT and
+// counts the request against its quota
+func (p *ChatGPTProxy) authenticate(c *gin.Context) {
+	if p.auth.IsPublic(c.FullPath()) {
+		c.Next()
+		return
+	}
+	if p.adminRoutes[c.Request.Method+" "+c.FullPath()] && p.isAdminKey(bearerToken(c)) {
+		c.Next()
+		return
+	}
+
+	identity, err := p.auth.Authenticate(bearerToken(c))
+	if err != nil {
+		klog.V(3).Infof("authentication failed for %s. Err: %v\n", c.FullPath(), err)
+		c.Header(HeaderWWWAuthenticate, authChallenge)
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"message": err.Error()})
+		return
+	}
+
+	if err := p.auth.Consume(identity); err != nil {
+		var quotaErr *auth.QuotaError
+		if !errors.As(err, &quotaErr) {
+			klog.V(1).Infof("auth.Consume failed. Err: %v\n", err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+			return
+		}
+		c.Header(HeaderRetryAfter, strconv.Itoa(int(math.Ceil(quotaErr.RetryAfter.Seconds()))))
+		c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"message": err.Error()})
+		return
+	}
+
+	c.Set(contextKeyIdentity, identity)
+	c.Next()
+}
+
+// identity returns the authenticated caller, nil without authentication
+func identity(c *gin.Context) *interfaces.Identity {
+	value, ok := c.Get(contextKeyIdentity)
+	if !ok {
+		return nil
+	}
+	identity, _ := value.(*interfaces.Identity)
+	return identity
+}
+
+// allowModel writes a 403 and returns false when the caller's policies do not
+// allow the model
+func (p *ChatGPTProxy) allowModel(c *gin.Context, model string) bool {
+	if err := p.checkModel(c, model); err != nil {
+		c.IndentedJSON(http.StatusForbidden, gin.H{"message": err.Error()})
+		return false
+	}
+	return true
+}
+
+// checkModel returns an error when the caller's policies do not allow the model
+func (p *ChatGPTProxy) checkModel(c *gin.Context, model string) error {
+	return p.checkModelFor(identity(c), model)
+}
+
+// checkModelFor is checkModel for an identity, nil without authentication
+func (p *ChatGPTProxy) checkModelFor(caller *interfaces.Identity, model string) error {
+	if p.auth == nil || caller == nil {
+		return nil
+	}
+	return p.auth.AllowModel(caller, model)
+}
+
+// applySystemPrompt puts the system prompt of the caller's policies in front of
+// the chat messages
+func (p *ChatGPTProxy) applySystemPrompt(c *gin.Context, request *openai.ChatCompletionRequest) {
+	p.applySystemPromptFor(identity(c), request)
+}
+
+// applySystemPromptFor is applySystemPrompt for an identity, nil without
+// authentication
+func (p *ChatGPTProxy) applySystemPromptFor(caller *interfaces.Identity, request *openai.ChatCompletionRequest) {
+	if p.auth == nil || caller == nil {
+		return
+	}
+	prompt := p.auth.SystemPrompt(caller)
+	if len(prompt) == 0 {
+		return
+	}
+
+	messages := make([]openai.ChatCompletionMessage, 0, len(request.Messages)+1)
+	messages = append(messages, openai.ChatCompletionMessage{
+		Role:    openai.ChatMessageRoleSystem,
+		Content: prompt,
+	})
+	request.Messages = append(messages, request.Messages...)
+}
+
+// bearerToken returns the token of the Authorization header
+func bearerToken(c *gin.Context) string {
+	header := c.GetHeader(HeaderAuthorization)
+	if len(header) > len(bearerPrefix) && strings.EqualFold(header[:len(bearerPrefix)], bearerPrefix) {
+		return strings.TrimSpace(header[len(bearerPrefix):])
+	}
+	return ""
+}
+
+// editsModel is the model of an edits request, empty when not set
+func editsModel(request openai.EditsRequest) string {
+	if request.Model == nil {
+		return ""
+	}
+	return *request.Model
+}
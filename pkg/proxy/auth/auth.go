@@ -0,0 +1,241 @@
+// Copyright 2023 dvonthenen ChatGPT Proxy contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache License 2.0
+
+package auth
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	klog "k8s.io/klog/v2"
+
+	interfaces "github.com/dvonthenen/chat-gpeasy/pkg/proxy/interfaces"
+	storage "github.com/dvonthenen/chat-gpeasy/pkg/proxy/storage"
+)
+
+// New creates an authenticator. The key set is loaded on first use.
+func New(options AuthOptions) (*Authenticator, error) {
+	if len(options.JWKSFile) == 0 && len(options.JWKSURL) == 0 {
+		klog.V(1).Infof("JWKSFile or JWKSURL is required\n")
+		return nil, ErrInvalidInput
+	}
+	if options.CacheTTL <= 0 {
+		options.CacheTTL = DefaultCacheTTL
+	}
+	if options.MinRefresh <= 0 {
+		options.MinRefresh = DefaultMinRefresh
+	}
+	if options.Leeway <= 0 {
+		options.Leeway = DefaultLeeway
+	}
+	if options.HTTPClient == nil {
+		options.HTTPClient = &http.Client{Timeout: DefaultHTTPTimeout}
+	}
+	for i := range options.Policies {
+		policy := &options.Policies[i]
+		if len(policy.Claim) == 0 {
+			policy.Claim = DefaultGroupsClaimName
+		}
+		if len(policy.Name) == 0 {
+			policy.Name = fmt.Sprintf("%s=%s", policy.Claim, strings.Join(policy.Values, ","))
+		}
+		if policy.MaxRequests > 0 && policy.QuotaWindow <= 0 {
+			policy.QuotaWindow = DefaultQuotaWindow
+		}
+	}
+
+	var quotas storage.Store = storage.NewMemoryStore()
+	if options.Storage != nil {
+		quotas = options.Storage
+	}
+
+	return &Authenticator{
+		options: &options,
+		keys: &keySet{
+			file:       options.JWKSFile,
+			url:        options.JWKSURL,
+			ttl:        options.CacheTTL,
+			minRefresh: options.MinRefresh,
+			client:     options.HTTPClient,
+		},
+		quotas: quotas,
+	}, nil
+}
+
+// Authenticate validates a bearer token and returns the caller
+func (a *Authenticator) Authenticate(token string) (*interfaces.Identity, error) {
+	if len(token) == 0 {
+		return nil, ErrMissingToken
+	}
+
+	claims, err := a.verify(token)
+	if err != nil {
+		klog.V(3).Infof("token verification failed. Err: %v\n", err)
+		return nil, err
+	}
+	if err := a.validate(claims); err != nil {
+		klog.V(3).Infof("token validation failed. Err: %v\n", err)
+		return nil, err
+	}
+
+	identity := &interfaces.Identity{
+		Audience: stringValues(claims["aud"]),
+		Claims:   claims,
+	}
+	identity.Subject, _ = claims["sub"].(string)
+	identity.Issuer, _ = claims["iss"].(string)
+	identity.ExpiresAt, _ = numericDate(claims["exp"])
+
+	// quotas are counted per subject
+	if len(identity.Subject) == 0 {
+		klog.V(3).Infof("token has no subject\n")
+		return nil, ErrMissingSubject
+	}
+
+	for _, policy := range a.options.Policies {
+		if matches(policy, claims) {
+			identity.Policies = append(identity.Policies, policy.Name)
+		}
+	}
+
+	return identity, nil
+}
+
+// IsPublic returns true for routes served without a token
+func (a *Authenticator) IsPublic(route string) bool {
+	for _, public := range a.options.PublicRoutes {
+		if route == public {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowModel checks the caller's policies allow the model
+func (a *Authenticator) AllowModel(identity *interfaces.Identity, model string) error {
+	g := a.grant(identity)
+	if g.anyModel {
+		return nil
+	}
+	for _, allowed := range g.allowedModels {
+		if allowed == model {
+			return nil
+		}
+		if strings.HasSuffix(allowed, modelWildcard) && strings.HasPrefix(model, strings.TrimSuffix(allowed, modelWildcard)) {
+			return nil
+		}
+	}
+
+	klog.V(3).Infof("model %s is not allowed for %s\n", model, identity.Subject)
+	return ErrModelNotAllowed
+}
+
+// Consume counts a request against the caller's quota. A *QuotaError is
+// returned once the quota is used up.
+func (a *Authenticator) Consume(identity *interfaces.Identity) error {
+	g := a.grant(identity)
+	if g.maxRequests == 0 {
+		return nil
+	}
+
+	// the window starts with the caller's first request, when the counter is
+	// created. subjects are only unique within their issuer.
+	requests, windowEnd, err := a.quotas.Incr(quotaPrefix+identity.Issuer+"|"+identity.Subject, 1, g.quotaWindow)
+	if err != nil {
+		klog.V(1).Infof("quota Incr failed. Err: %v\n", err)
+		return err
+	}
+
+	if requests > int64(g.maxRequests) {
+		return &QuotaError{
+			Subject:    identity.Subject,
+			RetryAfter: time.Until(windowEnd),
+		}
+	}
+
+	return nil
+}
+
+// SystemPrompt returns the system prompt of the caller's policies, the first
+// matching policy that has one wins
+func (a *Authenticator) SystemPrompt(identity *interfaces.Identity) string {
+	return a.grant(identity).systemPrompt
+}
+
+// grant merges the policies the caller matched. A caller that matched none
+// gets the DefaultPolicy, without one it may not use any model.
+func (a *Authenticator) grant(identity *interfaces.Identity) grant {
+	g := grant{}
+
+	matched := 0
+	for _, policy := range a.options.Policies {
+		if !contains(identity.Policies, policy.Name) {
+			continue
+		}
+		matched++
+		g.add(policy)
+	}
+
+	if matched == 0 && a.options.DefaultPolicy != nil {
+		g.add(*a.options.DefaultPolicy)
+	}
+	if g.maxRequests == -1 {
+		g.maxRequests = 0
+	}
+
+	return g
+}
+
+// add merges a policy into the grant, the most permissive setting wins
+func (g *grant) add(policy Policy) {
+	if len(policy.AllowedModels) == 0 {
+		g.anyModel = true
+	}
+	g.allowedModels = append(g.allowedModels, policy.AllowedModels...)
+
+	// unlimited beats any quota, otherwise the higher rate wins
+	if policy.MaxRequests == 0 || g.maxRequests == -1 {
+		g.maxRequests = -1
+	} else if g.maxRequests == 0 || rate(policy.MaxRequests, policy.QuotaWindow) > rate(g.maxRequests, g.quotaWindow) {
+		g.maxRequests = policy.MaxRequests
+		g.quotaWindow = policy.QuotaWindow
+	}
+
+	if len(g.systemPrompt) == 0 {
+		g.systemPrompt = policy.SystemPrompt
+	}
+}
+
+func (e *QuotaError) Error() string {
+	return fmt.Sprintf("%v: %s, retry after %v", ErrQuotaExceeded, e.Subject, e.RetryAfter)
+}
+
+// Is lets errors.Is match ErrQuotaExceeded
+func (e *QuotaError) Is(target error) bool {
+	return target == ErrQuotaExceeded
+}
+
+// matches returns true when the policy's claim holds one of its values
+func matches(policy Policy, claims map[string]interface{}) bool {
+	for _, value := range stringValues(claims[policy.Claim]) {
+		if contains(policy.Values, value) {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func rate(requests int, window time.Duration) float64 {
+	return float64(requests) / window.Seconds()
+}
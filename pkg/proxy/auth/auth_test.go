@@ -0,0 +1,387 @@
+// Copyright 2023 dvonthenen ChatGPT Proxy contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache License 2.0
+
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const (
+	testIssuer   string = "https://idp.example.com"
+	testAudience string = "chatgpt-proxy"
+)
+
+type testKeys struct {
+	rsaKey *rsa.PrivateKey
+	ecKey  *ecdsa.PrivateKey
+	jwks   []byte
+}
+
+func newTestKeys(t *testing.T) *testKeys {
+	t.Helper()
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("rsa.GenerateKey failed. Err: %v", err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("ecdsa.GenerateKey failed. Err: %v", err)
+	}
+
+	set := jwks{
+		Keys: []jwk{
+			{
+				Kty: keyTypeRSA,
+				Kid: "rsa-1",
+				Use: keyUseSig,
+				Alg: AlgorithmRS256,
+				N:   encode(rsaKey.N.Bytes()),
+				E:   encode(big.NewInt(int64(rsaKey.E)).Bytes()),
+			},
+			{
+				Kty: keyTypeEC,
+				Kid: "ec-1",
+				Use: keyUseSig,
+				Alg: AlgorithmES256,
+				Crv: curveP256,
+				X:   encode(ecKey.X.FillBytes(make([]byte, es256KeyLen))),
+				Y:   encode(ecKey.Y.FillBytes(make([]byte, es256KeyLen))),
+			},
+		},
+	}
+	data, err := json.Marshal(set)
+	if err != nil {
+		t.Fatalf("json.Marshal failed. Err: %v", err)
+	}
+
+	return &testKeys{
+		rsaKey: rsaKey,
+		ecKey:  ecKey,
+		jwks:   data,
+	}
+}
+
+// sign builds a compact JWT with the test keys
+func (k *testKeys) sign(t *testing.T, alg, kid string, claims map[string]interface{}) string {
+	t.Helper()
+
+	hdr, _ := json.Marshal(header{Alg: alg, Kid: kid, Typ: "JWT"})
+	body, _ := json.Marshal(claims)
+	input := encode(hdr) + "." + encode(body)
+	digest := sha256.Sum256([]byte(input))
+
+	var signature []byte
+	switch alg {
+	case AlgorithmRS256:
+		sig, err := rsa.SignPKCS1v15(rand.Reader, k.rsaKey, crypto.SHA256, digest[:])
+		if err != nil {
+			t.Fatalf("rsa.SignPKCS1v15 failed. Err: %v", err)
+		}
+		signature = sig
+	case AlgorithmES256:
+		r, s, err := ecdsa.Sign(rand.Reader, k.ecKey, digest[:])
+		if err != nil {
+			t.Fatalf("ecdsa.Sign failed. Err: %v", err)
+		}
+		signature = append(r.FillBytes(make([]byte, es256KeyLen)), s.FillBytes(make([]byte, es256KeyLen))...)
+	default:
+		signature = []byte("not a signature")
+	}
+
+	return input + "." + encode(signature)
+}
+
+func (k *testKeys) file(t *testing.T) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(path, k.jwks, 0600); err != nil {
+		t.Fatalf("os.WriteFile failed. Err: %v", err)
+	}
+	return path
+}
+
+func encode(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func validClaims() map[string]interface{} {
+	return map[string]interface{}{
+		"sub":    "service-a",
+		"iss":    testIssuer,
+		"aud":    []string{testAudience},
+		"exp":    time.Now().Add(time.Hour).Unix(),
+		"groups": []string{"search", "interactive"},
+		"team":   "platform",
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	keys := newTestKeys(t)
+
+	authenticator, err := New(AuthOptions{
+		JWKSFile:  keys.file(t),
+		Issuer:    testIssuer,
+		Audiences: []string{testAudience},
+	})
+	if err != nil {
+		t.Fatalf("New failed. Err: %v", err)
+	}
+
+	with := func(key string, value interface{}) map[string]interface{} {
+		claims := validClaims()
+		claims[key] = value
+		return claims
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		err   error
+	}{
+		{"rs256", keys.sign(t, AlgorithmRS256, "rsa-1", validClaims()), nil},
+		{"es256", keys.sign(t, AlgorithmES256, "ec-1", validClaims()), nil},
+		{"single audience", keys.sign(t, AlgorithmRS256, "rsa-1", with("aud", testAudience)), nil},
+		{"missing", "", ErrMissingToken},
+		{"malformed", "not.a.jwt.at.all", ErrInvalidToken},
+		{"expired", keys.sign(t, AlgorithmRS256, "rsa-1", with("exp", time.Now().Add(-time.Hour).Unix())), ErrExpired},
+		{"not yet valid", keys.sign(t, AlgorithmRS256, "rsa-1", with("nbf", time.Now().Add(time.Hour).Unix())), ErrNotYetValid},
+		{"issuer", keys.sign(t, AlgorithmRS256, "rsa-1", with("iss", "https://evil.example.com")), ErrInvalidIssuer},
+		{"audience", keys.sign(t, AlgorithmRS256, "rsa-1", with("aud", "someone-else")), ErrInvalidAudience},
+		{"missing subject", keys.sign(t, AlgorithmRS256, "rsa-1", with("sub", "")), ErrMissingSubject},
+		{"unknown kid", keys.sign(t, AlgorithmRS256, "rsa-2", validClaims()), ErrUnknownKey},
+		{"wrong key type", keys.sign(t, AlgorithmES256, "rsa-1", validClaims()), ErrInvalidToken},
+		{"unsupported algorithm", keys.sign(t, "HS256", "rsa-1", validClaims()), ErrUnsupportedAlgorithm},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			identity, err := authenticator.Authenticate(tt.token)
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("expected %v, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Authenticate failed. Err: %v", err)
+			}
+			if identity.Subject != "service-a" || identity.Issuer != testIssuer {
+				t.Errorf("unexpected identity %+v", identity)
+			}
+		})
+	}
+}
+
+func TestAuthenticateTamperedSignature(t *testing.T) {
+	keys := newTestKeys(t)
+	other := newTestKeys(t)
+
+	authenticator, err := New(AuthOptions{JWKSFile: keys.file(t)})
+	if err != nil {
+		t.Fatalf("New failed. Err: %v", err)
+	}
+
+	// same kid, signed by a key that is not in the set
+	token := other.sign(t, AlgorithmRS256, "rsa-1", validClaims())
+	if _, err := authenticator.Authenticate(token); !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("expected %v, got %v", ErrInvalidToken, err)
+	}
+}
+
+func TestKeySetURLCaching(t *testing.T) {
+	keys := newTestKeys(t)
+
+	var fetches int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fetches, 1)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(keys.jwks)
+	}))
+	defer server.Close()
+
+	authenticator, err := New(AuthOptions{
+		JWKSURL:    server.URL,
+		MinRefresh: time.Hour,
+	})
+	if err != nil {
+		t.Fatalf("New failed. Err: %v", err)
+	}
+
+	token := keys.sign(t, AlgorithmES256, "ec-1", validClaims())
+	for i := 0; i < 3; i++ {
+		if _, err := authenticator.Authenticate(token); err != nil {
+			t.Fatalf("Authenticate failed. Err: %v", err)
+		}
+	}
+
+	// an unknown kid does not reload within MinRefresh
+	unknown := keys.sign(t, AlgorithmES256, "ec-2", validClaims())
+	if _, err := authenticator.Authenticate(unknown); !errors.Is(err, ErrUnknownKey) {
+		t.Fatalf("expected %v, got %v", ErrUnknownKey, err)
+	}
+
+	if got := atomic.LoadInt32(&fetches); got != 1 {
+		t.Errorf("expected 1 fetch, got %d", got)
+	}
+}
+
+func TestPolicies(t *testing.T) {
+	keys := newTestKeys(t)
+
+	authenticator, err := New(AuthOptions{
+		JWKSFile: keys.file(t),
+		Policies: []Policy{
+			{
+				Name:          "search",
+				Values:        []string{"search"},
+				AllowedModels: []string{"text-embedding-ada-002"},
+				MaxRequests:   2,
+				QuotaWindow:   time.Hour,
+			},
+			{
+				Name:          "platform",
+				Claim:         "team",
+				Values:        []string{"platform"},
+				AllowedModels: []string{"gpt-3.5-turbo*"},
+				MaxRequests:   3,
+				QuotaWindow:   time.Hour,
+				SystemPrompt:  "You are an internal assistant.",
+			},
+			{
+				Name:   "admins",
+				Values: []string{"admins"},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("New failed. Err: %v", err)
+	}
+
+	identity, err := authenticator.Authenticate(keys.sign(t, AlgorithmRS256, "rsa-1", validClaims()))
+	if err != nil {
+		t.Fatalf("Authenticate failed. Err: %v", err)
+	}
+	if len(identity.Policies) != 2 || identity.Policies[0] != "search" || identity.Policies[1] != "platform" {
+		t.Fatalf("unexpected policies %v", identity.Policies)
+	}
+
+	for _, model := range []string{"text-embedding-ada-002", "gpt-3.5-turbo", "gpt-3.5-turbo-16k"} {
+		if err := authenticator.AllowModel(identity, model); err != nil {
+			t.Errorf("model %s should be allowed. Err: %v", model, err)
+		}
+	}
+	if err := authenticator.AllowModel(identity, "gpt-4"); !errors.Is(err, ErrModelNotAllowed) {
+		t.Errorf("expected %v, got %v", ErrModelNotAllowed, err)
+	}
+
+	if prompt := authenticator.SystemPrompt(identity); prompt != "You are an internal assistant." {
+		t.Errorf("unexpected system prompt %q", prompt)
+	}
+
+	// the larger quota of the two policies applies
+	for i := 0; i < 3; i++ {
+		if err := authenticator.Consume(identity); err != nil {
+			t.Fatalf("request %d should be within quota. Err: %v", i, err)
+		}
+	}
+	err = authenticator.Consume(identity)
+	var quotaErr *QuotaError
+	if !errors.As(err, &quotaErr) || !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("expected a quota error, got %v", err)
+	}
+	if quotaErr.RetryAfter <= 0 || quotaErr.RetryAfter > time.Hour {
+		t.Errorf("unexpected retry after %v", quotaErr.RetryAfter)
+	}
+
+	// a policy without limits lifts them
+	claims := validClaims()
+	claims["sub"] = "admin"
+	claims["groups"] = []string{"search", "admins"}
+	admin, err := authenticator.Authenticate(keys.sign(t, AlgorithmRS256, "rsa-1", claims))
+	if err != nil {
+		t.Fatalf("Authenticate failed. Err: %v", err)
+	}
+	if err := authenticator.AllowModel(admin, "gpt-4"); err != nil {
+		t.Errorf("admins should use any model. Err: %v", err)
+	}
+	for i := 0; i < 5; i++ {
+		if err := authenticator.Consume(admin); err != nil {
+			t.Fatalf("admins should not have a quota. Err: %v", err)
+		}
+	}
+}
+
+func TestDefaultPolicy(t *testing.T) {
+	keys := newTestKeys(t)
+
+	policies := []Policy{
+		{
+			Name:   "admins",
+			Values: []string{"admins"},
+		},
+	}
+
+	// callers that match no policy are denied without a default policy
+	authenticator, err := New(AuthOptions{
+		JWKSFile: keys.file(t),
+		Policies: policies,
+	})
+	if err != nil {
+		t.Fatalf("New failed. Err: %v", err)
+	}
+	identity, err := authenticator.Authenticate(keys.sign(t, AlgorithmRS256, "rsa-1", validClaims()))
+	if err != nil {
+		t.Fatalf("Authenticate failed. Err: %v", err)
+	}
+	if len(identity.Policies) != 0 {
+		t.Fatalf("unexpected policies %v", identity.Policies)
+	}
+	if err := authenticator.AllowModel(identity, "gpt-3.5-turbo"); !errors.Is(err, ErrModelNotAllowed) {
+		t.Errorf("expected %v, got %v", ErrModelNotAllowed, err)
+	}
+
+	// the default policy applies to them instead
+	authenticator, err = New(AuthOptions{
+		JWKSFile: keys.file(t),
+		Policies: policies,
+		DefaultPolicy: &Policy{
+			AllowedModels: []string{"gpt-3.5-turbo"},
+			MaxRequests:   1,
+			QuotaWindow:   time.Hour,
+		},
+	})
+	if err != nil {
+		t.Fatalf("New failed. Err: %v", err)
+	}
+	if err := authenticator.AllowModel(identity, "gpt-3.5-turbo"); err != nil {
+		t.Errorf("default policy should allow the model. Err: %v", err)
+	}
+	if err := authenticator.AllowModel(identity, "gpt-4"); !errors.Is(err, ErrModelNotAllowed) {
+		t.Errorf("expected %v, got %v", ErrModelNotAllowed, err)
+	}
+	if err := authenticator.Consume(identity); err != nil {
+		t.Fatalf("request should be within quota. Err: %v", err)
+	}
+	if err := authenticator.Consume(identity); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("expected %v, got %v", ErrQuotaExceeded, err)
+	}
+}
@@ -0,0 +1,76 @@
+// Copyright 2023 dvonthenen ChatGPT Proxy contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache License 2.0
+
+package auth
+
+import (
+	"errors"
+	"time"
+)
+
+const (
+	DefaultCacheTTL        time.Duration = 10 * time.Minute
+	DefaultMinRefresh      time.Duration = 1 * time.Minute
+	DefaultLeeway          time.Duration = 1 * time.Minute
+	DefaultQuotaWindow     time.Duration = 1 * time.Hour
+	DefaultHTTPTimeout     time.Duration = 10 * time.Second
+	DefaultGroupsClaimName string        = "groups"
+
+	// supported signing algorithms
+	AlgorithmRS256 string = "RS256"
+	AlgorithmES256 string = "ES256"
+
+	// json web key types
+	keyTypeRSA  string = "RSA"
+	keyTypeEC   string = "EC"
+	curveP256   string = "P-256"
+	keyUseSig   string = "sig"
+	es256KeyLen int    = 32
+
+	// wildcard suffix in AllowedModels
+	modelWildcard string = "*"
+
+	// quotaPrefix keys the request count of each caller in storage
+	quotaPrefix string = "quota/"
+)
+
+var (
+	// ErrInvalidInput required input was not found
+	ErrInvalidInput = errors.New("required input was not found")
+
+	// ErrMissingToken the request has no bearer token
+	ErrMissingToken = errors.New("bearer token is missing")
+
+	// ErrInvalidToken the token is malformed or its signature does not verify
+	ErrInvalidToken = errors.New("token is invalid")
+
+	// ErrUnsupportedAlgorithm the token is not signed with RS256 or ES256
+	ErrUnsupportedAlgorithm = errors.New("token signing algorithm is not supported")
+
+	// ErrUnknownKey the key set has no key with the token's kid
+	ErrUnknownKey = errors.New("token signing key is unknown")
+
+	// ErrExpired the token has expired
+	ErrExpired = errors.New("token has expired")
+
+	// ErrNotYetValid the token is used before its nbf
+	ErrNotYetValid = errors.New("token is not valid yet")
+
+	// ErrInvalidIssuer the token was issued by someone else
+	ErrInvalidIssuer = errors.New("token issuer is not accepted")
+
+	// ErrInvalidAudience the token is meant for someone else
+	ErrInvalidAudience = errors.New("token audience is not accepted")
+
+	// ErrMissingSubject the token has no sub claim to tell callers apart
+	ErrMissingSubject = errors.New("token subject is missing")
+
+	// ErrInvalidKeySet the key set could not be loaded
+	ErrInvalidKeySet = errors.New("key set is invalid")
+
+	// ErrModelNotAllowed the caller's policies do not allow the model
+	ErrModelNotAllowed = errors.New("model is not allowed")
+
+	// ErrQuotaExceeded the caller used up its request quota
+	ErrQuotaExceeded = errors.New("request quota exceeded")
+)
@@ -0,0 +1,154 @@
+// Copyright 2023 dvonthenen ChatGPT Proxy contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache License 2.0
+
+package auth
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// verify checks the signature of a compact JWT and returns its claims
+func (a *Authenticator) verify(token string) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidToken
+	}
+
+	var hdr header
+	if err := decodeSegment(parts[0], &hdr); err != nil {
+		return nil, err
+	}
+
+	key, err := a.keys.get(hdr.Kid)
+	if err != nil {
+		return nil, err
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+
+	switch hdr.Alg {
+	case AlgorithmRS256:
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return nil, ErrInvalidToken
+		}
+		if err := rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], signature); err != nil {
+			return nil, ErrInvalidToken
+		}
+	case AlgorithmES256:
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok || len(signature) != 2*es256KeyLen {
+			return nil, ErrInvalidToken
+		}
+		r := new(big.Int).SetBytes(signature[:es256KeyLen])
+		s := new(big.Int).SetBytes(signature[es256KeyLen:])
+		if !ecdsa.Verify(pub, digest[:], r, s) {
+			return nil, ErrInvalidToken
+		}
+	default:
+		return nil, ErrUnsupportedAlgorithm
+	}
+
+	claims := make(map[string]interface{})
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, err
+	}
+
+	return claims, nil
+}
+
+// validate checks the registered claims
+func (a *Authenticator) validate(claims map[string]interface{}) error {
+	now := time.Now()
+
+	exp, ok := numericDate(claims["exp"])
+	if !ok {
+		return ErrInvalidToken
+	}
+	if now.After(exp.Add(a.options.Leeway)) {
+		return ErrExpired
+	}
+	if nbf, ok := numericDate(claims["nbf"]); ok && now.Add(a.options.Leeway).Before(nbf) {
+		return ErrNotYetValid
+	}
+
+	if len(a.options.Issuer) > 0 {
+		if iss, _ := claims["iss"].(string); iss != a.options.Issuer {
+			return ErrInvalidIssuer
+		}
+	}
+
+	if len(a.options.Audiences) > 0 {
+		accepted := false
+		for _, aud := range stringValues(claims["aud"]) {
+			for _, want := range a.options.Audiences {
+				if aud == want {
+					accepted = true
+				}
+			}
+		}
+		if !accepted {
+			return ErrInvalidAudience
+		}
+	}
+
+	return nil
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return ErrInvalidToken
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(v); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	return nil
+}
+
+// numericDate reads a NumericDate claim
+func numericDate(v interface{}) (time.Time, bool) {
+	n, ok := v.(json.Number)
+	if !ok {
+		return time.Time{}, false
+	}
+	f, err := n.Float64()
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(int64(f), 0), true
+}
+
+// stringValues reads a claim that is a string or a list of strings
+func stringValues(v interface{}) []string {
+	switch value := v.(type) {
+	case string:
+		return []string{value}
+	case []interface{}:
+		values := make([]string, 0, len(value))
+		for _, item := range value {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
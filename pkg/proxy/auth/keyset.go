@@ -0,0 +1,153 @@
+// Copyright 2023 dvonthenen ChatGPT Proxy contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache License 2.0
+
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"time"
+
+	klog "k8s.io/klog/v2"
+)
+
+// get returns the key with that kid, loading the key set when it is stale or
+// does not know the kid
+func (ks *keySet) get(kid string) (crypto.PublicKey, error) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	if ks.keys == nil || time.Since(ks.loadedAt) > ks.ttl {
+		if err := ks.load(); err != nil && ks.keys == nil {
+			return nil, err
+		}
+	}
+
+	if key, ok := ks.keys[kid]; ok {
+		return key, nil
+	}
+
+	// the provider may have rotated its keys
+	if time.Since(ks.loadedAt) > ks.minRefresh {
+		if err := ks.load(); err != nil {
+			return nil, err
+		}
+		if key, ok := ks.keys[kid]; ok {
+			return key, nil
+		}
+	}
+
+	return nil, ErrUnknownKey
+}
+
+// load reads the key set. A failed load keeps the previous keys.
+func (ks *keySet) load() error {
+	var data []byte
+	var err error
+	if len(ks.url) > 0 {
+		data, err = ks.fetch()
+	} else {
+		data, err = os.ReadFile(ks.file)
+	}
+	if err != nil {
+		klog.V(1).Infof("key set load failed. Err: %v\n", err)
+		return fmt.Errorf("%w: %v", ErrInvalidKeySet, err)
+	}
+
+	keys, err := parseKeySet(data)
+	if err != nil {
+		klog.V(1).Infof("parseKeySet failed. Err: %v\n", err)
+		return err
+	}
+
+	klog.V(4).Infof("key set loaded with %d keys\n", len(keys))
+	ks.keys = keys
+	ks.loadedAt = time.Now()
+
+	return nil
+}
+
+func (ks *keySet) fetch() ([]byte, error) {
+	resp, err := ks.client.Get(ks.url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// parseKeySet decodes the RSA and P-256 signing keys of a JWKS document, other
+// keys are skipped
+func parseKeySet(data []byte) (map[string]crypto.PublicKey, error) {
+	var set jwks
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidKeySet, err)
+	}
+
+	keys := make(map[string]crypto.PublicKey)
+	for _, k := range set.Keys {
+		if len(k.Use) > 0 && k.Use != keyUseSig {
+			continue
+		}
+
+		switch k.Kty {
+		case keyTypeRSA:
+			n, err := decodeBigInt(k.N)
+			if err != nil {
+				return nil, err
+			}
+			e, err := decodeBigInt(k.E)
+			if err != nil {
+				return nil, err
+			}
+			keys[k.Kid] = &rsa.PublicKey{N: n, E: int(e.Int64())}
+		case keyTypeEC:
+			if k.Crv != curveP256 {
+				klog.V(3).Infof("key %s skipped, curve %s is not supported\n", k.Kid, k.Crv)
+				continue
+			}
+			x, err := decodeBigInt(k.X)
+			if err != nil {
+				return nil, err
+			}
+			y, err := decodeBigInt(k.Y)
+			if err != nil {
+				return nil, err
+			}
+			key := &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}
+			if !key.Curve.IsOnCurve(x, y) {
+				return nil, fmt.Errorf("%w: key %s is not on the curve", ErrInvalidKeySet, k.Kid)
+			}
+			keys[k.Kid] = key
+		default:
+			klog.V(3).Infof("key %s skipped, type %s is not supported\n", k.Kid, k.Kty)
+		}
+	}
+
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("%w: no usable keys", ErrInvalidKeySet)
+	}
+
+	return keys, nil
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(b) == 0 {
+		return nil, fmt.Errorf("%w: bad key parameter", ErrInvalidKeySet)
+	}
+	return new(big.Int).SetBytes(b), nil
+}
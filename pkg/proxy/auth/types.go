@@ -0,0 +1,134 @@
+// Copyright 2023 dvonthenen ChatGPT Proxy contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache License 2.0
+
+package auth
+
+import (
+	"crypto"
+	"net/http"
+	"sync"
+	"time"
+
+	storage "github.com/dvonthenen/chat-gpeasy/pkg/proxy/storage"
+)
+
+// AuthOptions configures bearer JWT authentication
+type AuthOptions struct {
+	// JWKSFile or JWKSURL holds the identity provider's public keys
+	JWKSFile string
+	JWKSURL  string
+
+	// CacheTTL is how long the key set is used before it is loaded again.
+	// A token signed with an unknown key reloads it at most every MinRefresh.
+	CacheTTL   time.Duration
+	MinRefresh time.Duration
+
+	// Issuer and Audiences the token must carry, not checked when empty
+	Issuer    string
+	Audiences []string
+
+	// Leeway allowed on exp and nbf for clock skew
+	Leeway time.Duration
+
+	// Policies map claims to what the caller may do
+	Policies []Policy
+
+	// DefaultPolicy applies to callers that match none of the Policies. Without
+	// it they may not use any model. Set it to an empty Policy to allow them
+	// every model without a quota.
+	DefaultPolicy *Policy
+
+	// PublicRoutes are served without a token, for example the image files
+	// route so stored images can be embedded in web pages
+	PublicRoutes []string
+
+	HTTPClient *http.Client
+
+	// Storage keeps the quota counters, so they survive restarts. They are
+	// kept in memory when nil.
+	Storage storage.Store
+}
+
+// Policy applies to callers whose Claim holds one of Values. The claim can be
+// a string or a list of strings, like groups. When several policies match, the
+// most permissive setting of each kind wins.
+type Policy struct {
+	Name   string
+	Claim  string
+	Values []string
+
+	// AllowedModels, any model when empty. A trailing * matches a prefix.
+	AllowedModels []string
+
+	// MaxRequests per QuotaWindow and caller, unlimited when 0
+	MaxRequests int
+	QuotaWindow time.Duration
+
+	// SystemPrompt is put in front of the caller's chat messages
+	SystemPrompt string
+}
+
+// QuotaError is returned when the caller used up its quota
+type QuotaError struct {
+	Subject    string
+	RetryAfter time.Duration
+}
+
+// Authenticator validates tokens and applies policies
+type Authenticator struct {
+	options *AuthOptions
+	keys    *keySet
+
+	// request counts per caller
+	quotas storage.Store
+}
+
+// grant is the merged result of the policies that matched a caller
+type grant struct {
+	anyModel      bool
+	allowedModels []string
+	maxRequests   int
+	quotaWindow   time.Duration
+	systemPrompt  string
+}
+
+type keySet struct {
+	file       string
+	url        string
+	ttl        time.Duration
+	minRefresh time.Duration
+	client     *http.Client
+
+	keys     map[string]crypto.PublicKey
+	loadedAt time.Time
+
+	// housekeeping
+	mu sync.Mutex
+}
+
+// jwk is a single JSON web key
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+
+	// RSA
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+
+	// EC
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+type jwks struct {
+	Keys []jwk `json:"keys"`
+}
+
+type header struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	Typ string `json:"typ,omitempty"`
+}
@@ -0,0 +1,98 @@
+// Copyright 2023 dvonthenen ChatGPT Proxy contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache License 2.0
+
+package proxy
+
+import (
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// BaseCallback implements every interfaces.ChatGPTCallback method as a no-op.
+// Embed it and override only the methods you care about.
+type BaseCallback struct{}
+
+func (bc *BaseCallback) CreateTranscription(_ openai.AudioRequest, _ openai.AudioResponse) error {
+	return nil
+}
+
+func (bc *BaseCallback) CreateTranslation(_ openai.AudioRequest, _ openai.AudioResponse) error {
+	return nil
+}
+
+func (bc *BaseCallback) CreateCompletion(_ openai.CompletionRequest, _ openai.CompletionResponse) error {
+	return nil
+}
+
+func (bc *BaseCallback) CreateChatCompletion(_ openai.ChatCompletionRequest, _ openai.ChatCompletionResponse) error {
+	return nil
+}
+
+func (bc *BaseCallback) Edits(_ openai.EditsRequest, _ openai.EditsResponse) error {
+	return nil
+}
+
+func (bc *BaseCallback) CreateEmbeddings(_ openai.EmbeddingRequest, _ openai.EmbeddingResponse) error {
+	return nil
+}
+
+func (bc *BaseCallback) ListFiles(_ openai.FilesList) error {
+	return nil
+}
+
+func (bc *BaseCallback) CreateFile(_ openai.FileRequest, _ openai.File) error {
+	return nil
+}
+
+func (bc *BaseCallback) DeleteFile(_ string) error {
+	return nil
+}
+
+func (bc *BaseCallback) GetFile(_ string, _ openai.File) error {
+	return nil
+}
+
+// GetFileContent: Not implemented in Go SDK
+
+func (bc *BaseCallback) CreateFineTune(_ openai.FineTuneRequest, _ openai.FineTune) error {
+	return nil
+}
+
+func (bc *BaseCallback) ListFineTunes(_ openai.FineTuneList) error {
+	return nil
+}
+
+func (bc *BaseCallback) GetFineTune(_ string, _ openai.FineTune) error {
+	return nil
+}
+
+func (bc *BaseCallback) CancelFineTune(_ string, _ openai.FineTune) error {
+	return nil
+}
+
+func (bc *BaseCallback) ListFineTuneEvents(_ string, _ openai.FineTuneEventList) error {
+	return nil
+}
+
+func (bc *BaseCallback) DeleteFineTune(_ string) error {
+	return nil
+}
+
+func (bc *BaseCallback) CreateImage(_ openai.ImageRequest, _ openai.ImageResponse) error {
+	return nil
+}
+
+func (bc *BaseCallback) CreateEditImage(_ openai.ImageEditRequest, _ openai.ImageResponse) error {
+	return nil
+}
+
+func (bc *BaseCallback) CreateVariImage(_ openai.ImageVariRequest, _ openai.ImageResponse) error {
+	return nil
+}
+
+func (bc *BaseCallback) ListModels(_ openai.ModelsList) error {
+	return nil
+}
+
+func (bc *BaseCallback) Moderations(_ openai.ModerationRequest, _ openai.ModerationResponse) error {
+	return nil
+}
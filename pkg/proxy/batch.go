@@ -0,0 +1,284 @@
+// Copyright 2023 dvonthenen ChatGPT Proxy contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache License 2.0
+
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	openai "github.com/sashabaranov/go-openai"
+	klog "k8s.io/klog/v2"
+
+	auth "github.com/dvonthenen/chat-gpeasy/pkg/proxy/auth"
+	batch "github.com/dvonthenen/chat-gpeasy/pkg/proxy/batch"
+	breaker "github.com/dvonthenen/chat-gpeasy/pkg/proxy/breaker"
+	interfaces "github.com/dvonthenen/chat-gpeasy/pkg/proxy/interfaces"
+	keypool "github.com/dvonthenen/chat-gpeasy/pkg/proxy/keypool"
+	policy "github.com/dvonthenen/chat-gpeasy/pkg/proxy/policy"
+	queue "github.com/dvonthenen/chat-gpeasy/pkg/proxy/queue"
+)
+
+// batchEndpoints are the routes executeBatchRequest understands
+var batchEndpoints = []string{
+	routeChatCompletions,
+	routeCompletions,
+	routeEmbeddings,
+	routeModerations,
+}
+
+// executeBatchRequest implements batch.Executor. Batch requests are made as the
+// owner of the batch and are checked like interactive requests: quota, allowed
+// models, system prompt, policies and the prompt guard. They reach the same
+// callbacks.
+func (p *ChatGPTProxy) executeBatchRequest(ctx context.Context, owner *batch.Owner, url string, body json.RawMessage) (interface{}, error) {
+	caller, err := p.batchCaller(owner)
+	if err != nil {
+		return nil, err
+	}
+	ctx = withRequestInfo(ctx, owner.Identity, caller.User, url)
+
+	switch url {
+	case routeChatCompletions:
+		var request openai.ChatCompletionRequest
+		if err := json.Unmarshal(body, &request); err != nil {
+			return nil, fmt.Errorf("%w: %v", batch.ErrInvalidRequest, err)
+		}
+
+		if err := p.authorizeBatch(owner, request.Model); err != nil {
+			return nil, err
+		}
+		p.applySystemPromptFor(owner.Identity, &request)
+		p.applyPoliciesFor(ctx, caller, url, &request)
+		if p.screenPrompt(ctx, caller.Key, url, request) != nil {
+			return nil, batchError(http.StatusBadRequest, ErrPromptBlocked)
+		}
+
+		var resp openai.ChatCompletionResponse
+		err := p.batchUpstream(ctx, routeChatCompletions, func(ctx context.Context) (err error) {
+			resp, err = p.client(ctx).CreateChatCompletion(ctx, request)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		p.filterChatCompletion(ctx, &resp)
+
+		p.recordUsage(ctx, request, resp)
+
+		if p.callback != nil {
+			err = p.dispatch(ctx, interfaces.EventTypeCreateChatCompletion, request, resp)
+			if err != nil {
+				klog.V(1).Infof("[CALLBACK] CreateChatCompletion failed. Err: %v\n", err)
+			}
+		}
+		return resp, nil
+	case routeCompletions:
+		var request openai.CompletionRequest
+		if err := json.Unmarshal(body, &request); err != nil {
+			return nil, fmt.Errorf("%w: %v", batch.ErrInvalidRequest, err)
+		}
+
+		chatRequests, err := p.legacyCompletion(request)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", batch.ErrInvalidRequest, err)
+		}
+
+		// a translated completion is answered by the chat model
+		model := request.Model
+		if len(chatRequests) > 0 {
+			model = chatRequests[0].Model
+		}
+		if err := p.authorizeBatch(owner, model); err != nil {
+			return nil, err
+		}
+		for i := range chatRequests {
+			p.applySystemPromptFor(owner.Identity, &chatRequests[i])
+			p.applyPoliciesFor(ctx, caller, url, &chatRequests[i])
+		}
+		if p.screenPrompt(ctx, caller.Key, url, request) != nil {
+			return nil, batchError(http.StatusBadRequest, ErrPromptBlocked)
+		}
+
+		var resp openai.CompletionResponse
+		err = p.batchUpstream(ctx, routeCompletions, func(ctx context.Context) (err error) {
+			resp, err = p.createCompletion(ctx, request, chatRequests)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		p.filterCompletion(ctx, &resp)
+
+		p.recordUsage(ctx, request, resp)
+
+		if p.callback != nil {
+			err = p.dispatch(ctx, interfaces.EventTypeCreateCompletion, request, resp)
+			if err != nil {
+				klog.V(1).Infof("[CALLBACK] CreateCompletion failed. Err: %v\n", err)
+			}
+		}
+		return resp, nil
+	case routeEmbeddings:
+		var request openai.EmbeddingRequest
+		if err := json.Unmarshal(body, &request); err != nil {
+			return nil, fmt.Errorf("%w: %v", batch.ErrInvalidRequest, err)
+		}
+
+		if err := p.authorizeBatch(owner, request.Model.String()); err != nil {
+			return nil, err
+		}
+
+		var resp openai.EmbeddingResponse
+		err := p.batchUpstream(ctx, routeEmbeddings, func(ctx context.Context) (err error) {
+			resp, err = p.client(ctx).CreateEmbeddings(ctx, request)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		p.recordUsage(ctx, request, resp)
+
+		if p.callback != nil {
+			err = p.dispatch(ctx, interfaces.EventTypeCreateEmbeddings, request, resp)
+			if err != nil {
+				klog.V(1).Infof("[CALLBACK] CreateEmbeddings failed. Err: %v\n", err)
+			}
+		}
+		return resp, nil
+	case routeModerations:
+		var request openai.ModerationRequest
+		if err := json.Unmarshal(body, &request); err != nil {
+			return nil, fmt.Errorf("%w: %v", batch.ErrInvalidRequest, err)
+		}
+
+		if err := p.authorizeBatch(owner, request.Model); err != nil {
+			return nil, err
+		}
+
+		var resp openai.ModerationResponse
+		err := p.batchUpstream(ctx, routeModerations, func(ctx context.Context) (err error) {
+			resp, err = p.client(ctx).Moderations(ctx, request)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		p.recordUsage(ctx, request, resp)
+
+		if p.callback != nil {
+			err = p.dispatch(ctx, interfaces.EventTypeModerations, request, resp)
+			if err != nil {
+				klog.V(1).Infof("[CALLBACK] Moderations failed. Err: %v\n", err)
+			}
+		}
+		return resp, nil
+	}
+
+	return nil, batch.ErrUnsupportedEndpoint
+}
+
+// batchCaller is the owner of a batch as policies and the prompt guard see it.
+// The key of an unauthenticated owner is only saved as its hash, so a job
+// resumed after a restart gets its key back from the keys policies and the
+// guard are configured with, see keyForHash.
+func (p *ChatGPTProxy) batchCaller(owner *batch.Owner) (policy.Caller, error) {
+	if owner == nil {
+		return policy.Caller{}, batchError(http.StatusForbidden, batch.ErrUnknownOwner)
+	}
+
+	caller := policy.Caller{
+		Key:  owner.Key,
+		User: owner.User,
+	}
+	if owner.Identity != nil {
+		caller.AuthPolicies = owner.Identity.Policies
+		if len(caller.Key) == 0 {
+			caller.Key = owner.Identity.Subject
+		}
+	}
+	if len(caller.Key) == 0 {
+		caller.Key = p.keyForHash(owner.KeyHash)
+	}
+	if len(caller.Key) == 0 && (p.policies != nil || p.guard != nil) {
+		return caller, batchError(http.StatusForbidden, batch.ErrUnknownOwner)
+	}
+	return caller, nil
+}
+
+// keyForHash returns the caller key policies or the prompt guard are
+// configured with that has the hash. Keys that are not configured only ever
+// match the rules for every caller, so the hash itself stands in for them.
+func (p *ChatGPTProxy) keyForHash(hash string) string {
+	if len(hash) == 0 {
+		return ""
+	}
+
+	if p.options.Policy != nil {
+		for _, configured := range p.options.Policy.Policies {
+			for _, key := range configured.Callers {
+				if keyHash(key) == hash {
+					return key
+				}
+			}
+		}
+	}
+	if p.options.Guard != nil {
+		for key := range p.options.Guard.Actions {
+			if keyHash(key) == hash {
+				return key
+			}
+		}
+	}
+	return hash
+}
+
+// authorizeBatch counts a batch request against the owner's quota and checks
+// that the owner may use the model
+func (p *ChatGPTProxy) authorizeBatch(owner *batch.Owner, model string) error {
+	if p.auth == nil || owner.Identity == nil {
+		return nil
+	}
+
+	if err := p.auth.Consume(owner.Identity); err != nil {
+		var quotaErr *auth.QuotaError
+		if errors.As(err, &quotaErr) {
+			return batchError(http.StatusTooManyRequests, err)
+		}
+		return err
+	}
+	if err := p.checkModelFor(owner.Identity, model); err != nil {
+		return batchError(http.StatusForbidden, err)
+	}
+	return nil
+}
+
+// batchError is an error with the status the result of a batch request reports
+func batchError(status int, err error) error {
+	return &openai.RequestError{
+		HTTPStatusCode: status,
+		Err:            err,
+	}
+}
+
+// batchUpstream makes a batch call behind the circuit breaker. Batch calls queue
+// behind interactive ones. An open breaker, a full queue or a pool without a
+// usable key is reported as a 503 so the job backs off and retries.
+func (p *ChatGPTProxy) batchUpstream(ctx context.Context, route string, call func(ctx context.Context) error) error {
+	if p.limiter != nil {
+		if _, ok := queue.TicketFrom(ctx); !ok {
+			ctx = queue.WithTicket(ctx, p.limiter.BatchTicket(ctx.Done()))
+		}
+	}
+
+	err := p.upstream(ctx, upstreamOpenAI, route, call)
+	if errors.Is(err, breaker.ErrOpen) || errors.Is(err, keypool.ErrNoKeys) || errors.Is(err, queue.ErrQueueFull) || errors.Is(err, queue.ErrQueueTimeout) {
+		return batchError(http.StatusServiceUnavailable, err)
+	}
+	return err
+}
@@ -0,0 +1,278 @@
+// Copyright 2023 dvonthenen ChatGPT Proxy contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache License 2.0
+
+package batch
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	klog "k8s.io/klog/v2"
+
+	interfaces "github.com/dvonthenen/chat-gpeasy/pkg/proxy/interfaces"
+)
+
+// New creates the batch manager and resumes jobs that were running when the
+// previous process exited
+func New(executor Executor, options BatchOptions) (*Manager, error) {
+	if executor == nil {
+		klog.V(1).Infof("executor is nil\n")
+		return nil, ErrInvalidInput
+	}
+	if len(options.Dir) == 0 {
+		klog.V(1).Infof("Dir is required\n")
+		return nil, ErrInvalidInput
+	}
+	if len(options.Endpoints) == 0 {
+		klog.V(1).Infof("Endpoints is required\n")
+		return nil, ErrInvalidInput
+	}
+	if options.Concurrency <= 0 {
+		options.Concurrency = DefaultConcurrency
+	}
+	if options.MaxRequests <= 0 {
+		options.MaxRequests = DefaultMaxRequests
+	}
+	if options.MaxRetries < 0 {
+		options.MaxRetries = 0
+	} else if options.MaxRetries == 0 {
+		options.MaxRetries = DefaultMaxRetries
+	}
+	if options.InitialBackoff <= 0 {
+		options.InitialBackoff = DefaultInitialBackoff
+	}
+	if options.MaxBackoff <= 0 {
+		options.MaxBackoff = DefaultMaxBackoff
+	}
+
+	m := &Manager{
+		options:  &options,
+		executor: executor,
+		limiter:  newLimiter(options.RequestsPerMinute),
+		files:    make(map[string]*File),
+		batches:  make(map[string]*Batch),
+		jobs:     make(map[string]*job),
+		slots:    make(chan struct{}, options.Concurrency),
+	}
+
+	err := m.load()
+	if err != nil {
+		klog.V(1).Infof("load failed. Err: %v\n", err)
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, batch := range m.batches {
+		switch batch.Status {
+		case StatusValidating, StatusInProgress:
+			klog.V(3).Infof("resuming %s\n", batch.ID)
+			m.start(batch)
+		case StatusCancelling:
+			m.finish(batch, StatusCancelled)
+		}
+	}
+
+	return m, nil
+}
+
+// Create starts a new job for an uploaded input file. Its requests are made as
+// the owner.
+func (m *Manager) Create(request CreateRequest, owner *Owner) (*Batch, error) {
+	klog.V(6).Infof("Manager.Create ENTER\n")
+
+	if !m.supported(request.Endpoint) {
+		klog.V(1).Infof("endpoint %s not supported\n", request.Endpoint)
+		klog.V(6).Infof("Manager.Create LEAVE\n")
+		return nil, ErrUnsupportedEndpoint
+	}
+
+	file, err := m.GetFile(request.InputFileID, owner.keyHash())
+	if err != nil || file.Purpose != PurposeBatch {
+		klog.V(1).Infof("input file %s not found\n", request.InputFileID)
+		klog.V(6).Infof("Manager.Create LEAVE\n")
+		return nil, ErrFileNotFound
+	}
+
+	batch := &Batch{
+		ID:               "batch_" + interfaces.NewEventID(),
+		Object:           BatchObject,
+		Endpoint:         request.Endpoint,
+		InputFileID:      request.InputFileID,
+		CompletionWindow: request.CompletionWindow,
+		Status:           StatusValidating,
+		CreatedAt:        time.Now().Unix(),
+		Metadata:         request.Metadata,
+		Owner:            owner,
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.stopped {
+		klog.V(6).Infof("Manager.Create LEAVE\n")
+		return nil, ErrStopped
+	}
+
+	err = m.saveBatch(batch)
+	if err != nil {
+		klog.V(1).Infof("saveBatch failed. Err: %v\n", err)
+		klog.V(6).Infof("Manager.Create LEAVE\n")
+		return nil, err
+	}
+	m.batches[batch.ID] = batch
+	m.start(batch)
+
+	klog.V(4).Infof("created %s\n", batch.ID)
+	klog.V(6).Infof("Manager.Create LEAVE\n")
+
+	copied := *batch
+	return &copied, nil
+}
+
+// Get returns the current state of a job of owner, see Owner.KeyHash. The jobs
+// of other callers are not found.
+func (m *Manager) Get(id, owner string) (*Batch, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	batch, ok := m.batches[id]
+	if !ok || batch.owner() != owner {
+		return nil, ErrBatchNotFound
+	}
+
+	copied := *batch
+	return &copied, nil
+}
+
+// List returns the jobs of owner, newest first
+func (m *Manager) List(owner string) []Batch {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	batches := make([]Batch, 0, len(m.batches))
+	for _, batch := range m.batches {
+		if batch.owner() != owner {
+			continue
+		}
+		batches = append(batches, *batch)
+	}
+	sort.Slice(batches, func(i, j int) bool {
+		if batches[i].CreatedAt == batches[j].CreatedAt {
+			return batches[i].ID > batches[j].ID
+		}
+		return batches[i].CreatedAt > batches[j].CreatedAt
+	})
+
+	return batches
+}
+
+// Cancel stops a job of owner. Results already written are kept; requests in
+// flight are abandoned.
+func (m *Manager) Cancel(id, owner string) (*Batch, error) {
+	klog.V(6).Infof("Manager.Cancel ENTER\n")
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	batch, ok := m.batches[id]
+	if !ok || batch.owner() != owner {
+		klog.V(6).Infof("Manager.Cancel LEAVE\n")
+		return nil, ErrBatchNotFound
+	}
+
+	switch batch.Status {
+	case StatusCompleted, StatusFailed, StatusCancelled:
+		klog.V(1).Infof("%s already %s\n", id, batch.Status)
+		klog.V(6).Infof("Manager.Cancel LEAVE\n")
+		return nil, ErrBatchFinished
+	}
+
+	batch.Status = StatusCancelling
+	batch.CancellingAt = time.Now().Unix()
+	if err := m.saveBatch(batch); err != nil {
+		klog.V(1).Infof("saveBatch failed. Err: %v\n", err)
+	}
+
+	if j, ok := m.jobs[id]; ok {
+		j.cancelled = true
+		j.cancel()
+	} else {
+		m.finish(batch, StatusCancelled)
+	}
+
+	klog.V(4).Infof("cancelling %s\n", id)
+	klog.V(6).Infof("Manager.Cancel LEAVE\n")
+
+	copied := *batch
+	return &copied, nil
+}
+
+// Stop interrupts running jobs and waits for requests in flight. Interrupted
+// jobs stay in_progress and resume the next time New is called.
+func (m *Manager) Stop() error {
+	klog.V(6).Infof("Manager.Stop ENTER\n")
+
+	m.mu.Lock()
+	if m.stopped {
+		m.mu.Unlock()
+		klog.V(6).Infof("Manager.Stop LEAVE\n")
+		return nil
+	}
+	m.stopped = true
+	for _, j := range m.jobs {
+		j.cancel()
+	}
+	m.mu.Unlock()
+
+	m.wg.Wait()
+
+	klog.V(4).Infof("batch manager stopped\n")
+	klog.V(6).Infof("Manager.Stop LEAVE\n")
+	return nil
+}
+
+// start runs the job in the background. must be called with mu held.
+func (m *Manager) start(batch *Batch) {
+	ctx, cancel := context.WithCancel(context.Background())
+	m.jobs[batch.ID] = &job{
+		cancel: cancel,
+	}
+
+	m.wg.Add(1)
+	go m.run(ctx, batch.ID)
+}
+
+// finish moves the job to a final state. must be called with mu held.
+func (m *Manager) finish(batch *Batch, status Status) {
+	now := time.Now().Unix()
+
+	batch.Status = status
+	switch status {
+	case StatusCompleted:
+		batch.CompletedAt = now
+	case StatusFailed:
+		batch.FailedAt = now
+	case StatusCancelled:
+		batch.CancelledAt = now
+	}
+
+	if err := m.saveBatch(batch); err != nil {
+		klog.V(1).Infof("saveBatch failed. Err: %v\n", err)
+	}
+}
+
+// owner is the KeyHash of the batch's owner
+func (b *Batch) owner() string {
+	return b.Owner.keyHash()
+}
+
+// keyHash is the KeyHash of the owner, empty when unknown
+func (o *Owner) keyHash() string {
+	if o == nil {
+		return ""
+	}
+	return o.KeyHash
+}
@@ -0,0 +1,72 @@
+// Copyright 2023 dvonthenen ChatGPT Proxy contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache License 2.0
+
+package batch
+
+import (
+	"errors"
+	"time"
+)
+
+// Status of a batch job
+type Status string
+
+const (
+	StatusValidating Status = "validating"
+	StatusInProgress Status = "in_progress"
+	StatusCompleted  Status = "completed"
+	StatusFailed     Status = "failed"
+	StatusCancelling Status = "cancelling"
+	StatusCancelled  Status = "cancelled"
+)
+
+const (
+	// object types returned by the batch endpoints
+	BatchObject string = "batch"
+	FileObject  string = "file"
+	ListObject  string = "list"
+
+	// file purposes
+	PurposeBatch       string = "batch"
+	PurposeBatchOutput string = "batch_output"
+
+	DefaultConcurrency    int           = 4
+	DefaultMaxRetries     int           = 5
+	DefaultMaxRequests    int           = 50000
+	DefaultInitialBackoff time.Duration = 1 * time.Second
+	DefaultMaxBackoff     time.Duration = 1 * time.Minute
+
+	batchesDir    string = "batches"
+	filesDir      string = "files"
+	metaExtension string = ".json"
+	dataExtension string = ".jsonl"
+)
+
+var (
+	// ErrInvalidInput required input was not found
+	ErrInvalidInput = errors.New("required input was not found")
+
+	// ErrInvalidFile the uploaded file is not a valid batch input file
+	ErrInvalidFile = errors.New("invalid batch input file")
+
+	// ErrInvalidRequest the body of a batch request could not be decoded
+	ErrInvalidRequest = errors.New("invalid batch request")
+
+	// ErrUnsupportedEndpoint the endpoint can not be used in a batch
+	ErrUnsupportedEndpoint = errors.New("endpoint is not supported by batches")
+
+	// ErrFileNotFound no file with that ID
+	ErrFileNotFound = errors.New("file not found")
+
+	// ErrBatchNotFound no batch with that ID
+	ErrBatchNotFound = errors.New("batch not found")
+
+	// ErrBatchFinished the batch has already finished
+	ErrBatchFinished = errors.New("batch has already finished")
+
+	// ErrUnknownOwner the caller a batch runs as is not known
+	ErrUnknownOwner = errors.New("the caller of the batch is not known, create the batch again")
+
+	// ErrStopped the batch manager has been stopped
+	ErrStopped = errors.New("batch manager has been stopped")
+)
@@ -0,0 +1,236 @@
+// Copyright 2023 dvonthenen ChatGPT Proxy contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache License 2.0
+
+package batch
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	klog "k8s.io/klog/v2"
+
+	interfaces "github.com/dvonthenen/chat-gpeasy/pkg/proxy/interfaces"
+)
+
+// CreateFile validates and stores a JSONL input file uploaded by owner, see
+// Owner.KeyHash
+func (m *Manager) CreateFile(filename string, data []byte, owner string) (*File, error) {
+	klog.V(6).Infof("Manager.CreateFile ENTER\n")
+
+	requests, err := m.parseRequests(data)
+	if err != nil {
+		klog.V(1).Infof("parseRequests failed. Err: %v\n", err)
+		klog.V(6).Infof("Manager.CreateFile LEAVE\n")
+		return nil, err
+	}
+
+	file := &File{
+		ID:        "file-" + interfaces.NewEventID(),
+		Object:    FileObject,
+		Bytes:     int64(len(data)),
+		CreatedAt: time.Now().Unix(),
+		Filename:  filename,
+		Purpose:   PurposeBatch,
+		Owner:     owner,
+	}
+
+	err = writeAtomic(m.filePath(file.ID, dataExtension), data)
+	if err != nil {
+		klog.V(1).Infof("writeAtomic failed. Err: %v\n", err)
+		klog.V(6).Infof("Manager.CreateFile LEAVE\n")
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	err = m.saveFile(file)
+	if err != nil {
+		klog.V(1).Infof("saveFile failed. Err: %v\n", err)
+		klog.V(6).Infof("Manager.CreateFile LEAVE\n")
+		return nil, err
+	}
+	m.files[file.ID] = file
+
+	klog.V(4).Infof("stored %s with %d requests\n", file.ID, len(requests))
+	klog.V(6).Infof("Manager.CreateFile LEAVE\n")
+
+	return file, nil
+}
+
+// GetFile returns the metadata of an input or results file of owner. The files
+// of other callers are not found.
+func (m *Manager) GetFile(id, owner string) (*File, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	file, ok := m.files[id]
+	if !ok || file.Owner != owner {
+		return nil, ErrFileNotFound
+	}
+
+	copied := *file
+	return &copied, nil
+}
+
+// FileContentPath returns the location of the JSONL content of a file of owner
+func (m *Manager) FileContentPath(id, owner string) (string, error) {
+	if _, err := m.GetFile(id, owner); err != nil {
+		return "", err
+	}
+	return m.filePath(id, dataExtension), nil
+}
+
+// parseRequests validates every line of an input file
+func (m *Manager) parseRequests(data []byte) ([]Request, error) {
+	requests := make([]Request, 0)
+	seen := make(map[string]bool)
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+
+	line := 0
+	for scanner.Scan() {
+		line++
+
+		text := bytes.TrimSpace(scanner.Bytes())
+		if len(text) == 0 {
+			continue
+		}
+
+		var request Request
+		if err := json.Unmarshal(text, &request); err != nil {
+			return nil, fmt.Errorf("%w: line %d: %v", ErrInvalidFile, line, err)
+		}
+		if len(request.CustomID) == 0 {
+			return nil, fmt.Errorf("%w: line %d: custom_id is required", ErrInvalidFile, line)
+		}
+		if seen[request.CustomID] {
+			return nil, fmt.Errorf("%w: line %d: duplicate custom_id %s", ErrInvalidFile, line, request.CustomID)
+		}
+		if len(request.Method) > 0 && !strings.EqualFold(request.Method, http.MethodPost) {
+			return nil, fmt.Errorf("%w: line %d: method must be POST", ErrInvalidFile, line)
+		}
+		if !m.supported(request.URL) {
+			return nil, fmt.Errorf("%w: line %d: %s", ErrUnsupportedEndpoint, line, request.URL)
+		}
+		if len(request.Body) == 0 {
+			return nil, fmt.Errorf("%w: line %d: body is required", ErrInvalidFile, line)
+		}
+
+		seen[request.CustomID] = true
+		requests = append(requests, request)
+
+		if len(requests) > m.options.MaxRequests {
+			return nil, fmt.Errorf("%w: more than %d requests", ErrInvalidFile, m.options.MaxRequests)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidFile, err)
+	}
+	if len(requests) == 0 {
+		return nil, fmt.Errorf("%w: no requests", ErrInvalidFile)
+	}
+
+	return requests, nil
+}
+
+func (m *Manager) readRequests(fileID string) ([]Request, error) {
+	data, err := os.ReadFile(m.filePath(fileID, dataExtension))
+	if err != nil {
+		return nil, err
+	}
+	return m.parseRequests(data)
+}
+
+func (m *Manager) supported(url string) bool {
+	for _, endpoint := range m.options.Endpoints {
+		if url == endpoint {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *Manager) filePath(id, extension string) string {
+	return filepath.Join(m.options.Dir, filesDir, id+extension)
+}
+
+func (m *Manager) batchPath(id string) string {
+	return filepath.Join(m.options.Dir, batchesDir, id+metaExtension)
+}
+
+func (m *Manager) saveFile(file *File) error {
+	data, err := json.Marshal(storedFile{File: file, Owner: file.Owner})
+	if err != nil {
+		return err
+	}
+	return writeAtomic(m.filePath(file.ID, metaExtension), data)
+}
+
+// saveBatch persists the job state. must be called with mu held.
+func (m *Manager) saveBatch(batch *Batch) error {
+	data, err := json.Marshal(storedBatch{Batch: batch, Owner: batch.Owner})
+	if err != nil {
+		return err
+	}
+	return writeAtomic(m.batchPath(batch.ID), data)
+}
+
+// load restores files and jobs from a previous run
+func (m *Manager) load() error {
+	for _, dir := range []string{filesDir, batchesDir} {
+		err := os.MkdirAll(filepath.Join(m.options.Dir, dir), 0700)
+		if err != nil {
+			klog.V(1).Infof("os.MkdirAll failed. Err: %v\n", err)
+			return err
+		}
+	}
+
+	matches, _ := filepath.Glob(filepath.Join(m.options.Dir, filesDir, "*"+metaExtension))
+	for _, path := range matches {
+		file := storedFile{File: &File{}}
+		if err := readJSON(path, &file); err != nil {
+			klog.V(1).Infof("skipping corrupt file %s. Err: %v\n", path, err)
+			continue
+		}
+		file.File.Owner = file.Owner
+		m.files[file.ID] = file.File
+	}
+
+	matches, _ = filepath.Glob(filepath.Join(m.options.Dir, batchesDir, "*"+metaExtension))
+	for _, path := range matches {
+		batch := storedBatch{Batch: &Batch{}}
+		if err := readJSON(path, &batch); err != nil {
+			klog.V(1).Infof("skipping corrupt batch %s. Err: %v\n", path, err)
+			continue
+		}
+		batch.Batch.Owner = batch.Owner
+		m.batches[batch.ID] = batch.Batch
+	}
+
+	return nil
+}
+
+func readJSON(path string, v interface{}) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+func writeAtomic(path string, data []byte) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,332 @@
+// Copyright 2023 dvonthenen ChatGPT Proxy contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache License 2.0
+
+package batch
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	openai "github.com/sashabaranov/go-openai"
+	klog "k8s.io/klog/v2"
+
+	interfaces "github.com/dvonthenen/chat-gpeasy/pkg/proxy/interfaces"
+)
+
+// run processes the requests of a job that do not have a result yet
+func (m *Manager) run(ctx context.Context, id string) {
+	defer m.wg.Done()
+
+	m.mu.Lock()
+	batch := m.batches[id]
+	inputFileID := batch.InputFileID
+	endpoint := batch.Endpoint
+	outputFileID := batch.OutputFileID
+	owner := batch.Owner
+	m.mu.Unlock()
+
+	requests, err := m.readRequests(inputFileID)
+	if err != nil {
+		klog.V(1).Infof("readRequests failed. Err: %v\n", err)
+		m.fail(id, err)
+		return
+	}
+	for _, request := range requests {
+		if request.URL != endpoint {
+			m.fail(id, fmt.Errorf("%w: %s does not match the batch endpoint %s", ErrInvalidFile, request.URL, endpoint))
+			return
+		}
+	}
+
+	if len(outputFileID) == 0 {
+		outputFileID = "file-" + interfaces.NewEventID()
+	}
+	outputPath := m.filePath(outputFileID, dataExtension)
+
+	done, counts, err := resumeOutput(outputPath)
+	if err != nil {
+		klog.V(1).Infof("resumeOutput failed. Err: %v\n", err)
+		m.fail(id, err)
+		return
+	}
+	counts.Total = len(requests)
+
+	output, err := os.OpenFile(outputPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		klog.V(1).Infof("os.OpenFile failed. Err: %v\n", err)
+		m.fail(id, err)
+		return
+	}
+
+	m.mu.Lock()
+	batch.Status = StatusInProgress
+	if batch.InProgressAt == 0 {
+		batch.InProgressAt = time.Now().Unix()
+	}
+	batch.OutputFileID = outputFileID
+	batch.RequestCounts = counts
+	if _, ok := m.files[outputFileID]; !ok {
+		m.files[outputFileID] = &File{
+			ID:        outputFileID,
+			Object:    FileObject,
+			CreatedAt: time.Now().Unix(),
+			Filename:  id + "_output" + dataExtension,
+			Purpose:   PurposeBatchOutput,
+			Owner:     batch.owner(),
+		}
+	}
+	if err := m.saveFile(m.files[outputFileID]); err != nil {
+		klog.V(1).Infof("saveFile failed. Err: %v\n", err)
+	}
+	if err := m.saveBatch(batch); err != nil {
+		klog.V(1).Infof("saveBatch failed. Err: %v\n", err)
+	}
+	m.mu.Unlock()
+
+	klog.V(4).Infof("%s: %d of %d requests remaining\n", id, len(requests)-len(done), len(requests))
+
+	var wg sync.WaitGroup
+	var writeMu sync.Mutex
+
+loop:
+	for _, request := range requests {
+		if done[request.CustomID] {
+			continue
+		}
+
+		select {
+		case m.slots <- struct{}{}:
+		case <-ctx.Done():
+			break loop
+		}
+		if err := m.limiter.Wait(ctx); err != nil {
+			<-m.slots
+			break loop
+		}
+
+		wg.Add(1)
+		go func(request Request) {
+			defer wg.Done()
+			defer func() { <-m.slots }()
+
+			result := m.execute(ctx, owner, request)
+			if result == nil {
+				// interrupted, picked up again when the job resumes
+				return
+			}
+
+			data, err := json.Marshal(result)
+			if err != nil {
+				klog.V(1).Infof("json.Marshal failed. Err: %v\n", err)
+				return
+			}
+
+			writeMu.Lock()
+			_, err = output.Write(append(data, '\n'))
+			writeMu.Unlock()
+			if err != nil {
+				klog.V(1).Infof("output.Write failed. Err: %v\n", err)
+				return
+			}
+
+			m.mu.Lock()
+			if result.Error == nil {
+				batch.RequestCounts.Completed++
+			} else {
+				batch.RequestCounts.Failed++
+			}
+			if err := m.saveBatch(batch); err != nil {
+				klog.V(1).Infof("saveBatch failed. Err: %v\n", err)
+			}
+			m.mu.Unlock()
+		}(request)
+	}
+
+	wg.Wait()
+	output.Close()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if info, err := os.Stat(outputPath); err == nil {
+		m.files[outputFileID].Bytes = info.Size()
+		if err := m.saveFile(m.files[outputFileID]); err != nil {
+			klog.V(1).Infof("saveFile failed. Err: %v\n", err)
+		}
+	}
+
+	j := m.jobs[id]
+	delete(m.jobs, id)
+
+	switch {
+	case j != nil && j.cancelled:
+		klog.V(3).Infof("%s cancelled\n", id)
+		m.finish(batch, StatusCancelled)
+	case ctx.Err() != nil:
+		klog.V(3).Infof("%s interrupted, resuming on restart\n", id)
+		if err := m.saveBatch(batch); err != nil {
+			klog.V(1).Infof("saveBatch failed. Err: %v\n", err)
+		}
+	default:
+		klog.V(3).Infof("%s completed (%d ok, %d failed)\n", id, batch.RequestCounts.Completed, batch.RequestCounts.Failed)
+		m.finish(batch, StatusCompleted)
+	}
+}
+
+// execute sends a request, retrying rate limited and server errors. returns nil
+// when interrupted by the job context.
+func (m *Manager) execute(ctx context.Context, owner *Owner, request Request) *Result {
+	result := &Result{
+		ID:       "batch_req_" + interfaces.NewEventID(),
+		CustomID: request.CustomID,
+	}
+
+	for attempt := 0; ; attempt++ {
+		response, err := m.executor(ctx, owner, request.URL, request.Body)
+		if err == nil {
+			result.Response = &ResultResponse{
+				StatusCode: http.StatusOK,
+				Body:       response,
+			}
+			return result
+		}
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		status := statusCode(err)
+		if !retryable(status) || attempt >= m.options.MaxRetries {
+			klog.V(3).Infof("%s failed (%d). Err: %v\n", request.CustomID, status, err)
+			if status > 0 {
+				result.Response = &ResultResponse{
+					StatusCode: status,
+				}
+			}
+			result.Error = &ResultError{
+				Code:    errorCode(status),
+				Message: err.Error(),
+			}
+			return result
+		}
+
+		backoff := m.backoff(attempt)
+		klog.V(4).Infof("%s retrying in %v (%d). Err: %v\n", request.CustomID, backoff, status, err)
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil
+		case <-timer.C:
+		}
+	}
+}
+
+// fail moves a job that can not be processed to failed
+func (m *Manager) fail(id string, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	batch := m.batches[id]
+	batch.Errors = append(batch.Errors, err.Error())
+	delete(m.jobs, id)
+	m.finish(batch, StatusFailed)
+}
+
+func (m *Manager) backoff(attempt int) time.Duration {
+	backoff := m.options.InitialBackoff << uint(attempt)
+	if backoff <= 0 || backoff > m.options.MaxBackoff {
+		backoff = m.options.MaxBackoff
+	}
+	half := int64(backoff / 2)
+	return time.Duration(half + rand.Int63n(half+1)) //nolint:gosec
+}
+
+// resumeOutput reads the results written by a previous run. a partially written
+// last line is truncated so new results are appended on a clean line.
+func resumeOutput(path string) (map[string]bool, RequestCounts, error) {
+	done := make(map[string]bool)
+	counts := RequestCounts{}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return done, counts, nil
+	}
+	if err != nil {
+		return nil, counts, err
+	}
+
+	end := bytes.LastIndexByte(data, '\n') + 1
+	if end < len(data) {
+		klog.V(3).Infof("truncating partial result in %s\n", path)
+		if err := os.Truncate(path, int64(end)); err != nil {
+			return nil, counts, err
+		}
+		data = data[:end]
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		var result struct {
+			CustomID string          `json:"custom_id"`
+			Error    json.RawMessage `json:"error"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &result); err != nil || len(result.CustomID) == 0 {
+			continue
+		}
+		if done[result.CustomID] {
+			continue
+		}
+		done[result.CustomID] = true
+		if len(result.Error) == 0 || string(result.Error) == "null" {
+			counts.Completed++
+		} else {
+			counts.Failed++
+		}
+	}
+
+	return done, counts, scanner.Err()
+}
+
+// statusCode extracts the HTTP status from an upstream error. 0 means the
+// request never got a response.
+func statusCode(err error) int {
+	var apiErr *openai.APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.HTTPStatusCode
+	}
+	var reqErr *openai.RequestError
+	if errors.As(err, &reqErr) {
+		return reqErr.HTTPStatusCode
+	}
+	if errors.Is(err, ErrInvalidRequest) {
+		return http.StatusBadRequest
+	}
+	return 0
+}
+
+func retryable(status int) bool {
+	return status == 0 || status == http.StatusTooManyRequests || status >= http.StatusInternalServerError
+}
+
+func errorCode(status int) string {
+	switch {
+	case status == http.StatusTooManyRequests:
+		return "rate_limit_exceeded"
+	case status >= http.StatusInternalServerError:
+		return "server_error"
+	case status >= http.StatusBadRequest:
+		return "invalid_request"
+	}
+	return "request_failed"
+}
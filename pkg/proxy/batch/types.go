@@ -0,0 +1,182 @@
+// Copyright 2023 dvonthenen ChatGPT Proxy contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache License 2.0
+
+package batch
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	interfaces "github.com/dvonthenen/chat-gpeasy/pkg/proxy/interfaces"
+)
+
+// Executor sends a single request to the upstream API as the owner of the batch
+// and returns the response body. It is provided by the proxy so batch requests
+// go through the same path (and callbacks) as interactive ones.
+type Executor func(ctx context.Context, owner *Owner, url string, body json.RawMessage) (interface{}, error)
+
+// BatchOptions configures the batch subsystem
+type BatchOptions struct {
+	// Dir persists uploaded files, job state and results
+	Dir string
+
+	// Endpoints that may be used in a batch. Defaults to every endpoint the
+	// Executor understands.
+	Endpoints []string
+
+	// Concurrency is the number of requests in flight across all jobs
+	Concurrency int
+
+	// RequestsPerMinute caps the rate requests are sent upstream. 0 is unlimited.
+	RequestsPerMinute int
+
+	// MaxRequests is the maximum number of lines in an input file
+	MaxRequests int
+
+	// rate limited (429) and server errors are retried with exponential backoff
+	MaxRetries     int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+}
+
+// File is an uploaded input file or a generated results file
+type File struct {
+	ID        string `json:"id"`
+	Object    string `json:"object"`
+	Bytes     int64  `json:"bytes"`
+	CreatedAt int64  `json:"created_at"`
+	Filename  string `json:"filename"`
+	Purpose   string `json:"purpose"`
+
+	// Owner is the KeyHash of the caller that uploaded the file, or of the
+	// owner of the batch that wrote it. It is saved but not returned by the API.
+	Owner string `json:"-"`
+}
+
+// storedFile is a file as it is saved to disk
+type storedFile struct {
+	*File
+	Owner string `json:"owner,omitempty"`
+}
+
+// Request is a single line of an input file
+type Request struct {
+	CustomID string          `json:"custom_id"`
+	Method   string          `json:"method"`
+	URL      string          `json:"url"`
+	Body     json.RawMessage `json:"body"`
+}
+
+// Result is a single line of a results file
+type Result struct {
+	ID       string          `json:"id"`
+	CustomID string          `json:"custom_id"`
+	Response *ResultResponse `json:"response"`
+	Error    *ResultError    `json:"error"`
+}
+
+type ResultResponse struct {
+	StatusCode int         `json:"status_code"`
+	Body       interface{} `json:"body"`
+}
+
+type ResultError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// CreateRequest is the body of POST /v1/batches
+type CreateRequest struct {
+	InputFileID      string            `json:"input_file_id"`
+	Endpoint         string            `json:"endpoint"`
+	CompletionWindow string            `json:"completion_window,omitempty"`
+	Metadata         map[string]string `json:"metadata,omitempty"`
+}
+
+// Owner is the caller that created a batch. Its requests are made as that caller.
+type Owner struct {
+	// Identity is the authenticated caller, nil without authentication
+	Identity *interfaces.Identity `json:"identity,omitempty"`
+
+	// Key identifies the caller to policies and the prompt guard. It can be a
+	// bearer token, so it is kept in memory only. KeyHash, its SHA-256, is
+	// saved with the job. It scopes the batch and its files to the owner and
+	// identifies the caller when the job resumes after a restart.
+	Key     string `json:"-"`
+	KeyHash string `json:"key_hash"`
+
+	// User is sent upstream as the caller
+	User string `json:"user"`
+}
+
+type RequestCounts struct {
+	Total     int `json:"total"`
+	Completed int `json:"completed"`
+	Failed    int `json:"failed"`
+}
+
+// Batch is the state of a batch job
+type Batch struct {
+	ID               string            `json:"id"`
+	Object           string            `json:"object"`
+	Endpoint         string            `json:"endpoint"`
+	InputFileID      string            `json:"input_file_id"`
+	CompletionWindow string            `json:"completion_window,omitempty"`
+	Status           Status            `json:"status"`
+	OutputFileID     string            `json:"output_file_id,omitempty"`
+	Errors           []string          `json:"errors,omitempty"`
+	CreatedAt        int64             `json:"created_at"`
+	InProgressAt     int64             `json:"in_progress_at,omitempty"`
+	CompletedAt      int64             `json:"completed_at,omitempty"`
+	FailedAt         int64             `json:"failed_at,omitempty"`
+	CancellingAt     int64             `json:"cancelling_at,omitempty"`
+	CancelledAt      int64             `json:"cancelled_at,omitempty"`
+	RequestCounts    RequestCounts     `json:"request_counts"`
+	Metadata         map[string]string `json:"metadata,omitempty"`
+
+	// Owner is saved with the job but not returned by the API
+	Owner *Owner `json:"-"`
+}
+
+// storedBatch is a batch as it is saved to disk
+type storedBatch struct {
+	*Batch
+	Owner *Owner `json:"owner,omitempty"`
+}
+
+// BatchList is returned by GET /v1/batches
+type BatchList struct {
+	Object string  `json:"object"`
+	Data   []Batch `json:"data"`
+}
+
+// Manager runs batch jobs
+type Manager struct {
+	options  *BatchOptions
+	executor Executor
+	limiter  *limiter
+
+	// state
+	files   map[string]*File
+	batches map[string]*Batch
+	jobs    map[string]*job
+	slots   chan struct{}
+
+	// housekeeping
+	stopped bool
+	wg      sync.WaitGroup
+	mu      sync.Mutex
+}
+
+type job struct {
+	cancel    context.CancelFunc
+	cancelled bool
+}
+
+type limiter struct {
+	interval time.Duration
+	next     time.Time
+	mu       sync.Mutex
+}
@@ -0,0 +1,287 @@
+// Copyright 2023 dvonthenen ChatGPT Proxy contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache License 2.0
+
+package breaker
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	klog "k8s.io/klog/v2"
+
+	interfaces "github.com/dvonthenen/chat-gpeasy/pkg/proxy/interfaces"
+)
+
+// New creates a group. Breakers are created the first time a route is used.
+func New(options BreakerOptions) *Group {
+	if options.Window <= 0 {
+		options.Window = DefaultWindow
+	}
+	if options.MinRequests <= 0 {
+		options.MinRequests = DefaultMinRequests
+	}
+	if options.ErrorRateThreshold <= 0 || options.ErrorRateThreshold > 1 {
+		options.ErrorRateThreshold = DefaultErrorRateThreshold
+	}
+	if options.SlowCallDuration <= 0 {
+		options.SlowCallDuration = DefaultSlowCallDuration
+	}
+	if options.SlowRateThreshold <= 0 || options.SlowRateThreshold > 1 {
+		options.SlowRateThreshold = DefaultSlowRateThreshold
+	}
+	if options.OpenDuration <= 0 {
+		options.OpenDuration = DefaultOpenDuration
+	}
+	if options.HalfOpenProbes <= 0 {
+		options.HalfOpenProbes = DefaultHalfOpenProbes
+	}
+
+	return &Group{
+		options:  &options,
+		breakers: make(map[string]*Breaker),
+	}
+}
+
+// Get returns the breaker for an upstream route
+func (g *Group) Get(upstream, route string) *Breaker {
+	key := upstream + " " + route
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	b, ok := g.breakers[key]
+	if !ok {
+		b = &Breaker{
+			upstream: upstream,
+			route:    route,
+			options:  g.options,
+			state:    StateClosed,
+			buckets:  make([]bucket, windowBuckets),
+		}
+		g.breakers[key] = b
+		publish(key, b)
+	}
+
+	return b
+}
+
+// Statuses returns a snapshot of every breaker
+func (g *Group) Statuses() []Status {
+	g.mu.Lock()
+	breakers := make([]*Breaker, 0, len(g.breakers))
+	for _, b := range g.breakers {
+		breakers = append(breakers, b)
+	}
+	g.mu.Unlock()
+
+	statuses := make([]Status, 0, len(breakers))
+	for _, b := range breakers {
+		statuses = append(statuses, b.Status())
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		if statuses[i].Upstream != statuses[j].Upstream {
+			return statuses[i].Upstream < statuses[j].Upstream
+		}
+		return statuses[i].Route < statuses[j].Route
+	})
+
+	return statuses
+}
+
+// Allow returns an *OpenError when the call must not be made. Every allowed
+// call has to be followed by Record.
+func (b *Breaker) Allow() error {
+	b.mu.Lock()
+
+	now := time.Now()
+	var change *interfaces.BreakerStateChange
+
+	switch b.state {
+	case StateOpen:
+		remaining := b.openedAt.Add(b.options.OpenDuration).Sub(now)
+		if remaining > 0 {
+			b.rejected++
+			b.mu.Unlock()
+			return b.openError(remaining)
+		}
+		change = b.transition(StateHalfOpen, ReasonOpenTimeExpired, now)
+		b.probes = 1
+	case StateHalfOpen:
+		if b.probes >= b.options.HalfOpenProbes {
+			b.rejected++
+			b.mu.Unlock()
+			return b.openError(halfOpenRetryAfter)
+		}
+		b.probes++
+	}
+
+	b.mu.Unlock()
+	b.notify(change)
+
+	return nil
+}
+
+// Record reports the outcome of an allowed call
+func (b *Breaker) Record(failed bool, latency time.Duration) {
+	b.mu.Lock()
+
+	now := time.Now()
+	slow := latency >= b.options.SlowCallDuration
+	var change *interfaces.BreakerStateChange
+
+	switch b.state {
+	case StateClosed:
+		current := b.bucket(now)
+		current.requests++
+		if failed {
+			current.failures++
+		}
+		if slow {
+			current.slow++
+		}
+
+		requests, errorRate, slowRate := b.rates(now)
+		if requests >= b.options.MinRequests {
+			switch {
+			case errorRate >= b.options.ErrorRateThreshold:
+				change = b.transition(StateOpen, ReasonErrorRate, now)
+			case slowRate >= b.options.SlowRateThreshold:
+				change = b.transition(StateOpen, ReasonSlowCallRate, now)
+			}
+		}
+	case StateHalfOpen:
+		if failed || slow {
+			change = b.transition(StateOpen, ReasonProbeFailed, now)
+			break
+		}
+		b.probeSuccesses++
+		if b.probeSuccesses >= b.options.HalfOpenProbes {
+			change = b.transition(StateClosed, ReasonProbesPassed, now)
+		}
+	}
+
+	b.mu.Unlock()
+	b.notify(change)
+}
+
+// Status returns a snapshot of the breaker
+func (b *Breaker) Status() Status {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	status := Status{
+		Upstream: b.upstream,
+		Route:    b.route,
+		State:    b.state,
+		Trips:    b.trips,
+		Rejected: b.rejected,
+	}
+	if b.state != StateClosed {
+		openedAt := b.openedAt
+		status.OpenedAt = &openedAt
+	}
+	for _, bkt := range b.buckets {
+		if now.Sub(bkt.start) < b.options.Window {
+			status.Requests += bkt.requests
+			status.Failures += bkt.failures
+			status.Slow += bkt.slow
+		}
+	}
+	if status.Requests > 0 {
+		status.ErrorRate = float64(status.Failures) / float64(status.Requests)
+		status.SlowRate = float64(status.Slow) / float64(status.Requests)
+	}
+
+	return status
+}
+
+// transition moves to a new state and returns the change to report. Must be
+// called with the lock held.
+func (b *Breaker) transition(to, reason string, now time.Time) *interfaces.BreakerStateChange {
+	requests, errorRate, slowRate := b.rates(now)
+	change := &interfaces.BreakerStateChange{
+		Upstream:  b.upstream,
+		Route:     b.route,
+		From:      b.state,
+		To:        to,
+		Reason:    reason,
+		Requests:  requests,
+		ErrorRate: errorRate,
+		SlowRate:  slowRate,
+	}
+
+	b.state = to
+	b.probes = 0
+	b.probeSuccesses = 0
+
+	switch to {
+	case StateOpen:
+		b.openedAt = now
+		b.trips++
+		change.RetryAfter = b.options.OpenDuration
+	case StateClosed:
+		// start over so the failures that tripped the breaker do not count
+		b.buckets = make([]bucket, windowBuckets)
+	}
+
+	return change
+}
+
+func (b *Breaker) notify(change *interfaces.BreakerStateChange) {
+	if change == nil {
+		return
+	}
+
+	klog.V(2).Infof("circuit breaker %s %s: %s -> %s (%s)\n", change.Upstream, change.Route, change.From, change.To, change.Reason)
+
+	if b.options.OnStateChange != nil {
+		b.options.OnStateChange(*change)
+	}
+}
+
+// bucket returns the bucket for now, clearing it if it belongs to an earlier
+// pass over the ring. Must be called with the lock held.
+func (b *Breaker) bucket(now time.Time) *bucket {
+	width := b.options.Window / time.Duration(windowBuckets)
+	start := now.Truncate(width)
+	current := &b.buckets[int(start.UnixNano()/int64(width))%windowBuckets]
+	if !current.start.Equal(start) {
+		*current = bucket{start: start}
+	}
+	return current
+}
+
+// rates sums the buckets inside the window. Must be called with the lock held.
+func (b *Breaker) rates(now time.Time) (int, float64, float64) {
+	requests, failures, slow := 0, 0, 0
+	for _, bkt := range b.buckets {
+		if now.Sub(bkt.start) < b.options.Window {
+			requests += bkt.requests
+			failures += bkt.failures
+			slow += bkt.slow
+		}
+	}
+	if requests == 0 {
+		return 0, 0, 0
+	}
+	return requests, float64(failures) / float64(requests), float64(slow) / float64(requests)
+}
+
+func (b *Breaker) openError(retryAfter time.Duration) *OpenError {
+	return &OpenError{
+		Upstream:   b.upstream,
+		Route:      b.route,
+		RetryAfter: retryAfter,
+	}
+}
+
+func (e *OpenError) Error() string {
+	return fmt.Sprintf("%v: %s %s, retry after %v", ErrOpen, e.Upstream, e.Route, e.RetryAfter)
+}
+
+// Is lets errors.Is match ErrOpen
+func (e *OpenError) Is(target error) bool {
+	return target == ErrOpen
+}
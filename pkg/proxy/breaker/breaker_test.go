@@ -0,0 +1,173 @@
+// Copyright 2023 dvonthenen ChatGPT Proxy contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache License 2.0
+
+package breaker
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	interfaces "github.com/dvonthenen/chat-gpeasy/pkg/proxy/interfaces"
+)
+
+const (
+	testSlowCall     time.Duration = 10 * time.Millisecond
+	testOpenDuration time.Duration = 20 * time.Millisecond
+)
+
+// step is one thing done to a breaker. ok, fail and slow are an allowed call
+// and its outcome, probe is an allowed call without an outcome yet, reject is a
+// call the breaker must refuse and wait lets the open duration pass.
+type step struct {
+	action string
+	count  int
+}
+
+func TestTransitions(t *testing.T) {
+	tests := []struct {
+		name    string
+		steps   []step
+		state   string
+		reasons []string
+	}{
+		{
+			name:  "below min requests",
+			steps: []step{{"fail", 3}},
+			state: StateClosed,
+		},
+		{
+			name:    "error rate opens",
+			steps:   []step{{"ok", 2}, {"fail", 2}, {"reject", 1}},
+			state:   StateOpen,
+			reasons: []string{ReasonErrorRate},
+		},
+		{
+			name:    "slow call rate opens",
+			steps:   []step{{"ok", 2}, {"slow", 2}, {"reject", 1}},
+			state:   StateOpen,
+			reasons: []string{ReasonSlowCallRate},
+		},
+		{
+			name:    "open duration expires",
+			steps:   []step{{"fail", 4}, {"wait", 1}, {"probe", 1}},
+			state:   StateHalfOpen,
+			reasons: []string{ReasonErrorRate, ReasonOpenTimeExpired},
+		},
+		{
+			name:    "half open limits probes",
+			steps:   []step{{"fail", 4}, {"wait", 1}, {"probe", 2}, {"reject", 1}},
+			state:   StateHalfOpen,
+			reasons: []string{ReasonErrorRate, ReasonOpenTimeExpired},
+		},
+		{
+			name:    "probes close",
+			steps:   []step{{"fail", 4}, {"wait", 1}, {"ok", 2}},
+			state:   StateClosed,
+			reasons: []string{ReasonErrorRate, ReasonOpenTimeExpired, ReasonProbesPassed},
+		},
+		{
+			name:    "failed probe opens",
+			steps:   []step{{"fail", 4}, {"wait", 1}, {"fail", 1}, {"reject", 1}},
+			state:   StateOpen,
+			reasons: []string{ReasonErrorRate, ReasonOpenTimeExpired, ReasonProbeFailed},
+		},
+		{
+			name:    "slow probe opens",
+			steps:   []step{{"fail", 4}, {"wait", 1}, {"ok", 1}, {"slow", 1}},
+			state:   StateOpen,
+			reasons: []string{ReasonErrorRate, ReasonOpenTimeExpired, ReasonProbeFailed},
+		},
+		{
+			name:    "closing forgets old failures",
+			steps:   []step{{"fail", 4}, {"wait", 1}, {"ok", 2}, {"fail", 3}},
+			state:   StateClosed,
+			reasons: []string{ReasonErrorRate, ReasonOpenTimeExpired, ReasonProbesPassed},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var reasons []string
+			group := New(BreakerOptions{
+				MinRequests:        4,
+				ErrorRateThreshold: 0.5,
+				SlowCallDuration:   testSlowCall,
+				SlowRateThreshold:  0.5,
+				OpenDuration:       testOpenDuration,
+				HalfOpenProbes:     2,
+				OnStateChange: func(change interfaces.BreakerStateChange) {
+					reasons = append(reasons, change.Reason)
+				},
+			})
+			b := group.Get("test", tt.name)
+
+			for _, s := range tt.steps {
+				for i := 0; i < s.count; i++ {
+					run(t, b, s.action)
+				}
+			}
+
+			if status := b.Status(); status.State != tt.state {
+				t.Errorf("expected state %s, got %s", tt.state, status.State)
+			}
+			if !reflect.DeepEqual(reasons, tt.reasons) {
+				t.Errorf("expected changes %v, got %v", tt.reasons, reasons)
+			}
+		})
+	}
+}
+
+func run(t *testing.T, b *Breaker, action string) {
+	t.Helper()
+
+	switch action {
+	case "wait":
+		time.Sleep(testOpenDuration + 5*time.Millisecond)
+		return
+	case "reject":
+		err := b.Allow()
+		var openErr *OpenError
+		if !errors.Is(err, ErrOpen) || !errors.As(err, &openErr) || openErr.RetryAfter <= 0 {
+			t.Fatalf("expected an *OpenError, got %v", err)
+		}
+		return
+	}
+
+	if err := b.Allow(); err != nil {
+		t.Fatalf("Allow failed on %s. Err: %v", action, err)
+	}
+
+	switch action {
+	case "ok":
+		b.Record(false, time.Millisecond)
+	case "fail":
+		b.Record(true, time.Millisecond)
+	case "slow":
+		b.Record(false, testSlowCall)
+	case "probe":
+	default:
+		t.Fatalf("unknown action %s", action)
+	}
+}
+
+func TestWindow(t *testing.T) {
+	group := New(BreakerOptions{
+		Window:      100 * time.Millisecond,
+		MinRequests: 4,
+	})
+	b := group.Get("test", "window")
+
+	for i := 0; i < 3; i++ {
+		run(t, b, "fail")
+	}
+	time.Sleep(120 * time.Millisecond)
+	run(t, b, "fail")
+
+	// the first three failures left the window
+	status := b.Status()
+	if status.State != StateClosed || status.Requests != 1 {
+		t.Errorf("expected closed with 1 request, got %s with %d", status.State, status.Requests)
+	}
+}
@@ -0,0 +1,44 @@
+// Copyright 2023 dvonthenen ChatGPT Proxy contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache License 2.0
+
+package breaker
+
+import (
+	"errors"
+	"time"
+)
+
+const (
+	StateClosed   string = "closed"
+	StateOpen     string = "open"
+	StateHalfOpen string = "half_open"
+
+	// reasons reported with a state change
+	ReasonErrorRate       string = "error_rate"
+	ReasonSlowCallRate    string = "slow_call_rate"
+	ReasonProbeFailed     string = "probe_failed"
+	ReasonProbesPassed    string = "probes_succeeded"
+	ReasonOpenTimeExpired string = "open_duration_expired"
+
+	DefaultWindow             time.Duration = time.Minute
+	DefaultMinRequests        int           = 10
+	DefaultErrorRateThreshold float64       = 0.5
+	DefaultSlowCallDuration   time.Duration = 30 * time.Second
+	DefaultSlowRateThreshold  float64       = 0.8
+	DefaultOpenDuration       time.Duration = 30 * time.Second
+	DefaultHalfOpenProbes     int           = 3
+
+	// the rolling window is kept in this many buckets
+	windowBuckets int = 10
+
+	// Retry-After while half open and every probe slot is taken
+	halfOpenRetryAfter time.Duration = time.Second
+
+	// expvar map holding the status of every breaker
+	metricsName string = "chatgpt_proxy_circuit_breakers"
+)
+
+var (
+	// ErrOpen the circuit breaker is open and the call was not made
+	ErrOpen = errors.New("circuit breaker is open")
+)
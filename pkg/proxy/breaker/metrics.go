@@ -0,0 +1,29 @@
+// Copyright 2023 dvonthenen ChatGPT Proxy contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache License 2.0
+
+package breaker
+
+import (
+	"expvar"
+	"sync"
+)
+
+var (
+	metricsOnce sync.Once
+	metrics     *expvar.Map
+)
+
+// publish exposes the status of a breaker on /debug/vars
+func publish(key string, b *Breaker) {
+	metricsOnce.Do(func() {
+		if existing, ok := expvar.Get(metricsName).(*expvar.Map); ok {
+			metrics = existing
+			return
+		}
+		metrics = expvar.NewMap(metricsName)
+	})
+
+	metrics.Set(key, expvar.Func(func() interface{} {
+		return b.Status()
+	}))
+}
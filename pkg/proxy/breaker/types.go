@@ -0,0 +1,100 @@
+// Copyright 2023 dvonthenen ChatGPT Proxy contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache License 2.0
+
+package breaker
+
+import (
+	"sync"
+	"time"
+
+	interfaces "github.com/dvonthenen/chat-gpeasy/pkg/proxy/interfaces"
+)
+
+// StateChangeHandler is told about every state change
+type StateChangeHandler func(change interfaces.BreakerStateChange)
+
+// BreakerOptions configures every breaker in a group
+type BreakerOptions struct {
+	// Window is how far back the error and slow call rates look
+	Window time.Duration
+
+	// MinRequests in the window before the breaker can trip
+	MinRequests int
+
+	// ErrorRateThreshold (0-1) trips the breaker. Rate limits, server errors
+	// and network errors count, other client errors do not.
+	ErrorRateThreshold float64
+
+	// SlowRateThreshold (0-1) of calls slower than SlowCallDuration trips
+	// the breaker. Streams are timed to their first byte.
+	SlowCallDuration  time.Duration
+	SlowRateThreshold float64
+
+	// OpenDuration is how long calls fail fast before probes are let through
+	OpenDuration time.Duration
+
+	// HalfOpenProbes must all succeed for the breaker to close again
+	HalfOpenProbes int
+
+	// OnStateChange is called outside of any lock
+	OnStateChange StateChangeHandler
+}
+
+// Status is a snapshot of a breaker
+type Status struct {
+	Upstream  string     `json:"upstream"`
+	Route     string     `json:"route"`
+	State     string     `json:"state"`
+	Requests  int        `json:"requests"`
+	Failures  int        `json:"failures"`
+	Slow      int        `json:"slow"`
+	ErrorRate float64    `json:"error_rate"`
+	SlowRate  float64    `json:"slow_rate"`
+	OpenedAt  *time.Time `json:"opened_at,omitempty"`
+	Trips     int64      `json:"trips"`
+	Rejected  int64      `json:"rejected"`
+}
+
+// OpenError is returned while a breaker is open
+type OpenError struct {
+	Upstream   string
+	Route      string
+	RetryAfter time.Duration
+}
+
+// Group holds a breaker per upstream and route
+type Group struct {
+	options  *BreakerOptions
+	breakers map[string]*Breaker
+
+	// housekeeping
+	mu sync.Mutex
+}
+
+// Breaker guards the calls to one upstream route
+type Breaker struct {
+	upstream string
+	route    string
+	options  *BreakerOptions
+
+	// state
+	state          string
+	openedAt       time.Time
+	probes         int
+	probeSuccesses int
+	buckets        []bucket
+
+	// counters
+	trips    int64
+	rejected int64
+
+	// housekeeping
+	mu sync.Mutex
+}
+
+type bucket struct {
+	start    time.Time
+	requests int
+	failures int
+	slow     int
+}
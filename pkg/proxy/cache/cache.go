@@ -0,0 +1,423 @@
+// Copyright 2023 dvonthenen ChatGPT Proxy contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache License 2.0
+
+package cache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	openai "github.com/sashabaranov/go-openai"
+	klog "k8s.io/klog/v2"
+
+	interfaces "github.com/dvonthenen/chat-gpeasy/pkg/proxy/interfaces"
+	storage "github.com/dvonthenen/chat-gpeasy/pkg/proxy/storage"
+	vector "github.com/dvonthenen/chat-gpeasy/pkg/vector"
+)
+
+// New creates the cache and starts expiring entries in the background
+func New(embedder Embedder, options CacheOptions) (*SemanticCache, error) {
+	if embedder == nil {
+		klog.V(1).Infof("embedder is nil\n")
+		return nil, ErrInvalidInput
+	}
+	if options.Threshold <= 0 || options.Threshold > 1 {
+		options.Threshold = DefaultThreshold
+	}
+	if options.TTL <= 0 {
+		options.TTL = DefaultTTL
+	}
+	if options.MaxEntries <= 0 {
+		options.MaxEntries = DefaultMaxEntries
+	}
+	if options.EmbeddingModel == openai.Unknown {
+		options.EmbeddingModel = DefaultEmbeddingModel
+	}
+	if options.CleanupInterval <= 0 {
+		options.CleanupInterval = DefaultCleanupInterval
+	}
+
+	sc := &SemanticCache{
+		options:  &options,
+		embedder: embedder,
+		index:    vector.New(),
+		entries:  make(map[string]*Entry),
+		stopChan: make(chan struct{}),
+	}
+
+	if options.Storage != nil {
+		if err := sc.load(); err != nil {
+			klog.V(1).Infof("load failed. Err: %v\n", err)
+			return nil, err
+		}
+	}
+
+	sc.wg.Add(1)
+	go sc.cleanup()
+
+	return sc, nil
+}
+
+// Lookup embeds the last user message and returns the cached answer when one is
+// similar enough and was made for the caller. On a miss the returned Key can be
+// passed to Store.
+func (sc *SemanticCache) Lookup(ctx context.Context, caller string, request openai.ChatCompletionRequest) (*openai.ChatCompletionResponse, *Key, error) {
+	klog.V(6).Infof("SemanticCache.Lookup ENTER\n")
+
+	prompt, ok := cacheable(request)
+	if !ok {
+		klog.V(5).Infof("request is not cacheable\n")
+		klog.V(6).Infof("SemanticCache.Lookup LEAVE\n")
+		return nil, nil, ErrNotCacheable
+	}
+
+	embedding, err := sc.embedder(ctx, sc.options.EmbeddingModel, prompt)
+	if err != nil {
+		klog.V(1).Infof("embedder failed. Err: %v\n", err)
+		klog.V(6).Infof("SemanticCache.Lookup LEAVE\n")
+		return nil, nil, err
+	}
+
+	if sc.options.Shared {
+		caller = ""
+	}
+
+	key := &Key{
+		Scope:  Scope(caller, request),
+		Model:  request.Model,
+		Prompt: prompt,
+		Vector: embedding,
+	}
+
+	matches, err := sc.index.Search(embedding, vector.SearchOptions{
+		K:        lookupCandidates,
+		MinScore: sc.options.Threshold,
+		Filter:   map[string]string{metadataScope: key.Scope},
+	})
+	if err != nil {
+		klog.V(1).Infof("index.Search failed. Err: %v\n", err)
+		klog.V(6).Infof("SemanticCache.Lookup LEAVE\n")
+		return nil, key, err
+	}
+
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	for _, match := range matches {
+		entry, ok := sc.entries[match.ID]
+		if !ok || time.Now().After(entry.ExpiresAt) {
+			continue
+		}
+
+		entry.Hits++
+		sc.stats.Hits++
+
+		klog.V(4).Infof("cache hit %s (score: %f)\n", entry.ID, match.Score)
+		klog.V(6).Infof("SemanticCache.Lookup LEAVE\n")
+
+		response := entry.Response
+		return &response, key, nil
+	}
+
+	sc.stats.Misses++
+
+	klog.V(4).Infof("cache miss\n")
+	klog.V(6).Infof("SemanticCache.Lookup LEAVE\n")
+
+	return nil, key, nil
+}
+
+// Store caches the upstream answer for a Key returned by Lookup
+func (sc *SemanticCache) Store(key *Key, response openai.ChatCompletionResponse) error {
+	if key == nil || len(key.Vector) == 0 {
+		klog.V(1).Infof("key is empty\n")
+		return ErrInvalidInput
+	}
+	if len(response.Choices) == 0 {
+		klog.V(1).Infof("response has no choices\n")
+		return ErrNotCacheable
+	}
+
+	now := time.Now()
+	entry := &Entry{
+		ID:        "cache-" + interfaces.NewEventID(),
+		Scope:     key.Scope,
+		Model:     key.Model,
+		Prompt:    key.Prompt,
+		Response:  response,
+		CreatedAt: now,
+		ExpiresAt: now.Add(sc.options.TTL),
+	}
+
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	err := sc.index.Add(entry.ID, key.Vector, map[string]string{metadataScope: entry.Scope})
+	if err != nil {
+		klog.V(1).Infof("index.Add failed. Err: %v\n", err)
+		return err
+	}
+	sc.entries[entry.ID] = entry
+	sc.stats.Stores++
+
+	if sc.options.Storage != nil {
+		saved := stored{
+			Entry:  *entry,
+			Vector: key.Vector,
+		}
+		if err := storage.SetJSON(sc.options.Storage, keyPrefix+entry.ID, saved, sc.options.TTL); err != nil {
+			klog.V(1).Infof("storage.SetJSON failed. Err: %v\n", err)
+		}
+	}
+
+	sc.evict()
+
+	return nil
+}
+
+// Entries returns the cached entries, newest first
+func (sc *SemanticCache) Entries() []Entry {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	entries := make([]Entry, 0, len(sc.entries))
+	for _, entry := range sc.entries {
+		entries = append(entries, *entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].CreatedAt.After(entries[j].CreatedAt)
+	})
+
+	return entries
+}
+
+// Stats returns a snapshot of the cache counters
+func (sc *SemanticCache) Stats() Stats {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	stats := sc.stats
+	stats.Entries = len(sc.entries)
+	return stats
+}
+
+// Invalidate removes a single entry
+func (sc *SemanticCache) Invalidate(id string) error {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	if _, ok := sc.entries[id]; !ok {
+		return ErrEntryNotFound
+	}
+	sc.remove(id)
+
+	return nil
+}
+
+// InvalidateScope removes every entry for a scope and returns the number removed
+func (sc *SemanticCache) InvalidateScope(scope string) int {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	removed := 0
+	for id, entry := range sc.entries {
+		if entry.Scope == scope {
+			sc.remove(id)
+			removed++
+		}
+	}
+
+	return removed
+}
+
+// InvalidateModel removes every entry for a model and returns the number removed
+func (sc *SemanticCache) InvalidateModel(model string) int {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	removed := 0
+	for id, entry := range sc.entries {
+		if entry.Model == model {
+			sc.remove(id)
+			removed++
+		}
+	}
+
+	return removed
+}
+
+// Purge removes every entry and returns the number removed
+func (sc *SemanticCache) Purge() int {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	removed := len(sc.entries)
+	for id := range sc.entries {
+		sc.remove(id)
+	}
+
+	return removed
+}
+
+// Stop ends the background cleanup
+func (sc *SemanticCache) Stop() error {
+	sc.mu.Lock()
+	if sc.stopped {
+		sc.mu.Unlock()
+		return nil
+	}
+	sc.stopped = true
+	sc.mu.Unlock()
+
+	close(sc.stopChan)
+	sc.wg.Wait()
+
+	return nil
+}
+
+// Scope identifies what a cached answer is valid for: the caller, the model and
+// every message but the last user message, which is matched by similarity. An
+// empty caller is a scope shared by every caller.
+func Scope(caller string, request openai.ChatCompletionRequest) string {
+	prompt := lastUserMessage(request)
+
+	hash := sha256.New()
+	hash.Write([]byte(caller))
+	hash.Write([]byte{0})
+	hash.Write([]byte(request.Model))
+	for i, message := range request.Messages {
+		if i == prompt {
+			continue
+		}
+		hash.Write([]byte{0})
+		hash.Write([]byte(message.Role))
+		hash.Write([]byte{0})
+		hash.Write([]byte(message.Name))
+		hash.Write([]byte{0})
+		hash.Write([]byte(message.Content))
+	}
+	return hex.EncodeToString(hash.Sum(nil))[:32]
+}
+
+// cacheable returns the last user message when the request can be answered
+// from the cache. streams, multiple choices and function calls are not cached.
+func cacheable(request openai.ChatCompletionRequest) (string, bool) {
+	if request.Stream || request.N > 1 || len(request.Functions) > 0 {
+		return "", false
+	}
+
+	i := lastUserMessage(request)
+	if i < 0 {
+		return "", false
+	}
+	prompt := strings.TrimSpace(request.Messages[i].Content)
+	return prompt, len(prompt) > 0
+}
+
+// lastUserMessage returns the index of the last user message, -1 if none
+func lastUserMessage(request openai.ChatCompletionRequest) int {
+	for i := len(request.Messages) - 1; i >= 0; i-- {
+		if request.Messages[i].Role == openai.ChatMessageRoleUser {
+			return i
+		}
+	}
+	return -1
+}
+
+// evict drops the oldest entries above MaxEntries. must be called with mu held.
+func (sc *SemanticCache) evict() {
+	over := len(sc.entries) - sc.options.MaxEntries
+	if over <= 0 {
+		return
+	}
+
+	entries := make([]*Entry, 0, len(sc.entries))
+	for _, entry := range sc.entries {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].CreatedAt.Before(entries[j].CreatedAt)
+	})
+
+	for _, entry := range entries[:over] {
+		sc.remove(entry.ID)
+		sc.stats.Evicted++
+	}
+}
+
+// remove must be called with mu held
+func (sc *SemanticCache) remove(id string) {
+	delete(sc.entries, id)
+	sc.index.Delete(id)
+
+	if sc.options.Storage != nil {
+		err := sc.options.Storage.Delete(keyPrefix + id)
+		if err != nil && !errors.Is(err, storage.ErrNotFound) {
+			klog.V(1).Infof("storage.Delete failed. Err: %v\n", err)
+		}
+	}
+}
+
+// load adds the live entries in storage to the index. Hits are counted in
+// memory only.
+func (sc *SemanticCache) load() error {
+	items, err := sc.options.Storage.List(keyPrefix)
+	if err != nil {
+		return err
+	}
+
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	for _, item := range items {
+		var saved stored
+		if err := json.Unmarshal(item.Value, &saved); err != nil {
+			klog.V(1).Infof("skipping cache entry %s. Err: %v\n", item.Key, err)
+			continue
+		}
+
+		entry := saved.Entry
+		err := sc.index.Add(entry.ID, saved.Vector, map[string]string{metadataScope: entry.Scope})
+		if err != nil {
+			klog.V(1).Infof("index.Add failed. Err: %v\n", err)
+			continue
+		}
+		sc.entries[entry.ID] = &entry
+	}
+	sc.evict()
+
+	klog.V(4).Infof("loaded %d cache entries\n", len(sc.entries))
+	return nil
+}
+
+func (sc *SemanticCache) cleanup() {
+	defer sc.wg.Done()
+
+	ticker := time.NewTicker(sc.options.CleanupInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-sc.stopChan:
+			return
+		case <-ticker.C:
+		}
+
+		now := time.Now()
+
+		sc.mu.Lock()
+		for id, entry := range sc.entries {
+			if now.After(entry.ExpiresAt) {
+				sc.remove(id)
+				sc.stats.Expired++
+			}
+		}
+		sc.mu.Unlock()
+	}
+}
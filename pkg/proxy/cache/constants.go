@@ -0,0 +1,40 @@
+// Copyright 2023 dvonthenen ChatGPT Proxy contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache License 2.0
+
+package cache
+
+import (
+	"errors"
+	"time"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+const (
+	DefaultThreshold       float32               = 0.95
+	DefaultTTL             time.Duration         = 24 * time.Hour
+	DefaultMaxEntries      int                   = 10000
+	DefaultCleanupInterval time.Duration         = 1 * time.Minute
+	DefaultEmbeddingModel  openai.EmbeddingModel = openai.AdaEmbeddingV2
+
+	// lookupCandidates is how many of the closest entries a lookup considers,
+	// the best may have expired without being cleaned up yet
+	lookupCandidates int = 5
+
+	// metadata keys on the vector index
+	metadataScope string = "scope"
+
+	// keyPrefix keys the entries in storage
+	keyPrefix string = "cache/"
+)
+
+var (
+	// ErrInvalidInput required input was not found
+	ErrInvalidInput = errors.New("required input was not found")
+
+	// ErrNotCacheable the request can not be answered from the cache
+	ErrNotCacheable = errors.New("request is not cacheable")
+
+	// ErrEntryNotFound no cache entry with that ID
+	ErrEntryNotFound = errors.New("cache entry not found")
+)
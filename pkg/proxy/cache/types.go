@@ -0,0 +1,99 @@
+// Copyright 2023 dvonthenen ChatGPT Proxy contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache License 2.0
+
+package cache
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	openai "github.com/sashabaranov/go-openai"
+
+	storage "github.com/dvonthenen/chat-gpeasy/pkg/proxy/storage"
+	vector "github.com/dvonthenen/chat-gpeasy/pkg/vector"
+)
+
+// Embedder turns text into a vector. It is provided by the proxy so the cache
+// uses the same upstream client.
+type Embedder func(ctx context.Context, model openai.EmbeddingModel, text string) ([]float32, error)
+
+// CacheOptions configures the semantic cache
+type CacheOptions struct {
+	// Threshold is the minimum cosine similarity for a hit
+	Threshold float32
+
+	// TTL is how long an answer is served from the cache
+	TTL time.Duration
+
+	// MaxEntries evicts the oldest entries once reached
+	MaxEntries int
+
+	// EmbeddingModel used to embed the last user message
+	EmbeddingModel openai.EmbeddingModel
+
+	// Shared serves cached answers to every caller. By default an answer is
+	// only served to the caller it was made for.
+	Shared bool
+
+	CleanupInterval time.Duration
+
+	// Storage keeps the entries so they survive restarts, they are only kept
+	// in memory when nil
+	Storage storage.Store
+}
+
+// Entry is a cached answer
+type Entry struct {
+	ID        string                        `json:"id"`
+	Scope     string                        `json:"scope"`
+	Model     string                        `json:"model"`
+	Prompt    string                        `json:"prompt"`
+	Response  openai.ChatCompletionResponse `json:"response"`
+	Hits      int64                         `json:"hits"`
+	CreatedAt time.Time                     `json:"created_at"`
+	ExpiresAt time.Time                     `json:"expires_at"`
+}
+
+// stored is the form an entry is saved in, with the vector of its prompt
+type stored struct {
+	Entry
+	Vector []float32 `json:"vector"`
+}
+
+// Key is the result of a lookup that missed. Pass it to Store once the
+// upstream answer is available so the prompt is not embedded twice.
+type Key struct {
+	Scope  string
+	Model  string
+	Prompt string
+	Vector []float32
+}
+
+// Stats counters for the cache
+type Stats struct {
+	Entries int   `json:"entries"`
+	Hits    int64 `json:"hits"`
+	Misses  int64 `json:"misses"`
+	Stores  int64 `json:"stores"`
+	Evicted int64 `json:"evicted"`
+	Expired int64 `json:"expired"`
+}
+
+// SemanticCache answers chat completions whose last user message is similar to
+// one answered before for the same caller, model and earlier messages
+type SemanticCache struct {
+	options  *CacheOptions
+	embedder Embedder
+	index    *vector.Index
+
+	// state
+	entries map[string]*Entry
+	stats   Stats
+
+	// housekeeping
+	stopChan chan struct{}
+	stopped  bool
+	wg       sync.WaitGroup
+	mu       sync.Mutex
+}
@@ -0,0 +1,121 @@
+// Copyright 2023 dvonthenen ChatGPT Proxy contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache License 2.0
+
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+
+	"github.com/gin-gonic/gin"
+	openai "github.com/sashabaranov/go-openai"
+	klog "k8s.io/klog/v2"
+
+	coalesce "github.com/dvonthenen/chat-gpeasy/pkg/proxy/coalesce"
+	queue "github.com/dvonthenen/chat-gpeasy/pkg/proxy/queue"
+)
+
+// coalesceEndpoints are coalesced when CoalesceOptions.Routes is empty
+var coalesceEndpoints = []string{
+	routeChatCompletions,
+	routeCompletions,
+	routeEmbeddings,
+}
+
+// coalesceCall makes the upstream call for a request, or shares the result of
+// an identical request already in flight. coalesced is true when the result was
+// shared, it is also recorded for the callback event. The shared call runs
+// without the leader's client, so the leader going away does not fail the
+// requests waiting on it.
+func (p *ChatGPTProxy) coalesceCall(ctx context.Context, c *gin.Context, upstream string, request interface{}, call func(ctx context.Context) (interface{}, error)) (interface{}, error, bool) {
+	route := c.FullPath()
+	if p.coalescer == nil || !p.coalesceRoutes[route] || !coalescable(c, request) {
+		value, err := call(ctx)
+		return value, err, false
+	}
+
+	key, err := coalesce.Key(route, upstream, request)
+	if err != nil {
+		klog.V(1).Infof("coalesce.Key failed. Err: %v\n", err)
+		value, err := call(ctx)
+		return value, err, false
+	}
+
+	for {
+		value, err, coalesced := p.coalescer.Do(key, func() (interface{}, error) {
+			return call(detachClient(ctx))
+		})
+
+		// the shared call gave up on a client, try again while ours is still here
+		if coalesced && errors.Is(err, queue.ErrClientGone) && !clientGone(ctx) {
+			continue
+		}
+		if coalesced {
+			if info := requestInfoFrom(ctx); info != nil {
+				info.setCoalesced()
+			}
+		}
+		return value, err, coalesced
+	}
+}
+
+// coalescable returns true for requests with a deterministic answer. The
+// completion APIs default to temperature 1, so the body has to set 0.
+func coalescable(c *gin.Context, request interface{}) bool {
+	switch req := request.(type) {
+	case openai.EmbeddingRequest:
+		return true
+	case openai.CompletionRequest:
+		return req.Temperature == 0 && temperatureSet(c) && req.N <= 1 && !req.Stream
+	case openai.ChatCompletionRequest:
+		return req.Temperature == 0 && temperatureSet(c) && req.N <= 1 && !req.Stream
+	}
+	return false
+}
+
+// temperatureSet returns true when the body kept by ShouldBindBodyWith has a
+// temperature
+func temperatureSet(c *gin.Context) bool {
+	body, ok := c.Get(gin.BodyBytesKey)
+	if !ok {
+		return false
+	}
+	data, ok := body.([]byte)
+	if !ok {
+		return false
+	}
+
+	var fields struct {
+		Temperature *float32 `json:"temperature"`
+	}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return false
+	}
+	return fields.Temperature != nil
+}
+
+// detachClient keeps the queue ticket of a request without the client that
+// sent it
+func detachClient(ctx context.Context) context.Context {
+	ticket, ok := queue.TicketFrom(ctx)
+	if !ok {
+		return ctx
+	}
+	ticket.Done = nil
+	return queue.WithTicket(ctx, ticket)
+}
+
+// clientGone returns true when the client of a request has disconnected
+func clientGone(ctx context.Context) bool {
+	ticket, ok := queue.TicketFrom(ctx)
+	if !ok || ticket.Done == nil {
+		return false
+	}
+	select {
+	case <-ticket.Done:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,93 @@
+// Copyright 2023 dvonthenen ChatGPT Proxy contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache License 2.0
+
+package coalesce
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+
+	klog "k8s.io/klog/v2"
+)
+
+// New creates a group
+func New() *Group {
+	return &Group{
+		calls: make(map[string]*call),
+	}
+}
+
+// Key hashes a request into its canonical form. The request is marshalled from
+// its typed struct, so field order and whitespace in the client's body do not
+// matter.
+func Key(route, upstream string, request interface{}) (string, error) {
+	if len(route) == 0 || request == nil {
+		return "", ErrInvalidInput
+	}
+
+	body, err := json.Marshal(request)
+	if err != nil {
+		return "", err
+	}
+
+	h := sha256.New()
+	h.Write([]byte(route))
+	h.Write([]byte{0})
+	h.Write([]byte(upstream))
+	h.Write([]byte{0})
+	h.Write(body)
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Do runs fn once for every key in flight. Callers that arrive while it runs
+// wait for the result instead of running fn again; for them coalesced is true.
+func (g *Group) Do(key string, fn func() (interface{}, error)) (value interface{}, err error, coalesced bool) {
+	g.mu.Lock()
+	if c, ok := g.calls[key]; ok {
+		c.waiters++
+		g.stats.Coalesced++
+		g.mu.Unlock()
+
+		klog.V(4).Infof("coalesced request %s\n", key)
+		<-c.done
+		return c.value, c.err, true
+	}
+
+	c := &call{
+		done: make(chan struct{}),
+	}
+	g.calls[key] = c
+	g.stats.Calls++
+	g.mu.Unlock()
+
+	defer func() {
+		if r := recover(); r != nil {
+			c.err = fmt.Errorf("%w: %v", ErrCallPanicked, r)
+			err = c.err
+		}
+
+		g.mu.Lock()
+		delete(g.calls, key)
+		g.mu.Unlock()
+
+		close(c.done)
+	}()
+
+	c.value, c.err = fn()
+
+	return c.value, c.err, false
+}
+
+// Stats returns a snapshot of the counters
+func (g *Group) Stats() Stats {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	stats := g.stats
+	stats.InFlight = len(g.calls)
+
+	return stats
+}
@@ -0,0 +1,160 @@
+// Copyright 2023 dvonthenen ChatGPT Proxy contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache License 2.0
+
+package coalesce
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var errUpstream = errors.New("upstream failed")
+
+// waitFor polls until cond is true or fails the test after a second
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestDo(t *testing.T) {
+	tests := []struct {
+		name  string
+		fn    func() (interface{}, error)
+		value interface{}
+		err   error
+	}{
+		{
+			name:  "value",
+			fn:    func() (interface{}, error) { return "answer", nil },
+			value: "answer",
+		},
+		{
+			name: "error",
+			fn:   func() (interface{}, error) { return nil, errUpstream },
+			err:  errUpstream,
+		},
+		{
+			name: "panic",
+			fn:   func() (interface{}, error) { panic("boom") },
+			err:  ErrCallPanicked,
+		},
+	}
+
+	const callers = 4
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := New()
+			gate := make(chan struct{})
+			var calls int32
+
+			fn := func() (interface{}, error) {
+				atomic.AddInt32(&calls, 1)
+				<-gate
+				return tt.fn()
+			}
+
+			type result struct {
+				value     interface{}
+				err       error
+				coalesced bool
+			}
+			results := make([]result, callers)
+
+			var wg sync.WaitGroup
+			do := func(i int) {
+				defer wg.Done()
+				value, err, coalesced := g.Do("key", fn)
+				results[i] = result{value: value, err: err, coalesced: coalesced}
+			}
+
+			// the first caller makes the call, the others join it
+			wg.Add(callers)
+			go do(0)
+			waitFor(t, "the call to start", func() bool {
+				return g.Stats().InFlight == 1
+			})
+			for i := 1; i < callers; i++ {
+				go do(i)
+			}
+			waitFor(t, "the callers to join", func() bool {
+				return g.Stats().Coalesced == callers-1
+			})
+			close(gate)
+			wg.Wait()
+
+			if calls != 1 {
+				t.Errorf("expected 1 call, got %d", calls)
+			}
+			for i, r := range results {
+				if r.value != tt.value || !errors.Is(r.err, tt.err) {
+					t.Errorf("caller %d: expected (%v, %v), got (%v, %v)", i, tt.value, tt.err, r.value, r.err)
+				}
+				if r.coalesced != (i > 0) {
+					t.Errorf("caller %d: unexpected coalesced %v", i, r.coalesced)
+				}
+			}
+
+			// the key is free again
+			stats := g.Stats()
+			if stats.InFlight != 0 || stats.Calls != 1 || stats.Coalesced != callers-1 {
+				t.Errorf("unexpected stats %+v", stats)
+			}
+			value, err, coalesced := g.Do("key", func() (interface{}, error) { return "again", nil })
+			if value != "again" || err != nil || coalesced {
+				t.Errorf("expected a new call, got (%v, %v, %v)", value, err, coalesced)
+			}
+		})
+	}
+}
+
+func TestKey(t *testing.T) {
+	request := map[string]interface{}{"model": "gpt-3.5-turbo", "temperature": 0}
+	other := map[string]interface{}{"model": "gpt-4", "temperature": 0}
+
+	base, err := Key("/v1/chat/completions", "openai", request)
+	if err != nil {
+		t.Fatalf("Key failed. Err: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		route    string
+		upstream string
+		request  interface{}
+		same     bool
+		err      error
+	}{
+		{"same request", "/v1/chat/completions", "openai", request, true, nil},
+		{"other route", "/v1/completions", "openai", request, false, nil},
+		{"other upstream", "/v1/chat/completions", "https://candidate.example.com", request, false, nil},
+		{"other request", "/v1/chat/completions", "openai", other, false, nil},
+		{"no route", "", "openai", request, false, ErrInvalidInput},
+		{"no request", "/v1/chat/completions", "openai", nil, false, ErrInvalidInput},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := Key(tt.route, tt.upstream, tt.request)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("expected %v, got %v", tt.err, err)
+			}
+			if tt.err != nil {
+				return
+			}
+			if (key == base) != tt.same {
+				t.Errorf("expected same key %v", tt.same)
+			}
+		})
+	}
+}
@@ -0,0 +1,16 @@
+// Copyright 2023 dvonthenen ChatGPT Proxy contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache License 2.0
+
+package coalesce
+
+import (
+	"errors"
+)
+
+var (
+	// ErrInvalidInput required input was not found
+	ErrInvalidInput = errors.New("required input was not found")
+
+	// ErrCallPanicked the shared call panicked
+	ErrCallPanicked = errors.New("coalesced call panicked")
+)
@@ -0,0 +1,38 @@
+// Copyright 2023 dvonthenen ChatGPT Proxy contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache License 2.0
+
+package coalesce
+
+import (
+	"sync"
+)
+
+// CoalesceOptions configures request coalescing
+type CoalesceOptions struct {
+	// Routes that are coalesced. Embeddings, completions and chat completions
+	// when empty. Completions are only coalesced when the body sets temperature 0.
+	Routes []string
+}
+
+// Stats counters for coalesced requests
+type Stats struct {
+	InFlight  int   `json:"in_flight"`
+	Calls     int64 `json:"calls"`
+	Coalesced int64 `json:"coalesced"`
+}
+
+// Group makes one call for identical concurrent requests
+type Group struct {
+	calls map[string]*call
+	stats Stats
+
+	// housekeeping
+	mu sync.Mutex
+}
+
+type call struct {
+	waiters int
+	value   interface{}
+	err     error
+	done    chan struct{}
+}
@@ -5,10 +5,118 @@ package proxy
 
 import (
 	"errors"
+	"time"
 )
 
 const (
 	DefaultPort int = 443
+
+	// HeaderDryRun returns an estimate instead of calling OpenAI
+	HeaderDryRun    string = "dry_run"
+	HeaderDryRunAlt string = "Dry-Run"
+
+	// semantic cache headers
+	HeaderCache        string = "X-Cache"
+	HeaderCacheScope   string = "X-Cache-Scope"
+	HeaderCacheControl string = "Cache-Control"
+
+	// HeaderRetryAfter is set when a circuit breaker is open
+	HeaderRetryAfter string = "Retry-After"
+
+	// HeaderAuthorization carries the caller's bearer token, a JWT when
+	// authentication is enabled
+	HeaderAuthorization   string = "Authorization"
+	HeaderWWWAuthenticate string = "WWW-Authenticate"
+
+	// EstimateObject object type returned by the estimate endpoints
+	EstimateObject string = "estimate"
+	StatusObject   string = "status"
+	TokenizeObject string = "tokenize"
+	ListObject     string = "list"
+)
+
+const (
+	// frames a client sends on /v1/chat/ws
+	FrameInit      string = "init"
+	FrameQuery     string = "query"
+	FrameEdit      string = "edit"
+	FrameDirective string = "directive"
+	FrameCancel    string = "cancel"
+
+	// FrameConversation asks for the conversation and carries it back
+	FrameConversation string = "conversation"
+
+	// frames the proxy sends on /v1/chat/ws
+	FrameToken string = "token"
+	FrameDone  string = "done"
+	FrameError string = "error"
+
+	// finish reasons of a done frame
+	FinishReasonStop          string = "stop"
+	FinishReasonCancelled     string = "cancelled"
+	FinishReasonContentFilter string = "content_filter"
+
+	// DefaultChatSocketPingInterval is how often an idle chat socket is pinged
+	DefaultChatSocketPingInterval time.Duration = 30 * time.Second
+
+	// DefaultChatSocketReadLimit is the largest frame read from a chat socket
+	DefaultChatSocketReadLimit int64 = 1024 * 1024
+)
+
+const (
+	routeCompletions     string = "/v1/completions"
+	routeChatCompletions string = "/v1/chat/completions"
+	routeEmbeddings      string = "/v1/embeddings"
+	routeModerations     string = "/v1/moderations"
+	routeChatSocket      string = "/v1/chat/ws"
+
+	// server-sent events of streamed completions
+	streamContentType         string = "text/event-stream"
+	streamDataPrefix          string = "data: "
+	streamDone                string = "[DONE]"
+	chatCompletionObject      string = "chat.completion"
+	chatCompletionChunkObject string = "chat.completion.chunk"
+	textCompletionObject      string = "text_completion"
+
+	// chat sockets
+	chatSocketWriteWait time.Duration = 10 * time.Second
+	defaultChatSkill    string        = "generic"
+
+	// upstreamOpenAI names the proxy's own client in circuit breakers
+	upstreamOpenAI string = "openai"
+
+	// authentication
+	authChallenge      string = `Bearer error="invalid_token"`
+	contextKeyIdentity string = "identity"
+
+	// users sent upstream for anonymous callers
+	callerUserPrefix  string = "caller-"
+	callerUserHashLen int    = 16
+
+	// concurrency limits
+	bearerPrefix              string = "Bearer "
+	queueRetryAfter           int    = 1
+	statusClientClosedRequest int    = 499
+
+	defaultCompletionMaxTokens int = 16
+
+	defaultBatchFilename string = "batch.jsonl"
+	contentTypeJSONL     string = "application/jsonl"
+
+	// usage reports
+	usageFormatCSV     string = "csv"
+	usageFormatJSON    string = "json"
+	contentTypeCSV     string = "text/csv"
+	contentDisposition string = "Content-Disposition"
+
+	cacheHit            string = "HIT"
+	cacheMiss           string = "MISS"
+	cacheControlNoCache string = "no-cache"
+	cacheControlNoStore string = "no-store"
+
+	// largest audio upload accepted before it is split
+	defaultMaxAudioBytes int64  = 1024 * 1024 * 1024
+	defaultAudioFilename string = "audio.wav"
 )
 
 var (
@@ -17,4 +125,28 @@ var (
 
 	// ErrInvalidOpenAiClient invalid open ai client
 	ErrInvalidOpenAiClient = errors.New("invalid open ai client")
+
+	// ErrUnsupportedRequest body is not a chat, completion or embedding request
+	ErrUnsupportedRequest = errors.New("body is not a chat, completion or embedding request")
+
+	// ErrCallbackExists a callback with the same name is already registered
+	ErrCallbackExists = errors.New("callback already registered")
+
+	// ErrCallbackNotFound no callback with that name is registered
+	ErrCallbackNotFound = errors.New("callback not found")
+
+	// ErrCallbackPanic the callback panicked
+	ErrCallbackPanic = errors.New("callback panicked")
+
+	// ErrPromptBlocked the prompt guard blocked the prompt
+	ErrPromptBlocked = errors.New("prompt blocked by policy")
+
+	// ErrAdminNotConfigured no admin keys or policies are configured
+	ErrAdminNotConfigured = errors.New("admin routes are not configured")
+
+	// ErrAdminRequired the caller is not an admin
+	ErrAdminRequired = errors.New("admin access required")
+
+	// errOutputBlocked the output filter blocked a streamed reply
+	errOutputBlocked = errors.New("output blocked by policy")
 )
@@ -10,6 +10,8 @@ import (
 	"github.com/davecgh/go-spew/spew"
 	openai "github.com/sashabaranov/go-openai"
 	klog "k8s.io/klog/v2"
+
+	interfaces "github.com/dvonthenen/chat-gpeasy/pkg/proxy/interfaces"
 )
 
 type DefaultChatGPTCallback struct {
@@ -62,6 +64,9 @@ func (dcc *DefaultChatGPTCallback) CreateChatCompletion(request openai.ChatCompl
 	klog.Infof("CreateChatCompletion:\n\n")
 	klog.Infof("Request:\n%s\n\n", spew.Sdump(request))
 	klog.Infof("Response:\n%s\n", spew.Sdump(response))
+	for _, call := range interfaces.FunctionCalls(response) {
+		klog.Infof("Function Call: %s(%s)\n", call.Name, call.Arguments)
+	}
 	klog.Infof("-------------------------------\n\n")
 	return nil
 }
@@ -215,3 +220,23 @@ func (dcc *DefaultChatGPTCallback) Moderations(request openai.ModerationRequest,
 	klog.Infof("-------------------------------\n\n")
 	return nil
 }
+
+func (dcc *DefaultChatGPTCallback) CircuitBreaker(change interfaces.BreakerStateChange) error {
+	klog.Infof("\n\n-------------------------------\n")
+	klog.Infof("CircuitBreaker:\n\n")
+	klog.Infof("%s %s: %s -> %s (%s)\n", change.Upstream, change.Route, change.From, change.To, change.Reason)
+	klog.Infof("Requests: %d, Error Rate: %.2f, Slow Rate: %.2f\n", change.Requests, change.ErrorRate, change.SlowRate)
+	klog.Infof("-------------------------------\n\n")
+	return nil
+}
+
+func (dcc *DefaultChatGPTCallback) PromptBlocked(request interface{}, detections []interfaces.Detection) error {
+	klog.Infof("\n\n-------------------------------\n")
+	klog.Infof("PromptBlocked:\n\n")
+	klog.Infof("Request:\n%s\n\n", spew.Sdump(request))
+	for _, detection := range detections {
+		klog.Infof("Detection: %s %s (%.2f)\n", detection.Kind, detection.Name, detection.Score)
+	}
+	klog.Infof("-------------------------------\n\n")
+	return nil
+}
@@ -0,0 +1,96 @@
+// Copyright 2023 dvonthenen ChatGPT Proxy contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache License 2.0
+
+package dispatch
+
+import (
+	openai "github.com/sashabaranov/go-openai"
+
+	interfaces "github.com/dvonthenen/chat-gpeasy/pkg/proxy/interfaces"
+)
+
+func (d *Dispatcher) CreateTranscription(request openai.AudioRequest, response openai.AudioResponse) error {
+	return d.submit(interfaces.NewEvent(interfaces.EventTypeCreateTranscription, request, response))
+}
+
+func (d *Dispatcher) CreateTranslation(request openai.AudioRequest, response openai.AudioResponse) error {
+	return d.submit(interfaces.NewEvent(interfaces.EventTypeCreateTranslation, request, response))
+}
+
+func (d *Dispatcher) CreateCompletion(request openai.CompletionRequest, response openai.CompletionResponse) error {
+	return d.submit(interfaces.NewEvent(interfaces.EventTypeCreateCompletion, request, response))
+}
+
+func (d *Dispatcher) CreateChatCompletion(request openai.ChatCompletionRequest, response openai.ChatCompletionResponse) error {
+	return d.submit(interfaces.NewEvent(interfaces.EventTypeCreateChatCompletion, request, response))
+}
+
+func (d *Dispatcher) Edits(request openai.EditsRequest, response openai.EditsResponse) error {
+	return d.submit(interfaces.NewEvent(interfaces.EventTypeEdits, request, response))
+}
+
+func (d *Dispatcher) CreateEmbeddings(request openai.EmbeddingRequest, response openai.EmbeddingResponse) error {
+	return d.submit(interfaces.NewEvent(interfaces.EventTypeCreateEmbeddings, request, response))
+}
+
+func (d *Dispatcher) ListFiles(list openai.FilesList) error {
+	return d.submit(interfaces.NewEvent(interfaces.EventTypeListFiles, nil, list))
+}
+
+func (d *Dispatcher) CreateFile(request openai.FileRequest, response openai.File) error {
+	return d.submit(interfaces.NewEvent(interfaces.EventTypeCreateFile, request, response))
+}
+
+func (d *Dispatcher) DeleteFile(ID string) error {
+	return d.submit(interfaces.NewEvent(interfaces.EventTypeDeleteFile, interfaces.IDRequest{ID: ID}, nil))
+}
+
+func (d *Dispatcher) GetFile(ID string, response openai.File) error {
+	return d.submit(interfaces.NewEvent(interfaces.EventTypeGetFile, interfaces.IDRequest{ID: ID}, response))
+}
+
+// GetFileContent: Not implemented in Go SDK
+
+func (d *Dispatcher) CreateFineTune(request openai.FineTuneRequest, response openai.FineTune) error {
+	return d.submit(interfaces.NewEvent(interfaces.EventTypeCreateFineTune, request, response))
+}
+
+func (d *Dispatcher) ListFineTunes(list openai.FineTuneList) error {
+	return d.submit(interfaces.NewEvent(interfaces.EventTypeListFineTunes, nil, list))
+}
+
+func (d *Dispatcher) GetFineTune(ID string, response openai.FineTune) error {
+	return d.submit(interfaces.NewEvent(interfaces.EventTypeGetFineTune, interfaces.IDRequest{ID: ID}, response))
+}
+
+func (d *Dispatcher) CancelFineTune(ID string, response openai.FineTune) error {
+	return d.submit(interfaces.NewEvent(interfaces.EventTypeCancelFineTune, interfaces.IDRequest{ID: ID}, response))
+}
+
+func (d *Dispatcher) ListFineTuneEvents(ID string, response openai.FineTuneEventList) error {
+	return d.submit(interfaces.NewEvent(interfaces.EventTypeListFineTuneEvents, interfaces.IDRequest{ID: ID}, response))
+}
+
+func (d *Dispatcher) DeleteFineTune(ID string) error {
+	return d.submit(interfaces.NewEvent(interfaces.EventTypeDeleteFineTune, interfaces.IDRequest{ID: ID}, nil))
+}
+
+func (d *Dispatcher) CreateImage(request openai.ImageRequest, response openai.ImageResponse) error {
+	return d.submit(interfaces.NewEvent(interfaces.EventTypeCreateImage, request, response))
+}
+
+func (d *Dispatcher) CreateEditImage(request openai.ImageEditRequest, response openai.ImageResponse) error {
+	return d.submit(interfaces.NewEvent(interfaces.EventTypeCreateEditImage, request, response))
+}
+
+func (d *Dispatcher) CreateVariImage(request openai.ImageVariRequest, response openai.ImageResponse) error {
+	return d.submit(interfaces.NewEvent(interfaces.EventTypeCreateVariImage, request, response))
+}
+
+func (d *Dispatcher) ListModels(list openai.ModelsList) error {
+	return d.submit(interfaces.NewEvent(interfaces.EventTypeListModels, nil, list))
+}
+
+func (d *Dispatcher) Moderations(request openai.ModerationRequest, response openai.ModerationResponse) error {
+	return d.submit(interfaces.NewEvent(interfaces.EventTypeModerations, request, response))
+}
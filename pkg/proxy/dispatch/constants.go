@@ -0,0 +1,47 @@
+// Copyright 2023 dvonthenen ChatGPT Proxy contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache License 2.0
+
+package dispatch
+
+import (
+	"errors"
+	"time"
+)
+
+// OverflowPolicy decides what happens to an event when the queue is full
+type OverflowPolicy int64
+
+const (
+	// OverflowBlock waits for room in the queue
+	OverflowBlock OverflowPolicy = iota
+
+	// OverflowDrop discards the event
+	OverflowDrop
+
+	// OverflowSpill writes the event to SpillDir and replays it once the queue has room
+	OverflowSpill
+)
+
+const (
+	DefaultWorkers       int           = 4
+	DefaultQueueSize     int           = 1000
+	DefaultTimeout       time.Duration = 30 * time.Second
+	DefaultDrainTimeout  time.Duration = 10 * time.Second
+	DefaultSpillInterval time.Duration = 1 * time.Second
+
+	spillExtension string = ".json"
+)
+
+var (
+	// ErrInvalidInput required input was not found
+	ErrInvalidInput = errors.New("required input was not found")
+
+	// ErrQueueFull the event was dropped because the queue is full
+	ErrQueueFull = errors.New("dispatch queue is full")
+
+	// ErrStopped the dispatcher has been stopped
+	ErrStopped = errors.New("dispatcher has been stopped")
+
+	// ErrTimeout the callback did not finish within the timeout
+	ErrTimeout = errors.New("callback timed out")
+)
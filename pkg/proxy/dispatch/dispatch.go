@@ -0,0 +1,224 @@
+// Copyright 2023 dvonthenen ChatGPT Proxy contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache License 2.0
+
+package dispatch
+
+import (
+	"os"
+	"time"
+
+	klog "k8s.io/klog/v2"
+
+	interfaces "github.com/dvonthenen/chat-gpeasy/pkg/proxy/interfaces"
+)
+
+// New wraps a callback with a dispatcher and starts the workers. Events spilled
+// by a previous run are replayed.
+func New(callback *interfaces.ChatGPTCallback, options DispatcherOptions) (*Dispatcher, error) {
+	if callback == nil {
+		klog.V(1).Infof("callback is nil\n")
+		return nil, ErrInvalidInput
+	}
+	if options.Workers <= 0 {
+		options.Workers = DefaultWorkers
+	}
+	if options.QueueSize <= 0 {
+		options.QueueSize = DefaultQueueSize
+	}
+	if options.Timeout <= 0 {
+		options.Timeout = DefaultTimeout
+	}
+	if options.DrainTimeout <= 0 {
+		options.DrainTimeout = DefaultDrainTimeout
+	}
+	if options.SpillInterval <= 0 {
+		options.SpillInterval = DefaultSpillInterval
+	}
+	if options.Overflow == OverflowSpill && len(options.SpillDir) == 0 {
+		klog.V(1).Infof("SpillDir is required for OverflowSpill\n")
+		return nil, ErrInvalidInput
+	}
+
+	d := &Dispatcher{
+		callback:  *callback,
+		options:   &options,
+		queue:     make(chan *interfaces.Event, options.QueueSize),
+		spillWake: make(chan struct{}, 1),
+		stopChan:  make(chan struct{}),
+	}
+
+	if options.Synchronous {
+		klog.V(4).Infof("dispatcher running in synchronous mode\n")
+		return d, nil
+	}
+
+	if len(options.SpillDir) > 0 {
+		err := os.MkdirAll(options.SpillDir, 0700)
+		if err != nil {
+			klog.V(1).Infof("os.MkdirAll failed. Err: %v\n", err)
+			return nil, err
+		}
+
+		d.spiller.Add(1)
+		go d.replaySpilled()
+	}
+
+	d.workers.Add(options.Workers)
+	for i := 0; i < options.Workers; i++ {
+		go d.worker()
+	}
+
+	return d, nil
+}
+
+// OnEvent implements interfaces.ChatGPTEventCallback
+func (d *Dispatcher) OnEvent(event *interfaces.Event) error {
+	return d.submit(event)
+}
+
+// Stats returns a snapshot of the dispatcher counters
+func (d *Dispatcher) Stats() Stats {
+	d.statsMu.Lock()
+	defer d.statsMu.Unlock()
+
+	stats := d.stats
+	stats.Depth = len(d.queue)
+	return stats
+}
+
+// Stop rejects new events and waits up to DrainTimeout for queued and spilled
+// events to be processed. Events still queued after that are spilled to disk
+// when SpillDir is set, otherwise they are dropped.
+func (d *Dispatcher) Stop() error {
+	klog.V(6).Infof("Dispatcher.Stop ENTER\n")
+
+	d.mu.Lock()
+	if d.stopped {
+		d.mu.Unlock()
+		klog.V(6).Infof("Dispatcher.Stop LEAVE\n")
+		return nil
+	}
+	d.stopped = true
+	d.mu.Unlock()
+
+	if d.options.Synchronous {
+		klog.V(6).Infof("Dispatcher.Stop LEAVE\n")
+		return nil
+	}
+
+	close(d.stopChan)
+	d.spiller.Wait()
+
+	deadline := time.Now().Add(d.options.DrainTimeout)
+	if len(d.options.SpillDir) > 0 {
+		d.drainSpilled(deadline)
+	}
+	close(d.queue)
+
+	done := make(chan struct{})
+	go func() {
+		d.workers.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		klog.V(4).Infof("dispatcher drained\n")
+	case <-time.After(time.Until(deadline)):
+		leftover := 0
+		for event := range d.queue {
+			leftover++
+			d.overflow(event, true)
+		}
+		klog.V(1).Infof("dispatcher drain timed out with %d events left\n", leftover)
+	}
+
+	klog.V(6).Infof("Dispatcher.Stop LEAVE\n")
+	return nil
+}
+
+func (d *Dispatcher) submit(event *interfaces.Event) error {
+	if d.options.Synchronous {
+		return d.run(event)
+	}
+
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	if d.stopped {
+		return ErrStopped
+	}
+
+	select {
+	case d.queue <- event:
+		d.count(&d.stats.Queued)
+		return nil
+	default:
+	}
+
+	if d.options.Overflow == OverflowBlock {
+		d.queue <- event
+		d.count(&d.stats.Queued)
+		return nil
+	}
+
+	return d.overflow(event, false)
+}
+
+// overflow drops or spills an event that could not be queued
+func (d *Dispatcher) overflow(event *interfaces.Event, stopping bool) error {
+	if d.options.Overflow == OverflowSpill || (stopping && len(d.options.SpillDir) > 0) {
+		err := d.spill(event)
+		if err == nil {
+			d.count(&d.stats.Spilled)
+			return nil
+		}
+		klog.V(1).Infof("spill failed. Err: %v\n", err)
+	}
+
+	klog.V(3).Infof("dropping %s event %s\n", event.Type, event.ID)
+	d.count(&d.stats.Dropped)
+	return ErrQueueFull
+}
+
+func (d *Dispatcher) worker() {
+	defer d.workers.Done()
+
+	for event := range d.queue {
+		err := d.run(event)
+		if err != nil {
+			klog.V(1).Infof("[CALLBACK] %s failed. Err: %v\n", event.Type, err)
+		}
+	}
+}
+
+// run invokes the callback and gives up waiting after Timeout. callbacks do not
+// take a context so a timed out callback keeps running in the background.
+func (d *Dispatcher) run(event *interfaces.Event) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- interfaces.Dispatch(d.callback, event)
+	}()
+
+	timer := time.NewTimer(d.options.Timeout)
+	defer timer.Stop()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			d.count(&d.stats.Failed)
+			return err
+		}
+		d.count(&d.stats.Processed)
+		return nil
+	case <-timer.C:
+		d.count(&d.stats.TimedOut)
+		return ErrTimeout
+	}
+}
+
+func (d *Dispatcher) count(counter *int64) {
+	d.statsMu.Lock()
+	*counter++
+	d.statsMu.Unlock()
+}
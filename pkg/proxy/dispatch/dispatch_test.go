@@ -0,0 +1,186 @@
+// Copyright 2023 dvonthenen ChatGPT Proxy contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache License 2.0
+
+package dispatch
+
+import (
+	"errors"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	interfaces "github.com/dvonthenen/chat-gpeasy/pkg/proxy/interfaces"
+)
+
+// gatedCallback holds every event until the gate is closed. The typed methods
+// are never called because Dispatch prefers OnEvent.
+type gatedCallback struct {
+	interfaces.ChatGPTCallback
+
+	started chan struct{}
+	gate    chan struct{}
+	calls   int32
+}
+
+func newGatedCallback() *gatedCallback {
+	return &gatedCallback{
+		started: make(chan struct{}, 16),
+		gate:    make(chan struct{}),
+	}
+}
+
+func (gc *gatedCallback) OnEvent(event *interfaces.Event) error {
+	gc.started <- struct{}{}
+	<-gc.gate
+	atomic.AddInt32(&gc.calls, 1)
+	return nil
+}
+
+func newTestEvent() *interfaces.Event {
+	return interfaces.NewEvent(interfaces.EventTypeDeleteFile, interfaces.IDRequest{ID: "file-1"}, nil)
+}
+
+// waitFor polls until cond is true or fails the test after a second
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestOverflow(t *testing.T) {
+	tests := []struct {
+		name     string
+		overflow OverflowPolicy
+		spill    bool
+		blocks   bool
+		err      error
+		stats    Stats
+	}{
+		{
+			name:     "block",
+			overflow: OverflowBlock,
+			blocks:   true,
+			stats:    Stats{Queued: 3, Processed: 3},
+		},
+		{
+			name:     "drop",
+			overflow: OverflowDrop,
+			err:      ErrQueueFull,
+			stats:    Stats{Queued: 2, Processed: 2, Dropped: 1},
+		},
+		{
+			name:     "spill",
+			overflow: OverflowSpill,
+			spill:    true,
+			stats:    Stats{Queued: 3, Processed: 3, Spilled: 1},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			callback := newGatedCallback()
+			var wrapped interfaces.ChatGPTCallback = callback
+
+			options := DispatcherOptions{
+				Workers:       1,
+				QueueSize:     1,
+				Overflow:      tt.overflow,
+				SpillInterval: 10 * time.Millisecond,
+			}
+			if tt.spill {
+				options.SpillDir = t.TempDir()
+			}
+
+			d, err := New(&wrapped, options)
+			if err != nil {
+				t.Fatalf("New failed. Err: %v", err)
+			}
+
+			// the worker holds the first event, the second fills the queue
+			if err := d.OnEvent(newTestEvent()); err != nil {
+				t.Fatalf("OnEvent failed. Err: %v", err)
+			}
+			<-callback.started
+			if err := d.OnEvent(newTestEvent()); err != nil {
+				t.Fatalf("OnEvent failed. Err: %v", err)
+			}
+
+			// the third overflows
+			result := make(chan error, 1)
+			go func() {
+				result <- d.OnEvent(newTestEvent())
+			}()
+
+			if tt.blocks {
+				select {
+				case err := <-result:
+					t.Fatalf("OnEvent returned %v while the queue was full", err)
+				case <-time.After(50 * time.Millisecond):
+				}
+				close(callback.gate)
+			}
+
+			select {
+			case err := <-result:
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("expected %v, got %v", tt.err, err)
+				}
+			case <-time.After(time.Second):
+				t.Fatalf("OnEvent did not return")
+			}
+
+			if tt.spill {
+				files, err := os.ReadDir(options.SpillDir)
+				if err != nil {
+					t.Fatalf("os.ReadDir failed. Err: %v", err)
+				}
+				if len(files) != 1 {
+					t.Fatalf("expected 1 spilled event, got %d", len(files))
+				}
+			}
+
+			if !tt.blocks {
+				close(callback.gate)
+			}
+
+			waitFor(t, "the events to be processed", func() bool {
+				return d.Stats().Processed == tt.stats.Processed
+			})
+			if err := d.Stop(); err != nil {
+				t.Fatalf("Stop failed. Err: %v", err)
+			}
+
+			if stats := d.Stats(); stats != tt.stats {
+				t.Errorf("expected %+v, got %+v", tt.stats, stats)
+			}
+			if calls := atomic.LoadInt32(&callback.calls); int64(calls) != tt.stats.Processed {
+				t.Errorf("expected %d calls, got %d", tt.stats.Processed, calls)
+			}
+		})
+	}
+}
+
+func TestSubmitAfterStop(t *testing.T) {
+	callback := newGatedCallback()
+	close(callback.gate)
+	var wrapped interfaces.ChatGPTCallback = callback
+
+	d, err := New(&wrapped, DispatcherOptions{})
+	if err != nil {
+		t.Fatalf("New failed. Err: %v", err)
+	}
+	if err := d.Stop(); err != nil {
+		t.Fatalf("Stop failed. Err: %v", err)
+	}
+
+	if err := d.OnEvent(newTestEvent()); !errors.Is(err, ErrStopped) {
+		t.Fatalf("expected %v, got %v", ErrStopped, err)
+	}
+}
@@ -0,0 +1,150 @@
+// Copyright 2023 dvonthenen ChatGPT Proxy contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache License 2.0
+
+package dispatch
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	klog "k8s.io/klog/v2"
+
+	interfaces "github.com/dvonthenen/chat-gpeasy/pkg/proxy/interfaces"
+)
+
+// spill writes the event to SpillDir. file names sort in spill order.
+func (d *Dispatcher) spill(event *interfaces.Event) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf("%020d-%s%s", time.Now().UnixNano(), event.ID, spillExtension)
+	path := filepath.Join(d.options.SpillDir, name)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+
+	select {
+	case d.spillWake <- struct{}{}:
+	default:
+	}
+
+	return nil
+}
+
+// spilled lists the spilled events, oldest first
+func (d *Dispatcher) spilled() []string {
+	files, err := os.ReadDir(d.options.SpillDir)
+	if err != nil {
+		klog.V(1).Infof("os.ReadDir failed. Err: %v\n", err)
+		return nil
+	}
+
+	names := make([]string, 0, len(files))
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), spillExtension) {
+			continue
+		}
+		names = append(names, file.Name())
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+func (d *Dispatcher) readSpilled(name string) (*interfaces.Event, error) {
+	path := filepath.Join(d.options.SpillDir, name)
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var event interfaces.Event
+	if err := json.Unmarshal(data, &event); err != nil {
+		klog.V(1).Infof("discarding corrupt spilled event %s. Err: %v\n", name, err)
+		os.Remove(path) //nolint:errcheck
+		return nil, err
+	}
+
+	return &event, nil
+}
+
+// replaySpilled moves spilled events back into the queue whenever there is room
+func (d *Dispatcher) replaySpilled() {
+	defer d.spiller.Done()
+
+	ticker := time.NewTicker(d.options.SpillInterval)
+	defer ticker.Stop()
+
+	for {
+		d.requeueSpilled()
+
+		select {
+		case <-d.stopChan:
+			return
+		case <-d.spillWake:
+		case <-ticker.C:
+		}
+	}
+}
+
+func (d *Dispatcher) requeueSpilled() {
+	for _, name := range d.spilled() {
+		event, err := d.readSpilled(name)
+		if err != nil {
+			continue
+		}
+
+		d.mu.RLock()
+		queued := false
+		if !d.stopped {
+			select {
+			case d.queue <- event:
+				queued = true
+			default:
+			}
+		}
+		d.mu.RUnlock()
+
+		if !queued {
+			return
+		}
+
+		d.count(&d.stats.Queued)
+		os.Remove(filepath.Join(d.options.SpillDir, name)) //nolint:errcheck
+	}
+}
+
+// drainSpilled blocks on the queue until every spilled event is queued or the
+// deadline passes. called from Stop once new submissions are rejected.
+func (d *Dispatcher) drainSpilled(deadline time.Time) {
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+
+	for _, name := range d.spilled() {
+		event, err := d.readSpilled(name)
+		if err != nil {
+			continue
+		}
+
+		select {
+		case d.queue <- event:
+			d.count(&d.stats.Queued)
+			os.Remove(filepath.Join(d.options.SpillDir, name)) //nolint:errcheck
+		case <-timer.C:
+			klog.V(3).Infof("spilled events remain in %s for the next run\n", d.options.SpillDir)
+			return
+		}
+	}
+}
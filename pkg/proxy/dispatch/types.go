@@ -0,0 +1,62 @@
+// Copyright 2023 dvonthenen ChatGPT Proxy contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache License 2.0
+
+package dispatch
+
+import (
+	"sync"
+	"time"
+
+	interfaces "github.com/dvonthenen/chat-gpeasy/pkg/proxy/interfaces"
+)
+
+// DispatcherOptions configures the worker pool in front of a callback
+type DispatcherOptions struct {
+	// Synchronous runs the callback inline, before the proxy writes the response
+	Synchronous bool
+
+	Workers   int
+	QueueSize int
+	Overflow  OverflowPolicy
+
+	// SpillDir holds events that did not fit in the queue when Overflow is
+	// OverflowSpill. Spilled events survive a restart.
+	SpillDir      string
+	SpillInterval time.Duration
+
+	// Timeout bounds a single callback invocation
+	Timeout time.Duration
+
+	// DrainTimeout bounds how long Stop waits for the queue to empty
+	DrainTimeout time.Duration
+}
+
+// Stats are running totals since the dispatcher was created
+type Stats struct {
+	Queued    int64 `json:"queued"`
+	Processed int64 `json:"processed"`
+	Failed    int64 `json:"failed"`
+	TimedOut  int64 `json:"timed_out"`
+	Dropped   int64 `json:"dropped"`
+	Spilled   int64 `json:"spilled"`
+	Depth     int   `json:"depth"`
+}
+
+// Dispatcher implements interfaces.ChatGPTCallback and hands every call to the
+// wrapped callback on a bounded pool of workers
+type Dispatcher struct {
+	callback interfaces.ChatGPTCallback
+	options  *DispatcherOptions
+
+	queue     chan *interfaces.Event
+	spillWake chan struct{}
+	stats     Stats
+
+	// housekeeping
+	stopChan chan struct{}
+	stopped  bool
+	workers  sync.WaitGroup
+	spiller  sync.WaitGroup
+	mu       sync.RWMutex
+	statsMu  sync.Mutex
+}
@@ -0,0 +1,229 @@
+// Copyright 2023 dvonthenen ChatGPT Proxy contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache License 2.0
+
+package proxy
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	openai "github.com/sashabaranov/go-openai"
+	klog "k8s.io/klog/v2"
+
+	tokens "github.com/dvonthenen/chat-gpeasy/pkg/tokens"
+)
+
+// isDryRun returns true when the client asked for an estimate instead of an
+// upstream call
+func isDryRun(c *gin.Context) bool {
+	for _, header := range []string{HeaderDryRun, HeaderDryRunAlt} {
+		value := c.GetHeader(header)
+		if len(value) == 0 {
+			continue
+		}
+		dryRun, err := strconv.ParseBool(strings.TrimSpace(value))
+		if err != nil {
+			klog.V(1).Infof("invalid %s header value: %s\n", header, value)
+			continue
+		}
+		return dryRun
+	}
+	return false
+}
+
+// estimateRequest detects which kind of request the body holds and estimates it
+func estimateRequest(body []byte) (*Estimate, error) {
+	var probe struct {
+		Messages json.RawMessage `json:"messages"`
+		Prompt   json.RawMessage `json:"prompt"`
+		Input    json.RawMessage `json:"input"`
+	}
+	if err := json.Unmarshal(body, &probe); err != nil {
+		klog.V(1).Infof("json.Unmarshal failed. Err: %v\n", err)
+		return nil, err
+	}
+
+	switch {
+	case probe.Messages != nil:
+		var request openai.ChatCompletionRequest
+		if err := json.Unmarshal(body, &request); err != nil {
+			klog.V(1).Infof("json.Unmarshal ChatCompletionRequest failed. Err: %v\n", err)
+			return nil, err
+		}
+		return estimateChatCompletion(request), nil
+	case probe.Prompt != nil:
+		var request openai.CompletionRequest
+		if err := json.Unmarshal(body, &request); err != nil {
+			klog.V(1).Infof("json.Unmarshal CompletionRequest failed. Err: %v\n", err)
+			return nil, err
+		}
+		return estimateCompletion(request), nil
+	case probe.Input != nil:
+		var request openai.EmbeddingRequest
+		if err := json.Unmarshal(body, &request); err != nil {
+			klog.V(1).Infof("json.Unmarshal EmbeddingRequest failed. Err: %v\n", err)
+			return nil, err
+		}
+		return estimateEmbedding(request), nil
+	}
+
+	klog.V(1).Infof("body is not a chat, completion or embedding request\n")
+	return nil, ErrUnsupportedRequest
+}
+
+func estimateChatCompletion(request openai.ChatCompletionRequest) *Estimate {
+	counts := make([]int, 0, len(request.Messages))
+	for _, message := range request.Messages {
+		counts = append(counts, tokens.CountMessage(message))
+	}
+
+	return newEstimate(routeChatCompletions, request.Model, tokens.CountMessages(request.Messages), counts, request.MaxTokens, request.N)
+}
+
+func estimateCompletion(request openai.CompletionRequest) *Estimate {
+	var prompts []string
+	switch prompt := request.Prompt.(type) {
+	case string:
+		prompts = []string{prompt}
+	case []string:
+		prompts = prompt
+	case []interface{}:
+		for _, item := range prompt {
+			if str, ok := item.(string); ok {
+				prompts = append(prompts, str)
+			}
+		}
+	}
+
+	counts := tokens.CountStrings(prompts)
+	suffix := tokens.Count(request.Suffix)
+
+	// each prompt is a separate completion, the context limit applies to the largest
+	largest := 0
+	total := 0
+	for _, count := range counts {
+		total += count + suffix
+		if count+suffix > largest {
+			largest = count + suffix
+		}
+	}
+
+	n := request.N
+	if n <= 0 {
+		n = 1
+	}
+
+	// unlike chat, the completions API defaults max_tokens to 16
+	maxTokens := request.MaxTokens
+	if maxTokens <= 0 {
+		maxTokens = defaultCompletionMaxTokens
+	}
+
+	estimate := newEstimate(routeCompletions, request.Model, largest, counts, maxTokens, n*maxInt(len(prompts), 1))
+	estimate.PromptTokens = total
+	estimate.TotalTokens = estimate.PromptTokens + estimate.CompletionTokens
+	estimate.Cost = tokens.EstimateCost(request.Model, estimate.PromptTokens, estimate.CompletionTokens)
+
+	return estimate
+}
+
+func estimateEmbedding(request openai.EmbeddingRequest) *Estimate {
+	model := request.Model.String()
+	counts := embeddingCounts(request.Input)
+
+	largest := 0
+	total := 0
+	for _, count := range counts {
+		total += count
+		if count > largest {
+			largest = count
+		}
+	}
+
+	limit := tokens.ContextLimit(model)
+	estimate := &Estimate{
+		Object:       EstimateObject,
+		Endpoint:     routeEmbeddings,
+		Model:        model,
+		PromptTokens: total,
+		TotalTokens:  total,
+		InputTokens:  counts,
+		ContextLimit: limit,
+		Remaining:    limit - largest,
+		Cost:         tokens.EstimateCost(model, total, 0),
+	}
+
+	return estimate
+}
+
+// embeddingCounts returns the tokens of each input of an embedding request.
+// the API accepts a single string, a list of strings or pre-tokenized input,
+// which is counted as one token per entry.
+func embeddingCounts(input interface{}) []int {
+	switch v := input.(type) {
+	case string:
+		return []int{tokens.Count(v)}
+	case []string:
+		return tokens.CountStrings(v)
+	case []interface{}:
+		counts := make([]int, 0, len(v))
+		for _, item := range v {
+			switch item := item.(type) {
+			case string:
+				counts = append(counts, tokens.Count(item))
+			case []interface{}:
+				counts = append(counts, len(item))
+			}
+		}
+		return counts
+	case [][]int:
+		counts := make([]int, 0, len(v))
+		for _, item := range v {
+			counts = append(counts, len(item))
+		}
+		return counts
+	}
+	return nil
+}
+
+// newEstimate projects the completion side as max_tokens when set, otherwise
+// as whatever headroom is left in the context window
+func newEstimate(endpoint, model string, promptTokens int, counts []int, maxTokens, n int) *Estimate {
+	if n <= 0 {
+		n = 1
+	}
+
+	limit := tokens.ContextLimit(model)
+	remaining := limit - promptTokens
+
+	completionTokens := remaining
+	if maxTokens > 0 {
+		completionTokens = maxTokens
+	}
+	if completionTokens < 0 {
+		completionTokens = 0
+	}
+	completionTokens *= n
+
+	return &Estimate{
+		Object:           EstimateObject,
+		Endpoint:         endpoint,
+		Model:            model,
+		PromptTokens:     promptTokens,
+		CompletionTokens: completionTokens,
+		TotalTokens:      promptTokens + completionTokens,
+		InputTokens:      counts,
+		ContextLimit:     limit,
+		Remaining:        remaining,
+		Cost:             tokens.EstimateCost(model, promptTokens, completionTokens),
+	}
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,106 @@
+// Copyright 2023 dvonthenen ChatGPT Proxy contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache License 2.0
+
+package proxy
+
+import (
+	"context"
+	"sync"
+
+	interfaces "github.com/dvonthenen/chat-gpeasy/pkg/proxy/interfaces"
+)
+
+// requestInfo collects what the proxy learns about a request while serving
+// it. It ends up on the callback event.
+type requestInfo struct {
+	caller      string
+	route       string
+	upstreamKey string
+	coalesced   bool
+	cached      bool
+	identity    *interfaces.Identity
+	detections  []interfaces.Detection
+	findings    []interfaces.Detection
+	original    interface{}
+
+	// housekeeping
+	mu sync.Mutex
+}
+
+type requestInfoKey struct{}
+
+// withRequestInfo attaches a requestInfo for the caller to the context. The
+// caller and route are what usage is recorded under.
+func withRequestInfo(ctx context.Context, identity *interfaces.Identity, caller, route string) context.Context {
+	return context.WithValue(ctx, requestInfoKey{}, &requestInfo{
+		caller:   caller,
+		route:    route,
+		identity: identity,
+	})
+}
+
+// requestInfoFrom returns the requestInfo of the context, nil if none
+func requestInfoFrom(ctx context.Context) *requestInfo {
+	info, _ := ctx.Value(requestInfoKey{}).(*requestInfo)
+	return info
+}
+
+func (i *requestInfo) setUpstreamKey(name string) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.upstreamKey = name
+}
+
+func (i *requestInfo) setCoalesced() {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.coalesced = true
+}
+
+func (i *requestInfo) setCached() {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.cached = true
+}
+
+func (i *requestInfo) setDetections(detections []interfaces.Detection) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.detections = detections
+}
+
+func (i *requestInfo) addOutputFindings(findings []interfaces.Detection) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.findings = append(i.findings, findings...)
+}
+
+func (i *requestInfo) setOriginalRequest(request interface{}) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.original = request
+}
+
+// apply copies the request's details onto its event
+func (i *requestInfo) apply(event *interfaces.Event) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	event.UpstreamKey = i.upstreamKey
+	event.Coalesced = i.coalesced
+	event.Cached = i.cached
+	event.Identity = i.identity
+	event.Detections = i.detections
+	event.OutputFindings = i.findings
+	event.OriginalRequest = i.original
+}
+
+// dispatch raises the callback event of a request. Callbacks that implement
+// OnEvent see what the proxy learned about the request, others get the typed
+// method.
+func (p *ChatGPTProxy) dispatch(ctx context.Context, eventType interfaces.EventType, request, response interface{}) error {
+	event := interfaces.NewEvent(eventType, request, response)
+	if info := requestInfoFrom(ctx); info != nil {
+		info.apply(event)
+	}
+	return interfaces.Dispatch(*p.callback, event)
+}
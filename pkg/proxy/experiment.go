@@ -0,0 +1,54 @@
+// Copyright 2023 dvonthenen ChatGPT Proxy contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache License 2.0
+
+package proxy
+
+import (
+	"context"
+
+	"github.com/gin-gonic/gin"
+	openai "github.com/sashabaranov/go-openai"
+	klog "k8s.io/klog/v2"
+
+	experiment "github.com/dvonthenen/chat-gpeasy/pkg/proxy/experiment"
+)
+
+// assignVariant returns the variant and client that serve a chat request.
+// Without an experiment every request goes to the proxy's client, and callers
+// whose policies do not allow the candidate model always get the control.
+func (p *ChatGPTProxy) assignVariant(c *gin.Context, request *openai.ChatCompletionRequest) (string, *openai.Client) {
+	if p.experiment == nil {
+		return experiment.VariantControl, p.chatgptClient
+	}
+	if err := p.checkModel(c, p.options.Experiment.CandidateModel); err != nil {
+		klog.V(5).Infof("caller is not allowed the candidate model. Err: %v\n", err)
+		return experiment.VariantControl, p.chatgptClient
+	}
+	return p.experiment.Assign(callerID(c, request.User), request)
+}
+
+// variantClient returns the client a variant's upstream call is made with. A
+// candidate with its own BaseURL uses the experiment's client, every other call
+// uses the pooled key picked for it.
+func (p *ChatGPTProxy) variantClient(ctx context.Context, variant string, client *openai.Client) *openai.Client {
+	if variant == experiment.VariantCandidate && len(p.options.Experiment.BaseURL) > 0 {
+		return client
+	}
+	return p.client(ctx)
+}
+
+// callerID identifies the end user for sticky assignment
+func callerID(c *gin.Context, user string) string {
+	if len(user) > 0 {
+		return user
+	}
+	return c.ClientIP()
+}
+
+// upstreamName names the upstream serving a variant for circuit breakers
+func (p *ChatGPTProxy) upstreamName(variant string) string {
+	if variant == experiment.VariantCandidate && len(p.options.Experiment.BaseURL) > 0 {
+		return p.options.Experiment.BaseURL
+	}
+	return upstreamOpenAI
+}
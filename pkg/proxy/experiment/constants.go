@@ -0,0 +1,37 @@
+// Copyright 2023 dvonthenen ChatGPT Proxy contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache License 2.0
+
+package experiment
+
+import (
+	"errors"
+	"time"
+)
+
+const (
+	// VariantControl is the model the client asked for
+	VariantControl string = "control"
+
+	// VariantCandidate is the model under evaluation
+	VariantCandidate string = "candidate"
+
+	// ModeShadow records a comparison of a mirrored request
+	ModeShadow string = "shadow"
+
+	// ModeSplit records the request of a caller assigned to a variant
+	ModeSplit string = "split"
+
+	DefaultMaxShadows    int           = 8
+	DefaultShadowTimeout time.Duration = 2 * time.Minute
+
+	// ComparisonObject is the object type of a record
+	ComparisonObject string = "experiment.comparison"
+)
+
+var (
+	// ErrInvalidInput required input was not found
+	ErrInvalidInput = errors.New("required input was not found")
+
+	// ErrInvalidRate rates must be between 0 and 1
+	ErrInvalidRate = errors.New("rates must be between 0 and 1")
+)
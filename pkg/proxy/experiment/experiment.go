@@ -0,0 +1,317 @@
+// Copyright 2023 dvonthenen ChatGPT Proxy contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache License 2.0
+
+package experiment
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	mrand "math/rand"
+	"time"
+
+	openai "github.com/sashabaranov/go-openai"
+	klog "k8s.io/klog/v2"
+)
+
+// New creates an experiment. client is the proxy's upstream, it also serves
+// the candidate unless BaseURL is set.
+func New(client *openai.Client, options ExperimentOptions) (*Experiment, error) {
+	if client == nil || len(options.CandidateModel) == 0 {
+		klog.V(1).Infof("client or candidate model is missing\n")
+		return nil, ErrInvalidInput
+	}
+	if options.ShadowRate < 0 || options.ShadowRate > 1 || options.SplitRate < 0 || options.SplitRate > 1 {
+		klog.V(1).Infof("invalid rates. shadow: %f split: %f\n", options.ShadowRate, options.SplitRate)
+		return nil, ErrInvalidRate
+	}
+	if options.MaxShadows <= 0 {
+		options.MaxShadows = DefaultMaxShadows
+	}
+	if options.ShadowTimeout <= 0 {
+		options.ShadowTimeout = DefaultShadowTimeout
+	}
+
+	candidate := client
+	if len(options.BaseURL) > 0 {
+		config := openai.DefaultConfig(options.APIKey)
+		config.BaseURL = options.BaseURL
+		candidate = openai.NewClientWithConfig(config)
+	}
+
+	recorders := make([]Recorder, 0)
+	if options.Recorder != nil {
+		recorders = append(recorders, options.Recorder)
+	}
+	if len(options.OutputFile) > 0 {
+		recorder, err := NewFileRecorder(options.OutputFile)
+		if err != nil {
+			return nil, err
+		}
+		recorders = append(recorders, recorder)
+	}
+
+	models := make(map[string]bool)
+	for _, model := range options.Models {
+		models[model] = true
+	}
+
+	var seed [8]byte
+	rand.Read(seed[:])
+
+	e := &Experiment{
+		options:   &options,
+		primary:   client,
+		candidate: candidate,
+		models:    models,
+		slots:     make(chan struct{}, options.MaxShadows),
+		recorders: recorders,
+		rand:      mrand.New(mrand.NewSource(int64(binary.LittleEndian.Uint64(seed[:])))),
+		stats: Stats{
+			CandidateModel: options.CandidateModel,
+			ShadowRate:     options.ShadowRate,
+			SplitRate:      options.SplitRate,
+			Split:          newVariantStats(),
+			Shadow:         newVariantStats(),
+		},
+	}
+
+	return e, nil
+}
+
+// Assign picks the variant that serves the request. When the caller lands on
+// the candidate, the request model is rewritten and the candidate client is
+// returned.
+func (e *Experiment) Assign(caller string, request *openai.ChatCompletionRequest) (string, *openai.Client) {
+	if !e.applies(request.Model) || e.options.SplitRate == 0 {
+		return VariantControl, e.primary
+	}
+
+	if bucket(e.options.CandidateModel, caller) < e.options.SplitRate {
+		klog.V(5).Infof("caller %s assigned to the candidate\n", caller)
+		request.Model = e.options.CandidateModel
+		return VariantCandidate, e.candidate
+	}
+
+	return VariantControl, e.primary
+}
+
+// Observe records the request that was served. Control requests are mirrored
+// to the candidate at ShadowRate in the background.
+func (e *Experiment) Observe(variant, caller string, request openai.ChatCompletionRequest, response *openai.ChatCompletionResponse, err error, latency time.Duration) {
+	if !e.applies(request.Model) && variant == VariantControl {
+		return
+	}
+
+	served := newResult(variant, request.Model, response, err, latency)
+
+	if e.options.SplitRate > 0 {
+		comparison := e.newComparison(ModeSplit, caller, request)
+		if variant == VariantCandidate {
+			comparison.Candidate = served
+		} else {
+			comparison.Control = served
+		}
+		e.record(comparison)
+	}
+
+	if variant != VariantControl || err != nil || !e.sample() {
+		return
+	}
+
+	select {
+	case e.slots <- struct{}{}:
+	default:
+		klog.V(4).Infof("too many shadow requests in flight, dropping\n")
+		e.mu.Lock()
+		e.stats.ShadowsDropped++
+		e.mu.Unlock()
+		return
+	}
+
+	e.mu.Lock()
+	if e.closed {
+		e.mu.Unlock()
+		<-e.slots
+		return
+	}
+	e.wg.Add(1)
+	e.mu.Unlock()
+
+	go func() {
+		defer e.wg.Done()
+		defer func() { <-e.slots }()
+
+		e.shadow(caller, request, served)
+	}()
+}
+
+// Stats returns a copy of the counters
+func (e *Experiment) Stats() Stats {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	stats := e.stats
+	stats.Split = copyVariantStats(e.stats.Split)
+	stats.Shadow = copyVariantStats(e.stats.Shadow)
+
+	return stats
+}
+
+// Stop waits for shadow requests in flight and closes the recorders
+func (e *Experiment) Stop() error {
+	e.mu.Lock()
+	if e.closed {
+		e.mu.Unlock()
+		return nil
+	}
+	e.closed = true
+	e.mu.Unlock()
+
+	e.wg.Wait()
+
+	var firstErr error
+	for _, recorder := range e.recorders {
+		if err := recorder.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	return firstErr
+}
+
+// shadow sends the request to the candidate and records both responses
+func (e *Experiment) shadow(caller string, request openai.ChatCompletionRequest, control *Result) {
+	ctx, cancel := context.WithTimeout(context.Background(), e.options.ShadowTimeout)
+	defer cancel()
+
+	// a streamed request is shadowed without streaming
+	shadowRequest := request
+	shadowRequest.Model = e.options.CandidateModel
+	shadowRequest.Stream = false
+
+	start := time.Now()
+	response, err := e.candidate.CreateChatCompletion(ctx, shadowRequest)
+	latency := time.Since(start)
+	if err != nil {
+		klog.V(3).Infof("shadow request to %s failed. Err: %v\n", shadowRequest.Model, err)
+	}
+
+	comparison := e.newComparison(ModeShadow, caller, request)
+	comparison.Control = control
+	comparison.Candidate = newResult(VariantCandidate, shadowRequest.Model, &response, err, latency)
+
+	e.record(comparison)
+}
+
+func (e *Experiment) record(comparison Comparison) {
+	e.mu.Lock()
+	stats := e.stats.Split
+	if comparison.Mode == ModeShadow {
+		stats = e.stats.Shadow
+	}
+	for _, result := range []*Result{comparison.Control, comparison.Candidate} {
+		if result != nil {
+			stats[result.Variant].add(result)
+		}
+	}
+	e.mu.Unlock()
+
+	for _, recorder := range e.recorders {
+		if err := recorder.Record(comparison); err != nil {
+			klog.V(1).Infof("recorder.Record failed. Err: %v\n", err)
+		}
+	}
+}
+
+func (e *Experiment) newComparison(mode, caller string, request openai.ChatCompletionRequest) Comparison {
+	return Comparison{
+		ID:        "cmp-" + newID(),
+		Object:    ComparisonObject,
+		Mode:      mode,
+		Caller:    caller,
+		CreatedAt: time.Now(),
+		Request:   request,
+	}
+}
+
+// applies returns true when requests for the model take part
+func (e *Experiment) applies(model string) bool {
+	if model == e.options.CandidateModel {
+		return false
+	}
+	return len(e.models) == 0 || e.models[model]
+}
+
+func (e *Experiment) sample() bool {
+	if e.options.ShadowRate == 0 {
+		return false
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return e.rand.Float64() < e.options.ShadowRate
+}
+
+func newResult(variant, model string, response *openai.ChatCompletionResponse, err error, latency time.Duration) *Result {
+	result := &Result{
+		Variant:   variant,
+		Model:     model,
+		LatencyMs: latency.Milliseconds(),
+	}
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	if response != nil {
+		result.Response = response
+		result.Usage = response.Usage
+		if len(response.Model) > 0 {
+			result.Model = response.Model
+		}
+	}
+	return result
+}
+
+func (s *VariantStats) add(result *Result) {
+	s.Requests++
+	if len(result.Error) > 0 {
+		s.Errors++
+	}
+	s.PromptTokens += int64(result.Usage.PromptTokens)
+	s.CompletionTokens += int64(result.Usage.CompletionTokens)
+	s.totalLatencyMs += result.LatencyMs
+	s.AvgLatencyMs = float64(s.totalLatencyMs) / float64(s.Requests)
+}
+
+func newVariantStats() map[string]*VariantStats {
+	return map[string]*VariantStats{
+		VariantControl:   {},
+		VariantCandidate: {},
+	}
+}
+
+func copyVariantStats(stats map[string]*VariantStats) map[string]*VariantStats {
+	copied := make(map[string]*VariantStats, len(stats))
+	for variant, s := range stats {
+		c := *s
+		copied[variant] = &c
+	}
+	return copied
+}
+
+// bucket maps a caller to [0, 1). The candidate model is mixed in so a new
+// experiment does not reuse the same callers.
+func bucket(salt, caller string) float64 {
+	sum := sha256.Sum256([]byte(salt + "\x00" + caller))
+	return float64(binary.BigEndian.Uint64(sum[:8])>>11) / (1 << 53)
+}
+
+func newID() string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
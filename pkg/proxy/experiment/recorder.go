@@ -0,0 +1,58 @@
+// Copyright 2023 dvonthenen ChatGPT Proxy contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache License 2.0
+
+package experiment
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+
+	klog "k8s.io/klog/v2"
+)
+
+// NewFileRecorder appends comparisons to a JSONL file
+func NewFileRecorder(path string) (Recorder, error) {
+	if len(path) == 0 {
+		klog.V(1).Infof("path is empty\n")
+		return nil, ErrInvalidInput
+	}
+
+	if dir := filepath.Dir(path); len(dir) > 0 {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			klog.V(1).Infof("os.MkdirAll failed. Err: %v\n", err)
+			return nil, err
+		}
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		klog.V(1).Infof("os.OpenFile failed. Err: %v\n", err)
+		return nil, err
+	}
+
+	return &fileRecorder{
+		file: file,
+	}, nil
+}
+
+func (r *fileRecorder) Record(comparison Comparison) error {
+	data, err := json.Marshal(comparison)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	_, err = r.file.Write(data)
+	return err
+}
+
+func (r *fileRecorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.file.Close()
+}
@@ -0,0 +1,128 @@
+// Copyright 2023 dvonthenen ChatGPT Proxy contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache License 2.0
+
+package experiment
+
+import (
+	"math/rand"
+	"os"
+	"sync"
+	"time"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// ExperimentOptions compares a candidate model against live chat traffic
+type ExperimentOptions struct {
+	// CandidateModel is the model under evaluation
+	CandidateModel string
+
+	// BaseURL and APIKey send candidate requests to another OpenAI compatible
+	// upstream. The proxy's client is used when BaseURL is empty.
+	BaseURL string
+	APIKey  string
+
+	// Models limits the experiment to requests for these models, all chat
+	// requests take part when empty
+	Models []string
+
+	// ShadowRate is the fraction (0-1) of requests that are also sent to the
+	// candidate in the background. The shadow response never reaches the client.
+	ShadowRate float64
+
+	// SplitRate is the fraction (0-1) of callers that are served by the
+	// candidate. A caller always lands on the same variant.
+	SplitRate float64
+
+	// MaxShadows is the number of shadow requests in flight. Requests beyond
+	// it are not mirrored.
+	MaxShadows    int
+	ShadowTimeout time.Duration
+
+	// OutputFile appends every comparison as a JSON line when set
+	OutputFile string
+
+	// Recorder receives every comparison when set
+	Recorder Recorder
+}
+
+// Recorder stores comparisons
+type Recorder interface {
+	Record(comparison Comparison) error
+	Close() error
+}
+
+// Result is one side of a comparison
+type Result struct {
+	Variant   string                         `json:"variant"`
+	Model     string                         `json:"model"`
+	LatencyMs int64                          `json:"latency_ms"`
+	Usage     openai.Usage                   `json:"usage"`
+	Response  *openai.ChatCompletionResponse `json:"response,omitempty"`
+	Error     string                         `json:"error,omitempty"`
+}
+
+// Comparison is a recorded request with the responses of the variants that
+// handled it. Shadow comparisons have both, split records only the served one.
+type Comparison struct {
+	ID        string                       `json:"id"`
+	Object    string                       `json:"object"`
+	Mode      string                       `json:"mode"`
+	Caller    string                       `json:"caller,omitempty"`
+	CreatedAt time.Time                    `json:"created_at"`
+	Request   openai.ChatCompletionRequest `json:"request"`
+	Control   *Result                      `json:"control,omitempty"`
+	Candidate *Result                      `json:"candidate,omitempty"`
+}
+
+// VariantStats aggregates the requests a variant handled
+type VariantStats struct {
+	Requests         int64   `json:"requests"`
+	Errors           int64   `json:"errors"`
+	AvgLatencyMs     float64 `json:"avg_latency_ms"`
+	PromptTokens     int64   `json:"prompt_tokens"`
+	CompletionTokens int64   `json:"completion_tokens"`
+
+	totalLatencyMs int64
+}
+
+// Stats is returned by GET /v1/experiment
+type Stats struct {
+	CandidateModel string  `json:"candidate_model"`
+	ShadowRate     float64 `json:"shadow_rate"`
+	SplitRate      float64 `json:"split_rate"`
+
+	// Split is keyed by variant
+	Split map[string]*VariantStats `json:"split"`
+
+	// Shadow is keyed by variant
+	Shadow         map[string]*VariantStats `json:"shadow"`
+	ShadowsDropped int64                    `json:"shadows_dropped"`
+}
+
+// Experiment assigns requests to variants and mirrors shadow traffic
+type Experiment struct {
+	options   *ExperimentOptions
+	primary   *openai.Client
+	candidate *openai.Client
+	models    map[string]bool
+
+	// shadows in flight
+	slots chan struct{}
+	wg    sync.WaitGroup
+
+	// recording
+	recorders []Recorder
+	stats     Stats
+	rand      *rand.Rand
+
+	// housekeeping
+	mu     sync.Mutex
+	closed bool
+}
+
+// fileRecorder appends comparisons to a JSONL file
+type fileRecorder struct {
+	file *os.File
+	mu   sync.Mutex
+}
@@ -0,0 +1,88 @@
+// Copyright 2023 dvonthenen ChatGPT Proxy contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache License 2.0
+
+package proxy
+
+import (
+	"path"
+
+	interfaces "github.com/dvonthenen/chat-gpeasy/pkg/proxy/interfaces"
+)
+
+// EventFilter decides whether a MultiCallback member receives an event
+type EventFilter func(event *interfaces.Event) bool
+
+// FilterByEventType matches events of any of the given types
+func FilterByEventType(eventTypes ...interfaces.EventType) EventFilter {
+	return func(event *interfaces.Event) bool {
+		for _, eventType := range eventTypes {
+			if event.Type == eventType {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// FilterByModel matches events whose model matches any of the patterns. Patterns
+// use path.Match syntax, ie "gpt-4*".
+func FilterByModel(patterns ...string) EventFilter {
+	return func(event *interfaces.Event) bool {
+		return matchAny(patterns, event.Model)
+	}
+}
+
+// FilterByCaller matches events whose caller matches any of the patterns. The
+// caller is the subject of the authenticated identity, or the request's user
+// without authentication. Patterns use path.Match syntax.
+func FilterByCaller(patterns ...string) EventFilter {
+	return func(event *interfaces.Event) bool {
+		if event.Identity != nil && len(event.Identity.Subject) > 0 {
+			return matchAny(patterns, event.Identity.Subject)
+		}
+		return matchAny(patterns, event.Caller)
+	}
+}
+
+// FilterAll matches when every filter matches
+func FilterAll(filters ...EventFilter) EventFilter {
+	return func(event *interfaces.Event) bool {
+		for _, filter := range filters {
+			if !filter(event) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// FilterAny matches when at least one filter matches
+func FilterAny(filters ...EventFilter) EventFilter {
+	return func(event *interfaces.Event) bool {
+		for _, filter := range filters {
+			if filter(event) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// FilterNot inverts a filter
+func FilterNot(filter EventFilter) EventFilter {
+	return func(event *interfaces.Event) bool {
+		return !filter(event)
+	}
+}
+
+func matchAny(patterns []string, value string) bool {
+	for _, pattern := range patterns {
+		if pattern == value {
+			return true
+		}
+		if matched, err := path.Match(pattern, value); err == nil && matched {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,105 @@
+// Copyright 2023 dvonthenen ChatGPT Proxy contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache License 2.0
+
+package proxy
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	openai "github.com/sashabaranov/go-openai"
+	klog "k8s.io/klog/v2"
+
+	guard "github.com/dvonthenen/chat-gpeasy/pkg/proxy/guard"
+	interfaces "github.com/dvonthenen/chat-gpeasy/pkg/proxy/interfaces"
+)
+
+// screen checks the prompt of a request for jailbreaks and prompt injection.
+// Flagged detections are recorded for the callback event. It returns false,
+// after answering the client, when the prompt is blocked.
+func (p *ChatGPTProxy) screen(ctx context.Context, c *gin.Context, request interface{}) bool {
+	detections := p.screenPrompt(ctx, callerKey(c), c.FullPath(), request)
+	if detections == nil {
+		return true
+	}
+
+	c.IndentedJSON(http.StatusBadRequest, gin.H{
+		"message":    ErrPromptBlocked.Error(),
+		"detections": detections,
+	})
+	return false
+}
+
+// screenPrompt screens the prompt of a request made by caller, see screen. It
+// returns the detections when the prompt is blocked and nil otherwise.
+func (p *ChatGPTProxy) screenPrompt(ctx context.Context, caller, route string, request interface{}) []interfaces.Detection {
+	if p.guard == nil {
+		return nil
+	}
+
+	detections := p.guard.Screen(promptTexts(request))
+	if len(detections) == 0 {
+		p.guard.Record("")
+		return nil
+	}
+
+	action := p.guard.Action(caller)
+	p.guard.Record(action)
+	klog.V(3).Infof("prompt guard %s %s: %v\n", action, route, detections)
+
+	switch action {
+	case guard.ActionAllow:
+		return nil
+	case guard.ActionFlag:
+		if info := requestInfoFrom(ctx); info != nil {
+			info.setDetections(detections)
+		}
+		return nil
+	}
+
+	if info := requestInfoFrom(ctx); info != nil {
+		info.setDetections(detections)
+	}
+	if p.callback != nil {
+		klog.V(6).Infof("PromptBlocked Callback...\n")
+		err := p.dispatch(ctx, interfaces.EventTypePromptBlocked, request, nil)
+		if err != nil {
+			klog.V(1).Infof("[CALLBACK] PromptBlocked failed. Err: %v\n", err)
+		}
+	}
+
+	return detections
+}
+
+// promptTexts returns the text of a request that the model is asked to follow
+func promptTexts(request interface{}) []string {
+	var texts []string
+
+	switch req := request.(type) {
+	case openai.ChatCompletionRequest:
+		for _, message := range req.Messages {
+			if message.Role == openai.ChatMessageRoleAssistant || message.Role == openai.ChatMessageRoleFunction {
+				continue
+			}
+			texts = append(texts, message.Content)
+		}
+	case openai.CompletionRequest:
+		switch prompt := req.Prompt.(type) {
+		case string:
+			texts = append(texts, prompt)
+		case []string:
+			texts = append(texts, prompt...)
+		case []interface{}:
+			for _, item := range prompt {
+				if s, ok := item.(string); ok {
+					texts = append(texts, s)
+				}
+			}
+		}
+	case openai.EditsRequest:
+		texts = append(texts, req.Input, req.Instruction)
+	}
+
+	return texts
+}
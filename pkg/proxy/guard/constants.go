@@ -0,0 +1,45 @@
+// Copyright 2023 dvonthenen ChatGPT Proxy contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache License 2.0
+
+package guard
+
+import (
+	"errors"
+)
+
+const (
+	// actions taken on a prompt with detections
+	ActionBlock string = "block"
+	ActionFlag  string = "flag"
+	ActionAllow string = "allow"
+
+	// kinds of detections
+	KindSignature string = "signature"
+	KindHeuristic string = "heuristic"
+
+	// DefaultSimilarityThreshold is the share of a signature's phrases a prompt
+	// must contain to match it
+	DefaultSimilarityThreshold float64 = 0.5
+
+	// DefaultHeuristicThreshold is the combined score of the heuristic rules
+	// that counts as a detection
+	DefaultHeuristicThreshold float64 = 0.7
+
+	// DefaultMinSharedPhrases keeps short prompts from matching on a phrase
+	// or two
+	DefaultMinSharedPhrases int = 8
+
+	// words per phrase when comparing text
+	shingleSize int = 3
+)
+
+var (
+	// ErrInvalidInput required input was not found
+	ErrInvalidInput = errors.New("required input was not found")
+
+	// ErrInvalidAction the action is not block, flag or allow
+	ErrInvalidAction = errors.New("invalid guard action")
+
+	// ErrInvalidRule the rule's pattern does not compile
+	ErrInvalidRule = errors.New("invalid guard rule")
+)
@@ -0,0 +1,194 @@
+// Copyright 2023 dvonthenen ChatGPT Proxy contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache License 2.0
+
+package guard
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+
+	klog "k8s.io/klog/v2"
+
+	interfaces "github.com/dvonthenen/chat-gpeasy/pkg/proxy/interfaces"
+)
+
+// New creates a guard with the default signatures and rules plus the ones in
+// the options
+func New(options GuardOptions) (*Guard, error) {
+	if options.SimilarityThreshold <= 0 {
+		options.SimilarityThreshold = DefaultSimilarityThreshold
+	}
+	if options.MinSharedPhrases <= 0 {
+		options.MinSharedPhrases = DefaultMinSharedPhrases
+	}
+	if options.HeuristicThreshold <= 0 {
+		options.HeuristicThreshold = DefaultHeuristicThreshold
+	}
+	if len(options.DefaultAction) == 0 {
+		options.DefaultAction = ActionBlock
+	}
+	if !validAction(options.DefaultAction) {
+		klog.V(1).Infof("guard default action %s is invalid\n", options.DefaultAction)
+		return nil, ErrInvalidAction
+	}
+	for key, action := range options.Actions {
+		if !validAction(action) {
+			klog.V(1).Infof("guard action %s for %s is invalid\n", action, key)
+			return nil, ErrInvalidAction
+		}
+	}
+
+	signatures := options.Signatures
+	if !options.DisableDefaultSignatures {
+		signatures = append(DefaultSignatures(), signatures...)
+	}
+	rules := options.Rules
+	if !options.DisableDefaultRules {
+		rules = append(DefaultRules(), rules...)
+	}
+
+	g := &Guard{
+		options: &options,
+	}
+	for _, sig := range signatures {
+		if len(sig.Name) == 0 || len(sig.Text) == 0 {
+			return nil, ErrInvalidInput
+		}
+		g.signatures = append(g.signatures, signature{
+			name:     sig.Name,
+			shingles: shingles(sig.Text),
+		})
+	}
+	for _, r := range rules {
+		re, err := regexp.Compile(r.Pattern)
+		if err != nil {
+			klog.V(1).Infof("guard rule %s does not compile. Err: %v\n", r.Name, err)
+			return nil, fmt.Errorf("%w: %s: %v", ErrInvalidRule, r.Name, err)
+		}
+		g.rules = append(g.rules, rule{
+			name:  r.Name,
+			re:    re,
+			score: r.Score,
+		})
+	}
+
+	return g, nil
+}
+
+// Action returns what to do with prompts of the caller that have detections
+func (g *Guard) Action(key string) string {
+	if action, ok := g.options.Actions[key]; ok {
+		return action
+	}
+	return g.options.DefaultAction
+}
+
+// Screen returns the detections in the prompt texts of a request, nil when it
+// looks clean
+func (g *Guard) Screen(texts []string) []interfaces.Detection {
+	text := strings.Join(texts, "\n")
+	if len(strings.TrimSpace(text)) == 0 {
+		return nil
+	}
+
+	var detections []interfaces.Detection
+
+	// fuzzy match against known prompts. A prompt that contains most phrases
+	// of a signature matches, even when words are changed or it is cut short.
+	prompt := shingles(text)
+	for _, sig := range g.signatures {
+		shared := 0
+		for s := range sig.shingles {
+			if _, ok := prompt[s]; ok {
+				shared++
+			}
+		}
+		if shared < g.options.MinSharedPhrases {
+			continue
+		}
+
+		smaller := len(sig.shingles)
+		if len(prompt) < smaller {
+			smaller = len(prompt)
+		}
+		score := float64(shared) / float64(smaller)
+		if score >= g.options.SimilarityThreshold {
+			detections = append(detections, interfaces.Detection{
+				Kind:  KindSignature,
+				Name:  sig.name,
+				Score: round(score),
+			})
+		}
+	}
+
+	// heuristics
+	var total float64
+	var matched []string
+	for _, r := range g.rules {
+		if r.re.MatchString(text) {
+			total += r.score
+			matched = append(matched, r.name)
+		}
+	}
+	if total >= g.options.HeuristicThreshold {
+		detections = append(detections, interfaces.Detection{
+			Kind:  KindHeuristic,
+			Name:  strings.Join(matched, ","),
+			Score: round(math.Min(total, 1)),
+		})
+	}
+
+	sort.SliceStable(detections, func(i, j int) bool {
+		return detections[i].Score > detections[j].Score
+	})
+
+	return detections
+}
+
+// Record counts the action taken on a screened prompt, empty when it was clean
+func (g *Guard) Record(action string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.stats.Screened++
+	switch action {
+	case ActionBlock:
+		g.stats.Blocked++
+	case ActionFlag:
+		g.stats.Flagged++
+	case ActionAllow:
+		g.stats.Allowed++
+	}
+}
+
+// Stats returns a snapshot of the counters
+func (g *Guard) Stats() Stats {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.stats
+}
+
+// shingles returns the set of overlapping word triples of the normalized text
+func shingles(text string) map[string]struct{} {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+
+	set := make(map[string]struct{})
+	for i := 0; i+shingleSize <= len(words); i++ {
+		set[strings.Join(words[i:i+shingleSize], " ")] = struct{}{}
+	}
+	return set
+}
+
+func validAction(action string) bool {
+	return action == ActionBlock || action == ActionFlag || action == ActionAllow
+}
+
+func round(f float64) float64 {
+	return math.Round(f*100) / 100
+}
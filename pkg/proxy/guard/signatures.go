@@ -0,0 +1,71 @@
+// Copyright 2023 dvonthenen ChatGPT Proxy contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache License 2.0
+
+package guard
+
+import (
+	personas "github.com/dvonthenen/chat-gpeasy/pkg/personas/interfaces"
+)
+
+// DefaultSignatures are the jailbreak prompts shipped with the personas
+func DefaultSignatures() []Signature {
+	return []Signature{
+		{Name: "dan", Text: personas.DANPrompt},
+		{Name: "stan", Text: personas.STANPrompt},
+		{Name: "dude", Text: personas.DUDEPrompt},
+		{Name: "jailbreak", Text: personas.JailBreakPrompt},
+		{Name: "mongo", Text: personas.MongoPrompt},
+	}
+}
+
+// DefaultRules are heuristics for jailbreaks and prompt injection that are not
+// in the signature library
+func DefaultRules() []Rule {
+	return []Rule{
+		{
+			Name:    "ignore-instructions",
+			Pattern: `(?i)\b(ignore|disregard|forget)\b.{0,30}\b(previous|prior|above|earlier|all)\b.{0,30}\b(instructions|rules|prompts?|directions)\b`,
+			Score:   0.7,
+		},
+		{
+			Name:    "reveal-system-prompt",
+			Pattern: `(?i)\b(reveal|print|show|repeat|output)\b.{0,30}\b(system prompt|initial instructions|hidden instructions)\b`,
+			Score:   0.7,
+		},
+		{
+			Name:    "do-anything-now",
+			Pattern: `(?i)\bdo anything now\b`,
+			Score:   0.7,
+		},
+		{
+			Name:    "developer-mode",
+			Pattern: `(?i)\b(developer|god|jailbreak|unrestricted) mode\b`,
+			Score:   0.5,
+		},
+		{
+			Name:    "broken-free",
+			Pattern: `(?i)\b(broken|break|freed?) (free )?(of|from) the (typical )?confines\b`,
+			Score:   0.5,
+		},
+		{
+			Name:    "no-restrictions",
+			Pattern: `(?i)\bwithout (any )?(restrictions|filters|censorship|limitations|guidelines)\b`,
+			Score:   0.3,
+		},
+		{
+			Name:    "not-abide-rules",
+			Pattern: `(?i)\b(do not|don't|does not|doesn't) (have to )?(abide|follow|adhere)\b.{0,30}\b(rules|policies|guidelines)\b`,
+			Score:   0.4,
+		},
+		{
+			Name:    "role-play-persona",
+			Pattern: `(?i)\b(pretend|act|role ?play) (to be|as) (an?|another) (ai|model|assistant|chatbot)\b`,
+			Score:   0.3,
+		},
+		{
+			Name:    "stay-in-character",
+			Pattern: `(?i)\b(stay in character|break(ing)? character)\b`,
+			Score:   0.3,
+		},
+	}
+}
@@ -0,0 +1,76 @@
+// Copyright 2023 dvonthenen ChatGPT Proxy contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache License 2.0
+
+package guard
+
+import (
+	"regexp"
+	"sync"
+)
+
+// GuardOptions configures prompt screening
+type GuardOptions struct {
+	// Signatures are known jailbreak prompts, added to DefaultSignatures
+	Signatures               []Signature
+	DisableDefaultSignatures bool
+
+	// SimilarityThreshold and MinSharedPhrases tune the fuzzy signature match
+	SimilarityThreshold float64
+	MinSharedPhrases    int
+
+	// Rules are heuristics, added to DefaultRules. Scores of matching rules are
+	// summed and compared to HeuristicThreshold.
+	Rules               []Rule
+	DisableDefaultRules bool
+	HeuristicThreshold  float64
+
+	// DefaultAction for prompts with detections, ActionBlock when empty
+	DefaultAction string
+
+	// Actions overrides DefaultAction per caller key, the subject of an
+	// authenticated caller, otherwise its bearer token or IP address
+	Actions map[string]string
+}
+
+// Signature is a known jailbreak prompt
+type Signature struct {
+	Name string
+	Text string
+}
+
+// Rule is a heuristic that adds Score when Pattern matches
+type Rule struct {
+	Name    string
+	Pattern string
+	Score   float64
+}
+
+// Stats counters for screened prompts
+type Stats struct {
+	Screened int64 `json:"screened"`
+	Flagged  int64 `json:"flagged"`
+	Blocked  int64 `json:"blocked"`
+	Allowed  int64 `json:"allowed"`
+}
+
+// Guard screens prompts for jailbreaks and prompt injection
+type Guard struct {
+	options    *GuardOptions
+	signatures []signature
+	rules      []rule
+	stats      Stats
+
+	// housekeeping
+	mu sync.Mutex
+}
+
+type signature struct {
+	name     string
+	shingles map[string]struct{}
+}
+
+type rule struct {
+	name  string
+	re    *regexp.Regexp
+	score float64
+}
@@ -0,0 +1,50 @@
+// Copyright 2023 dvonthenen ChatGPT Proxy contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache License 2.0
+
+package images
+
+import (
+	"errors"
+	"time"
+)
+
+// Source is the API that produced an image
+type Source string
+
+const (
+	SourceGeneration Source = "generation"
+	SourceEdit       Source = "edit"
+	SourceVariation  Source = "variation"
+)
+
+const (
+	// RouteFiles serves stored images, followed by the image ID
+	RouteFiles string = "/v1/images/files/"
+
+	// ResponseFormatURL and ResponseFormatB64JSON are the OpenAI response formats
+	ResponseFormatURL     string = "url"
+	ResponseFormatB64JSON string = "b64_json"
+
+	DefaultRetention       time.Duration = 30 * 24 * time.Hour
+	DefaultMaxBytes        int64         = 20 * 1024 * 1024
+	DefaultFetchTimeout    time.Duration = 30 * time.Second
+	DefaultCleanupInterval time.Duration = 1 * time.Hour
+
+	objectsDir    string = "objects"
+	metadataDir   string = "metadata"
+	metaExtension string = ".json"
+)
+
+var (
+	// ErrInvalidInput required input was not found
+	ErrInvalidInput = errors.New("required input was not found")
+
+	// ErrImageNotFound no image with that ID
+	ErrImageNotFound = errors.New("image not found")
+
+	// ErrImageTooLarge the image is larger than MaxBytes
+	ErrImageTooLarge = errors.New("image is too large")
+
+	// ErrFetchFailed the image URL could not be downloaded
+	ErrFetchFailed = errors.New("image fetch failed")
+)
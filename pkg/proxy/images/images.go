@@ -0,0 +1,357 @@
+// Copyright 2023 dvonthenen ChatGPT Proxy contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache License 2.0
+
+package images
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	openai "github.com/sashabaranov/go-openai"
+	klog "k8s.io/klog/v2"
+)
+
+// New creates the store and starts removing expired images in the background
+func New(options ImageStoreOptions) (*Store, error) {
+	if len(options.Dir) == 0 {
+		klog.V(1).Infof("Dir is required\n")
+		return nil, ErrInvalidInput
+	}
+	if len(options.PublicURL) == 0 {
+		klog.V(1).Infof("PublicURL is required\n")
+		return nil, ErrInvalidInput
+	}
+	if options.Retention == 0 {
+		options.Retention = DefaultRetention
+	}
+	if options.MaxBytes <= 0 {
+		options.MaxBytes = DefaultMaxBytes
+	}
+	if options.FetchTimeout <= 0 {
+		options.FetchTimeout = DefaultFetchTimeout
+	}
+	if options.CleanupInterval <= 0 {
+		options.CleanupInterval = DefaultCleanupInterval
+	}
+	options.PublicURL = strings.TrimSuffix(options.PublicURL, "/")
+
+	client := options.HTTPClient
+	if client == nil {
+		client = &http.Client{
+			Timeout: options.FetchTimeout,
+		}
+	}
+
+	for _, dir := range []string{objectsDir, metadataDir} {
+		err := os.MkdirAll(filepath.Join(options.Dir, dir), 0700)
+		if err != nil {
+			klog.V(1).Infof("os.MkdirAll failed. Err: %v\n", err)
+			return nil, err
+		}
+	}
+
+	s := &Store{
+		options:  &options,
+		client:   client,
+		stopChan: make(chan struct{}),
+	}
+
+	if options.Retention > 0 {
+		s.wg.Add(1)
+		go s.cleanup()
+	}
+
+	return s, nil
+}
+
+// RequestB64JSON returns true when upstream requests should ask for b64_json
+func (s *Store) RequestB64JSON() bool {
+	return s.options.RequestB64JSON
+}
+
+// Persist stores every image in the response and rewrites it to the format the
+// client asked for: a proxy URL for "url" (the default) or the base64 content
+// for "b64_json"
+func (s *Store) Persist(ctx context.Context, response openai.ImageResponse, responseFormat string, metadata Metadata) (openai.ImageResponse, error) {
+	klog.V(6).Infof("images.Persist ENTER\n")
+
+	rewritten := openai.ImageResponse{
+		Created: response.Created,
+		Data:    make([]openai.ImageResponseDataInner, 0, len(response.Data)),
+	}
+
+	for _, data := range response.Data {
+		var content []byte
+		var err error
+
+		switch {
+		case len(data.B64JSON) > 0:
+			content, err = base64.StdEncoding.DecodeString(data.B64JSON)
+		case len(data.URL) > 0:
+			content, err = s.fetch(ctx, data.URL)
+		default:
+			continue
+		}
+		if err != nil {
+			klog.V(1).Infof("reading image failed. Err: %v\n", err)
+			klog.V(6).Infof("images.Persist LEAVE\n")
+			return response, err
+		}
+
+		image, err := s.Save(content, metadata)
+		if err != nil {
+			klog.V(1).Infof("Save failed. Err: %v\n", err)
+			klog.V(6).Infof("images.Persist LEAVE\n")
+			return response, err
+		}
+
+		if responseFormat == ResponseFormatB64JSON {
+			rewritten.Data = append(rewritten.Data, openai.ImageResponseDataInner{
+				B64JSON: base64.StdEncoding.EncodeToString(content),
+			})
+		} else {
+			rewritten.Data = append(rewritten.Data, openai.ImageResponseDataInner{
+				URL: s.options.PublicURL + RouteFiles + image.ID,
+			})
+		}
+	}
+
+	klog.V(4).Infof("persisted %d images\n", len(rewritten.Data))
+	klog.V(6).Infof("images.Persist LEAVE\n")
+
+	return rewritten, nil
+}
+
+// Save stores the content under its SHA-256. Saving the same content again
+// refreshes its retention.
+func (s *Store) Save(content []byte, metadata Metadata) (*Image, error) {
+	if len(content) == 0 {
+		return nil, ErrInvalidInput
+	}
+	if int64(len(content)) > s.options.MaxBytes {
+		return nil, ErrImageTooLarge
+	}
+
+	sum := sha256.Sum256(content)
+	id := hex.EncodeToString(sum[:])
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now().UTC()
+	image, err := s.readMetadata(id)
+	if err != nil {
+		image = &Image{
+			ID:          id,
+			ContentType: http.DetectContentType(content),
+			Bytes:       int64(len(content)),
+			CreatedAt:   now,
+			Metadata:    metadata,
+		}
+
+		err = writeAtomic(s.objectPath(id), content)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if s.options.Retention > 0 {
+		image.ExpiresAt = now.Add(s.options.Retention)
+	}
+
+	data, err := json.Marshal(image)
+	if err != nil {
+		return nil, err
+	}
+	err = writeAtomic(s.metadataPath(id), data)
+	if err != nil {
+		return nil, err
+	}
+
+	return image, nil
+}
+
+// Get returns the metadata of an image and the path of its content
+func (s *Store) Get(id string) (*Image, string, error) {
+	if !validID(id) {
+		return nil, "", ErrImageNotFound
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	image, err := s.readMetadata(id)
+	if err != nil {
+		return nil, "", ErrImageNotFound
+	}
+	if !image.ExpiresAt.IsZero() && time.Now().After(image.ExpiresAt) {
+		return nil, "", ErrImageNotFound
+	}
+
+	return image, s.objectPath(id), nil
+}
+
+// Delete removes an image
+func (s *Store) Delete(id string) error {
+	if !validID(id) {
+		return ErrImageNotFound
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.readMetadata(id); err != nil {
+		return ErrImageNotFound
+	}
+	s.remove(id)
+
+	return nil
+}
+
+// Stop ends the background cleanup
+func (s *Store) Stop() error {
+	s.mu.Lock()
+	if s.stopped {
+		s.mu.Unlock()
+		return nil
+	}
+	s.stopped = true
+	s.mu.Unlock()
+
+	close(s.stopChan)
+	s.wg.Wait()
+
+	return nil
+}
+
+func (s *Store) fetch(ctx context.Context, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrFetchFailed, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: status %d", ErrFetchFailed, resp.StatusCode)
+	}
+
+	content, err := io.ReadAll(io.LimitReader(resp.Body, s.options.MaxBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrFetchFailed, err)
+	}
+	if int64(len(content)) > s.options.MaxBytes {
+		return nil, ErrImageTooLarge
+	}
+
+	return content, nil
+}
+
+func (s *Store) cleanup() {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(s.options.CleanupInterval)
+	defer ticker.Stop()
+
+	for {
+		s.removeExpired()
+
+		select {
+		case <-s.stopChan:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Store) removeExpired() {
+	files, err := os.ReadDir(filepath.Join(s.options.Dir, metadataDir))
+	if err != nil {
+		klog.V(1).Infof("os.ReadDir failed. Err: %v\n", err)
+		return
+	}
+
+	now := time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	removed := 0
+	for _, file := range files {
+		id := strings.TrimSuffix(file.Name(), metaExtension)
+		if !validID(id) {
+			continue
+		}
+
+		image, err := s.readMetadata(id)
+		if err != nil {
+			continue
+		}
+		if !image.ExpiresAt.IsZero() && now.After(image.ExpiresAt) {
+			s.remove(id)
+			removed++
+		}
+	}
+
+	if removed > 0 {
+		klog.V(3).Infof("removed %d expired images\n", removed)
+	}
+}
+
+// remove must be called with mu held
+func (s *Store) remove(id string) {
+	os.Remove(s.objectPath(id))   //nolint:errcheck
+	os.Remove(s.metadataPath(id)) //nolint:errcheck
+}
+
+func (s *Store) readMetadata(id string) (*Image, error) {
+	data, err := os.ReadFile(s.metadataPath(id))
+	if err != nil {
+		return nil, err
+	}
+
+	var image Image
+	if err := json.Unmarshal(data, &image); err != nil {
+		return nil, err
+	}
+
+	return &image, nil
+}
+
+func (s *Store) objectPath(id string) string {
+	return filepath.Join(s.options.Dir, objectsDir, id)
+}
+
+func (s *Store) metadataPath(id string) string {
+	return filepath.Join(s.options.Dir, metadataDir, id+metaExtension)
+}
+
+// validID guards the file system against IDs that are not a SHA-256
+func validID(id string) bool {
+	if len(id) != sha256.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(id)
+	return err == nil
+}
+
+func writeAtomic(path string, data []byte) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,64 @@
+// Copyright 2023 dvonthenen ChatGPT Proxy contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache License 2.0
+
+package images
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ImageStoreOptions configures local image persistence
+type ImageStoreOptions struct {
+	// Dir holds the images and their metadata
+	Dir string
+
+	// PublicURL is where clients reach the proxy, ie https://proxy.example.com.
+	// Rewritten image URLs start with it. Required.
+	PublicURL string
+
+	// Retention is how long an image is kept. A negative value keeps images
+	// forever.
+	Retention time.Duration
+
+	// RequestB64JSON asks OpenAI for b64_json so images do not have to be
+	// downloaded from the temporary URL
+	RequestB64JSON bool
+
+	MaxBytes        int64
+	FetchTimeout    time.Duration
+	CleanupInterval time.Duration
+
+	HTTPClient *http.Client
+}
+
+// Metadata describes how an image was produced
+type Metadata struct {
+	Source Source `json:"source"`
+	Prompt string `json:"prompt,omitempty"`
+	Caller string `json:"caller,omitempty"`
+	Size   string `json:"size,omitempty"`
+}
+
+// Image is a stored image. The ID is the SHA-256 of the content.
+type Image struct {
+	ID          string    `json:"id"`
+	ContentType string    `json:"content_type"`
+	Bytes       int64     `json:"bytes"`
+	CreatedAt   time.Time `json:"created_at"`
+	ExpiresAt   time.Time `json:"expires_at,omitempty"`
+	Metadata
+}
+
+// Store is a content-addressed image store
+type Store struct {
+	options *ImageStoreOptions
+	client  *http.Client
+
+	// housekeeping
+	stopChan chan struct{}
+	stopped  bool
+	wg       sync.WaitGroup
+	mu       sync.Mutex
+}
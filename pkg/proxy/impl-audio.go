@@ -5,11 +5,14 @@ package proxy
 
 import (
 	"context"
+	"errors"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
-	openai "github.com/sashabaranov/go-openai"
 	klog "k8s.io/klog/v2"
+
+	audio "github.com/dvonthenen/chat-gpeasy/pkg/audio"
+	interfaces "github.com/dvonthenen/chat-gpeasy/pkg/proxy/interfaces"
 )
 
 func (p *ChatGPTProxy) postTranscription(c *gin.Context) {
@@ -19,29 +22,52 @@ func (p *ChatGPTProxy) postTranscription(c *gin.Context) {
 		klog.V(5).Infof("HTTP Header: %s = %v\n", key, value)
 	}
 
-	ctx := context.Background()
+	ctx := p.requestContext(c)
 
-	var audioRequest openai.AudioRequest
+	request, audioRequest, err := bindAudioRequest(c)
+	if err != nil {
+		klog.V(6).Infof("postTranscription LEAVE\n")
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "invalid audio request"})
+		return
+	}
 
-	// Call BindJSON to bind the received JSON to completionRequest
-	if err := c.BindJSON(&audioRequest); err != nil {
-		klog.V(1).Infof("BindJSON failed. Err: %v\n", err)
+	if !p.allowModel(c, request.Model) {
 		klog.V(6).Infof("postTranscription LEAVE\n")
-		c.IndentedJSON(http.StatusNotFound, gin.H{"message": "bind json failed"})
 		return
 	}
 
-	resp, err := p.chatgptClient.CreateTranscription(ctx, audioRequest)
+	var transcript *audio.Transcript
+	err = p.upstream(ctx, upstreamOpenAI, c.FullPath(), func(ctx context.Context) (err error) {
+		request.Client = p.client(ctx)
+		transcript, err = p.transcriber.Transcribe(ctx, *request)
+		return err
+	})
 	if err != nil {
-		klog.V(6).Infof("client.CreateTranscription failed. Err: %v\n", err)
+		klog.V(1).Infof("transcriber.Transcribe failed. Err: %v\n", err)
 		klog.V(6).Infof("postTranscription LEAVE\n")
-		c.IndentedJSON(http.StatusNotFound, gin.H{"message": "transcription failed"})
+		if errors.Is(err, audio.ErrTooLarge) {
+			c.IndentedJSON(http.StatusRequestEntityTooLarge, gin.H{"message": "transcription failed"})
+			return
+		}
+		abortUpstream(c, err, "transcription failed")
 		return
 	}
 
+	body, contentType, err := transcript.Render(audioRequest.Format)
+	if err != nil {
+		klog.V(1).Infof("transcript.Render failed. Err: %v\n", err)
+		klog.V(6).Infof("postTranscription LEAVE\n")
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "unsupported response format"})
+		return
+	}
+
+	resp := transcript.Response()
+
+	p.recordUsage(ctx, *audioRequest, resp)
+
 	if p.callback != nil {
 		klog.V(6).Infof("CreateTranscription Callback...\n")
-		err = (*p.callback).CreateTranscription(audioRequest, resp)
+		err = p.dispatch(ctx, interfaces.EventTypeCreateTranscription, *audioRequest, resp)
 		if err != nil {
 			klog.V(1).Infof("[CALLBACK] CreateTranscription failed. Err: %v\n", err)
 		}
@@ -49,7 +75,7 @@ func (p *ChatGPTProxy) postTranscription(c *gin.Context) {
 
 	klog.V(4).Infof("postTranscription Succeeded\n")
 	klog.V(6).Infof("postTranscription LEAVE\n")
-	c.IndentedJSON(http.StatusOK, resp)
+	c.Data(http.StatusOK, contentType, body)
 }
 
 func (p *ChatGPTProxy) postTranslation(c *gin.Context) {
@@ -59,29 +85,52 @@ func (p *ChatGPTProxy) postTranslation(c *gin.Context) {
 		klog.V(5).Infof("HTTP Header: %s = %v\n", key, value)
 	}
 
-	ctx := context.Background()
+	ctx := p.requestContext(c)
 
-	var audioRequest openai.AudioRequest
+	request, audioRequest, err := bindAudioRequest(c)
+	if err != nil {
+		klog.V(6).Infof("postTranslation LEAVE\n")
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "invalid audio request"})
+		return
+	}
+
+	if !p.allowModel(c, request.Model) {
+		klog.V(6).Infof("postTranslation LEAVE\n")
+		return
+	}
 
-	// Call BindJSON to bind the received JSON to completionRequest
-	if err := c.BindJSON(&audioRequest); err != nil {
-		klog.V(1).Infof("BindJSON failed. Err: %v\n", err)
+	var transcript *audio.Transcript
+	err = p.upstream(ctx, upstreamOpenAI, c.FullPath(), func(ctx context.Context) (err error) {
+		request.Client = p.client(ctx)
+		transcript, err = p.transcriber.Translate(ctx, *request)
+		return err
+	})
+	if err != nil {
+		klog.V(1).Infof("transcriber.Translate failed. Err: %v\n", err)
 		klog.V(6).Infof("postTranslation LEAVE\n")
-		c.IndentedJSON(http.StatusNotFound, gin.H{"message": "bind json failed"})
+		if errors.Is(err, audio.ErrTooLarge) {
+			c.IndentedJSON(http.StatusRequestEntityTooLarge, gin.H{"message": "translation failed"})
+			return
+		}
+		abortUpstream(c, err, "translation failed")
 		return
 	}
 
-	resp, err := p.chatgptClient.CreateTranslation(ctx, audioRequest)
+	body, contentType, err := transcript.Render(audioRequest.Format)
 	if err != nil {
-		klog.V(6).Infof("client.CreateTranslation failed. Err: %v\n", err)
+		klog.V(1).Infof("transcript.Render failed. Err: %v\n", err)
 		klog.V(6).Infof("postTranslation LEAVE\n")
-		c.IndentedJSON(http.StatusNotFound, gin.H{"message": "transcription failed"})
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "unsupported response format"})
 		return
 	}
 
+	resp := transcript.Response()
+
+	p.recordUsage(ctx, *audioRequest, resp)
+
 	if p.callback != nil {
 		klog.V(6).Infof("CreateTranslation Callback...\n")
-		err = (*p.callback).CreateTranslation(audioRequest, resp)
+		err = p.dispatch(ctx, interfaces.EventTypeCreateTranslation, *audioRequest, resp)
 		if err != nil {
 			klog.V(1).Infof("[CALLBACK] CreateTranslation failed. Err: %v\n", err)
 		}
@@ -89,5 +138,5 @@ func (p *ChatGPTProxy) postTranslation(c *gin.Context) {
 
 	klog.V(4).Infof("postTranslation Succeeded\n")
 	klog.V(6).Infof("postTranslation LEAVE\n")
-	c.IndentedJSON(http.StatusOK, resp)
+	c.Data(http.StatusOK, contentType, body)
 }
@@ -0,0 +1,223 @@
+// Copyright 2023 dvonthenen ChatGPT Proxy contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache License 2.0
+
+package proxy
+
+import (
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	klog "k8s.io/klog/v2"
+
+	batch "github.com/dvonthenen/chat-gpeasy/pkg/proxy/batch"
+)
+
+func (p *ChatGPTProxy) postBatchFile(c *gin.Context) {
+	klog.V(6).Infof("postBatchFile ENTER\n")
+
+	for key, value := range c.Request.Header {
+		klog.V(5).Infof("HTTP Header: %s = %v\n", key, value)
+	}
+
+	// multipart upload (like /v1/files) or the raw JSONL as the body
+	filename := defaultBatchFilename
+	var data []byte
+	fileHeader, err := c.FormFile("file")
+	if err == nil {
+		filename = fileHeader.Filename
+
+		file, err := fileHeader.Open()
+		if err != nil {
+			klog.V(1).Infof("fileHeader.Open failed. Err: %v\n", err)
+			klog.V(6).Infof("postBatchFile LEAVE\n")
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "read file failed"})
+			return
+		}
+		defer file.Close()
+
+		data, err = io.ReadAll(file)
+		if err != nil {
+			klog.V(1).Infof("io.ReadAll failed. Err: %v\n", err)
+			klog.V(6).Infof("postBatchFile LEAVE\n")
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "read file failed"})
+			return
+		}
+	} else {
+		data, err = c.GetRawData()
+		if err != nil {
+			klog.V(1).Infof("GetRawData failed. Err: %v\n", err)
+			klog.V(6).Infof("postBatchFile LEAVE\n")
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "read body failed"})
+			return
+		}
+	}
+
+	file, err := p.batches.CreateFile(filename, data, keyHash(callerKey(c)))
+	if err != nil {
+		klog.V(1).Infof("CreateFile failed. Err: %v\n", err)
+		klog.V(6).Infof("postBatchFile LEAVE\n")
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	klog.V(4).Infof("postBatchFile Succeeded\n")
+	klog.V(6).Infof("postBatchFile LEAVE\n")
+	c.IndentedJSON(http.StatusOK, file)
+}
+
+func (p *ChatGPTProxy) getBatchFile(c *gin.Context) {
+	klog.V(6).Infof("getBatchFile ENTER\n")
+
+	fileID := c.Param("file_id")
+
+	klog.V(5).Infof("fileID: %s\n", fileID)
+	for key, value := range c.Request.Header {
+		klog.V(5).Infof("HTTP Header: %s = %v\n", key, value)
+	}
+
+	file, err := p.batches.GetFile(fileID, keyHash(callerKey(c)))
+	if err != nil {
+		klog.V(1).Infof("GetFile failed. Err: %v\n", err)
+		klog.V(6).Infof("getBatchFile LEAVE\n")
+		c.IndentedJSON(http.StatusNotFound, gin.H{"message": "file not found"})
+		return
+	}
+
+	klog.V(4).Infof("getBatchFile Succeeded\n")
+	klog.V(6).Infof("getBatchFile LEAVE\n")
+	c.IndentedJSON(http.StatusOK, file)
+}
+
+func (p *ChatGPTProxy) getBatchFileContent(c *gin.Context) {
+	klog.V(6).Infof("getBatchFileContent ENTER\n")
+
+	fileID := c.Param("file_id")
+
+	klog.V(5).Infof("fileID: %s\n", fileID)
+	for key, value := range c.Request.Header {
+		klog.V(5).Infof("HTTP Header: %s = %v\n", key, value)
+	}
+
+	path, err := p.batches.FileContentPath(fileID, keyHash(callerKey(c)))
+	if err != nil {
+		klog.V(1).Infof("FileContentPath failed. Err: %v\n", err)
+		klog.V(6).Infof("getBatchFileContent LEAVE\n")
+		c.IndentedJSON(http.StatusNotFound, gin.H{"message": "file not found"})
+		return
+	}
+
+	klog.V(4).Infof("getBatchFileContent Succeeded\n")
+	klog.V(6).Infof("getBatchFileContent LEAVE\n")
+	c.Header("Content-Type", contentTypeJSONL)
+	c.File(path)
+}
+
+func (p *ChatGPTProxy) postBatch(c *gin.Context) {
+	klog.V(6).Infof("postBatch ENTER\n")
+
+	for key, value := range c.Request.Header {
+		klog.V(5).Infof("HTTP Header: %s = %v\n", key, value)
+	}
+
+	var createRequest batch.CreateRequest
+
+	// Call BindJSON to bind the received JSON to createRequest
+	if err := c.BindJSON(&createRequest); err != nil {
+		klog.V(1).Infof("BindJSON failed. Err: %v\n", err)
+		klog.V(6).Infof("postBatch LEAVE\n")
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "bind json failed"})
+		return
+	}
+
+	owner := &batch.Owner{
+		Identity: identity(c),
+		Key:      callerKey(c),
+		KeyHash:  keyHash(callerKey(c)),
+		User:     callerUser(c),
+	}
+
+	resp, err := p.batches.Create(createRequest, owner)
+	if err != nil {
+		klog.V(1).Infof("Create failed. Err: %v\n", err)
+		klog.V(6).Infof("postBatch LEAVE\n")
+		if errors.Is(err, batch.ErrFileNotFound) {
+			c.IndentedJSON(http.StatusNotFound, gin.H{"message": err.Error()})
+		} else {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		}
+		return
+	}
+
+	klog.V(4).Infof("postBatch Succeeded\n")
+	klog.V(6).Infof("postBatch LEAVE\n")
+	c.IndentedJSON(http.StatusOK, resp)
+}
+
+func (p *ChatGPTProxy) getBatches(c *gin.Context) {
+	klog.V(6).Infof("getBatches ENTER\n")
+
+	for key, value := range c.Request.Header {
+		klog.V(5).Infof("HTTP Header: %s = %v\n", key, value)
+	}
+
+	resp := batch.BatchList{
+		Object: batch.ListObject,
+		Data:   p.batches.List(keyHash(callerKey(c))),
+	}
+
+	klog.V(4).Infof("getBatches Succeeded\n")
+	klog.V(6).Infof("getBatches LEAVE\n")
+	c.IndentedJSON(http.StatusOK, resp)
+}
+
+func (p *ChatGPTProxy) getBatch(c *gin.Context) {
+	klog.V(6).Infof("getBatch ENTER\n")
+
+	batchID := c.Param("batch_id")
+
+	klog.V(5).Infof("batchID: %s\n", batchID)
+	for key, value := range c.Request.Header {
+		klog.V(5).Infof("HTTP Header: %s = %v\n", key, value)
+	}
+
+	resp, err := p.batches.Get(batchID, keyHash(callerKey(c)))
+	if err != nil {
+		klog.V(1).Infof("Get failed. Err: %v\n", err)
+		klog.V(6).Infof("getBatch LEAVE\n")
+		c.IndentedJSON(http.StatusNotFound, gin.H{"message": "batch not found"})
+		return
+	}
+
+	klog.V(4).Infof("getBatch Succeeded\n")
+	klog.V(6).Infof("getBatch LEAVE\n")
+	c.IndentedJSON(http.StatusOK, resp)
+}
+
+func (p *ChatGPTProxy) postCancelBatch(c *gin.Context) {
+	klog.V(6).Infof("postCancelBatch ENTER\n")
+
+	batchID := c.Param("batch_id")
+
+	klog.V(5).Infof("batchID: %s\n", batchID)
+	for key, value := range c.Request.Header {
+		klog.V(5).Infof("HTTP Header: %s = %v\n", key, value)
+	}
+
+	resp, err := p.batches.Cancel(batchID, keyHash(callerKey(c)))
+	if err != nil {
+		klog.V(1).Infof("Cancel failed. Err: %v\n", err)
+		klog.V(6).Infof("postCancelBatch LEAVE\n")
+		if errors.Is(err, batch.ErrBatchNotFound) {
+			c.IndentedJSON(http.StatusNotFound, gin.H{"message": err.Error()})
+		} else {
+			c.IndentedJSON(http.StatusConflict, gin.H{"message": err.Error()})
+		}
+		return
+	}
+
+	klog.V(4).Infof("postCancelBatch Succeeded\n")
+	klog.V(6).Infof("postCancelBatch LEAVE\n")
+	c.IndentedJSON(http.StatusOK, resp)
+}
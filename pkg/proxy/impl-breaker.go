@@ -0,0 +1,28 @@
+// Copyright 2023 dvonthenen ChatGPT Proxy contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache License 2.0
+
+package proxy
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	klog "k8s.io/klog/v2"
+)
+
+func (p *ChatGPTProxy) getBreakers(c *gin.Context) {
+	klog.V(6).Infof("getBreakers ENTER\n")
+
+	for key, value := range c.Request.Header {
+		klog.V(5).Infof("HTTP Header: %s = %v\n", key, value)
+	}
+
+	statuses := p.breakers.Statuses()
+
+	klog.V(4).Infof("getBreakers Succeeded\n")
+	klog.V(6).Infof("getBreakers LEAVE\n")
+	c.IndentedJSON(http.StatusOK, BreakerList{
+		Object: ListObject,
+		Data:   statuses,
+	})
+}
@@ -0,0 +1,81 @@
+// Copyright 2023 dvonthenen ChatGPT Proxy contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache License 2.0
+
+package proxy
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	klog "k8s.io/klog/v2"
+)
+
+func (p *ChatGPTProxy) getCache(c *gin.Context) {
+	klog.V(6).Infof("getCache ENTER\n")
+
+	for key, value := range c.Request.Header {
+		klog.V(5).Infof("HTTP Header: %s = %v\n", key, value)
+	}
+
+	resp := CacheList{
+		Object: ListObject,
+		Stats:  p.cache.Stats(),
+		Data:   p.cache.Entries(),
+	}
+
+	klog.V(4).Infof("getCache Succeeded\n")
+	klog.V(6).Infof("getCache LEAVE\n")
+	c.IndentedJSON(http.StatusOK, resp)
+}
+
+// deleteCache purges the whole cache or, with the model or scope query
+// parameter, only the matching entries
+func (p *ChatGPTProxy) deleteCache(c *gin.Context) {
+	klog.V(6).Infof("deleteCache ENTER\n")
+
+	model := c.Query("model")
+	scope := c.Query("scope")
+
+	klog.V(5).Infof("model: %s\n", model)
+	klog.V(5).Infof("scope: %s\n", scope)
+	for key, value := range c.Request.Header {
+		klog.V(5).Infof("HTTP Header: %s = %v\n", key, value)
+	}
+
+	var removed int
+	switch {
+	case len(scope) > 0:
+		removed = p.cache.InvalidateScope(scope)
+	case len(model) > 0:
+		removed = p.cache.InvalidateModel(model)
+	default:
+		removed = p.cache.Purge()
+	}
+
+	klog.V(4).Infof("deleteCache Succeeded (%d removed)\n", removed)
+	klog.V(6).Infof("deleteCache LEAVE\n")
+	c.IndentedJSON(http.StatusOK, gin.H{"message": "cache invalidated", "removed": removed})
+}
+
+func (p *ChatGPTProxy) deleteCacheEntry(c *gin.Context) {
+	klog.V(6).Infof("deleteCacheEntry ENTER\n")
+
+	entryID := c.Param("entry_id")
+
+	klog.V(5).Infof("entryID: %s\n", entryID)
+	for key, value := range c.Request.Header {
+		klog.V(5).Infof("HTTP Header: %s = %v\n", key, value)
+	}
+
+	err := p.cache.Invalidate(entryID)
+	if err != nil {
+		klog.V(1).Infof("Invalidate failed. Err: %v\n", err)
+		klog.V(6).Infof("deleteCacheEntry LEAVE\n")
+		c.IndentedJSON(http.StatusNotFound, gin.H{"message": "cache entry not found"})
+		return
+	}
+
+	klog.V(4).Infof("deleteCacheEntry Succeeded\n")
+	klog.V(6).Infof("deleteCacheEntry LEAVE\n")
+	c.IndentedJSON(http.StatusOK, gin.H{"message": "cache entry invalidated", "removed": 1})
+}
@@ -6,10 +6,14 @@ package proxy
 import (
 	"context"
 	"net/http"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
 	openai "github.com/sashabaranov/go-openai"
 	klog "k8s.io/klog/v2"
+
+	interfaces "github.com/dvonthenen/chat-gpeasy/pkg/proxy/interfaces"
 )
 
 func (p *ChatGPTProxy) postCompletion(c *gin.Context) {
@@ -19,29 +23,81 @@ func (p *ChatGPTProxy) postCompletion(c *gin.Context) {
 		klog.V(5).Infof("HTTP Header: %s = %v\n", key, value)
 	}
 
-	ctx := context.Background()
+	ctx := p.requestContext(c)
 
 	var completionRequest openai.CompletionRequest
 
-	// Call BindJSON to bind the received JSON to completionRequest
-	if err := c.BindJSON(&completionRequest); err != nil {
-		klog.V(1).Infof("BindJSON failed. Err: %v\n", err)
+	// the body is kept for coalescing, see temperatureSet
+	if err := c.ShouldBindBodyWith(&completionRequest, binding.JSON); err != nil {
+		klog.V(1).Infof("ShouldBindBodyWith failed. Err: %v\n", err)
 		klog.V(6).Infof("postCompletion LEAVE\n")
 		c.IndentedJSON(http.StatusNotFound, gin.H{"message": "bind json failed"})
 		return
 	}
 
-	resp, err := p.chatgptClient.CreateCompletion(ctx, completionRequest)
+	chatRequests, err := p.legacyCompletion(completionRequest)
+	if err != nil {
+		klog.V(1).Infof("legacyCompletion failed. Err: %v\n", err)
+		klog.V(6).Infof("postCompletion LEAVE\n")
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	// a translated completion is answered by the chat model
+	model := completionRequest.Model
+	if len(chatRequests) > 0 {
+		model = chatRequests[0].Model
+	}
+	if !p.allowModel(c, model) {
+		klog.V(6).Infof("postCompletion LEAVE\n")
+		return
+	}
+	for i := range chatRequests {
+		p.applySystemPrompt(c, &chatRequests[i])
+		p.applyPolicies(ctx, c, &chatRequests[i])
+	}
+
+	if !p.screen(ctx, c, completionRequest) {
+		klog.V(6).Infof("postCompletion LEAVE\n")
+		return
+	}
+
+	if isDryRun(c) {
+		klog.V(4).Infof("postCompletion dry run\n")
+		klog.V(6).Infof("postCompletion LEAVE\n")
+		c.IndentedJSON(http.StatusOK, estimateCompletion(completionRequest))
+		return
+	}
+
+	if completionRequest.Stream {
+		p.streamCompletion(ctx, c, completionRequest, chatRequests)
+		klog.V(6).Infof("postCompletion LEAVE\n")
+		return
+	}
+
+	value, err, _ := p.coalesceCall(ctx, c, upstreamOpenAI, completionRequest, func(ctx context.Context) (interface{}, error) {
+		var resp openai.CompletionResponse
+		err := p.upstream(ctx, upstreamOpenAI, c.FullPath(), func(ctx context.Context) (err error) {
+			resp, err = p.createCompletion(ctx, completionRequest, chatRequests)
+			return err
+		})
+		return resp, err
+	})
 	if err != nil {
 		klog.V(6).Infof("client.CreateCompletion failed. Err: %v\n", err)
 		klog.V(6).Infof("postCompletion LEAVE\n")
-		c.IndentedJSON(http.StatusNotFound, gin.H{"message": "completion failed"})
+		abortUpstream(c, err, "completion failed")
 		return
 	}
 
+	resp := value.(openai.CompletionResponse)
+	p.filterCompletion(ctx, &resp)
+
+	p.recordUsage(ctx, completionRequest, resp)
+
 	if p.callback != nil {
 		klog.V(6).Infof("CreateCompletion Callback...\n")
-		err = (*p.callback).CreateCompletion(completionRequest, resp)
+		err = p.dispatch(ctx, interfaces.EventTypeCreateCompletion, completionRequest, resp)
 		if err != nil {
 			klog.V(1).Infof("[CALLBACK] CreateCompletion failed. Err: %v\n", err)
 		}
@@ -59,29 +115,99 @@ func (p *ChatGPTProxy) postChatCompletion(c *gin.Context) {
 		klog.V(5).Infof("HTTP Header: %s = %v\n", key, value)
 	}
 
-	ctx := context.Background()
+	ctx := p.requestContext(c)
 
 	var completionRequest openai.ChatCompletionRequest
 
-	// Call BindJSON to bind the received JSON to completionRequest
-	if err := c.BindJSON(&completionRequest); err != nil {
-		klog.V(1).Infof("BindJSON failed. Err: %v\n", err)
+	// the body is kept for coalescing, see temperatureSet
+	if err := c.ShouldBindBodyWith(&completionRequest, binding.JSON); err != nil {
+		klog.V(1).Infof("ShouldBindBodyWith failed. Err: %v\n", err)
 		klog.V(6).Infof("postChatCompletion LEAVE\n")
 		c.IndentedJSON(http.StatusNotFound, gin.H{"message": "bind json failed"})
 		return
 	}
 
-	resp, err := p.chatgptClient.CreateChatCompletion(ctx, completionRequest)
+	if !p.allowModel(c, completionRequest.Model) {
+		klog.V(6).Infof("postChatCompletion LEAVE\n")
+		return
+	}
+	p.applySystemPrompt(c, &completionRequest)
+	p.applyPolicies(ctx, c, &completionRequest)
+
+	if !p.screen(ctx, c, completionRequest) {
+		klog.V(6).Infof("postChatCompletion LEAVE\n")
+		return
+	}
+
+	if isDryRun(c) {
+		klog.V(4).Infof("postChatCompletion dry run\n")
+		klog.V(6).Infof("postChatCompletion LEAVE\n")
+		c.IndentedJSON(http.StatusOK, estimateChatCompletion(completionRequest))
+		return
+	}
+
+	variant, client := p.assignVariant(c, &completionRequest)
+
+	if completionRequest.Stream {
+		p.streamChatCompletion(ctx, c, p.upstreamName(variant), variant, client, completionRequest)
+		klog.V(6).Infof("postChatCompletion LEAVE\n")
+		return
+	}
+
+	cached, cacheKey := p.cacheLookup(ctx, c, completionRequest)
+	if cached != nil {
+		resp := *cached
+		p.filterChatCompletion(ctx, &resp)
+
+		p.recordUsage(ctx, completionRequest, resp)
+
+		if p.callback != nil {
+			klog.V(6).Infof("CreateChatCompletion Callback...\n")
+			err := p.dispatch(ctx, interfaces.EventTypeCreateChatCompletion, completionRequest, resp)
+			if err != nil {
+				klog.V(1).Infof("[CALLBACK] CreateChatCompletion failed. Err: %v\n", err)
+			}
+		}
+
+		klog.V(4).Infof("postChatCompletion Succeeded (cached)\n")
+		klog.V(6).Infof("postChatCompletion LEAVE\n")
+		c.IndentedJSON(http.StatusOK, resp)
+		return
+	}
+
+	name := p.upstreamName(variant)
+	value, err, coalesced := p.coalesceCall(ctx, c, name, completionRequest, func(ctx context.Context) (interface{}, error) {
+		start := time.Now()
+		var resp openai.ChatCompletionResponse
+		err := p.upstream(ctx, name, c.FullPath(), func(ctx context.Context) (err error) {
+			resp, err = p.variantClient(ctx, variant, client).CreateChatCompletion(ctx, completionRequest)
+			return err
+		})
+		if p.experiment != nil {
+			p.experiment.Observe(variant, callerID(c, completionRequest.User), completionRequest, &resp, err, time.Since(start))
+		}
+		return resp, err
+	})
 	if err != nil {
 		klog.V(6).Infof("client.CreateChatCompletion failed. Err: %v\n", err)
 		klog.V(6).Infof("postChatCompletion LEAVE\n")
-		c.IndentedJSON(http.StatusNotFound, gin.H{"message": "chat completion failed"})
+		abortUpstream(c, err, "chat completion failed")
 		return
 	}
 
+	resp := value.(openai.ChatCompletionResponse)
+
+	// the request that made the call stores the answer
+	if !coalesced {
+		p.cacheStore(cacheKey, resp)
+	}
+	p.filterChatCompletion(ctx, &resp)
+
+	p.recordUsage(ctx, completionRequest, resp)
+
 	if p.callback != nil {
 		klog.V(6).Infof("CreateChatCompletion Callback...\n")
-		err = (*p.callback).CreateChatCompletion(completionRequest, resp)
+		err = p.dispatch(ctx, interfaces.EventTypeCreateChatCompletion, completionRequest, resp)
 		if err != nil {
 			klog.V(1).Infof("[CALLBACK] CreateChatCompletion failed. Err: %v\n", err)
 		}
@@ -99,7 +225,7 @@ func (p *ChatGPTProxy) postEdits(c *gin.Context) {
 		klog.V(5).Infof("HTTP Header: %s = %v\n", key, value)
 	}
 
-	ctx := context.Background()
+	ctx := p.requestContext(c)
 
 	var editsRequest openai.EditsRequest
 
@@ -111,17 +237,50 @@ func (p *ChatGPTProxy) postEdits(c *gin.Context) {
 		return
 	}
 
-	resp, err := p.chatgptClient.Edits(ctx, editsRequest)
+	chatRequest, err := p.legacyEdits(editsRequest)
+	if err != nil {
+		klog.V(1).Infof("legacyEdits failed. Err: %v\n", err)
+		klog.V(6).Infof("postEdits LEAVE\n")
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	// a translated edit is answered by the chat model
+	model := editsModel(editsRequest)
+	if chatRequest != nil {
+		model = chatRequest.Model
+	}
+	if !p.allowModel(c, model) {
+		klog.V(6).Infof("postEdits LEAVE\n")
+		return
+	}
+	if chatRequest != nil {
+		p.applySystemPrompt(c, chatRequest)
+		p.applyPolicies(ctx, c, chatRequest)
+	}
+
+	if !p.screen(ctx, c, editsRequest) {
+		klog.V(6).Infof("postEdits LEAVE\n")
+		return
+	}
+
+	var resp openai.EditsResponse
+	err = p.upstream(ctx, upstreamOpenAI, c.FullPath(), func(ctx context.Context) (err error) {
+		resp, err = p.createEdits(ctx, editsRequest, chatRequest)
+		return err
+	})
 	if err != nil {
 		klog.V(6).Infof("client.Edits failed. Err: %v\n", err)
 		klog.V(6).Infof("postEdits LEAVE\n")
-		c.IndentedJSON(http.StatusNotFound, gin.H{"message": "edits failed"})
+		abortUpstream(c, err, "edits failed")
 		return
 	}
 
+	p.recordUsage(ctx, editsRequest, resp)
+
 	if p.callback != nil {
 		klog.V(6).Infof("Edits Callback...\n")
-		err = (*p.callback).Edits(editsRequest, resp)
+		err = p.dispatch(ctx, interfaces.EventTypeEdits, editsRequest, resp)
 		if err != nil {
 			klog.V(1).Infof("[CALLBACK] Edits failed. Err: %v\n", err)
 		}
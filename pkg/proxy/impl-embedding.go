@@ -10,6 +10,8 @@ import (
 	"github.com/gin-gonic/gin"
 	openai "github.com/sashabaranov/go-openai"
 	klog "k8s.io/klog/v2"
+
+	interfaces "github.com/dvonthenen/chat-gpeasy/pkg/proxy/interfaces"
 )
 
 func (p *ChatGPTProxy) postEmbedding(c *gin.Context) {
@@ -19,7 +21,7 @@ func (p *ChatGPTProxy) postEmbedding(c *gin.Context) {
 		klog.V(5).Infof("HTTP Header: %s = %v\n", key, value)
 	}
 
-	ctx := context.Background()
+	ctx := p.requestContext(c)
 
 	var embeddingRequest openai.EmbeddingRequest
 
@@ -31,17 +33,40 @@ func (p *ChatGPTProxy) postEmbedding(c *gin.Context) {
 		return
 	}
 
-	resp, err := p.chatgptClient.CreateEmbeddings(ctx, embeddingRequest)
+	if !p.allowModel(c, embeddingRequest.Model.String()) {
+		klog.V(6).Infof("postEmbedding LEAVE\n")
+		return
+	}
+
+	if isDryRun(c) {
+		klog.V(4).Infof("postEmbedding dry run\n")
+		klog.V(6).Infof("postEmbedding LEAVE\n")
+		c.IndentedJSON(http.StatusOK, estimateEmbedding(embeddingRequest))
+		return
+	}
+
+	value, err, _ := p.coalesceCall(ctx, c, upstreamOpenAI, embeddingRequest, func(ctx context.Context) (interface{}, error) {
+		var resp openai.EmbeddingResponse
+		err := p.upstream(ctx, upstreamOpenAI, c.FullPath(), func(ctx context.Context) (err error) {
+			resp, err = p.client(ctx).CreateEmbeddings(ctx, embeddingRequest)
+			return err
+		})
+		return resp, err
+	})
 	if err != nil {
 		klog.V(6).Infof("client.CreateEmbeddings failed. Err: %v\n", err)
 		klog.V(6).Infof("postEmbedding LEAVE\n")
-		c.IndentedJSON(http.StatusNotFound, gin.H{"message": "embedding failed"})
+		abortUpstream(c, err, "embedding failed")
 		return
 	}
 
+	resp := value.(openai.EmbeddingResponse)
+
+	p.recordUsage(ctx, embeddingRequest, resp)
+
 	if p.callback != nil {
 		klog.V(6).Infof("CreateEmbeddings Callback...\n")
-		err = (*p.callback).CreateEmbeddings(embeddingRequest, resp)
+		err = p.dispatch(ctx, interfaces.EventTypeCreateEmbeddings, embeddingRequest, resp)
 		if err != nil {
 			klog.V(1).Infof("[CALLBACK] CreateEmbeddings failed. Err: %v\n", err)
 		}
@@ -0,0 +1,68 @@
+// Copyright 2023 dvonthenen ChatGPT Proxy contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache License 2.0
+
+package proxy
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	klog "k8s.io/klog/v2"
+)
+
+func (p *ChatGPTProxy) postTokenize(c *gin.Context) {
+	klog.V(6).Infof("postTokenize ENTER\n")
+
+	for key, value := range c.Request.Header {
+		klog.V(5).Infof("HTTP Header: %s = %v\n", key, value)
+	}
+
+	body, err := c.GetRawData()
+	if err != nil {
+		klog.V(1).Infof("GetRawData failed. Err: %v\n", err)
+		klog.V(6).Infof("postTokenize LEAVE\n")
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "read body failed"})
+		return
+	}
+
+	estimate, err := estimateRequest(body)
+	if err != nil {
+		klog.V(1).Infof("estimateRequest failed. Err: %v\n", err)
+		klog.V(6).Infof("postTokenize LEAVE\n")
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "tokenize failed"})
+		return
+	}
+	estimate.Object = TokenizeObject
+
+	klog.V(4).Infof("postTokenize Succeeded\n")
+	klog.V(6).Infof("postTokenize LEAVE\n")
+	c.IndentedJSON(http.StatusOK, estimate)
+}
+
+func (p *ChatGPTProxy) postEstimate(c *gin.Context) {
+	klog.V(6).Infof("postEstimate ENTER\n")
+
+	for key, value := range c.Request.Header {
+		klog.V(5).Infof("HTTP Header: %s = %v\n", key, value)
+	}
+
+	body, err := c.GetRawData()
+	if err != nil {
+		klog.V(1).Infof("GetRawData failed. Err: %v\n", err)
+		klog.V(6).Infof("postEstimate LEAVE\n")
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "read body failed"})
+		return
+	}
+
+	estimate, err := estimateRequest(body)
+	if err != nil {
+		klog.V(1).Infof("estimateRequest failed. Err: %v\n", err)
+		klog.V(6).Infof("postEstimate LEAVE\n")
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "estimate failed"})
+		return
+	}
+
+	klog.V(4).Infof("postEstimate Succeeded\n")
+	klog.V(6).Infof("postEstimate LEAVE\n")
+	c.IndentedJSON(http.StatusOK, estimate)
+}
@@ -0,0 +1,25 @@
+// Copyright 2023 dvonthenen ChatGPT Proxy contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache License 2.0
+
+package proxy
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	klog "k8s.io/klog/v2"
+)
+
+func (p *ChatGPTProxy) getExperiment(c *gin.Context) {
+	klog.V(6).Infof("getExperiment ENTER\n")
+
+	for key, value := range c.Request.Header {
+		klog.V(5).Infof("HTTP Header: %s = %v\n", key, value)
+	}
+
+	stats := p.experiment.Stats()
+
+	klog.V(4).Infof("getExperiment Succeeded\n")
+	klog.V(6).Infof("getExperiment LEAVE\n")
+	c.IndentedJSON(http.StatusOK, stats)
+}
@@ -10,6 +10,8 @@ import (
 	"github.com/gin-gonic/gin"
 	openai "github.com/sashabaranov/go-openai"
 	klog "k8s.io/klog/v2"
+
+	interfaces "github.com/dvonthenen/chat-gpeasy/pkg/proxy/interfaces"
 )
 
 func (p *ChatGPTProxy) getFiles(c *gin.Context) {
@@ -19,19 +21,23 @@ func (p *ChatGPTProxy) getFiles(c *gin.Context) {
 		klog.V(5).Infof("HTTP Header: %s = %v\n", key, value)
 	}
 
-	ctx := context.Background()
+	ctx := p.requestContext(c)
 
-	fileList, err := p.chatgptClient.ListFiles(ctx)
+	var fileList openai.FilesList
+	err := p.upstream(ctx, upstreamOpenAI, c.FullPath(), func(ctx context.Context) (err error) {
+		fileList, err = p.client(ctx).ListFiles(ctx)
+		return err
+	})
 	if err != nil {
 		klog.V(1).Infof("client.ListFiles failed. Err: %v\n", err)
 		klog.V(6).Infof("getFiles LEAVE\n")
-		c.IndentedJSON(http.StatusNotFound, gin.H{"message": "list files failed"})
+		abortUpstream(c, err, "list files failed")
 		return
 	}
 
 	if p.callback != nil {
 		klog.V(6).Infof("ListFiles Callback...\n")
-		err = (*p.callback).ListFiles(fileList)
+		err = p.dispatch(ctx, interfaces.EventTypeListFiles, nil, fileList)
 		if err != nil {
 			klog.V(1).Infof("[CALLBACK] ListFiles failed. Err: %v\n", err)
 		}
@@ -49,7 +55,7 @@ func (p *ChatGPTProxy) postCreateFile(c *gin.Context) {
 		klog.V(5).Infof("HTTP Header: %s = %v\n", key, value)
 	}
 
-	ctx := context.Background()
+	ctx := p.requestContext(c)
 
 	var fileRequest openai.FileRequest
 
@@ -61,17 +67,21 @@ func (p *ChatGPTProxy) postCreateFile(c *gin.Context) {
 		return
 	}
 
-	resp, err := p.chatgptClient.CreateFile(ctx, fileRequest)
+	var resp openai.File
+	err := p.upstream(ctx, upstreamOpenAI, c.FullPath(), func(ctx context.Context) (err error) {
+		resp, err = p.client(ctx).CreateFile(ctx, fileRequest)
+		return err
+	})
 	if err != nil {
 		klog.V(6).Infof("client.CreateFile failed. Err: %v\n", err)
 		klog.V(6).Infof("postCreateFile LEAVE\n")
-		c.IndentedJSON(http.StatusNotFound, gin.H{"message": "create file failed"})
+		abortUpstream(c, err, "create file failed")
 		return
 	}
 
 	if p.callback != nil {
 		klog.V(6).Infof("CreateFile Callback...\n")
-		err = (*p.callback).CreateFile(fileRequest, resp)
+		err = p.dispatch(ctx, interfaces.EventTypeCreateFile, fileRequest, resp)
 		if err != nil {
 			klog.V(1).Infof("[CALLBACK] CreateFile failed. Err: %v\n", err)
 		}
@@ -92,19 +102,21 @@ func (p *ChatGPTProxy) deleteFile(c *gin.Context) {
 		klog.V(5).Infof("HTTP Header: %s = %v\n", key, value)
 	}
 
-	ctx := context.Background()
+	ctx := p.requestContext(c)
 
-	err := p.chatgptClient.DeleteFile(ctx, fileID)
+	err := p.upstream(ctx, upstreamOpenAI, c.FullPath(), func(ctx context.Context) error {
+		return p.client(ctx).DeleteFile(ctx, fileID)
+	})
 	if err != nil {
 		klog.V(6).Infof("client.DeleteFile failed. Err: %v\n", err)
 		klog.V(6).Infof("deleteFile LEAVE\n")
-		c.IndentedJSON(http.StatusNotFound, gin.H{"message": "delete file failed"})
+		abortUpstream(c, err, "delete file failed")
 		return
 	}
 
 	if p.callback != nil {
 		klog.V(6).Infof("DeleteFile Callback...\n")
-		err = (*p.callback).DeleteFile(fileID)
+		err = p.dispatch(ctx, interfaces.EventTypeDeleteFile, interfaces.IDRequest{ID: fileID}, nil)
 		if err != nil {
 			klog.V(1).Infof("[CALLBACK] DeleteFile failed. Err: %v\n", err)
 		}
@@ -125,19 +137,23 @@ func (p *ChatGPTProxy) getFile(c *gin.Context) {
 		klog.V(5).Infof("HTTP Header: %s = %v\n", key, value)
 	}
 
-	ctx := context.Background()
+	ctx := p.requestContext(c)
 
-	resp, err := p.chatgptClient.GetFile(ctx, fileID)
+	var resp openai.File
+	err := p.upstream(ctx, upstreamOpenAI, c.FullPath(), func(ctx context.Context) (err error) {
+		resp, err = p.client(ctx).GetFile(ctx, fileID)
+		return err
+	})
 	if err != nil {
 		klog.V(6).Infof("client.GetFile failed. Err: %v\n", err)
 		klog.V(6).Infof("getFile LEAVE\n")
-		c.IndentedJSON(http.StatusNotFound, gin.H{"message": "get file failed"})
+		abortUpstream(c, err, "get file failed")
 		return
 	}
 
 	if p.callback != nil {
 		klog.V(6).Infof("GetFile Callback...\n")
-		err = (*p.callback).GetFile(fileID, resp)
+		err = p.dispatch(ctx, interfaces.EventTypeGetFile, interfaces.IDRequest{ID: fileID}, resp)
 		if err != nil {
 			klog.V(1).Infof("[CALLBACK] GetFile failed. Err: %v\n", err)
 		}
@@ -160,7 +176,7 @@ func (p *ChatGPTProxy) getFile(c *gin.Context) {
 
 // 	ctx := context.Background()
 
-// 	resp, err := p.chatgptClient.GetFile(ctx, fileID)
+// 	resp, err := p.client(ctx).GetFile(ctx, fileID)
 // 	if err != nil {
 // 		klog.V(6).Infof("client.GetFile failed. Err: %v\n", err)
 // 		klog.V(6).Infof("getFileContent LEAVE\n")
@@ -10,6 +10,8 @@ import (
 	"github.com/gin-gonic/gin"
 	openai "github.com/sashabaranov/go-openai"
 	klog "k8s.io/klog/v2"
+
+	interfaces "github.com/dvonthenen/chat-gpeasy/pkg/proxy/interfaces"
 )
 
 func (p *ChatGPTProxy) postCreateFineTune(c *gin.Context) {
@@ -19,7 +21,7 @@ func (p *ChatGPTProxy) postCreateFineTune(c *gin.Context) {
 		klog.V(5).Infof("HTTP Header: %s = %v\n", key, value)
 	}
 
-	ctx := context.Background()
+	ctx := p.requestContext(c)
 
 	var finetuneRequest openai.FineTuneRequest
 
@@ -31,17 +33,26 @@ func (p *ChatGPTProxy) postCreateFineTune(c *gin.Context) {
 		return
 	}
 
-	resp, err := p.chatgptClient.CreateFineTune(ctx, finetuneRequest)
+	if !p.allowModel(c, finetuneRequest.Model) {
+		klog.V(6).Infof("postCreateFineTune LEAVE\n")
+		return
+	}
+
+	var resp openai.FineTune
+	err := p.upstream(ctx, upstreamOpenAI, c.FullPath(), func(ctx context.Context) (err error) {
+		resp, err = p.client(ctx).CreateFineTune(ctx, finetuneRequest)
+		return err
+	})
 	if err != nil {
 		klog.V(6).Infof("client.CreateFineTune failed. Err: %v\n", err)
 		klog.V(6).Infof("postCreateFineTune LEAVE\n")
-		c.IndentedJSON(http.StatusNotFound, gin.H{"message": "create file failed"})
+		abortUpstream(c, err, "create file failed")
 		return
 	}
 
 	if p.callback != nil {
 		klog.V(6).Infof("CreateFineTune Callback...\n")
-		err = (*p.callback).CreateFineTune(finetuneRequest, resp)
+		err = p.dispatch(ctx, interfaces.EventTypeCreateFineTune, finetuneRequest, resp)
 		if err != nil {
 			klog.V(1).Infof("[CALLBACK] CreateFineTune failed. Err: %v\n", err)
 		}
@@ -59,19 +70,23 @@ func (p *ChatGPTProxy) getFineTunes(c *gin.Context) {
 		klog.V(5).Infof("HTTP Header: %s = %v\n", key, value)
 	}
 
-	ctx := context.Background()
+	ctx := p.requestContext(c)
 
-	fileList, err := p.chatgptClient.ListFineTunes(ctx)
+	var fileList openai.FineTuneList
+	err := p.upstream(ctx, upstreamOpenAI, c.FullPath(), func(ctx context.Context) (err error) {
+		fileList, err = p.client(ctx).ListFineTunes(ctx)
+		return err
+	})
 	if err != nil {
 		klog.V(1).Infof("client.ListFineTunes failed. Err: %v\n", err)
 		klog.V(6).Infof("getFineTunes LEAVE\n")
-		c.IndentedJSON(http.StatusNotFound, gin.H{"message": "list fine-tunes failed"})
+		abortUpstream(c, err, "list fine-tunes failed")
 		return
 	}
 
 	if p.callback != nil {
 		klog.V(6).Infof("ListFineTunes Callback...\n")
-		err = (*p.callback).ListFineTunes(fileList)
+		err = p.dispatch(ctx, interfaces.EventTypeListFineTunes, nil, fileList)
 		if err != nil {
 			klog.V(1).Infof("[CALLBACK] ListFineTunes failed. Err: %v\n", err)
 		}
@@ -92,19 +107,23 @@ func (p *ChatGPTProxy) getFineTune(c *gin.Context) {
 		klog.V(5).Infof("HTTP Header: %s = %v\n", key, value)
 	}
 
-	ctx := context.Background()
+	ctx := p.requestContext(c)
 
-	resp, err := p.chatgptClient.GetFineTune(ctx, finetuneID)
+	var resp openai.FineTune
+	err := p.upstream(ctx, upstreamOpenAI, c.FullPath(), func(ctx context.Context) (err error) {
+		resp, err = p.client(ctx).GetFineTune(ctx, finetuneID)
+		return err
+	})
 	if err != nil {
 		klog.V(6).Infof("client.GetFineTune failed. Err: %v\n", err)
 		klog.V(6).Infof("getFineTune LEAVE\n")
-		c.IndentedJSON(http.StatusNotFound, gin.H{"message": "get fine-tune failed"})
+		abortUpstream(c, err, "get fine-tune failed")
 		return
 	}
 
 	if p.callback != nil {
 		klog.V(6).Infof("GetFineTune Callback...\n")
-		err = (*p.callback).GetFineTune(finetuneID, resp)
+		err = p.dispatch(ctx, interfaces.EventTypeGetFineTune, interfaces.IDRequest{ID: finetuneID}, resp)
 		if err != nil {
 			klog.V(1).Infof("[CALLBACK] GetFineTune failed. Err: %v\n", err)
 		}
@@ -125,19 +144,23 @@ func (p *ChatGPTProxy) postCancelFineTune(c *gin.Context) {
 		klog.V(5).Infof("HTTP Header: %s = %v\n", key, value)
 	}
 
-	ctx := context.Background()
+	ctx := p.requestContext(c)
 
-	resp, err := p.chatgptClient.CancelFineTune(ctx, finetuneID)
+	var resp openai.FineTune
+	err := p.upstream(ctx, upstreamOpenAI, c.FullPath(), func(ctx context.Context) (err error) {
+		resp, err = p.client(ctx).CancelFineTune(ctx, finetuneID)
+		return err
+	})
 	if err != nil {
 		klog.V(6).Infof("client.CancelFineTune failed. Err: %v\n", err)
 		klog.V(6).Infof("postCancelFineTune LEAVE\n")
-		c.IndentedJSON(http.StatusNotFound, gin.H{"message": "cancel fine-tune failed"})
+		abortUpstream(c, err, "cancel fine-tune failed")
 		return
 	}
 
 	if p.callback != nil {
 		klog.V(6).Infof("CancelFineTune Callback...\n")
-		err = (*p.callback).CancelFineTune(finetuneID, resp)
+		err = p.dispatch(ctx, interfaces.EventTypeCancelFineTune, interfaces.IDRequest{ID: finetuneID}, resp)
 		if err != nil {
 			klog.V(1).Infof("[CALLBACK] CancelFineTune failed. Err: %v\n", err)
 		}
@@ -158,19 +181,23 @@ func (p *ChatGPTProxy) getFineTuneEvent(c *gin.Context) {
 		klog.V(5).Infof("HTTP Header: %s = %v\n", key, value)
 	}
 
-	ctx := context.Background()
+	ctx := p.requestContext(c)
 
-	resp, err := p.chatgptClient.ListFineTuneEvents(ctx, finetuneID)
+	var resp openai.FineTuneEventList
+	err := p.upstream(ctx, upstreamOpenAI, c.FullPath(), func(ctx context.Context) (err error) {
+		resp, err = p.client(ctx).ListFineTuneEvents(ctx, finetuneID)
+		return err
+	})
 	if err != nil {
 		klog.V(6).Infof("client.ListFineTuneEvents failed. Err: %v\n", err)
 		klog.V(6).Infof("getFineTuneEvent LEAVE\n")
-		c.IndentedJSON(http.StatusNotFound, gin.H{"message": "get fine-tune events failed"})
+		abortUpstream(c, err, "get fine-tune events failed")
 		return
 	}
 
 	if p.callback != nil {
 		klog.V(6).Infof("ListFineTuneEvents Callback...\n")
-		err = (*p.callback).ListFineTuneEvents(finetuneID, resp)
+		err = p.dispatch(ctx, interfaces.EventTypeListFineTuneEvents, interfaces.IDRequest{ID: finetuneID}, resp)
 		if err != nil {
 			klog.V(1).Infof("[CALLBACK] ListFineTuneEvents failed. Err: %v\n", err)
 		}
@@ -191,19 +218,23 @@ func (p *ChatGPTProxy) deleteFineTune(c *gin.Context) {
 		klog.V(5).Infof("HTTP Header: %s = %v\n", key, value)
 	}
 
-	ctx := context.Background()
+	ctx := p.requestContext(c)
 
-	resp, err := p.chatgptClient.DeleteFineTune(ctx, finetuneID)
+	var resp openai.FineTuneDeleteResponse
+	err := p.upstream(ctx, upstreamOpenAI, c.FullPath(), func(ctx context.Context) (err error) {
+		resp, err = p.client(ctx).DeleteFineTune(ctx, finetuneID)
+		return err
+	})
 	if err != nil {
 		klog.V(6).Infof("client.DeleteFineTune failed. Err: %v\n", err)
 		klog.V(6).Infof("deleteFineTune LEAVE\n")
-		c.IndentedJSON(http.StatusNotFound, gin.H{"message": "delete file failed"})
+		abortUpstream(c, err, "delete file failed")
 		return
 	}
 
 	if p.callback != nil {
 		klog.V(6).Infof("DeleteFineTune Callback...\n")
-		err = (*p.callback).DeleteFineTune(finetuneID)
+		err = p.dispatch(ctx, interfaces.EventTypeDeleteFineTune, interfaces.IDRequest{ID: finetuneID}, nil)
 		if err != nil {
 			klog.V(1).Infof("[CALLBACK] DeleteFineTune failed. Err: %v\n", err)
 		}
@@ -10,6 +10,9 @@ import (
 	"github.com/gin-gonic/gin"
 	openai "github.com/sashabaranov/go-openai"
 	klog "k8s.io/klog/v2"
+
+	images "github.com/dvonthenen/chat-gpeasy/pkg/proxy/images"
+	interfaces "github.com/dvonthenen/chat-gpeasy/pkg/proxy/interfaces"
 )
 
 func (p *ChatGPTProxy) postCreateImage(c *gin.Context) {
@@ -19,7 +22,7 @@ func (p *ChatGPTProxy) postCreateImage(c *gin.Context) {
 		klog.V(5).Infof("HTTP Header: %s = %v\n", key, value)
 	}
 
-	ctx := context.Background()
+	ctx := p.requestContext(c)
 
 	var imageRequest openai.ImageRequest
 
@@ -31,17 +34,40 @@ func (p *ChatGPTProxy) postCreateImage(c *gin.Context) {
 		return
 	}
 
-	resp, err := p.chatgptClient.CreateImage(ctx, imageRequest)
+	responseFormat := imageRequest.ResponseFormat
+	imageRequest.ResponseFormat = p.imageResponseFormat(responseFormat)
+
+	var resp openai.ImageResponse
+	err := p.upstream(ctx, upstreamOpenAI, c.FullPath(), func(ctx context.Context) (err error) {
+		resp, err = p.client(ctx).CreateImage(ctx, imageRequest)
+		return err
+	})
 	if err != nil {
 		klog.V(6).Infof("client.CreateImage failed. Err: %v\n", err)
 		klog.V(6).Infof("postCreateImage LEAVE\n")
-		c.IndentedJSON(http.StatusNotFound, gin.H{"message": "image creation failed"})
+		abortUpstream(c, err, "image creation failed")
+		return
+	}
+
+	imageRequest.ResponseFormat = responseFormat
+	resp, err = p.persistImages(ctx, resp, responseFormat, images.Metadata{
+		Source: images.SourceGeneration,
+		Prompt: imageRequest.Prompt,
+		Caller: imageRequest.User,
+		Size:   imageRequest.Size,
+	})
+	if err != nil {
+		klog.V(1).Infof("persistImages failed. Err: %v\n", err)
+		klog.V(6).Infof("postCreateImage LEAVE\n")
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"message": "image store failed"})
 		return
 	}
 
+	p.recordUsage(ctx, imageRequest, resp)
+
 	if p.callback != nil {
 		klog.V(6).Infof("CreateImage Callback...\n")
-		err = (*p.callback).CreateImage(imageRequest, resp)
+		err = p.dispatch(ctx, interfaces.EventTypeCreateImage, imageRequest, resp)
 		if err != nil {
 			klog.V(1).Infof("[CALLBACK] CreateImage failed. Err: %v\n", err)
 		}
@@ -59,7 +85,7 @@ func (p *ChatGPTProxy) postEditImage(c *gin.Context) {
 		klog.V(5).Infof("HTTP Header: %s = %v\n", key, value)
 	}
 
-	ctx := context.Background()
+	ctx := p.requestContext(c)
 
 	var imageRequest openai.ImageEditRequest
 
@@ -71,17 +97,39 @@ func (p *ChatGPTProxy) postEditImage(c *gin.Context) {
 		return
 	}
 
-	resp, err := p.chatgptClient.CreateEditImage(ctx, imageRequest)
+	responseFormat := imageRequest.ResponseFormat
+	imageRequest.ResponseFormat = p.imageResponseFormat(responseFormat)
+
+	var resp openai.ImageResponse
+	err := p.upstream(ctx, upstreamOpenAI, c.FullPath(), func(ctx context.Context) (err error) {
+		resp, err = p.client(ctx).CreateEditImage(ctx, imageRequest)
+		return err
+	})
 	if err != nil {
 		klog.V(6).Infof("client.CreateEditImage failed. Err: %v\n", err)
 		klog.V(6).Infof("postEditImage LEAVE\n")
-		c.IndentedJSON(http.StatusNotFound, gin.H{"message": "image creation failed"})
+		abortUpstream(c, err, "image creation failed")
 		return
 	}
 
+	imageRequest.ResponseFormat = responseFormat
+	resp, err = p.persistImages(ctx, resp, responseFormat, images.Metadata{
+		Source: images.SourceEdit,
+		Prompt: imageRequest.Prompt,
+		Size:   imageRequest.Size,
+	})
+	if err != nil {
+		klog.V(1).Infof("persistImages failed. Err: %v\n", err)
+		klog.V(6).Infof("postEditImage LEAVE\n")
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"message": "image store failed"})
+		return
+	}
+
+	p.recordUsage(ctx, imageRequest, resp)
+
 	if p.callback != nil {
 		klog.V(6).Infof("CreateEditImage Callback...\n")
-		err = (*p.callback).CreateEditImage(imageRequest, resp)
+		err = p.dispatch(ctx, interfaces.EventTypeCreateEditImage, imageRequest, resp)
 		if err != nil {
 			klog.V(1).Infof("[CALLBACK] CreateEditImage failed. Err: %v\n", err)
 		}
@@ -99,7 +147,7 @@ func (p *ChatGPTProxy) postVariationImage(c *gin.Context) {
 		klog.V(5).Infof("HTTP Header: %s = %v\n", key, value)
 	}
 
-	ctx := context.Background()
+	ctx := p.requestContext(c)
 
 	var imageRequest openai.ImageVariRequest
 
@@ -111,17 +159,38 @@ func (p *ChatGPTProxy) postVariationImage(c *gin.Context) {
 		return
 	}
 
-	resp, err := p.chatgptClient.CreateVariImage(ctx, imageRequest)
+	responseFormat := imageRequest.ResponseFormat
+	imageRequest.ResponseFormat = p.imageResponseFormat(responseFormat)
+
+	var resp openai.ImageResponse
+	err := p.upstream(ctx, upstreamOpenAI, c.FullPath(), func(ctx context.Context) (err error) {
+		resp, err = p.client(ctx).CreateVariImage(ctx, imageRequest)
+		return err
+	})
 	if err != nil {
 		klog.V(6).Infof("client.CreateVariImage failed. Err: %v\n", err)
 		klog.V(6).Infof("postVariationImage LEAVE\n")
-		c.IndentedJSON(http.StatusNotFound, gin.H{"message": "image variation failed"})
+		abortUpstream(c, err, "image variation failed")
 		return
 	}
 
+	imageRequest.ResponseFormat = responseFormat
+	resp, err = p.persistImages(ctx, resp, responseFormat, images.Metadata{
+		Source: images.SourceVariation,
+		Size:   imageRequest.Size,
+	})
+	if err != nil {
+		klog.V(1).Infof("persistImages failed. Err: %v\n", err)
+		klog.V(6).Infof("postVariationImage LEAVE\n")
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"message": "image store failed"})
+		return
+	}
+
+	p.recordUsage(ctx, imageRequest, resp)
+
 	if p.callback != nil {
 		klog.V(6).Infof("CreateVariImage Callback...\n")
-		err = (*p.callback).CreateVariImage(imageRequest, resp)
+		err = p.dispatch(ctx, interfaces.EventTypeCreateVariImage, imageRequest, resp)
 		if err != nil {
 			klog.V(1).Infof("[CALLBACK] CreateVariImage failed. Err: %v\n", err)
 		}
@@ -131,3 +200,73 @@ func (p *ChatGPTProxy) postVariationImage(c *gin.Context) {
 	klog.V(6).Infof("postVariationImage LEAVE\n")
 	c.IndentedJSON(http.StatusOK, resp)
 }
+
+func (p *ChatGPTProxy) getImageFile(c *gin.Context) {
+	klog.V(6).Infof("getImageFile ENTER\n")
+
+	imageID := c.Param("image_id")
+
+	klog.V(5).Infof("imageID: %s\n", imageID)
+	for key, value := range c.Request.Header {
+		klog.V(5).Infof("HTTP Header: %s = %v\n", key, value)
+	}
+
+	image, path, err := p.images.Get(imageID)
+	if err != nil {
+		klog.V(1).Infof("images.Get failed. Err: %v\n", err)
+		klog.V(6).Infof("getImageFile LEAVE\n")
+		c.IndentedJSON(http.StatusNotFound, gin.H{"message": "image not found"})
+		return
+	}
+
+	klog.V(4).Infof("getImageFile Succeeded\n")
+	klog.V(6).Infof("getImageFile LEAVE\n")
+	c.Header("Content-Type", image.ContentType)
+	c.File(path)
+}
+
+func (p *ChatGPTProxy) getImageMetadata(c *gin.Context) {
+	klog.V(6).Infof("getImageMetadata ENTER\n")
+
+	imageID := c.Param("image_id")
+
+	klog.V(5).Infof("imageID: %s\n", imageID)
+	for key, value := range c.Request.Header {
+		klog.V(5).Infof("HTTP Header: %s = %v\n", key, value)
+	}
+
+	image, _, err := p.images.Get(imageID)
+	if err != nil {
+		klog.V(1).Infof("images.Get failed. Err: %v\n", err)
+		klog.V(6).Infof("getImageMetadata LEAVE\n")
+		c.IndentedJSON(http.StatusNotFound, gin.H{"message": "image not found"})
+		return
+	}
+
+	klog.V(4).Infof("getImageMetadata Succeeded\n")
+	klog.V(6).Infof("getImageMetadata LEAVE\n")
+	c.IndentedJSON(http.StatusOK, image)
+}
+
+func (p *ChatGPTProxy) deleteImageFile(c *gin.Context) {
+	klog.V(6).Infof("deleteImageFile ENTER\n")
+
+	imageID := c.Param("image_id")
+
+	klog.V(5).Infof("imageID: %s\n", imageID)
+	for key, value := range c.Request.Header {
+		klog.V(5).Infof("HTTP Header: %s = %v\n", key, value)
+	}
+
+	err := p.images.Delete(imageID)
+	if err != nil {
+		klog.V(1).Infof("images.Delete failed. Err: %v\n", err)
+		klog.V(6).Infof("deleteImageFile LEAVE\n")
+		c.IndentedJSON(http.StatusNotFound, gin.H{"message": "image not found"})
+		return
+	}
+
+	klog.V(4).Infof("deleteImageFile Succeeded\n")
+	klog.V(6).Infof("deleteImageFile LEAVE\n")
+	c.IndentedJSON(http.StatusOK, gin.H{"message": "delete image succeeded"})
+}
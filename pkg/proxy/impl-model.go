@@ -8,7 +8,10 @@ import (
 	"net/http"
 
 	"github.com/gin-gonic/gin"
+	openai "github.com/sashabaranov/go-openai"
 	klog "k8s.io/klog/v2"
+
+	interfaces "github.com/dvonthenen/chat-gpeasy/pkg/proxy/interfaces"
 )
 
 func (p *ChatGPTProxy) getModels(c *gin.Context) {
@@ -18,19 +21,23 @@ func (p *ChatGPTProxy) getModels(c *gin.Context) {
 		klog.V(5).Infof("HTTP Header: %s = %v\n", key, value)
 	}
 
-	ctx := context.Background()
+	ctx := p.requestContext(c)
 
-	modelList, err := p.chatgptClient.ListModels(ctx)
+	var modelList openai.ModelsList
+	err := p.upstream(ctx, upstreamOpenAI, c.FullPath(), func(ctx context.Context) (err error) {
+		modelList, err = p.client(ctx).ListModels(ctx)
+		return err
+	})
 	if err != nil {
 		klog.V(1).Infof("client.ListModels failed. Err: %v\n", err)
 		klog.V(6).Infof("getModels LEAVE\n")
-		c.IndentedJSON(http.StatusNotFound, gin.H{"message": "list models failed"})
+		abortUpstream(c, err, "list models failed")
 		return
 	}
 
 	if p.callback != nil {
 		klog.V(6).Infof("ListModels Callback...\n")
-		err = (*p.callback).ListModels(modelList)
+		err = p.dispatch(ctx, interfaces.EventTypeListModels, nil, modelList)
 		if err != nil {
 			klog.V(1).Infof("[CALLBACK] ListModels failed. Err: %v\n", err)
 		}
@@ -10,6 +10,8 @@ import (
 	"github.com/gin-gonic/gin"
 	openai "github.com/sashabaranov/go-openai"
 	klog "k8s.io/klog/v2"
+
+	interfaces "github.com/dvonthenen/chat-gpeasy/pkg/proxy/interfaces"
 )
 
 func (p *ChatGPTProxy) postModeration(c *gin.Context) {
@@ -19,7 +21,7 @@ func (p *ChatGPTProxy) postModeration(c *gin.Context) {
 		klog.V(5).Infof("HTTP Header: %s = %v\n", key, value)
 	}
 
-	ctx := context.Background()
+	ctx := p.requestContext(c)
 
 	var moderationRequest openai.ModerationRequest
 
@@ -31,17 +33,28 @@ func (p *ChatGPTProxy) postModeration(c *gin.Context) {
 		return
 	}
 
-	resp, err := p.chatgptClient.Moderations(ctx, moderationRequest)
+	if !p.allowModel(c, moderationRequest.Model) {
+		klog.V(6).Infof("postModeration LEAVE\n")
+		return
+	}
+
+	var resp openai.ModerationResponse
+	err := p.upstream(ctx, upstreamOpenAI, c.FullPath(), func(ctx context.Context) (err error) {
+		resp, err = p.client(ctx).Moderations(ctx, moderationRequest)
+		return err
+	})
 	if err != nil {
 		klog.V(6).Infof("client.Moderations failed. Err: %v\n", err)
 		klog.V(6).Infof("postModeration LEAVE\n")
-		c.IndentedJSON(http.StatusNotFound, gin.H{"message": "post moderations failed"})
+		abortUpstream(c, err, "post moderations failed")
 		return
 	}
 
+	p.recordUsage(ctx, moderationRequest, resp)
+
 	if p.callback != nil {
 		klog.V(6).Infof("Moderations Callback...\n")
-		err = (*p.callback).Moderations(moderationRequest, resp)
+		err = p.dispatch(ctx, interfaces.EventTypeModerations, moderationRequest, resp)
 		if err != nil {
 			klog.V(1).Infof("[CALLBACK] LisModerationstModels failed. Err: %v\n", err)
 		}
@@ -0,0 +1,444 @@
+// Copyright 2023 dvonthenen ChatGPT Proxy contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache License 2.0
+
+package proxy
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	openai "github.com/sashabaranov/go-openai"
+	klog "k8s.io/klog/v2"
+
+	advanced "github.com/dvonthenen/chat-gpeasy/pkg/personas/rest/advanced"
+	sessions "github.com/dvonthenen/chat-gpeasy/pkg/proxy/sessions"
+)
+
+func (p *ChatGPTProxy) postSession(c *gin.Context) {
+	klog.V(6).Infof("postSession ENTER\n")
+
+	for key, value := range c.Request.Header {
+		klog.V(5).Infof("HTTP Header: %s = %v\n", key, value)
+	}
+
+	ctx := p.requestContext(c)
+
+	var createRequest sessions.CreateRequest
+
+	// Call BindJSON to bind the received JSON to createRequest
+	if err := c.BindJSON(&createRequest); err != nil {
+		klog.V(1).Infof("BindJSON failed. Err: %v\n", err)
+		klog.V(6).Infof("postSession LEAVE\n")
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "bind json failed"})
+		return
+	}
+
+	model := createRequest.Model
+	if len(model) == 0 {
+		model = openai.GPT3Dot5Turbo
+	}
+	if !p.allowModel(c, model) {
+		klog.V(6).Infof("postSession LEAVE\n")
+		return
+	}
+
+	var messages []sessions.Message
+	if len(createRequest.Skill) > 0 {
+		skill, ok := sessionSkills[createRequest.Skill]
+		if !ok {
+			klog.V(1).Infof("unknown skill %s\n", createRequest.Skill)
+			klog.V(6).Infof("postSession LEAVE\n")
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "unknown skill"})
+			return
+		}
+
+		skillMessages, err := p.skillMessages(skill, model)
+		if err != nil {
+			klog.V(1).Infof("skillMessages failed. Err: %v\n", err)
+			klog.V(6).Infof("postSession LEAVE\n")
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+			return
+		}
+		messages = append(messages, skillMessages...)
+	}
+	for _, message := range createRequest.Messages {
+		if !validSessionRole(message.Role) || len(message.Content) == 0 {
+			klog.V(1).Infof("invalid message (role: %s)\n", message.Role)
+			klog.V(6).Infof("postSession LEAVE\n")
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "invalid message"})
+			return
+		}
+	}
+	messages = append(messages, createRequest.Messages...)
+
+	if !p.screen(ctx, c, sessionRequest(model, messages)) {
+		klog.V(6).Infof("postSession LEAVE\n")
+		return
+	}
+
+	session, err := p.sessions.Create(sessionOwner(c), model, messages)
+	if err != nil {
+		klog.V(1).Infof("sessions.Create failed. Err: %v\n", err)
+		klog.V(6).Infof("postSession LEAVE\n")
+		abortSession(c, err)
+		return
+	}
+
+	klog.V(4).Infof("postSession Succeeded\n")
+	klog.V(6).Infof("postSession LEAVE\n")
+	c.IndentedJSON(http.StatusOK, session)
+}
+
+func (p *ChatGPTProxy) getSessions(c *gin.Context) {
+	klog.V(6).Infof("getSessions ENTER\n")
+
+	for key, value := range c.Request.Header {
+		klog.V(5).Infof("HTTP Header: %s = %v\n", key, value)
+	}
+
+	list, err := p.sessions.List(sessionOwner(c))
+	if err != nil {
+		klog.V(1).Infof("sessions.List failed. Err: %v\n", err)
+		klog.V(6).Infof("getSessions LEAVE\n")
+		abortSession(c, err)
+		return
+	}
+
+	klog.V(4).Infof("getSessions Succeeded\n")
+	klog.V(6).Infof("getSessions LEAVE\n")
+	c.IndentedJSON(http.StatusOK, sessions.SessionList{
+		Object: sessions.ListObject,
+		Data:   list,
+	})
+}
+
+func (p *ChatGPTProxy) getSession(c *gin.Context) {
+	klog.V(6).Infof("getSession ENTER\n")
+
+	sessionID := c.Param("session_id")
+
+	klog.V(5).Infof("sessionID: %s\n", sessionID)
+	for key, value := range c.Request.Header {
+		klog.V(5).Infof("HTTP Header: %s = %v\n", key, value)
+	}
+
+	session, err := p.sessions.Get(sessionOwner(c), sessionID)
+	if err != nil {
+		klog.V(1).Infof("sessions.Get failed. Err: %v\n", err)
+		klog.V(6).Infof("getSession LEAVE\n")
+		abortSession(c, err)
+		return
+	}
+
+	klog.V(4).Infof("getSession Succeeded\n")
+	klog.V(6).Infof("getSession LEAVE\n")
+	c.IndentedJSON(http.StatusOK, session)
+}
+
+func (p *ChatGPTProxy) deleteSession(c *gin.Context) {
+	klog.V(6).Infof("deleteSession ENTER\n")
+
+	sessionID := c.Param("session_id")
+
+	klog.V(5).Infof("sessionID: %s\n", sessionID)
+	for key, value := range c.Request.Header {
+		klog.V(5).Infof("HTTP Header: %s = %v\n", key, value)
+	}
+
+	unlock := p.sessions.Lock(sessionID)
+	defer unlock()
+
+	err := p.sessions.Delete(sessionOwner(c), sessionID)
+	if err != nil {
+		klog.V(1).Infof("sessions.Delete failed. Err: %v\n", err)
+		klog.V(6).Infof("deleteSession LEAVE\n")
+		abortSession(c, err)
+		return
+	}
+
+	klog.V(4).Infof("deleteSession Succeeded\n")
+	klog.V(6).Infof("deleteSession LEAVE\n")
+	c.IndentedJSON(http.StatusOK, gin.H{"id": sessionID, "object": sessions.SessionObject, "deleted": true})
+}
+
+func (p *ChatGPTProxy) getSessionMessages(c *gin.Context) {
+	klog.V(6).Infof("getSessionMessages ENTER\n")
+
+	sessionID := c.Param("session_id")
+
+	klog.V(5).Infof("sessionID: %s\n", sessionID)
+	for key, value := range c.Request.Header {
+		klog.V(5).Infof("HTTP Header: %s = %v\n", key, value)
+	}
+
+	session, err := p.sessions.Get(sessionOwner(c), sessionID)
+	if err != nil {
+		klog.V(1).Infof("sessions.Get failed. Err: %v\n", err)
+		klog.V(6).Infof("getSessionMessages LEAVE\n")
+		abortSession(c, err)
+		return
+	}
+
+	klog.V(4).Infof("getSessionMessages Succeeded\n")
+	klog.V(6).Infof("getSessionMessages LEAVE\n")
+	c.IndentedJSON(http.StatusOK, sessions.MessageList{
+		Object: sessions.ListObject,
+		Data:   session.Messages,
+	})
+}
+
+func (p *ChatGPTProxy) postSessionMessage(c *gin.Context) {
+	klog.V(6).Infof("postSessionMessage ENTER\n")
+
+	sessionID := c.Param("session_id")
+
+	klog.V(5).Infof("sessionID: %s\n", sessionID)
+	for key, value := range c.Request.Header {
+		klog.V(5).Infof("HTTP Header: %s = %v\n", key, value)
+	}
+
+	ctx := p.requestContext(c)
+
+	var messageRequest sessions.MessageRequest
+
+	// Call BindJSON to bind the received JSON to messageRequest
+	if err := c.BindJSON(&messageRequest); err != nil {
+		klog.V(1).Infof("BindJSON failed. Err: %v\n", err)
+		klog.V(6).Infof("postSessionMessage LEAVE\n")
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "bind json failed"})
+		return
+	}
+	if len(messageRequest.Role) == 0 {
+		messageRequest.Role = openai.ChatMessageRoleUser
+	}
+	if !validSessionRole(messageRequest.Role) || len(messageRequest.Content) == 0 {
+		klog.V(1).Infof("invalid message (role: %s)\n", messageRequest.Role)
+		klog.V(6).Infof("postSessionMessage LEAVE\n")
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "invalid message"})
+		return
+	}
+
+	unlock := p.sessions.Lock(sessionID)
+	defer unlock()
+
+	session, err := p.sessions.Get(sessionOwner(c), sessionID)
+	if err != nil {
+		klog.V(1).Infof("sessions.Get failed. Err: %v\n", err)
+		klog.V(6).Infof("postSessionMessage LEAVE\n")
+		abortSession(c, err)
+		return
+	}
+	if p.sessions.Full(session, 2) {
+		klog.V(1).Infof("session %s is full\n", sessionID)
+		klog.V(6).Infof("postSessionMessage LEAVE\n")
+		abortSession(c, sessions.ErrTooManyMessages)
+		return
+	}
+
+	newMessage := sessions.Message{Role: messageRequest.Role, Content: messageRequest.Content}
+	if !p.screen(ctx, c, sessionRequest(session.Model, []sessions.Message{newMessage})) {
+		klog.V(6).Infof("postSessionMessage LEAVE\n")
+		return
+	}
+
+	err = p.querySession(ctx, c, session, func(ctx context.Context, persona *advanced.Persona) error {
+		_, err := persona.Query(ctx, messageRequest.Role, messageRequest.Content)
+		return err
+	})
+	if err != nil {
+		klog.V(1).Infof("querySession failed. Err: %v\n", err)
+		klog.V(6).Infof("postSessionMessage LEAVE\n")
+		abortUpstream(c, err, "session message failed")
+		return
+	}
+
+	if err := p.sessions.Save(session); err != nil {
+		klog.V(1).Infof("sessions.Save failed. Err: %v\n", err)
+		klog.V(6).Infof("postSessionMessage LEAVE\n")
+		abortSession(c, err)
+		return
+	}
+
+	klog.V(4).Infof("postSessionMessage Succeeded\n")
+	klog.V(6).Infof("postSessionMessage LEAVE\n")
+	c.IndentedJSON(http.StatusOK, sessionReply(session))
+}
+
+func (p *ChatGPTProxy) putSessionMessage(c *gin.Context) {
+	klog.V(6).Infof("putSessionMessage ENTER\n")
+
+	sessionID := c.Param("session_id")
+	index, err := strconv.Atoi(c.Param("index"))
+
+	klog.V(5).Infof("sessionID: %s\n", sessionID)
+	klog.V(5).Infof("index: %s\n", c.Param("index"))
+	for key, value := range c.Request.Header {
+		klog.V(5).Infof("HTTP Header: %s = %v\n", key, value)
+	}
+
+	if err != nil {
+		klog.V(1).Infof("invalid index. Err: %v\n", err)
+		klog.V(6).Infof("putSessionMessage LEAVE\n")
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "invalid index"})
+		return
+	}
+
+	ctx := p.requestContext(c)
+
+	var messageRequest sessions.MessageRequest
+
+	// Call BindJSON to bind the received JSON to messageRequest
+	if err := c.BindJSON(&messageRequest); err != nil {
+		klog.V(1).Infof("BindJSON failed. Err: %v\n", err)
+		klog.V(6).Infof("putSessionMessage LEAVE\n")
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "bind json failed"})
+		return
+	}
+	if len(messageRequest.Content) == 0 {
+		klog.V(1).Infof("content is empty\n")
+		klog.V(6).Infof("putSessionMessage LEAVE\n")
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "invalid message"})
+		return
+	}
+
+	unlock := p.sessions.Lock(sessionID)
+	defer unlock()
+
+	session, err := p.sessions.Get(sessionOwner(c), sessionID)
+	if err != nil {
+		klog.V(1).Infof("sessions.Get failed. Err: %v\n", err)
+		klog.V(6).Infof("putSessionMessage LEAVE\n")
+		abortSession(c, err)
+		return
+	}
+
+	// responses can not be edited, only the messages that led to them
+	if index < 0 || index >= len(session.Messages) || session.Messages[index].Role == openai.ChatMessageRoleAssistant {
+		klog.V(1).Infof("message %d can not be edited\n", index)
+		klog.V(6).Infof("putSessionMessage LEAVE\n")
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "invalid index"})
+		return
+	}
+
+	edited := sessions.Message{Role: session.Messages[index].Role, Content: messageRequest.Content}
+	if !p.screen(ctx, c, sessionRequest(session.Model, []sessions.Message{edited})) {
+		klog.V(6).Infof("putSessionMessage LEAVE\n")
+		return
+	}
+
+	err = p.querySession(ctx, c, session, func(ctx context.Context, persona *advanced.Persona) error {
+		_, err := persona.EditConversation(index, messageRequest.Content)
+		return err
+	})
+	if err != nil {
+		klog.V(1).Infof("querySession failed. Err: %v\n", err)
+		klog.V(6).Infof("putSessionMessage LEAVE\n")
+		abortUpstream(c, err, "session edit failed")
+		return
+	}
+
+	if err := p.sessions.Save(session); err != nil {
+		klog.V(1).Infof("sessions.Save failed. Err: %v\n", err)
+		klog.V(6).Infof("putSessionMessage LEAVE\n")
+		abortSession(c, err)
+		return
+	}
+
+	klog.V(4).Infof("putSessionMessage Succeeded\n")
+	klog.V(6).Infof("putSessionMessage LEAVE\n")
+	c.IndentedJSON(http.StatusOK, sessionReply(session))
+}
+
+func (p *ChatGPTProxy) postSessionDirective(c *gin.Context) {
+	klog.V(6).Infof("postSessionDirective ENTER\n")
+
+	sessionID := c.Param("session_id")
+
+	klog.V(5).Infof("sessionID: %s\n", sessionID)
+	for key, value := range c.Request.Header {
+		klog.V(5).Infof("HTTP Header: %s = %v\n", key, value)
+	}
+
+	ctx := p.requestContext(c)
+
+	var messageRequest sessions.MessageRequest
+
+	// Call BindJSON to bind the received JSON to messageRequest
+	if err := c.BindJSON(&messageRequest); err != nil {
+		klog.V(1).Infof("BindJSON failed. Err: %v\n", err)
+		klog.V(6).Infof("postSessionDirective LEAVE\n")
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "bind json failed"})
+		return
+	}
+	if len(messageRequest.Content) == 0 {
+		klog.V(1).Infof("directive is empty\n")
+		klog.V(6).Infof("postSessionDirective LEAVE\n")
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "invalid directive"})
+		return
+	}
+
+	unlock := p.sessions.Lock(sessionID)
+	defer unlock()
+
+	session, err := p.sessions.Get(sessionOwner(c), sessionID)
+	if err != nil {
+		klog.V(1).Infof("sessions.Get failed. Err: %v\n", err)
+		klog.V(6).Infof("postSessionDirective LEAVE\n")
+		abortSession(c, err)
+		return
+	}
+	if p.sessions.Full(session, 1) {
+		klog.V(1).Infof("session %s is full\n", sessionID)
+		klog.V(6).Infof("postSessionDirective LEAVE\n")
+		abortSession(c, sessions.ErrTooManyMessages)
+		return
+	}
+
+	directive := sessions.Message{Role: openai.ChatMessageRoleSystem, Content: messageRequest.Content}
+	if !p.screen(ctx, c, sessionRequest(session.Model, []sessions.Message{directive})) {
+		klog.V(6).Infof("postSessionDirective LEAVE\n")
+		return
+	}
+
+	err = addSessionDirective(p.chatgptClient, session, messageRequest.Content)
+	if err != nil {
+		klog.V(1).Infof("addSessionDirective failed. Err: %v\n", err)
+		klog.V(6).Infof("postSessionDirective LEAVE\n")
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	if err := p.sessions.Save(session); err != nil {
+		klog.V(1).Infof("sessions.Save failed. Err: %v\n", err)
+		klog.V(6).Infof("postSessionDirective LEAVE\n")
+		abortSession(c, err)
+		return
+	}
+
+	klog.V(4).Infof("postSessionDirective Succeeded\n")
+	klog.V(6).Infof("postSessionDirective LEAVE\n")
+	c.IndentedJSON(http.StatusOK, session)
+}
+
+// abortSession writes the response for a failed session operation
+func abortSession(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, sessions.ErrSessionNotFound):
+		c.IndentedJSON(http.StatusNotFound, gin.H{"message": "session not found"})
+	case errors.Is(err, sessions.ErrTooManyMessages), errors.Is(err, sessions.ErrInvalidInput):
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+	default:
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"message": "session store failed"})
+	}
+}
+
+func validSessionRole(role string) bool {
+	switch role {
+	case openai.ChatMessageRoleUser, openai.ChatMessageRoleSystem, openai.ChatMessageRoleAssistant:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,51 @@
+// Copyright 2023 dvonthenen ChatGPT Proxy contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache License 2.0
+
+package proxy
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	klog "k8s.io/klog/v2"
+
+	queue "github.com/dvonthenen/chat-gpeasy/pkg/proxy/queue"
+)
+
+func (p *ChatGPTProxy) getStatus(c *gin.Context) {
+	klog.V(6).Infof("getStatus ENTER\n")
+
+	for key, value := range c.Request.Header {
+		klog.V(5).Infof("HTTP Header: %s = %v\n", key, value)
+	}
+
+	status := ProxyStatus{
+		Object: StatusObject,
+		Queues: []queue.Stats{},
+	}
+	if p.limiter != nil {
+		status.Queues = p.limiter.Stats()
+	}
+	if p.breakers != nil {
+		status.Breakers = p.breakers.Statuses()
+	}
+	if p.keys != nil {
+		status.Keys = p.keys.Status()
+	}
+	if p.guard != nil {
+		stats := p.guard.Stats()
+		status.Guard = &stats
+	}
+	if p.output != nil {
+		stats := p.output.Stats()
+		status.Output = &stats
+	}
+	if p.coalescer != nil {
+		stats := p.coalescer.Stats()
+		status.Coalescing = &stats
+	}
+
+	klog.V(4).Infof("getStatus Succeeded\n")
+	klog.V(6).Infof("getStatus LEAVE\n")
+	c.IndentedJSON(http.StatusOK, status)
+}
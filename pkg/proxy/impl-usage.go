@@ -0,0 +1,124 @@
+// Copyright 2023 dvonthenen ChatGPT Proxy contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache License 2.0
+
+package proxy
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	klog "k8s.io/klog/v2"
+
+	usage "github.com/dvonthenen/chat-gpeasy/pkg/proxy/usage"
+)
+
+func (p *ChatGPTProxy) getUsage(c *gin.Context) {
+	klog.V(6).Infof("getUsage ENTER\n")
+
+	for key, value := range c.Request.Header {
+		klog.V(5).Infof("HTTP Header: %s = %v\n", key, value)
+	}
+
+	query, err := usageQuery(c)
+	if err != nil {
+		klog.V(1).Infof("usageQuery failed. Err: %v\n", err)
+		klog.V(6).Infof("getUsage LEAVE\n")
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	format := strings.ToLower(c.DefaultQuery("format", usageFormatJSON))
+	if format != usageFormatJSON && format != usageFormatCSV {
+		klog.V(1).Infof("invalid format %s\n", format)
+		klog.V(6).Infof("getUsage LEAVE\n")
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "format must be json or csv"})
+		return
+	}
+
+	report, err := p.usage.Report(query)
+	if err != nil {
+		klog.V(1).Infof("usage.Report failed. Err: %v\n", err)
+		klog.V(6).Infof("getUsage LEAVE\n")
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	if format == usageFormatJSON {
+		klog.V(4).Infof("getUsage Succeeded\n")
+		klog.V(6).Infof("getUsage LEAVE\n")
+		c.IndentedJSON(http.StatusOK, report)
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := usage.WriteCSV(&buf, report); err != nil {
+		klog.V(1).Infof("usage.WriteCSV failed. Err: %v\n", err)
+		klog.V(6).Infof("getUsage LEAVE\n")
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"message": "csv export failed"})
+		return
+	}
+
+	klog.V(4).Infof("getUsage Succeeded\n")
+	klog.V(6).Infof("getUsage LEAVE\n")
+	c.Header(contentDisposition, fmt.Sprintf("attachment; filename=%q", usageFilename(report)))
+	c.Data(http.StatusOK, contentTypeCSV, buf.Bytes())
+}
+
+// usageQuery reads the filters and grouping of a usage report. month selects a
+// whole month, from and to a range of days.
+func usageQuery(c *gin.Context) (usage.Query, error) {
+	query := usage.Query{
+		Caller: c.Query("caller"),
+		Team:   c.Query("team"),
+		Key:    c.Query("key"),
+		Model:  c.Query("model"),
+		Route:  c.Query("route"),
+	}
+
+	if groupBy := c.Query("group_by"); len(groupBy) > 0 {
+		for _, group := range strings.Split(groupBy, ",") {
+			query.GroupBy = append(query.GroupBy, strings.TrimSpace(group))
+		}
+	}
+
+	if month := c.Query("month"); len(month) > 0 {
+		start, err := time.Parse(usage.MonthLayout, month)
+		if err != nil {
+			return query, fmt.Errorf("month must be %s", usage.MonthLayout)
+		}
+		query.From = start
+		query.To = start.AddDate(0, 1, -1)
+	}
+	if from := c.Query("from"); len(from) > 0 {
+		day, err := time.Parse(usage.DayLayout, from)
+		if err != nil {
+			return query, fmt.Errorf("from must be %s", usage.DayLayout)
+		}
+		query.From = day
+	}
+	if to := c.Query("to"); len(to) > 0 {
+		day, err := time.Parse(usage.DayLayout, to)
+		if err != nil {
+			return query, fmt.Errorf("to must be %s", usage.DayLayout)
+		}
+		query.To = day
+	}
+
+	return query, nil
+}
+
+// usageFilename names a CSV export after the days it covers
+func usageFilename(report *usage.Report) string {
+	name := "usage"
+	if len(report.From) > 0 {
+		name += "-" + report.From
+	}
+	if len(report.To) > 0 {
+		name += "-" + report.To
+	}
+	return name + "." + usageFormatCSV
+}
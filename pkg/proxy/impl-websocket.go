@@ -0,0 +1,66 @@
+// Copyright 2023 dvonthenen ChatGPT Proxy contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache License 2.0
+
+package proxy
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	openai "github.com/sashabaranov/go-openai"
+	klog "k8s.io/klog/v2"
+)
+
+func (p *ChatGPTProxy) getChatSocket(c *gin.Context) {
+	klog.V(6).Infof("getChatSocket ENTER\n")
+
+	for key, value := range c.Request.Header {
+		klog.V(5).Infof("HTTP Header: %s = %v\n", key, value)
+	}
+
+	model := c.DefaultQuery("model", openai.GPT3Dot5Turbo)
+	if !p.allowModel(c, model) {
+		klog.V(6).Infof("getChatSocket LEAVE\n")
+		return
+	}
+
+	skill, ok := sessionSkills[c.DefaultQuery("skill", defaultChatSkill)]
+	if !ok {
+		klog.V(1).Infof("unknown skill %s\n", c.Query("skill"))
+		klog.V(6).Infof("getChatSocket LEAVE\n")
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "unknown skill"})
+		return
+	}
+	messages, err := p.skillMessages(skill, model)
+	if err != nil {
+		klog.V(1).Infof("skillMessages failed. Err: %v\n", err)
+		klog.V(6).Infof("getChatSocket LEAVE\n")
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	if !p.screen(p.requestContext(c), c, sessionRequest(model, messages)) {
+		klog.V(6).Infof("getChatSocket LEAVE\n")
+		return
+	}
+
+	// the upgrader answers the client itself when the handshake fails
+	conn, err := p.chatSocketUpgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		klog.V(1).Infof("Upgrade failed. Err: %v\n", err)
+		klog.V(6).Infof("getChatSocket LEAVE\n")
+		return
+	}
+
+	socket := &chatSocket{
+		proxy:        p,
+		c:            c,
+		conn:         conn,
+		model:        model,
+		conversation: toCompletionMessages(messages),
+	}
+	socket.serve()
+
+	klog.V(4).Infof("getChatSocket Succeeded\n")
+	klog.V(6).Infof("getChatSocket LEAVE\n")
+}
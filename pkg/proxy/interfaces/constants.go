@@ -8,4 +8,10 @@ import "errors"
 var (
 	// ErrInvalidInput required input was not found
 	ErrInvalidInput = errors.New("required input was not found")
+
+	// ErrUnknownEventType event type does not map to a callback method
+	ErrUnknownEventType = errors.New("unknown event type")
+
+	// ErrEventMismatch event request or response does not match the event type
+	ErrEventMismatch = errors.New("event request or response does not match the event type")
 )
@@ -0,0 +1,436 @@
+// Copyright 2023 dvonthenen ChatGPT Proxy contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache License 2.0
+
+package interfaces
+
+import (
+	"encoding/json"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// Dispatch delivers an event to a callback. Callbacks implementing
+// ChatGPTEventCallback receive the event as is, everything else gets the typed
+// method matching the event type.
+func Dispatch(callback ChatGPTCallback, event *Event) error {
+	if eventCallback, ok := callback.(ChatGPTEventCallback); ok {
+		return eventCallback.OnEvent(event)
+	}
+
+	switch event.Type {
+	case EventTypeCreateTranscription:
+		request, okReq := event.Request.(openai.AudioRequest)
+		response, okResp := event.Response.(openai.AudioResponse)
+		if !okReq || !okResp {
+			return ErrEventMismatch
+		}
+		return callback.CreateTranscription(request, response)
+	case EventTypeCreateTranslation:
+		request, okReq := event.Request.(openai.AudioRequest)
+		response, okResp := event.Response.(openai.AudioResponse)
+		if !okReq || !okResp {
+			return ErrEventMismatch
+		}
+		return callback.CreateTranslation(request, response)
+	case EventTypeCreateCompletion:
+		request, okReq := event.Request.(openai.CompletionRequest)
+		response, okResp := event.Response.(openai.CompletionResponse)
+		if !okReq || !okResp {
+			return ErrEventMismatch
+		}
+		return callback.CreateCompletion(request, response)
+	case EventTypeCreateChatCompletion:
+		request, okReq := event.Request.(openai.ChatCompletionRequest)
+		response, okResp := event.Response.(openai.ChatCompletionResponse)
+		if !okReq || !okResp {
+			return ErrEventMismatch
+		}
+		return callback.CreateChatCompletion(request, response)
+	case EventTypeEdits:
+		request, okReq := event.Request.(openai.EditsRequest)
+		response, okResp := event.Response.(openai.EditsResponse)
+		if !okReq || !okResp {
+			return ErrEventMismatch
+		}
+		return callback.Edits(request, response)
+	case EventTypeCreateEmbeddings:
+		request, okReq := event.Request.(openai.EmbeddingRequest)
+		response, okResp := event.Response.(openai.EmbeddingResponse)
+		if !okReq || !okResp {
+			return ErrEventMismatch
+		}
+		return callback.CreateEmbeddings(request, response)
+	case EventTypeListFiles:
+		response, okResp := event.Response.(openai.FilesList)
+		if !okResp {
+			return ErrEventMismatch
+		}
+		return callback.ListFiles(response)
+	case EventTypeCreateFile:
+		request, okReq := event.Request.(openai.FileRequest)
+		response, okResp := event.Response.(openai.File)
+		if !okReq || !okResp {
+			return ErrEventMismatch
+		}
+		return callback.CreateFile(request, response)
+	case EventTypeDeleteFile:
+		request, okReq := event.Request.(IDRequest)
+		if !okReq {
+			return ErrEventMismatch
+		}
+		return callback.DeleteFile(request.ID)
+	case EventTypeGetFile:
+		request, okReq := event.Request.(IDRequest)
+		response, okResp := event.Response.(openai.File)
+		if !okReq || !okResp {
+			return ErrEventMismatch
+		}
+		return callback.GetFile(request.ID, response)
+	case EventTypeCreateFineTune:
+		request, okReq := event.Request.(openai.FineTuneRequest)
+		response, okResp := event.Response.(openai.FineTune)
+		if !okReq || !okResp {
+			return ErrEventMismatch
+		}
+		return callback.CreateFineTune(request, response)
+	case EventTypeListFineTunes:
+		response, okResp := event.Response.(openai.FineTuneList)
+		if !okResp {
+			return ErrEventMismatch
+		}
+		return callback.ListFineTunes(response)
+	case EventTypeGetFineTune:
+		request, okReq := event.Request.(IDRequest)
+		response, okResp := event.Response.(openai.FineTune)
+		if !okReq || !okResp {
+			return ErrEventMismatch
+		}
+		return callback.GetFineTune(request.ID, response)
+	case EventTypeCancelFineTune:
+		request, okReq := event.Request.(IDRequest)
+		response, okResp := event.Response.(openai.FineTune)
+		if !okReq || !okResp {
+			return ErrEventMismatch
+		}
+		return callback.CancelFineTune(request.ID, response)
+	case EventTypeListFineTuneEvents:
+		request, okReq := event.Request.(IDRequest)
+		response, okResp := event.Response.(openai.FineTuneEventList)
+		if !okReq || !okResp {
+			return ErrEventMismatch
+		}
+		return callback.ListFineTuneEvents(request.ID, response)
+	case EventTypeDeleteFineTune:
+		request, okReq := event.Request.(IDRequest)
+		if !okReq {
+			return ErrEventMismatch
+		}
+		return callback.DeleteFineTune(request.ID)
+	case EventTypeCreateImage:
+		request, okReq := event.Request.(openai.ImageRequest)
+		response, okResp := event.Response.(openai.ImageResponse)
+		if !okReq || !okResp {
+			return ErrEventMismatch
+		}
+		return callback.CreateImage(request, response)
+	case EventTypeCreateEditImage:
+		request, okReq := event.Request.(openai.ImageEditRequest)
+		response, okResp := event.Response.(openai.ImageResponse)
+		if !okReq || !okResp {
+			return ErrEventMismatch
+		}
+		return callback.CreateEditImage(request, response)
+	case EventTypeCreateVariImage:
+		request, okReq := event.Request.(openai.ImageVariRequest)
+		response, okResp := event.Response.(openai.ImageResponse)
+		if !okReq || !okResp {
+			return ErrEventMismatch
+		}
+		return callback.CreateVariImage(request, response)
+	case EventTypeListModels:
+		response, okResp := event.Response.(openai.ModelsList)
+		if !okResp {
+			return ErrEventMismatch
+		}
+		return callback.ListModels(response)
+	case EventTypeModerations:
+		request, okReq := event.Request.(openai.ModerationRequest)
+		response, okResp := event.Response.(openai.ModerationResponse)
+		if !okReq || !okResp {
+			return ErrEventMismatch
+		}
+		return callback.Moderations(request, response)
+	case EventTypeCircuitBreaker:
+		change, okReq := event.Request.(BreakerStateChange)
+		if !okReq {
+			return ErrEventMismatch
+		}
+		// only callbacks that ask for it are told
+		if breakerCallback, ok := callback.(ChatGPTBreakerCallback); ok {
+			return breakerCallback.CircuitBreaker(change)
+		}
+		return nil
+	case EventTypePromptBlocked:
+		// only callbacks that ask for it are told
+		if guardCallback, ok := callback.(ChatGPTGuardCallback); ok {
+			return guardCallback.PromptBlocked(event.Request, event.Detections)
+		}
+		return nil
+	}
+
+	return ErrUnknownEventType
+}
+
+// UnmarshalJSON restores the typed request and response for known event types
+// so a decoded event can be passed to Dispatch
+func (e *Event) UnmarshalJSON(data []byte) error {
+	type eventAlias Event
+	var raw struct {
+		eventAlias
+		Request  json.RawMessage `json:"request,omitempty"`
+		Response json.RawMessage `json:"response,omitempty"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	*e = Event(raw.eventAlias)
+	e.Request = nil
+	e.Response = nil
+
+	var request, response interface{}
+	switch e.Type {
+	case EventTypeCreateTranscription:
+		var req openai.AudioRequest
+		var resp openai.AudioResponse
+		if err := decodeIfSet(raw.Request, &req); err != nil {
+			return err
+		}
+		request = req
+		if err := decodeIfSet(raw.Response, &resp); err != nil {
+			return err
+		}
+		response = resp
+	case EventTypeCreateTranslation:
+		var req openai.AudioRequest
+		var resp openai.AudioResponse
+		if err := decodeIfSet(raw.Request, &req); err != nil {
+			return err
+		}
+		request = req
+		if err := decodeIfSet(raw.Response, &resp); err != nil {
+			return err
+		}
+		response = resp
+	case EventTypeCreateCompletion:
+		var req openai.CompletionRequest
+		var resp openai.CompletionResponse
+		if err := decodeIfSet(raw.Request, &req); err != nil {
+			return err
+		}
+		request = req
+		if err := decodeIfSet(raw.Response, &resp); err != nil {
+			return err
+		}
+		response = resp
+	case EventTypeCreateChatCompletion:
+		var req openai.ChatCompletionRequest
+		var resp openai.ChatCompletionResponse
+		if err := decodeIfSet(raw.Request, &req); err != nil {
+			return err
+		}
+		request = req
+		if err := decodeIfSet(raw.Response, &resp); err != nil {
+			return err
+		}
+		response = resp
+	case EventTypeEdits:
+		var req openai.EditsRequest
+		var resp openai.EditsResponse
+		if err := decodeIfSet(raw.Request, &req); err != nil {
+			return err
+		}
+		request = req
+		if err := decodeIfSet(raw.Response, &resp); err != nil {
+			return err
+		}
+		response = resp
+	case EventTypeCreateEmbeddings:
+		var req openai.EmbeddingRequest
+		var resp openai.EmbeddingResponse
+		if err := decodeIfSet(raw.Request, &req); err != nil {
+			return err
+		}
+		request = req
+		if err := decodeIfSet(raw.Response, &resp); err != nil {
+			return err
+		}
+		response = resp
+	case EventTypeListFiles:
+		var resp openai.FilesList
+		if err := decodeIfSet(raw.Response, &resp); err != nil {
+			return err
+		}
+		response = resp
+	case EventTypeCreateFile:
+		var req openai.FileRequest
+		var resp openai.File
+		if err := decodeIfSet(raw.Request, &req); err != nil {
+			return err
+		}
+		request = req
+		if err := decodeIfSet(raw.Response, &resp); err != nil {
+			return err
+		}
+		response = resp
+	case EventTypeDeleteFile:
+		var req IDRequest
+		if err := decodeIfSet(raw.Request, &req); err != nil {
+			return err
+		}
+		request = req
+	case EventTypeGetFile:
+		var req IDRequest
+		var resp openai.File
+		if err := decodeIfSet(raw.Request, &req); err != nil {
+			return err
+		}
+		request = req
+		if err := decodeIfSet(raw.Response, &resp); err != nil {
+			return err
+		}
+		response = resp
+	case EventTypeCreateFineTune:
+		var req openai.FineTuneRequest
+		var resp openai.FineTune
+		if err := decodeIfSet(raw.Request, &req); err != nil {
+			return err
+		}
+		request = req
+		if err := decodeIfSet(raw.Response, &resp); err != nil {
+			return err
+		}
+		response = resp
+	case EventTypeListFineTunes:
+		var resp openai.FineTuneList
+		if err := decodeIfSet(raw.Response, &resp); err != nil {
+			return err
+		}
+		response = resp
+	case EventTypeGetFineTune:
+		var req IDRequest
+		var resp openai.FineTune
+		if err := decodeIfSet(raw.Request, &req); err != nil {
+			return err
+		}
+		request = req
+		if err := decodeIfSet(raw.Response, &resp); err != nil {
+			return err
+		}
+		response = resp
+	case EventTypeCancelFineTune:
+		var req IDRequest
+		var resp openai.FineTune
+		if err := decodeIfSet(raw.Request, &req); err != nil {
+			return err
+		}
+		request = req
+		if err := decodeIfSet(raw.Response, &resp); err != nil {
+			return err
+		}
+		response = resp
+	case EventTypeListFineTuneEvents:
+		var req IDRequest
+		var resp openai.FineTuneEventList
+		if err := decodeIfSet(raw.Request, &req); err != nil {
+			return err
+		}
+		request = req
+		if err := decodeIfSet(raw.Response, &resp); err != nil {
+			return err
+		}
+		response = resp
+	case EventTypeDeleteFineTune:
+		var req IDRequest
+		if err := decodeIfSet(raw.Request, &req); err != nil {
+			return err
+		}
+		request = req
+	case EventTypeCreateImage:
+		var req openai.ImageRequest
+		var resp openai.ImageResponse
+		if err := decodeIfSet(raw.Request, &req); err != nil {
+			return err
+		}
+		request = req
+		if err := decodeIfSet(raw.Response, &resp); err != nil {
+			return err
+		}
+		response = resp
+	case EventTypeCreateEditImage:
+		var req openai.ImageEditRequest
+		var resp openai.ImageResponse
+		if err := decodeIfSet(raw.Request, &req); err != nil {
+			return err
+		}
+		request = req
+		if err := decodeIfSet(raw.Response, &resp); err != nil {
+			return err
+		}
+		response = resp
+	case EventTypeCreateVariImage:
+		var req openai.ImageVariRequest
+		var resp openai.ImageResponse
+		if err := decodeIfSet(raw.Request, &req); err != nil {
+			return err
+		}
+		request = req
+		if err := decodeIfSet(raw.Response, &resp); err != nil {
+			return err
+		}
+		response = resp
+	case EventTypeListModels:
+		var resp openai.ModelsList
+		if err := decodeIfSet(raw.Response, &resp); err != nil {
+			return err
+		}
+		response = resp
+	case EventTypeModerations:
+		var req openai.ModerationRequest
+		var resp openai.ModerationResponse
+		if err := decodeIfSet(raw.Request, &req); err != nil {
+			return err
+		}
+		request = req
+		if err := decodeIfSet(raw.Response, &resp); err != nil {
+			return err
+		}
+		response = resp
+	case EventTypeCircuitBreaker:
+		var req BreakerStateChange
+		if err := decodeIfSet(raw.Request, &req); err != nil {
+			return err
+		}
+		request = req
+	default:
+		// events without a typed method keep the raw JSON
+		if len(raw.Request) > 0 {
+			request = raw.Request
+		}
+		if len(raw.Response) > 0 {
+			response = raw.Response
+		}
+	}
+
+	e.Request = request
+	e.Response = response
+
+	return nil
+}
+
+func decodeIfSet(data json.RawMessage, v interface{}) error {
+	if len(data) == 0 || string(data) == "null" {
+		return nil
+	}
+	return json.Unmarshal(data, v)
+}
@@ -0,0 +1,196 @@
+// Copyright 2023 dvonthenen ChatGPT Proxy contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache License 2.0
+
+package interfaces
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// EventType is the name of the ChatGPTCallback method an event was raised for
+type EventType string
+
+const (
+	EventTypeCreateTranscription  EventType = "CreateTranscription"
+	EventTypeCreateTranslation    EventType = "CreateTranslation"
+	EventTypeCreateCompletion     EventType = "CreateCompletion"
+	EventTypeCreateChatCompletion EventType = "CreateChatCompletion"
+	EventTypeEdits                EventType = "Edits"
+	EventTypeCreateEmbeddings     EventType = "CreateEmbeddings"
+	EventTypeListFiles            EventType = "ListFiles"
+	EventTypeCreateFile           EventType = "CreateFile"
+	EventTypeDeleteFile           EventType = "DeleteFile"
+	EventTypeGetFile              EventType = "GetFile"
+	EventTypeCreateFineTune       EventType = "CreateFineTune"
+	EventTypeListFineTunes        EventType = "ListFineTunes"
+	EventTypeGetFineTune          EventType = "GetFineTune"
+	EventTypeCancelFineTune       EventType = "CancelFineTune"
+	EventTypeListFineTuneEvents   EventType = "ListFineTuneEvents"
+	EventTypeDeleteFineTune       EventType = "DeleteFineTune"
+	EventTypeCreateImage          EventType = "CreateImage"
+	EventTypeCreateEditImage      EventType = "CreateEditImage"
+	EventTypeCreateVariImage      EventType = "CreateVariImage"
+	EventTypeListModels           EventType = "ListModels"
+	EventTypeModerations          EventType = "Moderations"
+
+	// EventTypePromptBlocked is raised by the proxy itself when a prompt is
+	// blocked before it reaches OpenAI, the event carries the detections
+	EventTypePromptBlocked EventType = "PromptBlocked"
+
+	// EventTypeCircuitBreaker is raised by the proxy itself when a circuit
+	// breaker changes state, the request is a BreakerStateChange
+	EventTypeCircuitBreaker EventType = "CircuitBreaker"
+)
+
+// Event is the serializable form of a single proxied call
+type Event struct {
+	ID        string      `json:"id"`
+	Type      EventType   `json:"type"`
+	Timestamp time.Time   `json:"timestamp"`
+	Model     string      `json:"model,omitempty"`
+	Caller    string      `json:"caller,omitempty"`
+	Request   interface{} `json:"request,omitempty"`
+	Response  interface{} `json:"response,omitempty"`
+
+	// FunctionCalls are the functions the model asked the caller to run
+	FunctionCalls []openai.FunctionCall `json:"function_calls,omitempty"`
+
+	// Coalesced is true when the response was shared from an identical request
+	// already in flight, no upstream call was made for this one
+	Coalesced bool `json:"coalesced,omitempty"`
+
+	// Cached is true when the response came from the semantic cache, no
+	// upstream call was made for this one
+	Cached bool `json:"cached,omitempty"`
+
+	// UpstreamKey names the pooled API key the upstream call was made with
+	UpstreamKey string `json:"upstream_key,omitempty"`
+
+	// Identity is the authenticated caller, nil without authentication
+	Identity *Identity `json:"identity,omitempty"`
+
+	// Detections are the jailbreak and prompt injection findings on the prompt
+	Detections []Detection `json:"detections,omitempty"`
+
+	// OutputFindings are what the output filter redacted or blocked in the
+	// response, the response carries the filtered output
+	OutputFindings []Detection `json:"output_findings,omitempty"`
+
+	// OriginalRequest is the request as the client sent it when policies
+	// rewrote it, Request is what was sent upstream
+	OriginalRequest interface{} `json:"original_request,omitempty"`
+}
+
+// Detection is a finding of the prompt guard or the output filter
+type Detection struct {
+	// Kind is signature or heuristic for prompts, redaction, deny or
+	// moderation for output
+	Kind string `json:"kind"`
+
+	// Name of the signature, the heuristic rules, redaction or pattern that
+	// matched, or the flagged moderation categories
+	Name  string  `json:"name"`
+	Score float64 `json:"score"`
+}
+
+// Identity is a caller authenticated by a bearer JWT
+type Identity struct {
+	Subject   string    `json:"subject"`
+	Issuer    string    `json:"issuer,omitempty"`
+	Audience  []string  `json:"audience,omitempty"`
+	ExpiresAt time.Time `json:"expires_at"`
+
+	// Policies are the names of the policies the caller's claims matched
+	Policies []string `json:"policies,omitempty"`
+
+	Claims map[string]interface{} `json:"claims,omitempty"`
+}
+
+// BreakerStateChange describes a circuit breaker moving between the closed,
+// open and half_open states
+type BreakerStateChange struct {
+	Upstream string `json:"upstream"`
+	Route    string `json:"route"`
+	From     string `json:"from"`
+	To       string `json:"to"`
+	Reason   string `json:"reason"`
+
+	// the rolling window when the state changed
+	Requests  int     `json:"requests"`
+	ErrorRate float64 `json:"error_rate"`
+	SlowRate  float64 `json:"slow_rate"`
+
+	// RetryAfter is how long the breaker stays open
+	RetryAfter time.Duration `json:"retry_after,omitempty"`
+}
+
+// IDRequest is the request recorded for calls that only take an object ID
+type IDRequest struct {
+	ID string `json:"id"`
+}
+
+// NewEvent creates an event and fills in the model and caller from the request
+func NewEvent(eventType EventType, request, response interface{}) *Event {
+	event := &Event{
+		ID:        NewEventID(),
+		Type:      eventType,
+		Timestamp: time.Now().UTC(),
+		Request:   request,
+		Response:  response,
+	}
+
+	switch req := request.(type) {
+	case openai.CompletionRequest:
+		event.Model = req.Model
+		event.Caller = req.User
+	case openai.ChatCompletionRequest:
+		event.Model = req.Model
+		event.Caller = req.User
+	case openai.EditsRequest:
+		if req.Model != nil {
+			event.Model = *req.Model
+		}
+	case openai.EmbeddingRequest:
+		event.Model = req.Model.String()
+		event.Caller = req.User
+	case openai.AudioRequest:
+		event.Model = req.Model
+	case openai.ImageRequest:
+		event.Caller = req.User
+	case openai.FineTuneRequest:
+		event.Model = req.Model
+	case openai.ModerationRequest:
+		event.Model = req.Model
+	}
+
+	if resp, ok := response.(openai.ChatCompletionResponse); ok {
+		event.FunctionCalls = FunctionCalls(resp)
+	}
+
+	return event
+}
+
+// FunctionCalls returns the function calls made across all choices of a chat
+// completion
+func FunctionCalls(response openai.ChatCompletionResponse) []openai.FunctionCall {
+	var calls []openai.FunctionCall
+	for _, choice := range response.Choices {
+		if choice.Message.FunctionCall != nil {
+			calls = append(calls, *choice.Message.FunctionCall)
+		}
+	}
+	return calls
+}
+
+// NewEventID returns a random 128-bit hex ID
+func NewEventID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return time.Now().UTC().Format("20060102150405.000000000")
+	}
+	return hex.EncodeToString(b)
+}
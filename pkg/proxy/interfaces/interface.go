@@ -38,3 +38,28 @@ type ChatGPTCallback interface {
 
 	Moderations(openai.ModerationRequest, openai.ModerationResponse) error
 }
+
+// ChatGPTEventCallback is optionally implemented by callbacks that consume the
+// generic Event instead of (or in addition to) the typed methods. Dispatch
+// prefers OnEvent when it is available.
+type ChatGPTEventCallback interface {
+	OnEvent(event *Event) error
+}
+
+// ChatGPTBreakerCallback is optionally implemented by callbacks that want to
+// know when a circuit breaker opens or closes
+type ChatGPTBreakerCallback interface {
+	CircuitBreaker(change BreakerStateChange) error
+}
+
+// ChatGPTGuardCallback is optionally implemented by callbacks that want to
+// know when a prompt is blocked before it reaches OpenAI
+type ChatGPTGuardCallback interface {
+	PromptBlocked(request interface{}, detections []Detection) error
+}
+
+// ChatGPTCallbackStopper is optionally implemented by callbacks that buffer work
+// and need to flush it when the proxy is stopped
+type ChatGPTCallbackStopper interface {
+	Stop() error
+}
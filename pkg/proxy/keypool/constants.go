@@ -0,0 +1,36 @@
+// Copyright 2023 dvonthenen ChatGPT Proxy contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache License 2.0
+
+package keypool
+
+import (
+	"errors"
+	"time"
+)
+
+const (
+	// selection strategies
+	StrategyRoundRobin  string = "round_robin"
+	StrategyLeastLoaded string = "least_loaded"
+
+	// key states
+	StateHealthy string = "healthy"
+	StateEjected string = "ejected"
+
+	// DefaultRateLimitEjection is how long a key sits out after a 429
+	DefaultRateLimitEjection time.Duration = 30 * time.Second
+
+	// DefaultAuthEjection is how long a key sits out after a 401
+	DefaultAuthEjection time.Duration = 10 * time.Minute
+)
+
+var (
+	// ErrInvalidInput required input was not found
+	ErrInvalidInput = errors.New("required input was not found")
+
+	// ErrInvalidStrategy the selection strategy is unknown
+	ErrInvalidStrategy = errors.New("invalid key selection strategy")
+
+	// ErrNoKeys every key in the pool is ejected
+	ErrNoKeys = errors.New("no upstream key available")
+)
@@ -0,0 +1,217 @@
+// Copyright 2023 dvonthenen ChatGPT Proxy contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache License 2.0
+
+package keypool
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	openai "github.com/sashabaranov/go-openai"
+	klog "k8s.io/klog/v2"
+)
+
+// New creates a pool with a client per key
+func New(options PoolOptions) (*Pool, error) {
+	if len(options.Keys) == 0 {
+		klog.V(1).Infof("keypool has no keys\n")
+		return nil, ErrInvalidInput
+	}
+	switch options.Strategy {
+	case "":
+		options.Strategy = StrategyRoundRobin
+	case StrategyRoundRobin, StrategyLeastLoaded:
+	default:
+		klog.V(1).Infof("keypool strategy %s is invalid\n", options.Strategy)
+		return nil, ErrInvalidStrategy
+	}
+	if options.RateLimitEjection <= 0 {
+		options.RateLimitEjection = DefaultRateLimitEjection
+	}
+	if options.AuthEjection <= 0 {
+		options.AuthEjection = DefaultAuthEjection
+	}
+
+	members := make([]*Member, 0, len(options.Keys))
+	for i, key := range options.Keys {
+		if len(key.APIKey) == 0 {
+			klog.V(1).Infof("keypool key %d has no API key\n", i)
+			return nil, ErrInvalidInput
+		}
+		if len(key.Name) == 0 {
+			key.Name = fmt.Sprintf("key-%d", i+1)
+		}
+
+		config := openai.DefaultConfig(key.APIKey)
+		config.OrgID = key.OrgID
+		if len(options.BaseURL) > 0 {
+			config.BaseURL = options.BaseURL
+		}
+
+		members = append(members, &Member{
+			name:   key.Name,
+			orgID:  key.OrgID,
+			client: openai.NewClientWithConfig(config),
+		})
+	}
+
+	return &Pool{
+		options: &options,
+		members: members,
+	}, nil
+}
+
+// Pick selects the key for a call. Call Done on the member once the call
+// returns.
+func (p *Pool) Pick() (*Member, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+
+	var picked *Member
+	switch p.options.Strategy {
+	case StrategyLeastLoaded:
+		for i := 0; i < len(p.members); i++ {
+			// start after the last pick so ties rotate
+			m := p.members[(p.next+i)%len(p.members)]
+			if m.ejected(now) {
+				continue
+			}
+			if picked == nil || m.inFlight < picked.inFlight {
+				picked = m
+			}
+		}
+		p.next = (p.next + 1) % len(p.members)
+	default:
+		for i := 0; i < len(p.members); i++ {
+			m := p.members[p.next]
+			p.next = (p.next + 1) % len(p.members)
+			if !m.ejected(now) {
+				picked = m
+				break
+			}
+		}
+	}
+
+	if picked == nil {
+		return nil, &UnavailableError{RetryAfter: p.retryAfter(now)}
+	}
+
+	picked.inFlight++
+	picked.requests++
+
+	return picked, nil
+}
+
+// Done records the result of a call made with a member. A 429 or a 401 ejects
+// the key for a while.
+func (p *Pool) Done(m *Member, status int, failed bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	m.inFlight--
+	if status != 0 {
+		m.lastStatus = status
+	}
+	if failed {
+		m.failures++
+	}
+
+	switch status {
+	case http.StatusTooManyRequests:
+		m.rateLimited++
+		m.eject(p.options.RateLimitEjection)
+		klog.V(3).Infof("keypool ejected %s for %v (rate limited)\n", m.name, p.options.RateLimitEjection)
+	case http.StatusUnauthorized:
+		m.unauthorized++
+		m.eject(p.options.AuthEjection)
+		klog.V(3).Infof("keypool ejected %s for %v (unauthorized)\n", m.name, p.options.AuthEjection)
+	}
+}
+
+// Status returns a snapshot of every key
+func (p *Pool) Status() []KeyStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+
+	statuses := make([]KeyStatus, 0, len(p.members))
+	for _, m := range p.members {
+		status := KeyStatus{
+			Name:         m.name,
+			OrgID:        m.orgID,
+			State:        StateHealthy,
+			InFlight:     m.inFlight,
+			Requests:     m.requests,
+			Failures:     m.failures,
+			RateLimited:  m.rateLimited,
+			Unauthorized: m.unauthorized,
+			LastStatus:   m.lastStatus,
+		}
+		if m.ejected(now) {
+			until := m.ejectedUntil
+			status.State = StateEjected
+			status.EjectedUntil = &until
+		}
+		statuses = append(statuses, status)
+	}
+
+	return statuses
+}
+
+// retryAfter is how long until the first key comes back
+func (p *Pool) retryAfter(now time.Time) time.Duration {
+	var wait time.Duration
+	for _, m := range p.members {
+		left := m.ejectedUntil.Sub(now)
+		if wait == 0 || left < wait {
+			wait = left
+		}
+	}
+	return wait
+}
+
+// Name of the key
+func (m *Member) Name() string {
+	return m.name
+}
+
+// Client that calls the upstream with the key
+func (m *Member) Client() *openai.Client {
+	return m.client
+}
+
+func (m *Member) ejected(now time.Time) bool {
+	return now.Before(m.ejectedUntil)
+}
+
+func (m *Member) eject(d time.Duration) {
+	until := time.Now().Add(d)
+	if until.After(m.ejectedUntil) {
+		m.ejectedUntil = until
+	}
+}
+
+func (e *UnavailableError) Error() string {
+	return fmt.Sprintf("%v, retry after %v", ErrNoKeys, e.RetryAfter)
+}
+
+// Is lets errors.Is match ErrNoKeys
+func (e *UnavailableError) Is(target error) bool {
+	return target == ErrNoKeys
+}
+
+// WithMember attaches the member chosen for a call to its context
+func WithMember(ctx context.Context, m *Member) context.Context {
+	return context.WithValue(ctx, memberKey{}, m)
+}
+
+// MemberFrom returns the member attached to the context, nil if none
+func MemberFrom(ctx context.Context) *Member {
+	m, _ := ctx.Value(memberKey{}).(*Member)
+	return m
+}
@@ -0,0 +1,211 @@
+// Copyright 2023 dvonthenen ChatGPT Proxy contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache License 2.0
+
+package keypool
+
+import (
+	"errors"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+)
+
+const (
+	testRateLimitEjection time.Duration = time.Minute
+	testAuthEjection      time.Duration = 10 * time.Minute
+)
+
+func newTestPool(t *testing.T, strategy string) *Pool {
+	t.Helper()
+
+	pool, err := New(PoolOptions{
+		Keys: []Key{
+			{Name: "a", APIKey: "sk-a"},
+			{Name: "b", APIKey: "sk-b"},
+		},
+		Strategy:          strategy,
+		RateLimitEjection: testRateLimitEjection,
+		AuthEjection:      testAuthEjection,
+	})
+	if err != nil {
+		t.Fatalf("New failed. Err: %v", err)
+	}
+	return pool
+}
+
+func pick(t *testing.T, pool *Pool) *Member {
+	t.Helper()
+
+	m, err := pool.Pick()
+	if err != nil {
+		t.Fatalf("Pick failed. Err: %v", err)
+	}
+	return m
+}
+
+func TestEjection(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   int
+		failed   bool
+		picks    []string
+		ejected  time.Duration
+		counters KeyStatus
+	}{
+		{
+			name:     "ok",
+			status:   http.StatusOK,
+			picks:    []string{"b", "a", "b"},
+			counters: KeyStatus{Requests: 2, LastStatus: http.StatusOK},
+		},
+		{
+			name:     "server error",
+			status:   http.StatusInternalServerError,
+			failed:   true,
+			picks:    []string{"b", "a", "b"},
+			counters: KeyStatus{Requests: 2, Failures: 1, LastStatus: http.StatusOK},
+		},
+		{
+			name:     "rate limited",
+			status:   http.StatusTooManyRequests,
+			failed:   true,
+			picks:    []string{"b", "b", "b"},
+			ejected:  testRateLimitEjection,
+			counters: KeyStatus{Requests: 1, Failures: 1, RateLimited: 1, LastStatus: http.StatusTooManyRequests},
+		},
+		{
+			name:     "unauthorized",
+			status:   http.StatusUnauthorized,
+			failed:   true,
+			picks:    []string{"b", "b", "b"},
+			ejected:  testAuthEjection,
+			counters: KeyStatus{Requests: 1, Failures: 1, Unauthorized: 1, LastStatus: http.StatusUnauthorized},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pool := newTestPool(t, StrategyRoundRobin)
+
+			before := time.Now()
+			first := pick(t, pool)
+			if first.Name() != "a" {
+				t.Fatalf("expected a, got %s", first.Name())
+			}
+			pool.Done(first, tt.status, tt.failed)
+
+			picks := make([]string, 0, len(tt.picks))
+			for range tt.picks {
+				m := pick(t, pool)
+				picks = append(picks, m.Name())
+				pool.Done(m, http.StatusOK, false)
+			}
+			if !reflect.DeepEqual(picks, tt.picks) {
+				t.Errorf("expected picks %v, got %v", tt.picks, picks)
+			}
+
+			status := pool.Status()[0]
+			if tt.ejected == 0 {
+				if status.State != StateHealthy || status.EjectedUntil != nil {
+					t.Errorf("expected a healthy key, got %+v", status)
+				}
+			} else {
+				if status.State != StateEjected || status.EjectedUntil == nil {
+					t.Fatalf("expected an ejected key, got %+v", status)
+				}
+				if until := status.EjectedUntil.Sub(before); until < tt.ejected || until > tt.ejected+time.Second {
+					t.Errorf("expected ejection for %v, got %v", tt.ejected, until)
+				}
+			}
+
+			counters := KeyStatus{
+				Requests:     status.Requests,
+				Failures:     status.Failures,
+				RateLimited:  status.RateLimited,
+				Unauthorized: status.Unauthorized,
+				LastStatus:   status.LastStatus,
+			}
+			if counters != tt.counters {
+				t.Errorf("expected %+v, got %+v", tt.counters, counters)
+			}
+		})
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses []int
+		min      time.Duration
+		max      time.Duration
+	}{
+		{
+			name:     "rate limited key returns first",
+			statuses: []int{http.StatusUnauthorized, http.StatusTooManyRequests},
+			min:      testRateLimitEjection - time.Second,
+			max:      testRateLimitEjection,
+		},
+		{
+			name:     "every key unauthorized",
+			statuses: []int{http.StatusUnauthorized, http.StatusUnauthorized},
+			min:      testAuthEjection - time.Second,
+			max:      testAuthEjection,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pool := newTestPool(t, StrategyRoundRobin)
+
+			for _, status := range tt.statuses {
+				pool.Done(pick(t, pool), status, true)
+			}
+
+			_, err := pool.Pick()
+			var unavailable *UnavailableError
+			if !errors.Is(err, ErrNoKeys) || !errors.As(err, &unavailable) {
+				t.Fatalf("expected an *UnavailableError, got %v", err)
+			}
+			if unavailable.RetryAfter < tt.min || unavailable.RetryAfter > tt.max {
+				t.Errorf("expected retry after between %v and %v, got %v", tt.min, tt.max, unavailable.RetryAfter)
+			}
+		})
+	}
+}
+
+func TestEjectionExpires(t *testing.T) {
+	pool, err := New(PoolOptions{
+		Keys:              []Key{{Name: "a", APIKey: "sk-a"}},
+		RateLimitEjection: 20 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("New failed. Err: %v", err)
+	}
+
+	pool.Done(pick(t, pool), http.StatusTooManyRequests, true)
+	if _, err := pool.Pick(); !errors.Is(err, ErrNoKeys) {
+		t.Fatalf("expected %v, got %v", ErrNoKeys, err)
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	if m := pick(t, pool); m.Name() != "a" {
+		t.Errorf("expected a, got %s", m.Name())
+	}
+}
+
+func TestLeastLoaded(t *testing.T) {
+	pool := newTestPool(t, StrategyLeastLoaded)
+
+	a := pick(t, pool)
+	b := pick(t, pool)
+	if a.Name() != "a" || b.Name() != "b" {
+		t.Fatalf("expected a and b, got %s and %s", a.Name(), b.Name())
+	}
+
+	// b finishes first so it has the fewest calls in flight
+	pool.Done(b, http.StatusOK, false)
+	if m := pick(t, pool); m.Name() != "b" {
+		t.Errorf("expected b, got %s", m.Name())
+	}
+}
@@ -0,0 +1,82 @@
+// Copyright 2023 dvonthenen ChatGPT Proxy contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache License 2.0
+
+package keypool
+
+import (
+	"sync"
+	"time"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// Key is one upstream API key, optionally tied to an organization
+type Key struct {
+	// Name identifies the key in status output and callback events. The API key
+	// itself is never exposed. Defaults to key-<n>.
+	Name   string
+	APIKey string
+	OrgID  string
+}
+
+// PoolOptions configures a pool of keys for one upstream
+type PoolOptions struct {
+	Keys []Key
+
+	// Strategy is StrategyRoundRobin (default) or StrategyLeastLoaded
+	Strategy string
+
+	// BaseURL of the upstream, OpenAI when empty
+	BaseURL string
+
+	// how long a key is ejected after a 429 or a 401
+	RateLimitEjection time.Duration
+	AuthEjection      time.Duration
+}
+
+// KeyStatus is a snapshot of one key
+type KeyStatus struct {
+	Name         string     `json:"name"`
+	OrgID        string     `json:"org_id,omitempty"`
+	State        string     `json:"state"`
+	InFlight     int        `json:"in_flight"`
+	Requests     int64      `json:"requests"`
+	Failures     int64      `json:"failures"`
+	RateLimited  int64      `json:"rate_limited"`
+	Unauthorized int64      `json:"unauthorized"`
+	EjectedUntil *time.Time `json:"ejected_until,omitempty"`
+	LastStatus   int        `json:"last_status,omitempty"`
+}
+
+// UnavailableError is returned while every key is ejected
+type UnavailableError struct {
+	RetryAfter time.Duration
+}
+
+// Pool spreads calls over a set of keys
+type Pool struct {
+	options *PoolOptions
+	members []*Member
+	next    int
+
+	// housekeeping
+	mu sync.Mutex
+}
+
+// Member is one key of a pool
+type Member struct {
+	name   string
+	orgID  string
+	client *openai.Client
+
+	// state, guarded by the pool's lock
+	inFlight     int
+	ejectedUntil time.Time
+	requests     int64
+	failures     int64
+	rateLimited  int64
+	unauthorized int64
+	lastStatus   int
+}
+
+type memberKey struct{}
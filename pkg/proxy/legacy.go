@@ -0,0 +1,64 @@
+// Copyright 2023 dvonthenen ChatGPT Proxy contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache License 2.0
+
+package proxy
+
+import (
+	"context"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// legacyCompletion returns the chat requests a completion is translated to,
+// nil when it goes to the completions API
+func (p *ChatGPTProxy) legacyCompletion(request openai.CompletionRequest) ([]openai.ChatCompletionRequest, error) {
+	if p.legacy == nil || !p.legacy.Translates(request.Model) {
+		return nil, nil
+	}
+	return p.legacy.Completion(request)
+}
+
+// createCompletion calls the completions API, or the chat API for a translated
+// completion
+func (p *ChatGPTProxy) createCompletion(ctx context.Context, request openai.CompletionRequest, chatRequests []openai.ChatCompletionRequest) (openai.CompletionResponse, error) {
+	if len(chatRequests) == 0 {
+		return p.client(ctx).CreateCompletion(ctx, request)
+	}
+	return p.legacy.CreateCompletion(ctx, p.client(ctx), request, chatRequests)
+}
+
+// createCompletionStream streams from the completions API, or from the chat API
+// for a translated completion
+func (p *ChatGPTProxy) createCompletionStream(ctx context.Context, client *openai.Client, request openai.CompletionRequest, chatRequests []openai.ChatCompletionRequest) (completionStream, error) {
+	if len(chatRequests) == 0 {
+		return client.CreateCompletionStream(ctx, request)
+	}
+
+	stream, err := p.legacy.CreateCompletionStream(ctx, client, request, chatRequests)
+	if err != nil {
+		return nil, err
+	}
+	return stream, nil
+}
+
+// legacyEdits returns the chat request an edit is translated to, nil when it
+// goes to the edits API
+func (p *ChatGPTProxy) legacyEdits(request openai.EditsRequest) (*openai.ChatCompletionRequest, error) {
+	if p.legacy == nil || !p.legacy.Translates(editsModel(request)) {
+		return nil, nil
+	}
+
+	chatRequest, err := p.legacy.Edits(request)
+	if err != nil {
+		return nil, err
+	}
+	return &chatRequest, nil
+}
+
+// createEdits calls the edits API, or the chat API for a translated edit
+func (p *ChatGPTProxy) createEdits(ctx context.Context, request openai.EditsRequest, chatRequest *openai.ChatCompletionRequest) (openai.EditsResponse, error) {
+	if chatRequest == nil {
+		return p.client(ctx).Edits(ctx, request)
+	}
+	return p.legacy.CreateEdits(ctx, p.client(ctx), *chatRequest)
+}
@@ -0,0 +1,55 @@
+// Copyright 2023 dvonthenen ChatGPT Proxy contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache License 2.0
+
+package legacy
+
+import (
+	"errors"
+)
+
+const (
+	// DefaultMaxTokens is what the completions API generates when max_tokens is
+	// not set, translated requests keep that limit
+	DefaultMaxTokens int = 16
+
+	// object types of the responses the old APIs return
+	completionObject string = "text_completion"
+	editObject       string = "edit"
+
+	// wildcard suffix in Models
+	modelWildcard string = "*"
+
+	// system prompts of the translated requests
+	completePrompt string = "Continue the text the user sends. Reply with the continuation only, without repeating the text."
+	insertPrompt   string = "The user sends a text and a suffix. Write what goes between them. Reply with that part only, without repeating the text or the suffix."
+	editPrompt     string = "The user sends an instruction and a text. Apply the instruction to the text. Reply with the edited text only."
+)
+
+// DefaultModels are translated when LegacyOptions.Models is empty, the
+// completion and edit models OpenAI retired
+var DefaultModels = []string{
+	"text-davinci-003",
+	"text-davinci-002",
+	"text-davinci-001",
+	"text-curie-001",
+	"text-babbage-001",
+	"text-ada-001",
+	"davinci",
+	"curie",
+	"babbage",
+	"ada",
+	"code-davinci-002",
+	"code-davinci-001",
+	"code-cushman-002",
+	"code-cushman-001",
+	"text-davinci-edit-001",
+	"code-davinci-edit-001",
+}
+
+var (
+	// ErrInvalidInput required input was not found
+	ErrInvalidInput = errors.New("required input was not found")
+
+	// ErrUnsupportedPrompt only text prompts can be translated, not tokens
+	ErrUnsupportedPrompt = errors.New("only text prompts can be translated to chat")
+)
@@ -0,0 +1,220 @@
+// Copyright 2023 dvonthenen ChatGPT Proxy contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache License 2.0
+
+package legacy
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	openai "github.com/sashabaranov/go-openai"
+	klog "k8s.io/klog/v2"
+)
+
+// New creates a translator to the chat model in the options
+func New(options LegacyOptions) (*Translator, error) {
+	if len(options.ChatModel) == 0 {
+		klog.V(1).Infof("ChatModel is required\n")
+		return nil, ErrInvalidInput
+	}
+
+	if len(options.Models) == 0 {
+		options.Models = DefaultModels
+	}
+
+	return &Translator{
+		options: &options,
+	}, nil
+}
+
+// Translates returns true when requests for the model go to the chat model
+func (t *Translator) Translates(model string) bool {
+	for _, translated := range t.options.Models {
+		if translated == model {
+			return true
+		}
+		if strings.HasSuffix(translated, modelWildcard) && strings.HasPrefix(model, strings.TrimSuffix(translated, modelWildcard)) {
+			return true
+		}
+	}
+	return false
+}
+
+// ChatModel returns the model that answers the translated requests
+func (t *Translator) ChatModel() string {
+	return t.options.ChatModel
+}
+
+// Completion returns the chat requests of a completion, one per prompt. A
+// suffix asks for the text between the prompt and the suffix. best_of and
+// logprobs have no chat counterpart and are ignored.
+func (t *Translator) Completion(request openai.CompletionRequest) ([]openai.ChatCompletionRequest, error) {
+	prompts, err := Prompts(request)
+	if err != nil {
+		return nil, err
+	}
+	if request.BestOf > 1 || request.LogProbs > 0 {
+		klog.V(3).Infof("best_of and logprobs are not translated\n")
+	}
+
+	maxTokens := request.MaxTokens
+	if maxTokens == 0 {
+		maxTokens = DefaultMaxTokens
+	}
+
+	chatRequests := make([]openai.ChatCompletionRequest, 0, len(prompts))
+	for _, prompt := range prompts {
+		messages := []openai.ChatCompletionMessage{
+			{Role: openai.ChatMessageRoleSystem, Content: completePrompt},
+			{Role: openai.ChatMessageRoleUser, Content: prompt},
+		}
+		if len(request.Suffix) > 0 {
+			messages = []openai.ChatCompletionMessage{
+				{Role: openai.ChatMessageRoleSystem, Content: insertPrompt},
+				{Role: openai.ChatMessageRoleUser, Content: fmt.Sprintf("Text:\n%s\n\nSuffix:\n%s", prompt, request.Suffix)},
+			}
+		}
+
+		chatRequests = append(chatRequests, openai.ChatCompletionRequest{
+			Model:            t.options.ChatModel,
+			Messages:         messages,
+			MaxTokens:        maxTokens,
+			Temperature:      request.Temperature,
+			TopP:             request.TopP,
+			N:                request.N,
+			Stream:           request.Stream,
+			Stop:             request.Stop,
+			PresencePenalty:  request.PresencePenalty,
+			FrequencyPenalty: request.FrequencyPenalty,
+			LogitBias:        request.LogitBias,
+			User:             request.User,
+		})
+	}
+
+	return chatRequests, nil
+}
+
+// CreateCompletion answers a completion with its chat requests, see Completion
+func (t *Translator) CreateCompletion(ctx context.Context, client *openai.Client, request openai.CompletionRequest, chatRequests []openai.ChatCompletionRequest) (openai.CompletionResponse, error) {
+	prompts, err := Prompts(request)
+	if err != nil {
+		return openai.CompletionResponse{}, err
+	}
+	if len(prompts) != len(chatRequests) {
+		return openai.CompletionResponse{}, ErrInvalidInput
+	}
+
+	resp := openai.CompletionResponse{
+		Object:  completionObject,
+		Choices: make([]openai.CompletionChoice, 0),
+	}
+	for i, chatRequest := range chatRequests {
+		chatResp, err := client.CreateChatCompletion(ctx, chatRequest)
+		if err != nil {
+			return openai.CompletionResponse{}, err
+		}
+
+		if i == 0 {
+			resp.ID, resp.Created, resp.Model = chatResp.ID, chatResp.Created, chatResp.Model
+		}
+		resp.Usage.PromptTokens += chatResp.Usage.PromptTokens
+		resp.Usage.CompletionTokens += chatResp.Usage.CompletionTokens
+		resp.Usage.TotalTokens += chatResp.Usage.TotalTokens
+
+		for _, choice := range chatResp.Choices {
+			text := choice.Message.Content
+			if request.Echo {
+				text = prompts[i] + text
+			}
+			resp.Choices = append(resp.Choices, openai.CompletionChoice{
+				Text:         text,
+				Index:        choiceIndex(request, i, choice.Index),
+				FinishReason: string(choice.FinishReason),
+			})
+		}
+	}
+
+	return resp, nil
+}
+
+// Edits returns the chat request of an edit
+func (t *Translator) Edits(request openai.EditsRequest) (openai.ChatCompletionRequest, error) {
+	if len(request.Instruction) == 0 {
+		return openai.ChatCompletionRequest{}, ErrInvalidInput
+	}
+
+	return openai.ChatCompletionRequest{
+		Model: t.options.ChatModel,
+		Messages: []openai.ChatCompletionMessage{
+			{Role: openai.ChatMessageRoleSystem, Content: editPrompt},
+			{Role: openai.ChatMessageRoleUser, Content: fmt.Sprintf("Instruction:\n%s\n\nText:\n%s", request.Instruction, request.Input)},
+		},
+		Temperature: request.Temperature,
+		TopP:        request.TopP,
+		N:           request.N,
+	}, nil
+}
+
+// CreateEdits answers an edit with its chat request, see Edits
+func (t *Translator) CreateEdits(ctx context.Context, client *openai.Client, chatRequest openai.ChatCompletionRequest) (openai.EditsResponse, error) {
+	chatResp, err := client.CreateChatCompletion(ctx, chatRequest)
+	if err != nil {
+		return openai.EditsResponse{}, err
+	}
+
+	resp := openai.EditsResponse{
+		Object:  editObject,
+		Created: chatResp.Created,
+		Usage:   chatResp.Usage,
+		Choices: make([]openai.EditsChoice, 0, len(chatResp.Choices)),
+	}
+	for _, choice := range chatResp.Choices {
+		resp.Choices = append(resp.Choices, openai.EditsChoice{
+			Text:  choice.Message.Content,
+			Index: choice.Index,
+		})
+	}
+
+	return resp, nil
+}
+
+// Prompts returns the text prompts of a completion. Without a prompt the model
+// starts from nothing, like the completions API does.
+func Prompts(request openai.CompletionRequest) ([]string, error) {
+	switch prompt := request.Prompt.(type) {
+	case nil:
+		return []string{""}, nil
+	case string:
+		return []string{prompt}, nil
+	case []string:
+		if len(prompt) == 0 {
+			return nil, ErrInvalidInput
+		}
+		return prompt, nil
+	case []interface{}:
+		if len(prompt) == 0 {
+			return nil, ErrInvalidInput
+		}
+		prompts := make([]string, 0, len(prompt))
+		for _, item := range prompt {
+			s, ok := item.(string)
+			if !ok {
+				return nil, ErrUnsupportedPrompt
+			}
+			prompts = append(prompts, s)
+		}
+		return prompts, nil
+	}
+	return nil, ErrUnsupportedPrompt
+}
+
+// choiceIndex numbers the choices of all prompts in a row, n per prompt like
+// the completions API
+func choiceIndex(request openai.CompletionRequest, prompt, index int) int {
+	n := request.N
+	if n < 1 {
+		n = 1
+	}
+	return prompt*n + index
+}
@@ -0,0 +1,119 @@
+// Copyright 2023 dvonthenen ChatGPT Proxy contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache License 2.0
+
+package legacy
+
+import (
+	"context"
+	"errors"
+	"io"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// CreateCompletionStream streams a completion with its chat requests, see
+// Completion. The first prompt's stream is open when it returns.
+func (t *Translator) CreateCompletionStream(ctx context.Context, client *openai.Client, request openai.CompletionRequest, chatRequests []openai.ChatCompletionRequest) (*CompletionStream, error) {
+	prompts, err := Prompts(request)
+	if err != nil {
+		return nil, err
+	}
+	if len(chatRequests) == 0 || len(prompts) != len(chatRequests) {
+		return nil, ErrInvalidInput
+	}
+
+	s := &CompletionStream{
+		ctx:          ctx,
+		client:       client,
+		request:      request,
+		chatRequests: chatRequests,
+	}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Recv returns the next completion chunk, io.EOF once every prompt is done
+func (s *CompletionStream) Recv() (openai.CompletionResponse, error) {
+	for {
+		if len(s.pending) > 0 {
+			chunk := s.pending[0]
+			s.pending = s.pending[1:]
+			return chunk, nil
+		}
+
+		chatChunk, err := s.stream.Recv()
+		if errors.Is(err, io.EOF) {
+			s.stream.Close()
+			if s.current+1 >= len(s.chatRequests) {
+				return openai.CompletionResponse{}, io.EOF
+			}
+			s.current++
+			if err := s.open(); err != nil {
+				return openai.CompletionResponse{}, err
+			}
+			continue
+		}
+		if err != nil {
+			return openai.CompletionResponse{}, err
+		}
+
+		// the prompt goes in front of each choice once the stream has an id
+		if s.request.Echo && !s.echoed {
+			s.echoed = true
+			prompts, _ := Prompts(s.request)
+			n := s.request.N
+			if n < 1 {
+				n = 1
+			}
+			for index := 0; index < n; index++ {
+				s.pending = append(s.pending, s.chunk(chatChunk, openai.CompletionChoice{
+					Text:  prompts[s.current],
+					Index: choiceIndex(s.request, s.current, index),
+				}))
+			}
+		}
+
+		chunk := s.chunk(chatChunk)
+		for _, choice := range chatChunk.Choices {
+			chunk.Choices = append(chunk.Choices, openai.CompletionChoice{
+				Text:         choice.Delta.Content,
+				Index:        choiceIndex(s.request, s.current, choice.Index),
+				FinishReason: string(choice.FinishReason),
+			})
+		}
+		s.pending = append(s.pending, chunk)
+	}
+}
+
+// Close ends the stream of the current prompt, the later prompts are not sent
+func (s *CompletionStream) Close() {
+	if s.stream != nil {
+		s.stream.Close()
+	}
+}
+
+func (s *CompletionStream) open() error {
+	chatRequest := s.chatRequests[s.current]
+	chatRequest.Stream = true
+
+	stream, err := s.client.CreateChatCompletionStream(s.ctx, chatRequest)
+	if err != nil {
+		return err
+	}
+	s.stream = stream
+	s.echoed = false
+	return nil
+}
+
+// chunk is a completion chunk with the id of the chat chunk
+func (s *CompletionStream) chunk(chatChunk openai.ChatCompletionStreamResponse, choices ...openai.CompletionChoice) openai.CompletionResponse {
+	return openai.CompletionResponse{
+		ID:      chatChunk.ID,
+		Object:  completionObject,
+		Created: chatChunk.Created,
+		Model:   chatChunk.Model,
+		Choices: choices,
+	}
+}
@@ -0,0 +1,44 @@
+// Copyright 2023 dvonthenen ChatGPT Proxy contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache License 2.0
+
+package legacy
+
+import (
+	"context"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// LegacyOptions configures the translation of completions and edits to chat
+// completions
+type LegacyOptions struct {
+	// ChatModel answers the translated requests
+	ChatModel string
+
+	// Models are the completion and edit models that are translated,
+	// DefaultModels when empty. A trailing * matches a prefix.
+	Models []string
+}
+
+// Translator turns completions and edits into chat completions and the chat
+// responses back into the responses the old APIs return
+type Translator struct {
+	options *LegacyOptions
+}
+
+// CompletionStream streams a translated completion as completion chunks. Each
+// prompt of the request is streamed in turn.
+type CompletionStream struct {
+	ctx          context.Context
+	client       *openai.Client
+	request      openai.CompletionRequest
+	chatRequests []openai.ChatCompletionRequest
+
+	// the prompt being streamed
+	current int
+	stream  *openai.ChatCompletionStream
+	echoed  bool
+
+	// chunks to return before reading the stream again
+	pending []openai.CompletionResponse
+}
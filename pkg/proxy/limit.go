@@ -0,0 +1,49 @@
+// Copyright 2023 dvonthenen ChatGPT Proxy contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache License 2.0
+
+package proxy
+
+import (
+	"context"
+
+	"github.com/gin-gonic/gin"
+
+	queue "github.com/dvonthenen/chat-gpeasy/pkg/proxy/queue"
+)
+
+// requestContext is the context upstream calls of a request run with. It
+// collects the details of the request for its callback event and carries the
+// caller's queue ticket so a request waiting for a slot is dropped when the
+// client disconnects. Calls already in flight are not cancelled.
+func (p *ChatGPTProxy) requestContext(c *gin.Context) context.Context {
+	ctx := withRequestInfo(context.Background(), identity(c), callerUser(c), c.FullPath())
+	if p.limiter == nil {
+		return ctx
+	}
+	return queue.WithTicket(ctx, p.limiter.Ticket(callerKey(c), c.Request.Context().Done()))
+}
+
+// callerKey identifies the client for queue priorities: the subject of its
+// token when authenticated, otherwise the bearer token it sent or its IP address
+func callerKey(c *gin.Context) string {
+	if caller := identity(c); caller != nil {
+		return caller.Subject
+	}
+	if token := bearerToken(c); len(token) > 0 {
+		return token
+	}
+	return c.ClientIP()
+}
+
+// acquire waits for an upstream slot when concurrency limits are enabled
+func (p *ChatGPTProxy) acquire(ctx context.Context, name string) (func(), error) {
+	if p.limiter == nil {
+		return func() {}, nil
+	}
+
+	ticket, ok := queue.TicketFrom(ctx)
+	if !ok {
+		ticket = p.limiter.Ticket("", nil)
+	}
+	return p.limiter.Get(name).Acquire(ctx, ticket)
+}
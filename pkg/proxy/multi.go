@@ -0,0 +1,279 @@
+// Copyright 2023 dvonthenen ChatGPT Proxy contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache License 2.0
+
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	openai "github.com/sashabaranov/go-openai"
+	klog "k8s.io/klog/v2"
+
+	interfaces "github.com/dvonthenen/chat-gpeasy/pkg/proxy/interfaces"
+)
+
+// NewMultiCallback creates an empty composite callback. Use Add to register members.
+func NewMultiCallback(options MultiCallbackOptions) *MultiCallback {
+	return &MultiCallback{
+		options: &options,
+		members: make([]*multiMember, 0),
+	}
+}
+
+// Add registers a member. The member only receives events matching all of
+// the filters, no filters means every event.
+func (mc *MultiCallback) Add(name string, callback *interfaces.ChatGPTCallback, filters ...EventFilter) error {
+	if callback == nil || len(name) == 0 {
+		klog.V(1).Infof("name or callback is empty\n")
+		return ErrInvalidInput
+	}
+
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	for _, member := range mc.members {
+		if member.name == name {
+			klog.V(1).Infof("callback %s already registered\n", name)
+			return ErrCallbackExists
+		}
+	}
+
+	mc.members = append(mc.members, &multiMember{
+		name:     name,
+		callback: *callback,
+		filters:  filters,
+	})
+
+	return nil
+}
+
+// Remove unregisters a member
+func (mc *MultiCallback) Remove(name string) error {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	for pos, member := range mc.members {
+		if member.name == name {
+			mc.members = append(mc.members[:pos], mc.members[pos+1:]...)
+			return nil
+		}
+	}
+
+	return ErrCallbackNotFound
+}
+
+// OnEvent implements interfaces.ChatGPTEventCallback. Every matching member is
+// called even when another member fails or panics. Failures are passed to the
+// ErrorHandler and returned together as a *MultiError.
+func (mc *MultiCallback) OnEvent(event *interfaces.Event) error {
+	mc.mu.RLock()
+	members := make([]*multiMember, len(mc.members))
+	copy(members, mc.members)
+	mc.mu.RUnlock()
+
+	var multiErr *MultiError
+	for _, member := range members {
+		if !member.matches(event) {
+			continue
+		}
+
+		err := member.dispatch(event)
+		if err == nil {
+			continue
+		}
+
+		klog.V(1).Infof("[CALLBACK] %s %s failed. Err: %v\n", member.name, event.Type, err)
+		if mc.options.ErrorHandler != nil {
+			mc.options.ErrorHandler(member.name, event, err)
+		}
+
+		if multiErr == nil {
+			multiErr = &MultiError{}
+		}
+		multiErr.Errors = append(multiErr.Errors, MemberError{
+			Name:      member.name,
+			EventType: event.Type,
+			Err:       err,
+		})
+	}
+
+	if multiErr == nil {
+		return nil
+	}
+	return multiErr
+}
+
+// Stop stops every member that buffers work
+func (mc *MultiCallback) Stop() error {
+	mc.mu.RLock()
+	defer mc.mu.RUnlock()
+
+	var multiErr *MultiError
+	for _, member := range mc.members {
+		stopper, ok := member.callback.(interfaces.ChatGPTCallbackStopper)
+		if !ok {
+			continue
+		}
+		if err := stopper.Stop(); err != nil {
+			if multiErr == nil {
+				multiErr = &MultiError{}
+			}
+			multiErr.Errors = append(multiErr.Errors, MemberError{Name: member.name, Err: err})
+		}
+	}
+
+	if multiErr == nil {
+		return nil
+	}
+	return multiErr
+}
+
+func (m *multiMember) matches(event *interfaces.Event) bool {
+	for _, filter := range m.filters {
+		if !filter(event) {
+			return false
+		}
+	}
+	return true
+}
+
+// dispatch isolates the other members from a panicking member
+func (m *multiMember) dispatch(event *interfaces.Event) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%w: %v", ErrCallbackPanic, r)
+		}
+	}()
+
+	return interfaces.Dispatch(m.callback, event)
+}
+
+func (e *MultiError) Error() string {
+	messages := make([]string, 0, len(e.Errors))
+	for _, memberErr := range e.Errors {
+		messages = append(messages, memberErr.Error())
+	}
+	return strings.Join(messages, "; ")
+}
+
+// Is reports whether any member error matches target, so errors.Is looks
+// through a MultiError
+func (e *MultiError) Is(target error) bool {
+	for _, memberErr := range e.Errors {
+		if errors.Is(memberErr.Err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// As finds the first member error that matches target, so errors.As looks
+// through a MultiError
+func (e *MultiError) As(target interface{}) bool {
+	for _, memberErr := range e.Errors {
+		if errors.As(memberErr.Err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+func (e MemberError) Error() string {
+	if len(e.EventType) == 0 {
+		return fmt.Sprintf("%s: %v", e.Name, e.Err)
+	}
+	return fmt.Sprintf("%s (%s): %v", e.Name, e.EventType, e.Err)
+}
+
+// Unwrap returns the member error
+func (e MemberError) Unwrap() error {
+	return e.Err
+}
+
+func (mc *MultiCallback) CreateTranscription(request openai.AudioRequest, response openai.AudioResponse) error {
+	return mc.OnEvent(interfaces.NewEvent(interfaces.EventTypeCreateTranscription, request, response))
+}
+
+func (mc *MultiCallback) CreateTranslation(request openai.AudioRequest, response openai.AudioResponse) error {
+	return mc.OnEvent(interfaces.NewEvent(interfaces.EventTypeCreateTranslation, request, response))
+}
+
+func (mc *MultiCallback) CreateCompletion(request openai.CompletionRequest, response openai.CompletionResponse) error {
+	return mc.OnEvent(interfaces.NewEvent(interfaces.EventTypeCreateCompletion, request, response))
+}
+
+func (mc *MultiCallback) CreateChatCompletion(request openai.ChatCompletionRequest, response openai.ChatCompletionResponse) error {
+	return mc.OnEvent(interfaces.NewEvent(interfaces.EventTypeCreateChatCompletion, request, response))
+}
+
+func (mc *MultiCallback) Edits(request openai.EditsRequest, response openai.EditsResponse) error {
+	return mc.OnEvent(interfaces.NewEvent(interfaces.EventTypeEdits, request, response))
+}
+
+func (mc *MultiCallback) CreateEmbeddings(request openai.EmbeddingRequest, response openai.EmbeddingResponse) error {
+	return mc.OnEvent(interfaces.NewEvent(interfaces.EventTypeCreateEmbeddings, request, response))
+}
+
+func (mc *MultiCallback) ListFiles(list openai.FilesList) error {
+	return mc.OnEvent(interfaces.NewEvent(interfaces.EventTypeListFiles, nil, list))
+}
+
+func (mc *MultiCallback) CreateFile(request openai.FileRequest, response openai.File) error {
+	return mc.OnEvent(interfaces.NewEvent(interfaces.EventTypeCreateFile, request, response))
+}
+
+func (mc *MultiCallback) DeleteFile(ID string) error {
+	return mc.OnEvent(interfaces.NewEvent(interfaces.EventTypeDeleteFile, interfaces.IDRequest{ID: ID}, nil))
+}
+
+func (mc *MultiCallback) GetFile(ID string, response openai.File) error {
+	return mc.OnEvent(interfaces.NewEvent(interfaces.EventTypeGetFile, interfaces.IDRequest{ID: ID}, response))
+}
+
+// GetFileContent: Not implemented in Go SDK
+
+func (mc *MultiCallback) CreateFineTune(request openai.FineTuneRequest, response openai.FineTune) error {
+	return mc.OnEvent(interfaces.NewEvent(interfaces.EventTypeCreateFineTune, request, response))
+}
+
+func (mc *MultiCallback) ListFineTunes(list openai.FineTuneList) error {
+	return mc.OnEvent(interfaces.NewEvent(interfaces.EventTypeListFineTunes, nil, list))
+}
+
+func (mc *MultiCallback) GetFineTune(ID string, response openai.FineTune) error {
+	return mc.OnEvent(interfaces.NewEvent(interfaces.EventTypeGetFineTune, interfaces.IDRequest{ID: ID}, response))
+}
+
+func (mc *MultiCallback) CancelFineTune(ID string, response openai.FineTune) error {
+	return mc.OnEvent(interfaces.NewEvent(interfaces.EventTypeCancelFineTune, interfaces.IDRequest{ID: ID}, response))
+}
+
+func (mc *MultiCallback) ListFineTuneEvents(ID string, response openai.FineTuneEventList) error {
+	return mc.OnEvent(interfaces.NewEvent(interfaces.EventTypeListFineTuneEvents, interfaces.IDRequest{ID: ID}, response))
+}
+
+func (mc *MultiCallback) DeleteFineTune(ID string) error {
+	return mc.OnEvent(interfaces.NewEvent(interfaces.EventTypeDeleteFineTune, interfaces.IDRequest{ID: ID}, nil))
+}
+
+func (mc *MultiCallback) CreateImage(request openai.ImageRequest, response openai.ImageResponse) error {
+	return mc.OnEvent(interfaces.NewEvent(interfaces.EventTypeCreateImage, request, response))
+}
+
+func (mc *MultiCallback) CreateEditImage(request openai.ImageEditRequest, response openai.ImageResponse) error {
+	return mc.OnEvent(interfaces.NewEvent(interfaces.EventTypeCreateEditImage, request, response))
+}
+
+func (mc *MultiCallback) CreateVariImage(request openai.ImageVariRequest, response openai.ImageResponse) error {
+	return mc.OnEvent(interfaces.NewEvent(interfaces.EventTypeCreateVariImage, request, response))
+}
+
+func (mc *MultiCallback) ListModels(list openai.ModelsList) error {
+	return mc.OnEvent(interfaces.NewEvent(interfaces.EventTypeListModels, nil, list))
+}
+
+func (mc *MultiCallback) Moderations(request openai.ModerationRequest, response openai.ModerationResponse) error {
+	return mc.OnEvent(interfaces.NewEvent(interfaces.EventTypeModerations, request, response))
+}
@@ -0,0 +1,127 @@
+// Copyright 2023 dvonthenen ChatGPT Proxy contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache License 2.0
+
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+
+	openai "github.com/sashabaranov/go-openai"
+	klog "k8s.io/klog/v2"
+
+	interfaces "github.com/dvonthenen/chat-gpeasy/pkg/proxy/interfaces"
+	output "github.com/dvonthenen/chat-gpeasy/pkg/proxy/output"
+)
+
+// filterChatCompletion runs the choices of a chat completion through the
+// output filter. Blocked choices end with the content_filter finish reason.
+// The response may be shared with coalesced requests and the cache, so the
+// choices are copied before they are changed.
+func (p *ChatGPTProxy) filterChatCompletion(ctx context.Context, resp *openai.ChatCompletionResponse) {
+	if p.output == nil {
+		return
+	}
+
+	choices := make([]openai.ChatCompletionChoice, len(resp.Choices))
+	copy(choices, resp.Choices)
+	for i := range choices {
+		result := p.output.Apply(ctx, choices[i].Message.Content, p.moderateOutput)
+		p.recordOutput(ctx, result.Findings)
+
+		choices[i].Message.Content = result.Text
+		if result.Blocked {
+			choices[i].Message.FunctionCall = nil
+			choices[i].FinishReason = openai.FinishReasonContentFilter
+		}
+	}
+	resp.Choices = choices
+}
+
+// filterCompletion runs the choices of a completion through the output filter
+func (p *ChatGPTProxy) filterCompletion(ctx context.Context, resp *openai.CompletionResponse) {
+	if p.output == nil {
+		return
+	}
+
+	choices := make([]openai.CompletionChoice, len(resp.Choices))
+	copy(choices, resp.Choices)
+	for i := range choices {
+		result := p.output.Apply(ctx, choices[i].Text, p.moderateOutput)
+		p.recordOutput(ctx, result.Findings)
+
+		choices[i].Text = result.Text
+		if result.Blocked {
+			choices[i].FinishReason = string(openai.FinishReasonContentFilter)
+		}
+	}
+	resp.Choices = choices
+}
+
+// recordOutput keeps the output findings for the callback event
+func (p *ChatGPTProxy) recordOutput(ctx context.Context, findings []interfaces.Detection) {
+	if len(findings) == 0 {
+		return
+	}
+	klog.V(3).Infof("output filter findings: %v\n", findings)
+	if info := requestInfoFrom(ctx); info != nil {
+		info.addOutputFindings(findings)
+	}
+}
+
+// moderateOutput sends output to the moderation endpoint as an upstream call of
+// its own
+func (p *ChatGPTProxy) moderateOutput(ctx context.Context, text string) ([]string, error) {
+	var resp openai.ModerationResponse
+	err := p.upstream(ctx, upstreamOpenAI, routeModerations, func(ctx context.Context) (err error) {
+		resp, err = p.client(ctx).Moderations(ctx, openai.ModerationRequest{Input: text})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return flaggedCategories(resp), nil
+}
+
+// streamModerator moderates streamed output with the client of the stream. The
+// stream already holds an upstream slot, so the calls are not queued again.
+func (p *ChatGPTProxy) streamModerator(client *openai.Client) output.Moderator {
+	return func(ctx context.Context, text string) ([]string, error) {
+		resp, err := client.Moderations(ctx, openai.ModerationRequest{Input: text})
+		if err != nil {
+			return nil, err
+		}
+		return flaggedCategories(resp), nil
+	}
+}
+
+// flaggedCategories returns the names of the categories a moderation flagged
+func flaggedCategories(resp openai.ModerationResponse) []string {
+	var categories []string
+	for _, result := range resp.Results {
+		if !result.Flagged {
+			continue
+		}
+
+		data, err := json.Marshal(result.Categories)
+		if err != nil {
+			continue
+		}
+		var flags map[string]bool
+		if err := json.Unmarshal(data, &flags); err != nil {
+			continue
+		}
+		for name, flagged := range flags {
+			if flagged {
+				categories = append(categories, name)
+			}
+		}
+		if len(categories) == 0 {
+			categories = append(categories, "flagged")
+		}
+	}
+
+	sort.Strings(categories)
+	return categories
+}
@@ -0,0 +1,38 @@
+// Copyright 2023 dvonthenen ChatGPT Proxy contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache License 2.0
+
+package output
+
+import (
+	"errors"
+)
+
+const (
+	// kinds of findings
+	KindRedaction  string = "redaction"
+	KindDeny       string = "deny"
+	KindModeration string = "moderation"
+
+	// DefaultReplacement is written in place of redacted text
+	DefaultReplacement string = "[REDACTED]"
+
+	// DefaultBlockMessage replaces the content of a blocked response
+	DefaultBlockMessage string = "This response was blocked by the output policy."
+
+	// DefaultHoldBack is how many characters of streamed output are held back
+	// so a pattern split across chunks is still caught. Patterns that match
+	// longer text are only caught across chunks while they keep growing.
+	DefaultHoldBack int = 64
+
+	// DefaultModerationInterval is how many characters of streamed output are
+	// moderated together
+	DefaultModerationInterval int = 400
+)
+
+var (
+	// ErrInvalidInput required input was not found
+	ErrInvalidInput = errors.New("required input was not found")
+
+	// ErrInvalidPattern the pattern does not compile
+	ErrInvalidPattern = errors.New("invalid output pattern")
+)
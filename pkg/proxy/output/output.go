@@ -0,0 +1,231 @@
+// Copyright 2023 dvonthenen ChatGPT Proxy contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache License 2.0
+
+package output
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	klog "k8s.io/klog/v2"
+
+	interfaces "github.com/dvonthenen/chat-gpeasy/pkg/proxy/interfaces"
+)
+
+// New creates a filter with the default redactions plus the ones in the options
+func New(options OutputOptions) (*Filter, error) {
+	if options.HoldBack <= 0 {
+		options.HoldBack = DefaultHoldBack
+	}
+	if options.ModerationInterval <= 0 {
+		options.ModerationInterval = DefaultModerationInterval
+	}
+	if len(options.BlockMessage) == 0 {
+		options.BlockMessage = DefaultBlockMessage
+	}
+
+	redactions := options.Redactions
+	if !options.DisableDefaultRedactions {
+		redactions = append(DefaultRedactions(), redactions...)
+	}
+
+	f := &Filter{
+		options: &options,
+	}
+	for _, r := range redactions {
+		if len(r.Name) == 0 || len(r.Pattern) == 0 {
+			return nil, ErrInvalidInput
+		}
+		re, err := regexp.Compile(r.Pattern)
+		if err != nil {
+			klog.V(1).Infof("output redaction %s does not compile. Err: %v\n", r.Name, err)
+			return nil, fmt.Errorf("%w: %s: %v", ErrInvalidPattern, r.Name, err)
+		}
+		replacement := r.Replacement
+		if len(replacement) == 0 {
+			replacement = DefaultReplacement
+		}
+		f.redactions = append(f.redactions, redaction{
+			name:        r.Name,
+			re:          re,
+			replacement: replacement,
+			validate:    r.Validate,
+		})
+	}
+	for _, d := range options.Deny {
+		if len(d.Name) == 0 || len(d.Pattern) == 0 {
+			return nil, ErrInvalidInput
+		}
+		re, err := regexp.Compile(d.Pattern)
+		if err != nil {
+			klog.V(1).Infof("output deny pattern %s does not compile. Err: %v\n", d.Name, err)
+			return nil, fmt.Errorf("%w: %s: %v", ErrInvalidPattern, d.Name, err)
+		}
+		f.deny = append(f.deny, pattern{
+			name: d.Name,
+			re:   re,
+		})
+	}
+
+	return f, nil
+}
+
+// BlockMessage is the content sent in place of a blocked response
+func (f *Filter) BlockMessage() string {
+	return f.options.BlockMessage
+}
+
+// Apply filters the text of a complete response. A blocked result carries the
+// block message as its text.
+func (f *Filter) Apply(ctx context.Context, text string, moderate Moderator) Result {
+	var found findings
+
+	if name := f.denied(text); len(name) > 0 {
+		found.add(KindDeny, name)
+		f.record(found, true)
+		return Result{
+			Text:     f.options.BlockMessage,
+			Blocked:  true,
+			Findings: found,
+		}
+	}
+
+	text = f.redact(text, f.matches(text), &found)
+
+	if categories := f.moderate(ctx, text, moderate); len(categories) > 0 {
+		found.add(KindModeration, strings.Join(categories, ","))
+		f.record(found, true)
+		return Result{
+			Text:     f.options.BlockMessage,
+			Blocked:  true,
+			Findings: found,
+		}
+	}
+
+	f.record(found, false)
+	return Result{
+		Text:     text,
+		Findings: found,
+	}
+}
+
+// NewStream starts filtering a streamed response
+func (f *Filter) NewStream(ctx context.Context, moderate Moderator) *Stream {
+	return &Stream{
+		filter:   f,
+		ctx:      ctx,
+		moderate: moderate,
+		choices:  make(map[int]*buffer),
+	}
+}
+
+// Stats returns a snapshot of the counters
+func (f *Filter) Stats() Stats {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.stats
+}
+
+func (f *Filter) record(found findings, blocked bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.stats.Filtered++
+	if blocked {
+		f.stats.Blocked++
+	}
+	for _, finding := range found {
+		if finding.Kind == KindRedaction {
+			f.stats.Redacted++
+			break
+		}
+	}
+}
+
+// denied returns the name of the first deny pattern the text matches
+func (f *Filter) denied(text string) string {
+	for _, d := range f.deny {
+		if d.re.MatchString(text) {
+			return d.name
+		}
+	}
+	return ""
+}
+
+// matches returns where the redactions match the text, ordered by position
+// with the longest match first
+func (f *Filter) matches(text string) []match {
+	var all []match
+	for i := range f.redactions {
+		r := &f.redactions[i]
+		for _, loc := range r.re.FindAllStringIndex(text, -1) {
+			all = append(all, match{
+				start:     loc[0],
+				end:       loc[1],
+				redaction: r,
+			})
+		}
+	}
+	sort.SliceStable(all, func(i, j int) bool {
+		if all[i].start != all[j].start {
+			return all[i].start < all[j].start
+		}
+		return all[i].end > all[j].end
+	})
+	return all
+}
+
+// redact replaces the valid matches that lie within the text. Overlapping
+// matches are redacted once.
+func (f *Filter) redact(text string, matches []match, found *findings) string {
+	var sb strings.Builder
+	last := 0
+	for _, m := range matches {
+		if m.start < last || m.end > len(text) {
+			continue
+		}
+		if m.redaction.validate != nil && !m.redaction.validate(text[m.start:m.end]) {
+			continue
+		}
+		sb.WriteString(text[last:m.start])
+		sb.WriteString(m.redaction.replacement)
+		last = m.end
+		found.add(KindRedaction, m.redaction.name)
+	}
+	if last == 0 {
+		return text
+	}
+	sb.WriteString(text[last:])
+	return sb.String()
+}
+
+// moderate returns the categories the text is flagged for. Output is let
+// through when the moderation call fails.
+func (f *Filter) moderate(ctx context.Context, text string, moderate Moderator) []string {
+	if !f.options.Moderate || moderate == nil || len(strings.TrimSpace(text)) == 0 {
+		return nil
+	}
+
+	categories, err := moderate(ctx, text)
+	if err != nil {
+		klog.V(1).Infof("output moderation failed. Err: %v\n", err)
+		return nil
+	}
+	return categories
+}
+
+func (found *findings) add(kind, name string) {
+	for _, finding := range *found {
+		if finding.Kind == kind && finding.Name == name {
+			return
+		}
+	}
+	*found = append(*found, interfaces.Detection{
+		Kind:  kind,
+		Name:  name,
+		Score: 1,
+	})
+}
@@ -0,0 +1,67 @@
+// Copyright 2023 dvonthenen ChatGPT Proxy contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache License 2.0
+
+package output
+
+// DefaultRedactions are secrets and PII a model should never pass on
+func DefaultRedactions() []Redaction {
+	return []Redaction{
+		{
+			Name:    "private-key",
+			Pattern: `-----BEGIN [A-Z ]*PRIVATE KEY-----[A-Za-z0-9+/=\s]*(?:-----END [A-Z ]*PRIVATE KEY-----)?`,
+		},
+		{
+			Name:    "openai-key",
+			Pattern: `\bsk-[A-Za-z0-9_-]{20,}`,
+		},
+		{
+			Name:    "aws-access-key",
+			Pattern: `\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`,
+		},
+		{
+			Name:    "github-token",
+			Pattern: `\bgh[pousr]_[A-Za-z0-9]{36,}\b`,
+		},
+		{
+			Name:    "email",
+			Pattern: `\b[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}\b`,
+		},
+		{
+			Name:    "ssn",
+			Pattern: `\b\d{3}-\d{2}-\d{4}\b`,
+		},
+		{
+			Name:     "credit-card",
+			Pattern:  `\b\d(?:[ -]?\d){12,18}\b`,
+			Validate: luhn,
+		},
+		{
+			Name:    "phone",
+			Pattern: `(?:\+\d{1,3}[ .-]?)?\(?\b\d{3}\)?[ .-]\d{3}[ .-]\d{4}\b`,
+		},
+	}
+}
+
+// luhn returns true when the digits of s pass the Luhn checksum
+func luhn(s string) bool {
+	sum := 0
+	digits := 0
+	double := false
+	for i := len(s) - 1; i >= 0; i-- {
+		c := s[i]
+		if c < '0' || c > '9' {
+			continue
+		}
+		d := int(c - '0')
+		if double {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		digits++
+		double = !double
+	}
+	return digits >= 13 && sum%10 == 0
+}
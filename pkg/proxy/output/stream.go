@@ -0,0 +1,128 @@
+// Copyright 2023 dvonthenen ChatGPT Proxy contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache License 2.0
+
+package output
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	interfaces "github.com/dvonthenen/chat-gpeasy/pkg/proxy/interfaces"
+)
+
+// Write adds a chunk of output for a choice and returns the text that is safe
+// to send. It returns true once the response is blocked, nothing more may be
+// sent then.
+func (s *Stream) Write(index int, delta string) (string, bool) {
+	if s.blocked {
+		return "", true
+	}
+
+	b := s.choice(index)
+	b.pending += delta
+
+	// a deny match is never longer than the held back text when it completes,
+	// so none of it has been sent yet
+	if name := s.filter.denied(b.pending); len(name) > 0 {
+		s.block(KindDeny, name)
+		return "", true
+	}
+
+	matches := s.filter.matches(b.pending)
+	cut := s.safeCut(b.pending, matches)
+	release := s.filter.redact(b.pending[:cut], matches, &s.findings)
+	b.pending = b.pending[cut:]
+
+	return s.moderated(b, release, false)
+}
+
+// Close flushes what is held back for a choice once its output is complete
+func (s *Stream) Close(index int) (string, bool) {
+	if s.blocked {
+		return "", true
+	}
+
+	b := s.choice(index)
+	text := b.pending
+	b.pending = ""
+
+	if name := s.filter.denied(text); len(name) > 0 {
+		s.block(KindDeny, name)
+		return "", true
+	}
+
+	release := s.filter.redact(text, s.filter.matches(text), &s.findings)
+	return s.moderated(b, release, true)
+}
+
+// Blocked returns true once the response is blocked
+func (s *Stream) Blocked() bool {
+	return s.blocked
+}
+
+// Finish records the stream in the stats and returns its findings
+func (s *Stream) Finish() []interfaces.Detection {
+	s.filter.record(s.findings, s.blocked)
+	return s.findings
+}
+
+func (s *Stream) choice(index int) *buffer {
+	b, ok := s.choices[index]
+	if !ok {
+		b = &buffer{}
+		s.choices[index] = b
+	}
+	return b
+}
+
+func (s *Stream) block(kind, name string) {
+	s.blocked = true
+	s.findings.add(kind, name)
+	for _, b := range s.choices {
+		b.pending = ""
+		b.unmoderated = ""
+	}
+}
+
+// safeCut returns how much of the pending text can be released: everything but
+// the hold back, and never part of a match that may still grow
+func (s *Stream) safeCut(pending string, matches []match) int {
+	cut := len(pending) - s.filter.options.HoldBack
+	if cut <= 0 {
+		return 0
+	}
+
+	for moved := true; moved; {
+		moved = false
+		for _, m := range matches {
+			if m.start < cut && m.end > cut {
+				cut = m.start
+				moved = true
+			}
+		}
+	}
+	for cut > 0 && !utf8.RuneStart(pending[cut]) {
+		cut--
+	}
+	return cut
+}
+
+// moderated holds released text until enough of it is collected to moderate
+func (s *Stream) moderated(b *buffer, release string, final bool) (string, bool) {
+	if !s.filter.options.Moderate || s.moderate == nil {
+		return release, false
+	}
+
+	b.unmoderated += release
+	if !final && len(b.unmoderated) < s.filter.options.ModerationInterval {
+		return "", false
+	}
+
+	text := b.unmoderated
+	b.unmoderated = ""
+	if categories := s.filter.moderate(s.ctx, text, s.moderate); len(categories) > 0 {
+		s.block(KindModeration, strings.Join(categories, ","))
+		return "", true
+	}
+	return text, false
+}
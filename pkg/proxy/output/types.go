@@ -0,0 +1,119 @@
+// Copyright 2023 dvonthenen ChatGPT Proxy contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache License 2.0
+
+package output
+
+import (
+	"context"
+	"regexp"
+	"sync"
+
+	interfaces "github.com/dvonthenen/chat-gpeasy/pkg/proxy/interfaces"
+)
+
+// OutputOptions configures post-processing of chat and completion output
+type OutputOptions struct {
+	// Redactions replace secrets and PII in the output, added to
+	// DefaultRedactions
+	Redactions               []Redaction
+	DisableDefaultRedactions bool
+
+	// Deny blocks a response that matches any of the patterns
+	Deny []Pattern
+
+	// Moderate runs the output through the moderation endpoint and blocks it
+	// when flagged
+	Moderate bool
+
+	// ModerationInterval is how many characters of streamed output are held
+	// and moderated together, DefaultModerationInterval when 0
+	ModerationInterval int
+
+	// HoldBack is how many characters of streamed output are held until no
+	// pattern can match across them, DefaultHoldBack when 0
+	HoldBack int
+
+	// BlockMessage replaces the content of a blocked response,
+	// DefaultBlockMessage when empty
+	BlockMessage string
+}
+
+// Redaction replaces the text matching Pattern with Replacement,
+// DefaultReplacement when empty. When Validate is set a match is only
+// redacted when it returns true.
+type Redaction struct {
+	Name        string
+	Pattern     string
+	Replacement string
+	Validate    func(match string) bool
+}
+
+// Pattern is a named regular expression
+type Pattern struct {
+	Name    string
+	Pattern string
+}
+
+// Moderator returns the categories the text is flagged for, none when it is fine
+type Moderator func(ctx context.Context, text string) ([]string, error)
+
+// Result of filtering a complete response
+type Result struct {
+	Text     string
+	Blocked  bool
+	Findings []interfaces.Detection
+}
+
+// Stats counters for filtered output
+type Stats struct {
+	Filtered int64 `json:"filtered"`
+	Redacted int64 `json:"redacted"`
+	Blocked  int64 `json:"blocked"`
+}
+
+// Filter redacts, denies and moderates model output
+type Filter struct {
+	options    *OutputOptions
+	redactions []redaction
+	deny       []pattern
+	stats      Stats
+
+	// housekeeping
+	mu sync.Mutex
+}
+
+// Stream filters the output of one streamed response. Text is held back until
+// it is safe to send.
+type Stream struct {
+	filter   *Filter
+	ctx      context.Context
+	moderate Moderator
+	choices  map[int]*buffer
+	findings findings
+	blocked  bool
+}
+
+type buffer struct {
+	pending     string
+	unmoderated string
+}
+
+type redaction struct {
+	name        string
+	re          *regexp.Regexp
+	replacement string
+	validate    func(string) bool
+}
+
+type pattern struct {
+	name string
+	re   *regexp.Regexp
+}
+
+type match struct {
+	start, end int
+	redaction  *redaction
+}
+
+// findings collects each distinct finding once
+type findings []interfaces.Detection
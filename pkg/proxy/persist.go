@@ -0,0 +1,43 @@
+// Copyright 2023 dvonthenen ChatGPT Proxy contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache License 2.0
+
+package proxy
+
+import (
+	"context"
+
+	openai "github.com/sashabaranov/go-openai"
+	klog "k8s.io/klog/v2"
+
+	images "github.com/dvonthenen/chat-gpeasy/pkg/proxy/images"
+)
+
+// imageResponseFormat returns the response format to request upstream
+func (p *ChatGPTProxy) imageResponseFormat(requested string) string {
+	if p.images != nil && p.images.RequestB64JSON() {
+		return images.ResponseFormatB64JSON
+	}
+	return requested
+}
+
+// persistImages stores the images locally and rewrites the response. When the
+// store fails the upstream response is returned as is if it has the format the
+// client asked for. Otherwise, with RequestB64JSON and a client that asked for
+// URLs, persisting the images is the only way to answer and the error is
+// returned.
+func (p *ChatGPTProxy) persistImages(ctx context.Context, response openai.ImageResponse, responseFormat string, metadata images.Metadata) (openai.ImageResponse, error) {
+	if p.images == nil {
+		return response, nil
+	}
+
+	persisted, err := p.images.Persist(ctx, response, responseFormat, metadata)
+	if err != nil {
+		klog.V(1).Infof("images.Persist failed. Err: %v\n", err)
+		if p.imageResponseFormat(responseFormat) != responseFormat {
+			return response, err
+		}
+		return response, nil
+	}
+
+	return persisted, nil
+}
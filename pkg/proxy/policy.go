@@ -0,0 +1,64 @@
+// Copyright 2023 dvonthenen ChatGPT Proxy contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache License 2.0
+
+package proxy
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+
+	"github.com/gin-gonic/gin"
+	openai "github.com/sashabaranov/go-openai"
+	klog "k8s.io/klog/v2"
+
+	policy "github.com/dvonthenen/chat-gpeasy/pkg/proxy/policy"
+)
+
+// applyPolicies rewrites a chat request with the policies that match the
+// caller. The original request is kept for the callback event.
+func (p *ChatGPTProxy) applyPolicies(ctx context.Context, c *gin.Context, request *openai.ChatCompletionRequest) {
+	p.applyPoliciesFor(ctx, policyCaller(c), c.FullPath(), request)
+}
+
+// applyPoliciesFor is applyPolicies for a caller that is not the client of an
+// HTTP request, like the owner of a batch
+func (p *ChatGPTProxy) applyPoliciesFor(ctx context.Context, caller policy.Caller, route string, request *openai.ChatCompletionRequest) {
+	if p.policies == nil {
+		return
+	}
+
+	original := *request
+	rewritten, applied := p.policies.Apply(caller, original)
+	if len(applied) == 0 {
+		return
+	}
+
+	klog.V(4).Infof("policies %v applied to %s\n", applied, route)
+	*request = rewritten
+	if info := requestInfoFrom(ctx); info != nil {
+		info.setOriginalRequest(original)
+	}
+}
+
+// policyCaller is the client of the request as policies see it
+func policyCaller(c *gin.Context) policy.Caller {
+	caller := policy.Caller{
+		Key:  callerKey(c),
+		User: callerUser(c),
+	}
+	if id := identity(c); id != nil {
+		caller.AuthPolicies = id.Policies
+	}
+	return caller
+}
+
+// callerUser identifies the caller to OpenAI: the subject of an authenticated
+// caller, otherwise a hash of its key so bearer tokens are not sent upstream
+func callerUser(c *gin.Context) string {
+	if id := identity(c); id != nil && len(id.Subject) > 0 {
+		return id.Subject
+	}
+	sum := sha256.Sum256([]byte(callerKey(c)))
+	return callerUserPrefix + hex.EncodeToString(sum[:])[:callerUserHashLen]
+}
@@ -0,0 +1,37 @@
+// Copyright 2023 dvonthenen ChatGPT Proxy contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache License 2.0
+
+package policy
+
+import (
+	"errors"
+)
+
+const (
+	// where the mandated system message goes
+	PlacementPrepend string = "prepend"
+	PlacementAppend  string = "append"
+
+	// ForceUserCaller sets user to the caller's identity
+	ForceUserCaller string = "$caller"
+
+	// fields that can be stripped
+	FieldLogitBias        string = "logit_bias"
+	FieldFunctions        string = "functions"
+	FieldFunctionCall     string = "function_call"
+	FieldStop             string = "stop"
+	FieldPresencePenalty  string = "presence_penalty"
+	FieldFrequencyPenalty string = "frequency_penalty"
+	FieldUser             string = "user"
+)
+
+var (
+	// ErrInvalidInput required input was not found
+	ErrInvalidInput = errors.New("required input was not found")
+
+	// ErrInvalidPlacement the placement is not prepend or append
+	ErrInvalidPlacement = errors.New("invalid system message placement")
+
+	// ErrUnknownField the field can not be stripped
+	ErrUnknownField = errors.New("unknown request field")
+)
@@ -0,0 +1,143 @@
+// Copyright 2023 dvonthenen ChatGPT Proxy contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache License 2.0
+
+package policy
+
+import (
+	"fmt"
+
+	openai "github.com/sashabaranov/go-openai"
+	klog "k8s.io/klog/v2"
+)
+
+// New creates an engine
+func New(options PolicyOptions) (*Engine, error) {
+	policies := make([]Policy, 0, len(options.Policies))
+	for i, p := range options.Policies {
+		if len(p.Name) == 0 {
+			p.Name = fmt.Sprintf("policy-%d", i+1)
+		}
+		switch p.Placement {
+		case "":
+			p.Placement = PlacementPrepend
+		case PlacementPrepend, PlacementAppend:
+		default:
+			klog.V(1).Infof("policy %s placement %s is invalid\n", p.Name, p.Placement)
+			return nil, ErrInvalidPlacement
+		}
+		for _, field := range p.StripFields {
+			if !strippable(field) {
+				klog.V(1).Infof("policy %s can not strip %s\n", p.Name, field)
+				return nil, fmt.Errorf("%w: %s", ErrUnknownField, field)
+			}
+		}
+		policies = append(policies, p)
+	}
+
+	return &Engine{
+		policies: policies,
+	}, nil
+}
+
+// Apply returns the request rewritten by every policy that matches the caller,
+// and the names of those policies. The request passed in is not modified.
+func (e *Engine) Apply(caller Caller, request openai.ChatCompletionRequest) (openai.ChatCompletionRequest, []string) {
+	var applied []string
+	for _, p := range e.policies {
+		if !p.matches(caller) {
+			continue
+		}
+		request = p.apply(caller, request)
+		applied = append(applied, p.Name)
+	}
+	return request, applied
+}
+
+func (p *Policy) matches(caller Caller) bool {
+	if len(p.Callers) == 0 && len(p.AuthPolicies) == 0 {
+		return true
+	}
+	for _, key := range p.Callers {
+		if key == caller.Key {
+			return true
+		}
+	}
+	for _, name := range p.AuthPolicies {
+		for _, matched := range caller.AuthPolicies {
+			if name == matched {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// apply rewrites a copy of the request. Slices and maps are replaced, never
+// changed in place, so the caller's original stays intact.
+func (p *Policy) apply(caller Caller, request openai.ChatCompletionRequest) openai.ChatCompletionRequest {
+	if len(p.SystemMessage) > 0 {
+		system := openai.ChatCompletionMessage{
+			Role:    openai.ChatMessageRoleSystem,
+			Content: p.SystemMessage,
+		}
+		messages := make([]openai.ChatCompletionMessage, 0, len(request.Messages)+1)
+		if p.Placement == PlacementAppend {
+			messages = append(append(messages, request.Messages...), system)
+		} else {
+			messages = append(append(messages, system), request.Messages...)
+		}
+		request.Messages = messages
+	}
+
+	// 0 means unset, which OpenAI treats as its default
+	if p.MaxTokens > 0 && (request.MaxTokens == 0 || request.MaxTokens > p.MaxTokens) {
+		request.MaxTokens = p.MaxTokens
+	}
+	if p.MaxTemperature > 0 && (request.Temperature == 0 || request.Temperature > p.MaxTemperature) {
+		request.Temperature = p.MaxTemperature
+	}
+	if p.MaxTopP > 0 && (request.TopP == 0 || request.TopP > p.MaxTopP) {
+		request.TopP = p.MaxTopP
+	}
+	if p.MaxN > 0 && request.N > p.MaxN {
+		request.N = p.MaxN
+	}
+
+	for _, field := range p.StripFields {
+		switch field {
+		case FieldLogitBias:
+			request.LogitBias = nil
+		case FieldFunctions:
+			request.Functions = nil
+			request.FunctionCall = nil
+		case FieldFunctionCall:
+			request.FunctionCall = nil
+		case FieldStop:
+			request.Stop = nil
+		case FieldPresencePenalty:
+			request.PresencePenalty = 0
+		case FieldFrequencyPenalty:
+			request.FrequencyPenalty = 0
+		case FieldUser:
+			request.User = ""
+		}
+	}
+
+	switch p.ForceUser {
+	case "":
+	case ForceUserCaller:
+		request.User = caller.User
+	default:
+		request.User = p.ForceUser
+	}
+
+	return request
+}
+
+func strippable(field string) bool {
+	switch field {
+	case FieldLogitBias, FieldFunctions, FieldFunctionCall, FieldStop, FieldPresencePenalty, FieldFrequencyPenalty, FieldUser:
+		return true
+	}
+	return false
+}
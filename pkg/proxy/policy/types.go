@@ -0,0 +1,55 @@
+// Copyright 2023 dvonthenen ChatGPT Proxy contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache License 2.0
+
+package policy
+
+// PolicyOptions holds the policies applied to chat requests
+type PolicyOptions struct {
+	// Policies are applied in order, every matching policy is applied
+	Policies []Policy
+}
+
+// Policy rewrites the chat requests of matched callers
+type Policy struct {
+	Name string
+
+	// Callers are caller keys: the subject of an authenticated caller,
+	// otherwise its bearer token or IP address. AuthPolicies are names of
+	// authentication policies the caller matched. A policy with neither applies
+	// to every caller.
+	Callers      []string
+	AuthPolicies []string
+
+	// SystemMessage is put in front of the messages, or after them with
+	// PlacementAppend
+	SystemMessage string
+	Placement     string
+
+	// caps on numeric parameters, not capped when 0. A request that leaves a
+	// capped parameter unset gets the cap, since OpenAI's default may be higher.
+	MaxTokens      int
+	MaxTemperature float32
+	MaxTopP        float32
+	MaxN           int
+
+	// StripFields are removed from the request, see the Field constants
+	StripFields []string
+
+	// ForceUser replaces the user field, ForceUserCaller identifies the caller
+	ForceUser string
+}
+
+// Caller is who sent a request
+type Caller struct {
+	Key          string
+	AuthPolicies []string
+
+	// User is sent upstream by ForceUserCaller. It must not be a secret, unlike
+	// Key which can be a bearer token.
+	User string
+}
+
+// Engine applies policies
+type Engine struct {
+	policies []Policy
+}
@@ -5,6 +5,7 @@ package proxy
 
 import (
 	"context"
+	"expvar"
 	"fmt"
 	"net/http"
 	"os"
@@ -13,6 +14,25 @@ import (
 	"github.com/gin-gonic/gin"
 	openai "github.com/sashabaranov/go-openai"
 	klog "k8s.io/klog/v2"
+
+	audio "github.com/dvonthenen/chat-gpeasy/pkg/audio"
+	auth "github.com/dvonthenen/chat-gpeasy/pkg/proxy/auth"
+	batch "github.com/dvonthenen/chat-gpeasy/pkg/proxy/batch"
+	breaker "github.com/dvonthenen/chat-gpeasy/pkg/proxy/breaker"
+	cache "github.com/dvonthenen/chat-gpeasy/pkg/proxy/cache"
+	coalesce "github.com/dvonthenen/chat-gpeasy/pkg/proxy/coalesce"
+	experiment "github.com/dvonthenen/chat-gpeasy/pkg/proxy/experiment"
+	guard "github.com/dvonthenen/chat-gpeasy/pkg/proxy/guard"
+	images "github.com/dvonthenen/chat-gpeasy/pkg/proxy/images"
+	interfaces "github.com/dvonthenen/chat-gpeasy/pkg/proxy/interfaces"
+	keypool "github.com/dvonthenen/chat-gpeasy/pkg/proxy/keypool"
+	legacy "github.com/dvonthenen/chat-gpeasy/pkg/proxy/legacy"
+	output "github.com/dvonthenen/chat-gpeasy/pkg/proxy/output"
+	policy "github.com/dvonthenen/chat-gpeasy/pkg/proxy/policy"
+	queue "github.com/dvonthenen/chat-gpeasy/pkg/proxy/queue"
+	sessions "github.com/dvonthenen/chat-gpeasy/pkg/proxy/sessions"
+	storage "github.com/dvonthenen/chat-gpeasy/pkg/proxy/storage"
+	usage "github.com/dvonthenen/chat-gpeasy/pkg/proxy/usage"
 )
 
 func New(options ProxyOptions) (*ChatGPTProxy, error) {
@@ -62,8 +82,133 @@ func (p *ChatGPTProxy) Init() error {
 	}
 	klog.V(6).Infof("\n")
 
+	var audioOptions audio.TranscriberOptions
+	if p.options.Audio != nil {
+		audioOptions = *p.options.Audio
+	}
+	transcriber, err := audio.New(client, audioOptions)
+	if err != nil {
+		klog.V(1).Infof("audio.New failed. Err: %v\n", err)
+		klog.V(6).Infof("ChatGPTProxy.Init LEAVE\n")
+		return err
+	}
+
+	// circuit breakers are needed before any route or batch job runs
+	if p.options.Breaker != nil {
+		breakerOptions := *p.options.Breaker
+		onStateChange := breakerOptions.OnStateChange
+		breakerOptions.OnStateChange = func(change interfaces.BreakerStateChange) {
+			if onStateChange != nil {
+				onStateChange(change)
+			}
+			p.breakerStateChange(change)
+		}
+		p.breakers = breaker.New(breakerOptions)
+	}
+
+	// persistent state is needed by the features that keep it
+	if p.options.Storage != nil {
+		store, err := storage.New(*p.options.Storage)
+		if err != nil {
+			klog.V(1).Infof("storage.New failed. Err: %v\n", err)
+			klog.V(6).Infof("ChatGPTProxy.Init LEAVE\n")
+			return err
+		}
+		p.storage = store
+	}
+
+	// client authentication
+	if p.options.Auth != nil {
+		options := *p.options.Auth
+		if options.Storage == nil {
+			options.Storage = p.storage
+		}
+
+		authenticator, err := auth.New(options)
+		if err != nil {
+			klog.V(1).Infof("auth.New failed. Err: %v\n", err)
+			klog.V(6).Infof("ChatGPTProxy.Init LEAVE\n")
+			return err
+		}
+		p.auth = authenticator
+	}
+
+	// request policies
+	if p.options.Policy != nil {
+		policies, err := policy.New(*p.options.Policy)
+		if err != nil {
+			klog.V(1).Infof("policy.New failed. Err: %v\n", err)
+			klog.V(6).Infof("ChatGPTProxy.Init LEAVE\n")
+			return err
+		}
+		p.policies = policies
+	}
+
+	// prompt screening
+	if p.options.Guard != nil {
+		promptGuard, err := guard.New(*p.options.Guard)
+		if err != nil {
+			klog.V(1).Infof("guard.New failed. Err: %v\n", err)
+			klog.V(6).Infof("ChatGPTProxy.Init LEAVE\n")
+			return err
+		}
+		p.guard = promptGuard
+	}
+
+	// output filtering
+	if p.options.Output != nil {
+		outputFilter, err := output.New(*p.options.Output)
+		if err != nil {
+			klog.V(1).Infof("output.New failed. Err: %v\n", err)
+			klog.V(6).Infof("ChatGPTProxy.Init LEAVE\n")
+			return err
+		}
+		p.output = outputFilter
+	}
+
+	// pooled api keys
+	if p.options.KeyPool != nil {
+		keys, err := keypool.New(*p.options.KeyPool)
+		if err != nil {
+			klog.V(1).Infof("keypool.New failed. Err: %v\n", err)
+			klog.V(6).Infof("ChatGPTProxy.Init LEAVE\n")
+			return err
+		}
+		p.keys = keys
+	}
+
+	// completions and edits translated to chat
+	if p.options.Legacy != nil {
+		translator, err := legacy.New(*p.options.Legacy)
+		if err != nil {
+			klog.V(1).Infof("legacy.New failed. Err: %v\n", err)
+			klog.V(6).Infof("ChatGPTProxy.Init LEAVE\n")
+			return err
+		}
+		p.legacy = translator
+	}
+
+	// concurrency limits
+	if p.options.Queue != nil {
+		p.limiter = queue.New(*p.options.Queue)
+	}
+
+	// request coalescing
+	if p.options.Coalesce != nil {
+		routes := p.options.Coalesce.Routes
+		if len(routes) == 0 {
+			routes = coalesceEndpoints
+		}
+		p.coalesceRoutes = make(map[string]bool)
+		for _, route := range routes {
+			p.coalesceRoutes[route] = true
+		}
+		p.coalescer = coalesce.New()
+	}
+
 	// housekeeping
 	p.chatgptClient = client
+	p.transcriber = transcriber
 
 	klog.V(4).Infof("ChatGPTProxy.Init Succeeded\n")
 	klog.V(6).Infof("ChatGPTProxy.Init LEAVE\n")
@@ -76,6 +221,10 @@ func (p *ChatGPTProxy) Start() error {
 
 	// redirect
 	router := gin.Default()
+	p.adminRoutes = make(map[string]bool)
+	if p.auth != nil {
+		router.Use(p.authenticate)
+	}
 	router.GET("/v1/models", p.getModels)
 	// router.GET("/v1/models/:model", p.getModel) // NOT IMPLEMENTED IN GO SDK
 	router.POST("/v1/completions", p.postCompletion)
@@ -99,6 +248,139 @@ func (p *ChatGPTProxy) Start() error {
 	router.GET("/v1/fine-tunes/:fine_tune_id/events", p.getFineTuneEvent)
 	router.DELETE("/v1/fine-tunes/:fine_tune_id", p.deleteFineTune)
 	router.POST("/v1/moderations", p.postModeration)
+	router.POST("/v1/tokenize", p.postTokenize)
+	router.POST("/v1/estimate", p.postEstimate)
+	p.handleAdmin(router, http.MethodGet, "/admin/status", p.getStatus)
+
+	// batch jobs
+	if p.options.Batch != nil {
+		options := *p.options.Batch
+		if len(options.Endpoints) == 0 {
+			options.Endpoints = batchEndpoints
+		}
+
+		batches, err := batch.New(p.executeBatchRequest, options)
+		if err != nil {
+			klog.V(1).Infof("batch.New failed. Err: %v\n", err)
+			klog.V(6).Infof("ChatGPTProxy.Start LEAVE\n")
+			return err
+		}
+		p.batches = batches
+
+		router.POST("/v1/batches/files", p.postBatchFile)
+		router.GET("/v1/batches/files/:file_id", p.getBatchFile)
+		router.GET("/v1/batches/files/:file_id/content", p.getBatchFileContent)
+		router.POST("/v1/batches", p.postBatch)
+		router.GET("/v1/batches", p.getBatches)
+		router.GET("/v1/batches/:batch_id", p.getBatch)
+		router.POST("/v1/batches/:batch_id/cancel", p.postCancelBatch)
+	}
+
+	// conversation sessions
+	if p.options.Sessions != nil {
+		options := *p.options.Sessions
+		if options.Storage == nil {
+			options.Storage = p.storage
+		}
+
+		sessionManager, err := sessions.New(options)
+		if err != nil {
+			klog.V(1).Infof("sessions.New failed. Err: %v\n", err)
+			klog.V(6).Infof("ChatGPTProxy.Start LEAVE\n")
+			return err
+		}
+		p.sessions = sessionManager
+
+		router.POST("/v1/sessions", p.postSession)
+		router.GET("/v1/sessions", p.getSessions)
+		router.GET("/v1/sessions/:session_id", p.getSession)
+		router.DELETE("/v1/sessions/:session_id", p.deleteSession)
+		router.GET("/v1/sessions/:session_id/messages", p.getSessionMessages)
+		router.POST("/v1/sessions/:session_id/messages", p.postSessionMessage)
+		router.PUT("/v1/sessions/:session_id/messages/:index", p.putSessionMessage)
+		router.POST("/v1/sessions/:session_id/directives", p.postSessionDirective)
+	}
+
+	// usage reporting
+	if p.options.Usage != nil {
+		options := *p.options.Usage
+		if options.Storage == nil {
+			options.Storage = p.storage
+		}
+
+		tracker, err := usage.New(options)
+		if err != nil {
+			klog.V(1).Infof("usage.New failed. Err: %v\n", err)
+			klog.V(6).Infof("ChatGPTProxy.Start LEAVE\n")
+			return err
+		}
+		p.usage = tracker
+
+		p.handleAdmin(router, http.MethodGet, "/admin/usage", p.getUsage)
+	}
+
+	// websocket chat
+	if p.options.ChatSocket != nil {
+		p.chatSocketUpgrader = newChatSocketUpgrader(*p.options.ChatSocket)
+
+		router.GET(routeChatSocket, p.getChatSocket)
+	}
+
+	// semantic cache
+	if p.options.Cache != nil {
+		options := *p.options.Cache
+		if options.Storage == nil {
+			options.Storage = p.storage
+		}
+
+		semanticCache, err := cache.New(p.embed, options)
+		if err != nil {
+			klog.V(1).Infof("cache.New failed. Err: %v\n", err)
+			klog.V(6).Infof("ChatGPTProxy.Start LEAVE\n")
+			return err
+		}
+		p.cache = semanticCache
+
+		p.handleAdmin(router, http.MethodGet, "/v1/cache", p.getCache)
+		p.handleAdmin(router, http.MethodDelete, "/v1/cache", p.deleteCache)
+		p.handleAdmin(router, http.MethodDelete, "/v1/cache/:entry_id", p.deleteCacheEntry)
+	}
+
+	// circuit breakers
+	if p.breakers != nil {
+		p.handleAdmin(router, http.MethodGet, "/v1/breakers", p.getBreakers)
+	}
+	if p.breakers != nil || p.limiter != nil {
+		p.handleAdmin(router, http.MethodGet, "/debug/vars", gin.WrapH(expvar.Handler()))
+	}
+
+	// shadow and split traffic
+	if p.options.Experiment != nil {
+		exp, err := experiment.New(p.chatgptClient, *p.options.Experiment)
+		if err != nil {
+			klog.V(1).Infof("experiment.New failed. Err: %v\n", err)
+			klog.V(6).Infof("ChatGPTProxy.Start LEAVE\n")
+			return err
+		}
+		p.experiment = exp
+
+		router.GET("/v1/experiment", p.getExperiment)
+	}
+
+	// image store
+	if p.options.Images != nil {
+		imageStore, err := images.New(*p.options.Images)
+		if err != nil {
+			klog.V(1).Infof("images.New failed. Err: %v\n", err)
+			klog.V(6).Infof("ChatGPTProxy.Start LEAVE\n")
+			return err
+		}
+		p.images = imageStore
+
+		router.GET("/v1/images/files/:image_id", p.getImageFile)
+		p.handleAdmin(router, http.MethodGet, "/v1/images/files/:image_id/metadata", p.getImageMetadata)
+		p.handleAdmin(router, http.MethodDelete, "/v1/images/files/:image_id", p.deleteImageFile)
+	}
 
 	// server
 	p.server = &http.Server{
@@ -140,6 +422,56 @@ func (p *ChatGPTProxy) Stop() error {
 		klog.V(1).Infof("timeout of 5 seconds.")
 	}
 
+	// interrupted batch jobs resume on the next start
+	if p.batches != nil {
+		if err := p.batches.Stop(); err != nil {
+			klog.V(1).Infof("batches.Stop failed. Err: %v\n", err)
+		}
+	}
+
+	if p.cache != nil {
+		if err := p.cache.Stop(); err != nil {
+			klog.V(1).Infof("cache.Stop failed. Err: %v\n", err)
+		}
+	}
+
+	if p.experiment != nil {
+		if err := p.experiment.Stop(); err != nil {
+			klog.V(1).Infof("experiment.Stop failed. Err: %v\n", err)
+		}
+	}
+
+	if p.images != nil {
+		if err := p.images.Stop(); err != nil {
+			klog.V(1).Infof("images.Stop failed. Err: %v\n", err)
+		}
+	}
+
+	// usage of the last requests, batch jobs included
+	if p.usage != nil {
+		if err := p.usage.Stop(); err != nil {
+			klog.V(1).Infof("usage.Stop failed. Err: %v\n", err)
+		}
+	}
+
+	// last, the features above save their state on stop. a store passed in
+	// the options belongs to the caller.
+	if p.storage != nil && p.options.Storage.Store == nil {
+		if err := p.storage.Close(); err != nil {
+			klog.V(1).Infof("storage.Close failed. Err: %v\n", err)
+		}
+	}
+
+	// flush callbacks that buffer work (ie dispatch.Dispatcher)
+	if p.callback != nil {
+		if stopper, ok := (*p.callback).(interfaces.ChatGPTCallbackStopper); ok {
+			klog.V(4).Infof("Stopping callback...\n")
+			if err := stopper.Stop(); err != nil {
+				klog.V(1).Infof("[CALLBACK] Stop failed. Err: %v\n", err)
+			}
+		}
+	}
+
 	klog.V(4).Infof("ChatGPTProxy.Stop Succeeded\n")
 	klog.V(6).Infof("ChatGPTProxy.Stop LEAVE\n")
 
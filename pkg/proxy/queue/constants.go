@@ -0,0 +1,32 @@
+// Copyright 2023 dvonthenen ChatGPT Proxy contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache License 2.0
+
+package queue
+
+import (
+	"errors"
+	"time"
+)
+
+const (
+	DefaultMaxInFlight   int           = 16
+	DefaultMaxQueueDepth int           = 1000
+	DefaultMaxQueueWait  time.Duration = 30 * time.Second
+
+	// DefaultBatchPriority puts batch jobs behind interactive requests
+	DefaultBatchPriority int = -10
+
+	// expvar map holding the stats of every queue
+	metricsName string = "chatgpt_proxy_queues"
+)
+
+var (
+	// ErrQueueFull too many requests are already waiting
+	ErrQueueFull = errors.New("request queue is full")
+
+	// ErrQueueTimeout the request waited longer than MaxQueueWait
+	ErrQueueTimeout = errors.New("request timed out in the queue")
+
+	// ErrClientGone the client disconnected while the request was queued
+	ErrClientGone = errors.New("client disconnected while queued")
+)
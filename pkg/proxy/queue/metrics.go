@@ -0,0 +1,29 @@
+// Copyright 2023 dvonthenen ChatGPT Proxy contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache License 2.0
+
+package queue
+
+import (
+	"expvar"
+	"sync"
+)
+
+var (
+	metricsOnce sync.Once
+	metrics     *expvar.Map
+)
+
+// publish exposes the stats of a queue on /debug/vars
+func publish(upstream string, q *Queue) {
+	metricsOnce.Do(func() {
+		if existing, ok := expvar.Get(metricsName).(*expvar.Map); ok {
+			metrics = existing
+			return
+		}
+		metrics = expvar.NewMap(metricsName)
+	})
+
+	metrics.Set(upstream, expvar.Func(func() interface{} {
+		return q.Stats()
+	}))
+}
@@ -0,0 +1,220 @@
+// Copyright 2023 dvonthenen ChatGPT Proxy contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache License 2.0
+
+package queue
+
+import (
+	"container/heap"
+	"context"
+	"sort"
+	"time"
+
+	klog "k8s.io/klog/v2"
+)
+
+// New creates a limiter. Queues are created the first time an upstream is used.
+func New(options QueueOptions) *Limiter {
+	if options.MaxInFlight <= 0 {
+		options.MaxInFlight = DefaultMaxInFlight
+	}
+	if options.MaxQueueDepth <= 0 {
+		options.MaxQueueDepth = DefaultMaxQueueDepth
+	}
+	if options.MaxQueueWait <= 0 {
+		options.MaxQueueWait = DefaultMaxQueueWait
+	}
+	if options.BatchPriority == 0 {
+		options.BatchPriority = DefaultBatchPriority
+	}
+
+	return &Limiter{
+		options: &options,
+		queues:  make(map[string]*Queue),
+	}
+}
+
+// Ticket builds the ticket for an interactive request
+func (l *Limiter) Ticket(key string, done <-chan struct{}) Ticket {
+	priority, ok := l.options.Priorities[key]
+	if !ok {
+		priority = l.options.DefaultPriority
+	}
+	return Ticket{
+		Key:      key,
+		Priority: priority,
+		Done:     done,
+	}
+}
+
+// BatchTicket builds the ticket for a batch job request
+func (l *Limiter) BatchTicket(done <-chan struct{}) Ticket {
+	return Ticket{
+		Priority: l.options.BatchPriority,
+		Done:     done,
+	}
+}
+
+// Get returns the queue for an upstream
+func (l *Limiter) Get(upstream string) *Queue {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	q, ok := l.queues[upstream]
+	if !ok {
+		q = &Queue{
+			upstream: upstream,
+			options:  l.options,
+			waiters:  make(waiterHeap, 0),
+			stats: Stats{
+				Upstream:    upstream,
+				MaxInFlight: l.options.MaxInFlight,
+			},
+		}
+		l.queues[upstream] = q
+		publish(upstream, q)
+	}
+
+	return q
+}
+
+// Stats returns the stats of every queue
+func (l *Limiter) Stats() []Stats {
+	l.mu.Lock()
+	queues := make([]*Queue, 0, len(l.queues))
+	for _, q := range l.queues {
+		queues = append(queues, q)
+	}
+	l.mu.Unlock()
+
+	stats := make([]Stats, 0, len(queues))
+	for _, q := range queues {
+		stats = append(stats, q.Stats())
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].Upstream < stats[j].Upstream
+	})
+
+	return stats
+}
+
+// Acquire waits for a slot. The returned function releases it and must be
+// called once the upstream call is done.
+func (q *Queue) Acquire(ctx context.Context, ticket Ticket) (func(), error) {
+	q.mu.Lock()
+
+	// a free slot and nobody ahead
+	if q.inFlight < q.options.MaxInFlight && len(q.waiters) == 0 {
+		q.inFlight++
+		q.stats.Admitted++
+		q.mu.Unlock()
+		return q.release, nil
+	}
+
+	if len(q.waiters) >= q.options.MaxQueueDepth {
+		q.stats.Rejected++
+		q.mu.Unlock()
+		klog.V(3).Infof("queue %s is full\n", q.upstream)
+		return nil, ErrQueueFull
+	}
+
+	q.seq++
+	w := &waiter{
+		priority: ticket.Priority,
+		seq:      q.seq,
+		ready:    make(chan struct{}),
+	}
+	heap.Push(&q.waiters, w)
+	q.stats.Queued++
+	q.mu.Unlock()
+
+	start := time.Now()
+	timer := time.NewTimer(q.options.MaxQueueWait)
+	defer timer.Stop()
+
+	var err error
+	select {
+	case <-w.ready:
+		q.admitted(time.Since(start))
+		return q.release, nil
+	case <-timer.C:
+		err = ErrQueueTimeout
+	case <-ticket.Done:
+		err = ErrClientGone
+	case <-ctx.Done():
+		err = ErrClientGone
+	}
+
+	q.mu.Lock()
+	if w.granted {
+		// the slot was handed over while giving up, pass it on
+		q.mu.Unlock()
+		q.release()
+	} else {
+		heap.Remove(&q.waiters, w.index)
+		q.mu.Unlock()
+	}
+
+	q.mu.Lock()
+	if err == ErrQueueTimeout {
+		q.stats.TimedOut++
+	} else {
+		q.stats.Dropped++
+	}
+	q.mu.Unlock()
+
+	klog.V(3).Infof("queued request for %s (key: %s) gave up. Err: %v\n", q.upstream, ticket.Key, err)
+
+	return nil, err
+}
+
+// Stats returns a snapshot of the queue
+func (q *Queue) Stats() Stats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	stats := q.stats
+	stats.InFlight = q.inFlight
+	stats.Depth = len(q.waiters)
+	if stats.Admitted > 0 {
+		stats.AvgQueueWaitMs = float64(q.totalWaitMs) / float64(stats.Admitted)
+	}
+
+	return stats
+}
+
+// release hands the slot to the next waiter or frees it
+func (q *Queue) release() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.waiters) > 0 {
+		w := heap.Pop(&q.waiters).(*waiter)
+		w.granted = true
+		close(w.ready)
+		return
+	}
+	q.inFlight--
+}
+
+func (q *Queue) admitted(wait time.Duration) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	ms := wait.Milliseconds()
+	q.stats.Admitted++
+	q.totalWaitMs += ms
+	if ms > q.stats.MaxQueueWaitMs {
+		q.stats.MaxQueueWaitMs = ms
+	}
+}
+
+// WithTicket attaches a ticket to the context of an upstream call
+func WithTicket(ctx context.Context, ticket Ticket) context.Context {
+	return context.WithValue(ctx, ticketKey{}, ticket)
+}
+
+// TicketFrom returns the ticket attached to the context
+func TicketFrom(ctx context.Context) (Ticket, bool) {
+	ticket, ok := ctx.Value(ticketKey{}).(Ticket)
+	return ticket, ok
+}
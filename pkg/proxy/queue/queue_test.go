@@ -0,0 +1,219 @@
+// Copyright 2023 dvonthenen ChatGPT Proxy contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache License 2.0
+
+package queue
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// waitFor polls until cond is true or fails the test after a second
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestPriority(t *testing.T) {
+	tests := []struct {
+		name    string
+		tickets func(l *Limiter) []Ticket
+		order   []int
+	}{
+		{
+			name: "higher priority first",
+			tickets: func(l *Limiter) []Ticket {
+				return []Ticket{{Priority: 0}, {Priority: 10}, {Priority: 5}}
+			},
+			order: []int{1, 2, 0},
+		},
+		{
+			name: "arrival order within a priority",
+			tickets: func(l *Limiter) []Ticket {
+				return []Ticket{{Priority: 1}, {Priority: 1}, {Priority: 1}}
+			},
+			order: []int{0, 1, 2},
+		},
+		{
+			name: "keys and batches",
+			tickets: func(l *Limiter) []Ticket {
+				return []Ticket{l.BatchTicket(nil), l.Ticket("default", nil), l.Ticket("vip", nil)}
+			},
+			order: []int{2, 1, 0},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := New(QueueOptions{
+				MaxInFlight: 1,
+				Priorities:  map[string]int{"vip": 10},
+			})
+			q := l.Get("priority " + tt.name)
+
+			release, err := q.Acquire(context.Background(), Ticket{})
+			if err != nil {
+				t.Fatalf("Acquire failed. Err: %v", err)
+			}
+
+			type admission struct {
+				pos     int
+				release func()
+			}
+			admitted := make(chan admission)
+
+			// queue the tickets one at a time so they arrive in order
+			tickets := tt.tickets(l)
+			for pos, ticket := range tickets {
+				go func(pos int, ticket Ticket) {
+					release, err := q.Acquire(context.Background(), ticket)
+					if err != nil {
+						t.Errorf("Acquire failed. Err: %v", err)
+						return
+					}
+					admitted <- admission{pos: pos, release: release}
+				}(pos, ticket)
+
+				depth := pos + 1
+				waitFor(t, "the ticket to queue", func() bool {
+					return q.Stats().Depth == depth
+				})
+			}
+
+			order := make([]int, 0, len(tickets))
+			for range tickets {
+				release()
+				next := <-admitted
+				order = append(order, next.pos)
+				release = next.release
+			}
+			release()
+
+			if !reflect.DeepEqual(order, tt.order) {
+				t.Errorf("expected order %v, got %v", tt.order, order)
+			}
+			if stats := q.Stats(); stats.InFlight != 0 || stats.Depth != 0 || stats.Admitted != int64(len(tickets)+1) {
+				t.Errorf("unexpected stats %+v", stats)
+			}
+		})
+	}
+}
+
+func TestGiveUp(t *testing.T) {
+	tests := []struct {
+		name     string
+		wait     time.Duration
+		goneAt   time.Duration
+		cancelAt time.Duration
+		err      error
+		stats    Stats
+	}{
+		{
+			name:  "queue wait",
+			wait:  20 * time.Millisecond,
+			err:   ErrQueueTimeout,
+			stats: Stats{Queued: 1, TimedOut: 1},
+		},
+		{
+			name:   "client gone",
+			wait:   time.Second,
+			goneAt: 10 * time.Millisecond,
+			err:    ErrClientGone,
+			stats:  Stats{Queued: 1, Dropped: 1},
+		},
+		{
+			name:     "context canceled",
+			wait:     time.Second,
+			cancelAt: 10 * time.Millisecond,
+			err:      ErrClientGone,
+			stats:    Stats{Queued: 1, Dropped: 1},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := New(QueueOptions{
+				MaxInFlight:  1,
+				MaxQueueWait: tt.wait,
+			})
+			q := l.Get("give up " + tt.name)
+
+			release, err := q.Acquire(context.Background(), Ticket{})
+			if err != nil {
+				t.Fatalf("Acquire failed. Err: %v", err)
+			}
+
+			done := make(chan struct{})
+			if tt.goneAt > 0 {
+				time.AfterFunc(tt.goneAt, func() { close(done) })
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if tt.cancelAt > 0 {
+				time.AfterFunc(tt.cancelAt, cancel)
+			}
+
+			_, err = q.Acquire(ctx, Ticket{Done: done})
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("expected %v, got %v", tt.err, err)
+			}
+			release()
+
+			stats := q.Stats()
+			if stats.Queued != tt.stats.Queued || stats.TimedOut != tt.stats.TimedOut || stats.Dropped != tt.stats.Dropped {
+				t.Errorf("expected %+v, got %+v", tt.stats, stats)
+			}
+			if stats.InFlight != 0 || stats.Depth != 0 {
+				t.Errorf("slot or waiter leaked: %+v", stats)
+			}
+		})
+	}
+}
+
+func TestQueueFull(t *testing.T) {
+	l := New(QueueOptions{
+		MaxInFlight:   1,
+		MaxQueueDepth: 1,
+		MaxQueueWait:  time.Second,
+	})
+	q := l.Get("full")
+
+	release, err := q.Acquire(context.Background(), Ticket{})
+	if err != nil {
+		t.Fatalf("Acquire failed. Err: %v", err)
+	}
+
+	queued := make(chan error, 1)
+	go func() {
+		release, err := q.Acquire(context.Background(), Ticket{})
+		if err == nil {
+			release()
+		}
+		queued <- err
+	}()
+	waitFor(t, "the ticket to queue", func() bool {
+		return q.Stats().Depth == 1
+	})
+
+	if _, err := q.Acquire(context.Background(), Ticket{}); !errors.Is(err, ErrQueueFull) {
+		t.Fatalf("expected %v, got %v", ErrQueueFull, err)
+	}
+
+	release()
+	if err := <-queued; err != nil {
+		t.Fatalf("queued Acquire failed. Err: %v", err)
+	}
+	if stats := q.Stats(); stats.Rejected != 1 || stats.InFlight != 0 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
@@ -0,0 +1,94 @@
+// Copyright 2023 dvonthenen ChatGPT Proxy contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache License 2.0
+
+package queue
+
+import (
+	"sync"
+	"time"
+)
+
+// QueueOptions bounds the upstream calls in flight
+type QueueOptions struct {
+	// MaxInFlight is the number of concurrent calls per upstream
+	MaxInFlight int
+
+	// MaxQueueDepth is the number of requests that can wait per upstream.
+	// Requests beyond it are rejected right away.
+	MaxQueueDepth int
+
+	// MaxQueueWait is how long a request waits for a slot
+	MaxQueueWait time.Duration
+
+	// Priorities by caller key (the bearer token the client sent, or its IP
+	// address). Higher priorities are served first, equal ones in order.
+	Priorities      map[string]int
+	DefaultPriority int
+
+	// BatchPriority is used for batch jobs, DefaultBatchPriority when 0
+	BatchPriority int
+}
+
+// Ticket describes a request asking for a slot
+type Ticket struct {
+	Key      string
+	Priority int
+
+	// Done is closed when the client goes away
+	Done <-chan struct{}
+}
+
+// Stats for one upstream queue
+type Stats struct {
+	Upstream       string  `json:"upstream"`
+	MaxInFlight    int     `json:"max_in_flight"`
+	InFlight       int     `json:"in_flight"`
+	Depth          int     `json:"depth"`
+	Admitted       int64   `json:"admitted"`
+	Queued         int64   `json:"queued"`
+	Rejected       int64   `json:"rejected"`
+	TimedOut       int64   `json:"timed_out"`
+	Dropped        int64   `json:"dropped"`
+	AvgQueueWaitMs float64 `json:"avg_queue_wait_ms"`
+	MaxQueueWaitMs int64   `json:"max_queue_wait_ms"`
+}
+
+// Limiter holds a queue per upstream
+type Limiter struct {
+	options *QueueOptions
+	queues  map[string]*Queue
+
+	// housekeeping
+	mu sync.Mutex
+}
+
+// Queue bounds the calls to one upstream
+type Queue struct {
+	upstream string
+	options  *QueueOptions
+
+	// state
+	inFlight int
+	waiters  waiterHeap
+	seq      uint64
+
+	// counters
+	stats       Stats
+	totalWaitMs int64
+
+	// housekeeping
+	mu sync.Mutex
+}
+
+type waiter struct {
+	priority int
+	seq      uint64
+	index    int
+	granted  bool
+	ready    chan struct{}
+}
+
+// waiterHeap orders waiters by priority, then arrival
+type waiterHeap []*waiter
+
+type ticketKey struct{}
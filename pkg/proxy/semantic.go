@@ -0,0 +1,81 @@
+// Copyright 2023 dvonthenen ChatGPT Proxy contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache License 2.0
+
+package proxy
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	openai "github.com/sashabaranov/go-openai"
+	klog "k8s.io/klog/v2"
+
+	cache "github.com/dvonthenen/chat-gpeasy/pkg/proxy/cache"
+)
+
+// embed implements cache.Embedder
+func (p *ChatGPTProxy) embed(ctx context.Context, model openai.EmbeddingModel, text string) ([]float32, error) {
+	var resp openai.EmbeddingResponse
+	err := p.upstream(ctx, upstreamOpenAI, routeEmbeddings, func(ctx context.Context) (err error) {
+		resp, err = p.client(ctx).CreateEmbeddings(ctx, openai.EmbeddingRequest{
+			Input: []string{text},
+			Model: model,
+		})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Data) == 0 {
+		return nil, ErrInvalidInput
+	}
+	return resp.Data[0].Embedding, nil
+}
+
+// cacheLookup returns a cached answer for the request, if any. The returned key
+// is passed to cacheStore after a miss.
+func (p *ChatGPTProxy) cacheLookup(ctx context.Context, c *gin.Context, request openai.ChatCompletionRequest) (*openai.ChatCompletionResponse, *cache.Key) {
+	if p.cache == nil {
+		return nil, nil
+	}
+
+	// Cache-Control: no-cache or no-store bypasses the cache
+	cacheControl := strings.ToLower(c.GetHeader(HeaderCacheControl))
+	if strings.Contains(cacheControl, cacheControlNoCache) || strings.Contains(cacheControl, cacheControlNoStore) {
+		klog.V(4).Infof("cache bypassed by %s\n", HeaderCacheControl)
+		return nil, nil
+	}
+
+	resp, key, err := p.cache.Lookup(ctx, callerUser(c), request)
+	if err != nil {
+		if !errors.Is(err, cache.ErrNotCacheable) {
+			klog.V(1).Infof("cache.Lookup failed. Err: %v\n", err)
+		}
+		return nil, nil
+	}
+
+	c.Header(HeaderCacheScope, key.Scope)
+	if resp != nil {
+		c.Header(HeaderCache, cacheHit)
+		if info := requestInfoFrom(ctx); info != nil {
+			info.setCached()
+		}
+	} else {
+		c.Header(HeaderCache, cacheMiss)
+	}
+
+	return resp, key
+}
+
+func (p *ChatGPTProxy) cacheStore(key *cache.Key, response openai.ChatCompletionResponse) {
+	if p.cache == nil || key == nil {
+		return
+	}
+
+	err := p.cache.Store(key, response)
+	if err != nil {
+		klog.V(1).Infof("cache.Store failed. Err: %v\n", err)
+	}
+}
@@ -0,0 +1,225 @@
+// Copyright 2023 dvonthenen ChatGPT Proxy contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache License 2.0
+
+package proxy
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+
+	"github.com/gin-gonic/gin"
+	openai "github.com/sashabaranov/go-openai"
+	klog "k8s.io/klog/v2"
+
+	personainterfaces "github.com/dvonthenen/chat-gpeasy/pkg/personas/interfaces"
+	advanced "github.com/dvonthenen/chat-gpeasy/pkg/personas/rest/advanced"
+	interfaces "github.com/dvonthenen/chat-gpeasy/pkg/proxy/interfaces"
+	sessions "github.com/dvonthenen/chat-gpeasy/pkg/proxy/sessions"
+)
+
+// sessionSkills are the persona skills a session can start with
+var sessionSkills = map[string]personainterfaces.SkillType{
+	"generic":   personainterfaces.SkillTypeGeneric,
+	"expert":    personainterfaces.SkillTypeExpert,
+	"dan":       personainterfaces.SkillTypeDAN,
+	"stan":      personainterfaces.SkillTypeSTAN,
+	"dude":      personainterfaces.SkillTypeDUDE,
+	"jailbreak": personainterfaces.SkillTypeJailBreak,
+	"mongo":     personainterfaces.SkillTypeMongo,
+}
+
+// sessionOwner identifies the caller that owns a session. The caller key can be
+// a bearer token, so only its hash is stored.
+func sessionOwner(c *gin.Context) string {
+	return keyHash(callerKey(c))
+}
+
+// keyHash is the SHA-256 of a caller key, which is stored in its place
+func keyHash(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// sessionPersona is an advanced persona holding the conversation of a session,
+// so sessions behave exactly like AdvancedChat
+func sessionPersona(client *openai.Client, session *sessions.Session) (*advanced.Persona, error) {
+	persona, err := advanced.New(client)
+	if err != nil {
+		return nil, err
+	}
+	err = persona.DynamicInit(session.Model, toCompletionMessages(session.Messages))
+	if err != nil {
+		return nil, err
+	}
+	return persona, nil
+}
+
+// skillMessages returns the conversation a persona skill starts with
+func (p *ChatGPTProxy) skillMessages(skill personainterfaces.SkillType, model string) ([]sessions.Message, error) {
+	persona, err := advanced.New(p.chatgptClient)
+	if err != nil {
+		return nil, err
+	}
+	if err := persona.Init(skill, model); err != nil {
+		return nil, err
+	}
+	conversation, err := persona.GetConversation()
+	if err != nil {
+		return nil, err
+	}
+	return toSessionMessages(conversation), nil
+}
+
+// querySession runs a query of a session as an upstream chat completion. The
+// caller's system prompt and policies apply to the request the persona sends
+// but are not kept in the session. The reply passes through the output filter
+// before it is kept, and the completion is raised as a CreateChatCompletion
+// event.
+func (p *ChatGPTProxy) querySession(ctx context.Context, c *gin.Context, session *sessions.Session, query func(ctx context.Context, persona *advanced.Persona) error) error {
+	var conversation []personainterfaces.CompletionMessage
+	var request *openai.ChatCompletionRequest
+	var response *openai.ChatCompletionResponse
+
+	err := p.upstream(ctx, upstreamOpenAI, routeChatCompletions, func(ctx context.Context) error {
+		persona, err := sessionPersona(p.client(ctx), session)
+		if err != nil {
+			return err
+		}
+		persona.SetRequestHook(func(request *openai.ChatCompletionRequest) {
+			p.applySystemPrompt(c, request)
+			p.applyPolicies(ctx, c, request)
+		})
+		if err := query(ctx, persona); err != nil {
+			return err
+		}
+
+		request, response = persona.Last()
+		conversation, err = persona.GetConversation()
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	session.Messages = toSessionMessages(conversation)
+	if response == nil {
+		return nil
+	}
+
+	filtered := *response
+	p.filterChatCompletion(ctx, &filtered)
+	if last := len(session.Messages) - 1; last >= 0 && len(filtered.Choices) == 1 {
+		session.Messages[last] = toSessionMessage(filtered.Choices[0].Message)
+	}
+
+	p.recordUsage(ctx, *request, filtered)
+
+	if p.callback != nil {
+		klog.V(6).Infof("CreateChatCompletion Callback...\n")
+		err = p.dispatch(ctx, interfaces.EventTypeCreateChatCompletion, *request, filtered)
+		if err != nil {
+			klog.V(1).Infof("[CALLBACK] CreateChatCompletion failed. Err: %v\n", err)
+		}
+	}
+
+	return nil
+}
+
+// addSessionDirective adds a system directive to the conversation of a session
+func addSessionDirective(client *openai.Client, session *sessions.Session, directive string) error {
+	persona, err := sessionPersona(client, session)
+	if err != nil {
+		return err
+	}
+	if err := persona.AddDirective(directive); err != nil {
+		return err
+	}
+	conversation, err := persona.GetConversation()
+	if err != nil {
+		return err
+	}
+	session.Messages = toSessionMessages(conversation)
+	return nil
+}
+
+// sessionReply is the answer to a query of a session, its last message
+func sessionReply(session *sessions.Session) sessions.Reply {
+	reply := sessions.Reply{
+		Object:    sessions.ReplyObject,
+		SessionID: session.ID,
+		Index:     len(session.Messages) - 1,
+	}
+	if reply.Index >= 0 {
+		reply.Message = session.Messages[reply.Index]
+	}
+	return reply
+}
+
+// sessionRequest is the chat request a session's messages would make, so they
+// can be screened like any other prompt
+func sessionRequest(model string, messages []sessions.Message) openai.ChatCompletionRequest {
+	request := openai.ChatCompletionRequest{
+		Model: model,
+	}
+	for _, message := range messages {
+		request.Messages = append(request.Messages, openai.ChatCompletionMessage{
+			Role:    message.Role,
+			Content: message.Content,
+		})
+	}
+	return request
+}
+
+func toCompletionMessages(messages []sessions.Message) []personainterfaces.CompletionMessage {
+	converted := make([]personainterfaces.CompletionMessage, 0, len(messages))
+	for _, message := range messages {
+		msg := personainterfaces.CompletionMessage{
+			Role:    message.Role,
+			Content: message.Content,
+			Name:    message.Name,
+		}
+		if message.FunctionCall != nil {
+			msg.FunctionCall = &personainterfaces.FunctionCall{
+				Name:      message.FunctionCall.Name,
+				Arguments: message.FunctionCall.Arguments,
+			}
+		}
+		converted = append(converted, msg)
+	}
+	return converted
+}
+
+func toSessionMessages(messages []personainterfaces.CompletionMessage) []sessions.Message {
+	converted := make([]sessions.Message, 0, len(messages))
+	for _, message := range messages {
+		msg := sessions.Message{
+			Role:    message.Role,
+			Content: message.Content,
+			Name:    message.Name,
+		}
+		if message.FunctionCall != nil {
+			msg.FunctionCall = &sessions.FunctionCall{
+				Name:      message.FunctionCall.Name,
+				Arguments: message.FunctionCall.Arguments,
+			}
+		}
+		converted = append(converted, msg)
+	}
+	return converted
+}
+
+func toSessionMessage(message openai.ChatCompletionMessage) sessions.Message {
+	msg := sessions.Message{
+		Role:    message.Role,
+		Content: message.Content,
+		Name:    message.Name,
+	}
+	if message.FunctionCall != nil {
+		msg.FunctionCall = &sessions.FunctionCall{
+			Name:      message.FunctionCall.Name,
+			Arguments: message.FunctionCall.Arguments,
+		}
+	}
+	return msg
+}
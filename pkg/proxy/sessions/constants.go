@@ -0,0 +1,40 @@
+// Copyright 2023 dvonthenen ChatGPT Proxy contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache License 2.0
+
+package sessions
+
+import (
+	"errors"
+	"time"
+)
+
+const (
+	// object types returned by the session endpoints
+	SessionObject string = "chat.session"
+	ReplyObject   string = "chat.session.reply"
+	ListObject    string = "list"
+
+	// DefaultTTL is how long a session is kept after its last use
+	DefaultTTL time.Duration = 24 * time.Hour
+
+	// sweepInterval is the least time between removing expired sessions
+	sweepInterval time.Duration = time.Minute
+
+	idPrefix      string = "sess_"
+	metaExtension string = ".json"
+
+	// storageComponent names the sessions' schema, keyPrefix their keys
+	storageComponent string = "sessions"
+	keyPrefix        string = "sessions/"
+)
+
+var (
+	// ErrInvalidInput required input was not found
+	ErrInvalidInput = errors.New("required input was not found")
+
+	// ErrSessionNotFound no session with that ID for the caller
+	ErrSessionNotFound = errors.New("session not found")
+
+	// ErrTooManyMessages the session has reached MaxMessages
+	ErrTooManyMessages = errors.New("session has too many messages")
+)
@@ -0,0 +1,196 @@
+// Copyright 2023 dvonthenen ChatGPT Proxy contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache License 2.0
+
+package sessions
+
+import (
+	"errors"
+	"sort"
+	"time"
+
+	klog "k8s.io/klog/v2"
+
+	interfaces "github.com/dvonthenen/chat-gpeasy/pkg/proxy/interfaces"
+	storage "github.com/dvonthenen/chat-gpeasy/pkg/proxy/storage"
+)
+
+// New creates a session manager with the store in the options
+func New(options SessionOptions) (*Manager, error) {
+	if options.TTL <= 0 {
+		options.TTL = DefaultTTL
+	}
+	if options.MaxMessages < 0 {
+		klog.V(1).Infof("invalid MaxMessages (%d)\n", options.MaxMessages)
+		return nil, ErrInvalidInput
+	}
+
+	store := options.Store
+	if store == nil && options.Storage != nil {
+		if err := storage.Migrate(options.Storage, storageComponent, migrations(options.Dir)); err != nil {
+			klog.V(1).Infof("storage.Migrate failed. Err: %v\n", err)
+			return nil, err
+		}
+		store = NewKVStore(options.Storage)
+	}
+	if store == nil && len(options.Dir) > 0 {
+		fileStore, err := NewFileStore(options.Dir)
+		if err != nil {
+			klog.V(1).Infof("NewFileStore failed. Err: %v\n", err)
+			return nil, err
+		}
+		store = fileStore
+	}
+	if store == nil {
+		store = NewMemoryStore()
+	}
+
+	return &Manager{
+		options: &options,
+		store:   store,
+		locks:   make(map[string]*sessionLock),
+	}, nil
+}
+
+// Create starts a session for the owner with the messages it begins with
+func (m *Manager) Create(owner, model string, messages []Message) (*Session, error) {
+	if len(owner) == 0 || len(model) == 0 {
+		return nil, ErrInvalidInput
+	}
+	m.sweep()
+
+	now := time.Now()
+	session := &Session{
+		ID:        idPrefix + interfaces.NewEventID(),
+		Object:    SessionObject,
+		Model:     model,
+		Owner:     owner,
+		Messages:  messages,
+		CreatedAt: now,
+	}
+	if session.Messages == nil {
+		session.Messages = make([]Message, 0)
+	}
+
+	if err := m.Save(session); err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+// Get returns a session of the owner. Sessions of other callers and expired
+// sessions are not found.
+func (m *Manager) Get(owner, id string) (*Session, error) {
+	session, err := m.store.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	if session.Owner != owner {
+		return nil, ErrSessionNotFound
+	}
+	if time.Now().After(session.ExpiresAt) {
+		if err := m.store.Delete(id); err != nil {
+			klog.V(1).Infof("store.Delete failed. Err: %v\n", err)
+		}
+		return nil, ErrSessionNotFound
+	}
+	return session, nil
+}
+
+// List returns the live sessions of the owner, oldest first
+func (m *Manager) List(owner string) ([]*Session, error) {
+	all, err := m.store.List()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	sessions := make([]*Session, 0)
+	for _, session := range all {
+		if session.Owner == owner && now.Before(session.ExpiresAt) {
+			sessions = append(sessions, session)
+		}
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].CreatedAt.Before(sessions[j].CreatedAt)
+	})
+	return sessions, nil
+}
+
+// Save stores a changed session and extends its life
+func (m *Manager) Save(session *Session) error {
+	if m.Full(session, 0) {
+		return ErrTooManyMessages
+	}
+
+	now := time.Now()
+	session.UpdatedAt = now
+	session.ExpiresAt = now.Add(m.options.TTL)
+	return m.store.Put(session)
+}
+
+// Delete removes a session of the owner
+func (m *Manager) Delete(owner, id string) error {
+	if _, err := m.Get(owner, id); err != nil {
+		return err
+	}
+	return m.store.Delete(id)
+}
+
+// Full returns true when adding more messages would take the session past
+// MaxMessages
+func (m *Manager) Full(session *Session, more int) bool {
+	return m.options.MaxMessages > 0 && len(session.Messages)+more > m.options.MaxMessages
+}
+
+// Lock serializes the changes to a session. Call the returned function to
+// unlock.
+func (m *Manager) Lock(id string) func() {
+	m.mu.Lock()
+	lock, ok := m.locks[id]
+	if !ok {
+		lock = &sessionLock{}
+		m.locks[id] = lock
+	}
+	lock.refs++
+	m.mu.Unlock()
+
+	lock.mu.Lock()
+
+	return func() {
+		lock.mu.Unlock()
+
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		lock.refs--
+		if lock.refs == 0 {
+			delete(m.locks, id)
+		}
+	}
+}
+
+// sweep removes expired sessions, at most once per sweepInterval
+func (m *Manager) sweep() {
+	m.mu.Lock()
+	if time.Since(m.lastSweep) < sweepInterval {
+		m.mu.Unlock()
+		return
+	}
+	m.lastSweep = time.Now()
+	m.mu.Unlock()
+
+	all, err := m.store.List()
+	if err != nil {
+		klog.V(1).Infof("store.List failed. Err: %v\n", err)
+		return
+	}
+
+	now := time.Now()
+	for _, session := range all {
+		if now.Before(session.ExpiresAt) {
+			continue
+		}
+		if err := m.store.Delete(session.ID); err != nil && !errors.Is(err, ErrSessionNotFound) {
+			klog.V(1).Infof("store.Delete failed. Err: %v\n", err)
+		}
+	}
+}
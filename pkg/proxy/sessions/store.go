@@ -0,0 +1,306 @@
+// Copyright 2023 dvonthenen ChatGPT Proxy contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache License 2.0
+
+package sessions
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	klog "k8s.io/klog/v2"
+
+	storage "github.com/dvonthenen/chat-gpeasy/pkg/proxy/storage"
+)
+
+// NewMemoryStore creates an empty in-memory store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		sessions: make(map[string]*Session),
+	}
+}
+
+func (s *MemoryStore) Get(id string) (*Session, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	session, ok := s.sessions[id]
+	if !ok {
+		return nil, ErrSessionNotFound
+	}
+	return session.clone(), nil
+}
+
+func (s *MemoryStore) Put(session *Session) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sessions[session.ID] = session.clone()
+	return nil
+}
+
+func (s *MemoryStore) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.sessions[id]; !ok {
+		return ErrSessionNotFound
+	}
+	delete(s.sessions, id)
+	return nil
+}
+
+func (s *MemoryStore) List() ([]*Session, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	sessions := make([]*Session, 0, len(s.sessions))
+	for _, session := range s.sessions {
+		sessions = append(sessions, session.clone())
+	}
+	return sessions, nil
+}
+
+// NewFileStore creates a store in the directory, which is created when missing
+func NewFileStore(dir string) (*FileStore, error) {
+	if len(dir) == 0 {
+		return nil, ErrInvalidInput
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		klog.V(1).Infof("MkdirAll failed. Err: %v\n", err)
+		return nil, err
+	}
+	return &FileStore{
+		dir: dir,
+	}, nil
+}
+
+func (s *FileStore) Get(id string) (*Session, error) {
+	path, ok := s.path(id)
+	if !ok {
+		return nil, ErrSessionNotFound
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return readSession(path)
+}
+
+func (s *FileStore) Put(session *Session) error {
+	path, ok := s.path(session.ID)
+	if !ok {
+		return ErrInvalidInput
+	}
+
+	data, err := json.Marshal(stored{
+		Session: *session,
+		Owner:   session.Owner,
+	})
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func (s *FileStore) Delete(id string) error {
+	path, ok := s.path(id)
+	if !ok {
+		return ErrSessionNotFound
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := os.Remove(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return ErrSessionNotFound
+	}
+	return err
+}
+
+func (s *FileStore) List() ([]*Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	sessions := make([]*Session, 0, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), metaExtension) {
+			continue
+		}
+		session, err := readSession(filepath.Join(s.dir, entry.Name()))
+		if err != nil {
+			klog.V(1).Infof("skipping session %s. Err: %v\n", entry.Name(), err)
+			continue
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions, nil
+}
+
+// NewKVStore creates a store in the proxy's storage
+func NewKVStore(store storage.Store) *KVStore {
+	return &KVStore{
+		store: store,
+	}
+}
+
+func (s *KVStore) Get(id string) (*Session, error) {
+	var saved stored
+	err := storage.GetJSON(s.store, keyPrefix+id, &saved)
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, ErrSessionNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	session := saved.Session
+	session.Owner = saved.Owner
+	return &session, nil
+}
+
+// Put saves the session until it expires
+func (s *KVStore) Put(session *Session) error {
+	if len(session.ID) == 0 {
+		return ErrInvalidInput
+	}
+
+	saved := stored{
+		Session: *session,
+		Owner:   session.Owner,
+	}
+	return storage.SetJSON(s.store, keyPrefix+session.ID, saved, time.Until(session.ExpiresAt))
+}
+
+func (s *KVStore) Delete(id string) error {
+	err := s.store.Delete(keyPrefix + id)
+	if errors.Is(err, storage.ErrNotFound) {
+		return ErrSessionNotFound
+	}
+	return err
+}
+
+func (s *KVStore) List() ([]*Session, error) {
+	entries, err := s.store.List(keyPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	sessions := make([]*Session, 0, len(entries))
+	for _, entry := range entries {
+		var saved stored
+		if err := json.Unmarshal(entry.Value, &saved); err != nil {
+			klog.V(1).Infof("skipping session %s. Err: %v\n", entry.Key, err)
+			continue
+		}
+		session := saved.Session
+		session.Owner = saved.Owner
+		sessions = append(sessions, &session)
+	}
+	return sessions, nil
+}
+
+// migrations of the sessions kept in the proxy's storage
+func migrations(dir string) []storage.Migration {
+	return []storage.Migration{
+		{
+			Version:     1,
+			Description: "import the session files",
+			Migrate: func(store storage.Store) error {
+				return importFiles(dir, NewKVStore(store))
+			},
+		},
+	}
+}
+
+// importFiles copies the live sessions saved as files in dir. The files are
+// left in place.
+func importFiles(dir string, store Store) error {
+	if len(dir) == 0 {
+		return nil
+	}
+	if _, err := os.Stat(dir); errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	files, err := NewFileStore(dir)
+	if err != nil {
+		return err
+	}
+	all, err := files.List()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	imported := 0
+	for _, session := range all {
+		if now.After(session.ExpiresAt) {
+			continue
+		}
+		if err := store.Put(session); err != nil {
+			return err
+		}
+		imported++
+	}
+
+	klog.V(3).Infof("imported %d sessions from %s\n", imported, dir)
+	return nil
+}
+
+// path of the session's file. IDs come from clients, so only IDs the manager
+// could have made are accepted.
+func (s *FileStore) path(id string) (string, bool) {
+	if !strings.HasPrefix(id, idPrefix) || strings.ContainsAny(id, `/\.`) {
+		return "", false
+	}
+	return filepath.Join(s.dir, id+metaExtension), true
+}
+
+func readSession(path string) (*Session, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrSessionNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var saved stored
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return nil, err
+	}
+	session := saved.Session
+	session.Owner = saved.Owner
+	return &session, nil
+}
+
+func (s *Session) clone() *Session {
+	c := *s
+	c.Messages = make([]Message, len(s.Messages))
+	for i, message := range s.Messages {
+		c.Messages[i] = message
+		if message.FunctionCall != nil {
+			call := *message.FunctionCall
+			c.Messages[i].FunctionCall = &call
+		}
+	}
+	return &c
+}
@@ -0,0 +1,145 @@
+// Copyright 2023 dvonthenen ChatGPT Proxy contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache License 2.0
+
+package sessions
+
+import (
+	"sync"
+	"time"
+
+	storage "github.com/dvonthenen/chat-gpeasy/pkg/proxy/storage"
+)
+
+// SessionOptions configures server-side conversation sessions
+type SessionOptions struct {
+	// Store keeps the sessions. When nil, sessions are kept in Storage, saved
+	// as files in Dir, or kept in memory. With both Storage and Dir, the files
+	// in Dir are imported into Storage once.
+	Store   Store
+	Storage storage.Store
+	Dir     string
+
+	// TTL is how long a session is kept after its last use, DefaultTTL when 0
+	TTL time.Duration
+
+	// MaxMessages caps the length of a conversation, unlimited when 0
+	MaxMessages int
+}
+
+// Store keeps sessions. Implementations must be safe for concurrent use.
+type Store interface {
+	// Get returns ErrSessionNotFound when there is no session with the ID
+	Get(id string) (*Session, error)
+	Put(session *Session) error
+	Delete(id string) error
+	List() ([]*Session, error)
+}
+
+// Message is one message of a conversation
+type Message struct {
+	Role         string        `json:"role"`
+	Content      string        `json:"content"`
+	Name         string        `json:"name,omitempty"`
+	FunctionCall *FunctionCall `json:"function_call,omitempty"`
+}
+
+// FunctionCall the model asked for
+type FunctionCall struct {
+	Name      string `json:"name"`
+	Arguments string `json:"arguments"`
+}
+
+// Session is a conversation kept by the proxy. Owner is a hash of the caller
+// that created it, only that caller can use it.
+type Session struct {
+	ID        string    `json:"id"`
+	Object    string    `json:"object"`
+	Model     string    `json:"model"`
+	Owner     string    `json:"-"`
+	Messages  []Message `json:"messages"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// stored is the form a session is saved in, the owner included
+type stored struct {
+	Session
+	Owner string `json:"owner"`
+}
+
+// CreateRequest is the body of POST /v1/sessions. Skill names a persona skill
+// whose system prompt starts the conversation, Messages follow it.
+type CreateRequest struct {
+	Model    string    `json:"model"`
+	Skill    string    `json:"skill"`
+	Messages []Message `json:"messages"`
+}
+
+// MessageRequest is the body of posting or editing a message, Role defaults to
+// user
+type MessageRequest struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// Reply is returned for a message posted to, or edited in, a session
+type Reply struct {
+	Object    string  `json:"object"`
+	SessionID string  `json:"session_id"`
+	Index     int     `json:"index"`
+	Message   Message `json:"message"`
+}
+
+// SessionList is returned when listing the caller's sessions
+type SessionList struct {
+	Object string     `json:"object"`
+	Data   []*Session `json:"data"`
+}
+
+// MessageList is returned when fetching the history of a session
+type MessageList struct {
+	Object string    `json:"object"`
+	Data   []Message `json:"data"`
+}
+
+// Manager creates and finds the sessions of callers
+type Manager struct {
+	options *SessionOptions
+	store   Store
+
+	// one request at a time changes a session
+	locks map[string]*sessionLock
+
+	// expired sessions are removed while creating new ones
+	lastSweep time.Time
+
+	// housekeeping
+	mu sync.Mutex
+}
+
+type sessionLock struct {
+	mu   sync.Mutex
+	refs int
+}
+
+// MemoryStore keeps sessions in memory
+type MemoryStore struct {
+	sessions map[string]*Session
+
+	// housekeeping
+	mu sync.RWMutex
+}
+
+// FileStore saves each session as a JSON file in a directory
+type FileStore struct {
+	dir string
+
+	// housekeeping
+	mu sync.Mutex
+}
+
+// KVStore keeps sessions in the proxy's storage
+type KVStore struct {
+	store storage.Store
+}
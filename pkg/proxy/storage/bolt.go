@@ -0,0 +1,246 @@
+// Copyright 2023 dvonthenen ChatGPT Proxy contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache License 2.0
+
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+	klog "k8s.io/klog/v2"
+)
+
+// NewBoltStore opens the database file, which is created when missing. Only
+// one process can hold the file, opening fails after timeout.
+func NewBoltStore(path string, timeout time.Duration) (*BoltStore, error) {
+	if len(path) == 0 {
+		return nil, ErrInvalidInput
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		klog.V(1).Infof("MkdirAll failed. Err: %v\n", err)
+		return nil, err
+	}
+
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: timeout})
+	if err != nil {
+		klog.V(1).Infof("bolt.Open failed. Err: %v\n", err)
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(bucketName)
+		return err
+	})
+	if err != nil {
+		klog.V(1).Infof("CreateBucketIfNotExists failed. Err: %v\n", err)
+		db.Close()
+		return nil, err
+	}
+
+	return &BoltStore{
+		db: db,
+	}, nil
+}
+
+func (s *BoltStore) Get(key string) (*Entry, error) {
+	var entry *Entry
+	err := s.db.View(func(tx *bolt.Tx) error {
+		value := tx.Bucket(bucketName).Get([]byte(key))
+		if value == nil {
+			return ErrNotFound
+		}
+
+		var err error
+		entry, err = decode(key, value)
+		if err != nil {
+			return err
+		}
+		if expired(entry.ExpiresAt, time.Now()) {
+			return ErrNotFound
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+func (s *BoltStore) Set(key string, value []byte, ttl time.Duration) error {
+	if len(key) == 0 {
+		return ErrInvalidInput
+	}
+
+	return s.update(func(bucket *bolt.Bucket, now time.Time) error {
+		return bucket.Put([]byte(key), encode(value, expiry(now, ttl)))
+	})
+}
+
+func (s *BoltStore) Delete(key string) error {
+	// an error from fn rolls the transaction back, with the delete of an
+	// expired entry and the sweep, so a missing entry is reported afterwards
+	found := false
+	err := s.update(func(bucket *bolt.Bucket, now time.Time) error {
+		value := bucket.Get([]byte(key))
+		if value == nil {
+			return nil
+		}
+		entry, err := decode(key, value)
+		if err != nil {
+			return err
+		}
+		if err := bucket.Delete([]byte(key)); err != nil {
+			return err
+		}
+
+		found = !expired(entry.ExpiresAt, now)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if !found {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *BoltStore) Incr(key string, delta int64, ttl time.Duration) (int64, time.Time, error) {
+	if len(key) == 0 {
+		return 0, time.Time{}, ErrInvalidInput
+	}
+
+	var count int64
+	var expiresAt time.Time
+	err := s.update(func(bucket *bolt.Bucket, now time.Time) error {
+		entry := &Entry{
+			Key:       key,
+			Value:     []byte("0"),
+			ExpiresAt: expiry(now, ttl),
+		}
+		if value := bucket.Get([]byte(key)); value != nil {
+			stored, err := decode(key, value)
+			if err != nil {
+				return err
+			}
+			if !expired(stored.ExpiresAt, now) {
+				entry = stored
+			}
+		}
+
+		value, total, err := addCounter(entry.Value, delta)
+		if err != nil {
+			return err
+		}
+		count, expiresAt = total, entry.ExpiresAt
+		return bucket.Put([]byte(key), encode(value, entry.ExpiresAt))
+	})
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	return count, expiresAt, nil
+}
+
+func (s *BoltStore) List(prefix string) ([]Entry, error) {
+	entries := make([]Entry, 0)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		now := time.Now()
+		c := tx.Bucket(bucketName).Cursor()
+		for k, v := c.Seek([]byte(prefix)); k != nil && bytes.HasPrefix(k, []byte(prefix)); k, v = c.Next() {
+			entry, err := decode(string(k), v)
+			if err != nil {
+				klog.V(1).Infof("skipping %s. Err: %v\n", k, err)
+				continue
+			}
+			if !expired(entry.ExpiresAt, now) {
+				entries = append(entries, *entry)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+func (s *BoltStore) Close() error {
+	return s.db.Close()
+}
+
+// update runs fn in a write transaction, removing expired keys at most once per
+// sweepInterval
+func (s *BoltStore) update(fn func(bucket *bolt.Bucket, now time.Time) error) error {
+	now := time.Now()
+
+	s.mu.Lock()
+	sweep := now.Sub(s.lastSweep) >= sweepInterval
+	if sweep {
+		s.lastSweep = now
+	}
+	s.mu.Unlock()
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(bucketName)
+		if sweep {
+			if err := sweepBucket(bucket, now); err != nil {
+				return err
+			}
+		}
+		return fn(bucket, now)
+	})
+}
+
+func sweepBucket(bucket *bolt.Bucket, now time.Time) error {
+	var keys [][]byte
+	err := bucket.ForEach(func(k, v []byte) error {
+		if len(v) >= expiryLen && expired(expiresAt(v), now) {
+			keys = append(keys, append([]byte{}, k...))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, k := range keys {
+		if err := bucket.Delete(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// encode puts the expiry in front of the value, 0 when it does not expire
+func encode(value []byte, expiresAt time.Time) []byte {
+	data := make([]byte, expiryLen+len(value))
+	if !expiresAt.IsZero() {
+		binary.BigEndian.PutUint64(data, uint64(expiresAt.UnixNano()))
+	}
+	copy(data[expiryLen:], value)
+	return data
+}
+
+// decode copies the stored value, which is only valid during its transaction
+func decode(key string, data []byte) (*Entry, error) {
+	if len(data) < expiryLen {
+		return nil, ErrCorruptValue
+	}
+	return &Entry{
+		Key:       key,
+		Value:     append([]byte{}, data[expiryLen:]...),
+		ExpiresAt: expiresAt(data),
+	}, nil
+}
+
+func expiresAt(data []byte) time.Time {
+	nanos := binary.BigEndian.Uint64(data)
+	if nanos == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, int64(nanos))
+}
@@ -0,0 +1,52 @@
+// Copyright 2023 dvonthenen ChatGPT Proxy contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache License 2.0
+
+package storage
+
+import (
+	"errors"
+	"time"
+)
+
+const (
+	// DefaultFile is the database file created in Dir
+	DefaultFile string = "proxy.db"
+
+	// DefaultOpenTimeout is how long to wait for another process to release
+	// the database file
+	DefaultOpenTimeout time.Duration = 5 * time.Second
+
+	// sweepInterval is the least time between removing expired keys
+	sweepInterval time.Duration = time.Minute
+
+	// schemaPrefix holds the schema version of each component
+	schemaPrefix string = "schema/"
+
+	// expiryLen is the size of the expiry in front of a stored value
+	expiryLen int = 8
+)
+
+var (
+	// bucket holding every key of the file-backed store
+	bucketName = []byte("kv")
+)
+
+var (
+	// ErrInvalidInput required input was not found
+	ErrInvalidInput = errors.New("required input was not found")
+
+	// ErrNotFound the key does not exist or has expired
+	ErrNotFound = errors.New("key not found")
+
+	// ErrNotCounter the key holds a value that is not a counter
+	ErrNotCounter = errors.New("value is not a counter")
+
+	// ErrInvalidMigration migrations must have increasing versions above 0
+	ErrInvalidMigration = errors.New("migration versions must be increasing and above 0")
+
+	// ErrSchemaTooNew the stored schema was written by a newer release
+	ErrSchemaTooNew = errors.New("stored schema is newer than this release")
+
+	// ErrCorruptValue a stored value could not be decoded
+	ErrCorruptValue = errors.New("stored value is corrupt")
+)
@@ -0,0 +1,137 @@
+// Copyright 2023 dvonthenen ChatGPT Proxy contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache License 2.0
+
+package storage
+
+import (
+	"sort"
+	"strings"
+	"time"
+)
+
+// NewMemoryStore creates an empty in-memory store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		entries: make(map[string]*Entry),
+	}
+}
+
+func (s *MemoryStore) Get(key string) (*Entry, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	entry, ok := s.entries[key]
+	if !ok || expired(entry.ExpiresAt, time.Now()) {
+		return nil, ErrNotFound
+	}
+	return entry.clone(), nil
+}
+
+func (s *MemoryStore) Set(key string, value []byte, ttl time.Duration) error {
+	if len(key) == 0 {
+		return ErrInvalidInput
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.sweep(now)
+
+	s.entries[key] = &Entry{
+		Key:       key,
+		Value:     append([]byte{}, value...),
+		ExpiresAt: expiry(now, ttl),
+	}
+	return nil
+}
+
+func (s *MemoryStore) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.entries[key]
+	if !ok {
+		return ErrNotFound
+	}
+	delete(s.entries, key)
+
+	if expired(entry.ExpiresAt, time.Now()) {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *MemoryStore) Incr(key string, delta int64, ttl time.Duration) (int64, time.Time, error) {
+	if len(key) == 0 {
+		return 0, time.Time{}, ErrInvalidInput
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.sweep(now)
+
+	entry, ok := s.entries[key]
+	if !ok || expired(entry.ExpiresAt, now) {
+		entry = &Entry{
+			Key:       key,
+			Value:     []byte("0"),
+			ExpiresAt: expiry(now, ttl),
+		}
+	}
+
+	value, count, err := addCounter(entry.Value, delta)
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	s.entries[key] = &Entry{
+		Key:       key,
+		Value:     value,
+		ExpiresAt: entry.ExpiresAt,
+	}
+	return count, entry.ExpiresAt, nil
+}
+
+func (s *MemoryStore) List(prefix string) ([]Entry, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	now := time.Now()
+	entries := make([]Entry, 0)
+	for key, entry := range s.entries {
+		if strings.HasPrefix(key, prefix) && !expired(entry.ExpiresAt, now) {
+			entries = append(entries, *entry.clone())
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Key < entries[j].Key
+	})
+	return entries, nil
+}
+
+func (s *MemoryStore) Close() error {
+	return nil
+}
+
+// sweep removes expired keys, at most once per sweepInterval. must be called
+// with mu held.
+func (s *MemoryStore) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < sweepInterval {
+		return
+	}
+	s.lastSweep = now
+
+	for key, entry := range s.entries {
+		if expired(entry.ExpiresAt, now) {
+			delete(s.entries, key)
+		}
+	}
+}
+
+func (e *Entry) clone() *Entry {
+	c := *e
+	c.Value = append([]byte{}, e.Value...)
+	return &c
+}
@@ -0,0 +1,139 @@
+// Copyright 2023 dvonthenen ChatGPT Proxy contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache License 2.0
+
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	klog "k8s.io/klog/v2"
+)
+
+// New returns the store in the options
+func New(options StorageOptions) (Store, error) {
+	if options.Store != nil {
+		return options.Store, nil
+	}
+	if len(options.Dir) == 0 {
+		return NewMemoryStore(), nil
+	}
+
+	if len(options.File) == 0 {
+		options.File = DefaultFile
+	}
+	if options.OpenTimeout <= 0 {
+		options.OpenTimeout = DefaultOpenTimeout
+	}
+
+	store, err := NewBoltStore(filepath.Join(options.Dir, options.File), options.OpenTimeout)
+	if err != nil {
+		klog.V(1).Infof("NewBoltStore failed. Err: %v\n", err)
+		return nil, err
+	}
+	return store, nil
+}
+
+// Migrate brings the state of a component up to its last migration. Pending
+// migrations run once, in version order. The version is saved after each one,
+// so a failed migration runs again on the next start.
+func Migrate(store Store, component string, migrations []Migration) error {
+	if store == nil || len(component) == 0 {
+		return ErrInvalidInput
+	}
+	for i, migration := range migrations {
+		if migration.Version <= 0 || migration.Migrate == nil || (i > 0 && migration.Version <= migrations[i-1].Version) {
+			return ErrInvalidMigration
+		}
+	}
+
+	current, err := Version(store, component)
+	if err != nil {
+		return err
+	}
+	if len(migrations) > 0 && current > migrations[len(migrations)-1].Version {
+		klog.V(1).Infof("%s schema is at version %d, this release knows %d\n", component, current, migrations[len(migrations)-1].Version)
+		return ErrSchemaTooNew
+	}
+
+	for _, migration := range migrations {
+		if migration.Version <= current {
+			continue
+		}
+
+		klog.V(3).Infof("migrating %s to version %d: %s\n", component, migration.Version, migration.Description)
+		if err := migration.Migrate(store); err != nil {
+			klog.V(1).Infof("%s migration %d failed. Err: %v\n", component, migration.Version, err)
+			return err
+		}
+
+		value := []byte(strconv.Itoa(migration.Version))
+		if err := store.Set(schemaPrefix+component, value, 0); err != nil {
+			return err
+		}
+		current = migration.Version
+	}
+
+	return nil
+}
+
+// Version returns the schema version of a component, 0 before its first
+// migration
+func Version(store Store, component string) (int, error) {
+	entry, err := store.Get(schemaPrefix + component)
+	if errors.Is(err, ErrNotFound) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	version, err := strconv.Atoi(string(entry.Value))
+	if err != nil {
+		return 0, ErrCorruptValue
+	}
+	return version, nil
+}
+
+// GetJSON decodes the value of the key into v
+func GetJSON(store Store, key string, v interface{}) error {
+	entry, err := store.Get(key)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(entry.Value, v)
+}
+
+// SetJSON saves v encoded as JSON, see Store.Set
+func SetJSON(store Store, key string, v interface{}, ttl time.Duration) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return store.Set(key, data, ttl)
+}
+
+// expiry returns when a value saved now with the ttl expires
+func expiry(now time.Time, ttl time.Duration) time.Time {
+	if ttl <= 0 {
+		return time.Time{}
+	}
+	return now.Add(ttl)
+}
+
+func expired(expiresAt, now time.Time) bool {
+	return !expiresAt.IsZero() && !now.Before(expiresAt)
+}
+
+// addCounter adds delta to a stored counter value
+func addCounter(value []byte, delta int64) ([]byte, int64, error) {
+	count, err := strconv.ParseInt(string(value), 10, 64)
+	if err != nil {
+		return nil, 0, ErrNotCounter
+	}
+	count += delta
+	return []byte(strconv.FormatInt(count, 10)), count, nil
+}
@@ -0,0 +1,256 @@
+// Copyright 2023 dvonthenen ChatGPT Proxy contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache License 2.0
+
+package storage
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	testTTL time.Duration = 20 * time.Millisecond
+)
+
+// testStore is a store with access to what it keeps, expired or not
+type testStore struct {
+	Store
+
+	// stored reports whether the key is still kept
+	stored func(key string) bool
+
+	// sweepDue lets the next write sweep
+	sweepDue func()
+}
+
+func newTestStores(t *testing.T) map[string]func() *testStore {
+	t.Helper()
+
+	return map[string]func() *testStore{
+		"memory": func() *testStore {
+			s := NewMemoryStore()
+			return &testStore{
+				Store: s,
+				stored: func(key string) bool {
+					s.mu.RLock()
+					defer s.mu.RUnlock()
+					_, ok := s.entries[key]
+					return ok
+				},
+				sweepDue: func() {
+					s.mu.Lock()
+					defer s.mu.Unlock()
+					s.lastSweep = time.Time{}
+				},
+			}
+		},
+		"bolt": func() *testStore {
+			s, err := NewBoltStore(filepath.Join(t.TempDir(), DefaultFile), time.Second)
+			if err != nil {
+				t.Fatalf("NewBoltStore failed. Err: %v", err)
+			}
+			t.Cleanup(func() { s.Close() })
+			return &testStore{
+				Store: s,
+				stored: func(key string) bool {
+					found := false
+					s.db.View(func(tx *bolt.Tx) error { //nolint:errcheck
+						found = tx.Bucket(bucketName).Get([]byte(key)) != nil
+						return nil
+					})
+					return found
+				},
+				sweepDue: func() {
+					s.mu.Lock()
+					defer s.mu.Unlock()
+					s.lastSweep = time.Time{}
+				},
+			}
+		},
+	}
+}
+
+func set(t *testing.T, s Store, key string, ttl time.Duration) {
+	t.Helper()
+
+	if err := s.Set(key, []byte("value"), ttl); err != nil {
+		t.Fatalf("Set failed. Err: %v", err)
+	}
+}
+
+func TestTTL(t *testing.T) {
+	tests := []struct {
+		name string
+		run  func(t *testing.T, s *testStore)
+	}{
+		{
+			name: "get",
+			run: func(t *testing.T, s *testStore) {
+				set(t, s, "key", testTTL)
+				entry, err := s.Get("key")
+				if err != nil {
+					t.Fatalf("Get failed. Err: %v", err)
+				}
+				if entry.ExpiresAt.IsZero() || time.Until(entry.ExpiresAt) > testTTL {
+					t.Errorf("unexpected expiry %v", entry.ExpiresAt)
+				}
+
+				time.Sleep(2 * testTTL)
+				if _, err := s.Get("key"); !errors.Is(err, ErrNotFound) {
+					t.Errorf("expected %v, got %v", ErrNotFound, err)
+				}
+			},
+		},
+		{
+			name: "no ttl",
+			run: func(t *testing.T, s *testStore) {
+				set(t, s, "key", 0)
+				time.Sleep(2 * testTTL)
+				entry, err := s.Get("key")
+				if err != nil {
+					t.Fatalf("Get failed. Err: %v", err)
+				}
+				if !entry.ExpiresAt.IsZero() {
+					t.Errorf("expected no expiry, got %v", entry.ExpiresAt)
+				}
+			},
+		},
+		{
+			name: "list",
+			run: func(t *testing.T, s *testStore) {
+				set(t, s, "list/expiring", testTTL)
+				set(t, s, "list/kept", 0)
+				set(t, s, "other", 0)
+				time.Sleep(2 * testTTL)
+
+				entries, err := s.List("list/")
+				if err != nil {
+					t.Fatalf("List failed. Err: %v", err)
+				}
+				if len(entries) != 1 || entries[0].Key != "list/kept" {
+					t.Errorf("expected only list/kept, got %+v", entries)
+				}
+			},
+		},
+		{
+			name: "delete",
+			run: func(t *testing.T, s *testStore) {
+				set(t, s, "live", testTTL)
+				set(t, s, "expired", testTTL)
+				if err := s.Delete("live"); err != nil {
+					t.Errorf("Delete failed. Err: %v", err)
+				}
+
+				time.Sleep(2 * testTTL)
+				if err := s.Delete("expired"); !errors.Is(err, ErrNotFound) {
+					t.Errorf("expected %v, got %v", ErrNotFound, err)
+				}
+				if err := s.Delete("missing"); !errors.Is(err, ErrNotFound) {
+					t.Errorf("expected %v, got %v", ErrNotFound, err)
+				}
+
+				// an expired key is removed even though it is reported missing
+				if s.stored("live") || s.stored("expired") {
+					t.Errorf("deleted keys are still stored")
+				}
+			},
+		},
+		{
+			name: "incr",
+			run: func(t *testing.T, s *testStore) {
+				count, first, err := s.Incr("counter", 1, testTTL)
+				if err != nil || count != 1 {
+					t.Fatalf("expected 1, got %d. Err: %v", count, err)
+				}
+				count, second, err := s.Incr("counter", 2, time.Hour)
+				if err != nil || count != 3 || !second.Equal(first) {
+					t.Fatalf("expected 3 with the first expiry, got %d %v. Err: %v", count, second, err)
+				}
+
+				// the window is over, the counter starts again
+				time.Sleep(2 * testTTL)
+				count, third, err := s.Incr("counter", 1, testTTL)
+				if err != nil || count != 1 || !third.After(first) {
+					t.Fatalf("expected a new counter, got %d %v. Err: %v", count, third, err)
+				}
+			},
+		},
+		{
+			name: "sweep",
+			run: func(t *testing.T, s *testStore) {
+				set(t, s, "expiring", testTTL)
+				set(t, s, "kept", 0)
+				time.Sleep(2 * testTTL)
+
+				// a write within the sweep interval leaves expired keys alone
+				set(t, s, "write", 0)
+				if !s.stored("expiring") {
+					t.Fatalf("expired key was swept before the interval")
+				}
+
+				s.sweepDue()
+				set(t, s, "write", 0)
+				if s.stored("expiring") {
+					t.Errorf("expired key was not swept")
+				}
+				if !s.stored("kept") || !s.stored("write") {
+					t.Errorf("live keys were swept")
+				}
+			},
+		},
+	}
+
+	for storeName, newStore := range newTestStores(t) {
+		for _, tt := range tests {
+			t.Run(storeName+" "+tt.name, func(t *testing.T) {
+				s := newStore()
+
+				// the first write of a new store sweeps, get it out of the way
+				set(t, s, "first", 0)
+
+				tt.run(t, s)
+			})
+		}
+	}
+}
+
+func TestBoltReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), DefaultFile)
+
+	s, err := NewBoltStore(path, time.Second)
+	if err != nil {
+		t.Fatalf("NewBoltStore failed. Err: %v", err)
+	}
+	set(t, s, "expiring", time.Hour)
+	set(t, s, "expired", testTTL)
+	saved, err := s.Get("expiring")
+	if err != nil {
+		t.Fatalf("Get failed. Err: %v", err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close failed. Err: %v", err)
+	}
+
+	time.Sleep(2 * testTTL)
+
+	s, err = NewBoltStore(path, time.Second)
+	if err != nil {
+		t.Fatalf("NewBoltStore failed. Err: %v", err)
+	}
+	defer s.Close()
+
+	loaded, err := s.Get("expiring")
+	if err != nil {
+		t.Fatalf("Get failed. Err: %v", err)
+	}
+	if !loaded.ExpiresAt.Equal(saved.ExpiresAt) {
+		t.Errorf("expected expiry %v, got %v", saved.ExpiresAt, loaded.ExpiresAt)
+	}
+	if _, err := s.Get("expired"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected %v, got %v", ErrNotFound, err)
+	}
+}
@@ -0,0 +1,86 @@
+// Copyright 2023 dvonthenen ChatGPT Proxy contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache License 2.0
+
+package storage
+
+import (
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// StorageOptions configures where the proxy keeps its state
+type StorageOptions struct {
+	// Store keeps the state. When nil, it is saved in File in Dir, or kept in
+	// memory without a Dir.
+	Store Store
+	Dir   string
+
+	// File is the database file in Dir, DefaultFile when empty
+	File string
+
+	// OpenTimeout is how long to wait for the database file to be released by
+	// another process, DefaultOpenTimeout when 0
+	OpenTimeout time.Duration
+}
+
+// Store is a key value store. Keys are namespaced by a prefix per component,
+// like "sessions/". Implementations must be safe for concurrent use.
+type Store interface {
+	// Get returns ErrNotFound when the key does not exist or has expired
+	Get(key string) (*Entry, error)
+
+	// Set saves the value, which expires after ttl unless ttl is 0
+	Set(key string, value []byte, ttl time.Duration) error
+
+	// Delete returns ErrNotFound when the key does not exist or has expired
+	Delete(key string) error
+
+	// Incr atomically adds delta to a counter and returns its value and
+	// expiry. A missing counter starts at 0 and expires after ttl unless ttl is
+	// 0, an existing counter keeps its expiry.
+	Incr(key string, delta int64, ttl time.Duration) (int64, time.Time, error)
+
+	// List returns the live entries whose key starts with prefix, in key order
+	List(prefix string) ([]Entry, error)
+
+	Close() error
+}
+
+// Entry is a stored value. ExpiresAt is zero for values that do not expire.
+type Entry struct {
+	Key       string
+	Value     []byte
+	ExpiresAt time.Time
+}
+
+// Migration changes the stored state of a component from the previous version
+// to Version
+type Migration struct {
+	Version     int
+	Description string
+	Migrate     func(store Store) error
+}
+
+// MemoryStore keeps the state in memory, it is lost on restart
+type MemoryStore struct {
+	entries map[string]*Entry
+
+	// expired keys are removed while writing
+	lastSweep time.Time
+
+	// housekeeping
+	mu sync.RWMutex
+}
+
+// BoltStore keeps the state in an embedded database file
+type BoltStore struct {
+	db *bolt.DB
+
+	// expired keys are removed while writing
+	lastSweep time.Time
+
+	// housekeeping
+	mu sync.Mutex
+}
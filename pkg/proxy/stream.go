@@ -0,0 +1,432 @@
+// Copyright 2023 dvonthenen ChatGPT Proxy contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache License 2.0
+
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	openai "github.com/sashabaranov/go-openai"
+	klog "k8s.io/klog/v2"
+
+	interfaces "github.com/dvonthenen/chat-gpeasy/pkg/proxy/interfaces"
+	output "github.com/dvonthenen/chat-gpeasy/pkg/proxy/output"
+)
+
+// streamChatCompletion relays a streamed chat completion to the client as
+// server-sent events. Content passes through the output filter, which holds
+// text back until it is safe to send. The upstream slot is held until the
+// stream ends.
+func (p *ChatGPTProxy) streamChatCompletion(ctx context.Context, c *gin.Context, name, variant string, client *openai.Client, request openai.ChatCompletionRequest) {
+	klog.V(6).Infof("streamChatCompletion ENTER\n")
+
+	resp := openai.ChatCompletionResponse{
+		Object: chatCompletionObject,
+	}
+	choices := make(map[int]*openai.ChatCompletionChoice)
+	started := false
+
+	start := time.Now()
+	err := p.upstream(ctx, name, c.FullPath(), func(ctx context.Context) error {
+		upstreamClient := p.variantClient(ctx, variant, client)
+		stream, err := upstreamClient.CreateChatCompletionStream(ctx, request)
+		if err != nil {
+			return err
+		}
+		defer stream.Close()
+		streamOpened(ctx)
+
+		filter := p.newOutputStream(ctx, upstreamClient)
+		if filter != nil {
+			defer func() {
+				p.recordOutput(ctx, filter.Finish())
+			}()
+		}
+		open := make(map[int]bool)
+
+		started = true
+		startStream(c)
+
+		for {
+			chunk, err := stream.Recv()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				klog.V(1).Infof("stream.Recv failed. Err: %v\n", err)
+				writeStreamError(c, err)
+				return err
+			}
+			if c.Request.Context().Err() != nil {
+				klog.V(3).Infof("client left the stream\n")
+				return nil
+			}
+
+			resp.ID, resp.Created, resp.Model = chunk.ID, chunk.Created, chunk.Model
+
+			send := false
+			for i := range chunk.Choices {
+				choice := &chunk.Choices[i]
+				open[choice.Index] = true
+
+				text, blocked := filterDelta(filter, choice.Index, choice.Delta.Content, len(choice.FinishReason) > 0)
+				if blocked {
+					p.writeChatBlocked(c, chunk, open, choices)
+					return nil
+				}
+				if len(choice.FinishReason) > 0 {
+					delete(open, choice.Index)
+				}
+
+				choice.Delta.Content = text
+				accumulateChatChoice(choices, *choice)
+				send = send || len(text) > 0 || len(choice.Delta.Role) > 0 || choice.Delta.FunctionCall != nil || len(choice.FinishReason) > 0
+			}
+			if send {
+				writeStreamEvent(c, chunk)
+			}
+		}
+
+		// choices the upstream never finished still have text held back
+		for index := range open {
+			text, blocked := filterDelta(filter, index, "", true)
+			if blocked {
+				p.writeChatBlocked(c, openai.ChatCompletionStreamResponse{ID: resp.ID, Object: chatCompletionChunkObject, Created: resp.Created, Model: resp.Model}, open, choices)
+				return nil
+			}
+			if len(text) == 0 {
+				continue
+			}
+			choice := openai.ChatCompletionStreamChoice{
+				Index: index,
+				Delta: openai.ChatCompletionStreamChoiceDelta{Content: text},
+			}
+			accumulateChatChoice(choices, choice)
+			writeStreamEvent(c, openai.ChatCompletionStreamResponse{
+				ID:      resp.ID,
+				Object:  chatCompletionChunkObject,
+				Created: resp.Created,
+				Model:   resp.Model,
+				Choices: []openai.ChatCompletionStreamChoice{choice},
+			})
+		}
+		endStream(c)
+
+		return nil
+	})
+
+	indexes := make([]int, 0, len(choices))
+	for index := range choices {
+		indexes = append(indexes, index)
+	}
+	sort.Ints(indexes)
+	for _, index := range indexes {
+		resp.Choices = append(resp.Choices, *choices[index])
+	}
+
+	// the experiment compares the reply the client was streamed
+	if p.experiment != nil {
+		p.experiment.Observe(variant, callerID(c, request.User), request, &resp, err, time.Since(start))
+	}
+
+	if err != nil && !started {
+		klog.V(6).Infof("client.CreateChatCompletionStream failed. Err: %v\n", err)
+		klog.V(6).Infof("streamChatCompletion LEAVE\n")
+		abortUpstream(c, err, "chat completion failed")
+		return
+	}
+
+	p.recordUsage(ctx, request, resp)
+
+	if p.callback != nil {
+		klog.V(6).Infof("CreateChatCompletion Callback...\n")
+		err = p.dispatch(ctx, interfaces.EventTypeCreateChatCompletion, request, resp)
+		if err != nil {
+			klog.V(1).Infof("[CALLBACK] CreateChatCompletion failed. Err: %v\n", err)
+		}
+	}
+
+	klog.V(4).Infof("streamChatCompletion Succeeded\n")
+	klog.V(6).Infof("streamChatCompletion LEAVE\n")
+}
+
+// streamCompletion relays a streamed completion to the client as server-sent
+// events, see streamChatCompletion. A translated completion is streamed with its
+// chat requests.
+func (p *ChatGPTProxy) streamCompletion(ctx context.Context, c *gin.Context, request openai.CompletionRequest, chatRequests []openai.ChatCompletionRequest) {
+	klog.V(6).Infof("streamCompletion ENTER\n")
+
+	resp := openai.CompletionResponse{
+		Object: textCompletionObject,
+	}
+	choices := make(map[int]*openai.CompletionChoice)
+	started := false
+
+	err := p.upstream(ctx, upstreamOpenAI, c.FullPath(), func(ctx context.Context) error {
+		upstreamClient := p.client(ctx)
+		stream, err := p.createCompletionStream(ctx, upstreamClient, request, chatRequests)
+		if err != nil {
+			return err
+		}
+		defer stream.Close()
+		streamOpened(ctx)
+
+		filter := p.newOutputStream(ctx, upstreamClient)
+		if filter != nil {
+			defer func() {
+				p.recordOutput(ctx, filter.Finish())
+			}()
+		}
+		open := make(map[int]bool)
+
+		started = true
+		startStream(c)
+
+		for {
+			chunk, err := stream.Recv()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				klog.V(1).Infof("stream.Recv failed. Err: %v\n", err)
+				writeStreamError(c, err)
+				return err
+			}
+			if c.Request.Context().Err() != nil {
+				klog.V(3).Infof("client left the stream\n")
+				return nil
+			}
+
+			resp.ID, resp.Created, resp.Model = chunk.ID, chunk.Created, chunk.Model
+
+			send := false
+			for i := range chunk.Choices {
+				choice := &chunk.Choices[i]
+				open[choice.Index] = true
+
+				text, blocked := filterDelta(filter, choice.Index, choice.Text, len(choice.FinishReason) > 0)
+				if blocked {
+					p.writeCompletionBlocked(c, chunk, open, choices)
+					return nil
+				}
+				if len(choice.FinishReason) > 0 {
+					delete(open, choice.Index)
+				}
+
+				choice.Text = text
+				accumulateCompletionChoice(choices, *choice)
+				send = send || len(text) > 0 || len(choice.FinishReason) > 0
+			}
+			if send {
+				writeStreamEvent(c, chunk)
+			}
+		}
+
+		// choices the upstream never finished still have text held back
+		for index := range open {
+			text, blocked := filterDelta(filter, index, "", true)
+			if blocked {
+				p.writeCompletionBlocked(c, openai.CompletionResponse{ID: resp.ID, Object: resp.Object, Created: resp.Created, Model: resp.Model}, open, choices)
+				return nil
+			}
+			if len(text) == 0 {
+				continue
+			}
+			choice := openai.CompletionChoice{
+				Index: index,
+				Text:  text,
+			}
+			accumulateCompletionChoice(choices, choice)
+			writeStreamEvent(c, openai.CompletionResponse{
+				ID:      resp.ID,
+				Object:  resp.Object,
+				Created: resp.Created,
+				Model:   resp.Model,
+				Choices: []openai.CompletionChoice{choice},
+			})
+		}
+		endStream(c)
+
+		return nil
+	})
+	if err != nil && !started {
+		klog.V(6).Infof("client.CreateCompletionStream failed. Err: %v\n", err)
+		klog.V(6).Infof("streamCompletion LEAVE\n")
+		abortUpstream(c, err, "completion failed")
+		return
+	}
+
+	indexes := make([]int, 0, len(choices))
+	for index := range choices {
+		indexes = append(indexes, index)
+	}
+	sort.Ints(indexes)
+	for _, index := range indexes {
+		resp.Choices = append(resp.Choices, *choices[index])
+	}
+
+	p.recordUsage(ctx, request, resp)
+
+	if p.callback != nil {
+		klog.V(6).Infof("CreateCompletion Callback...\n")
+		err = p.dispatch(ctx, interfaces.EventTypeCreateCompletion, request, resp)
+		if err != nil {
+			klog.V(1).Infof("[CALLBACK] CreateCompletion failed. Err: %v\n", err)
+		}
+	}
+
+	klog.V(4).Infof("streamCompletion Succeeded\n")
+	klog.V(6).Infof("streamCompletion LEAVE\n")
+}
+
+// newOutputStream starts filtering a stream, nil without an output filter
+func (p *ChatGPTProxy) newOutputStream(ctx context.Context, client *openai.Client) *output.Stream {
+	if p.output == nil {
+		return nil
+	}
+	return p.output.NewStream(ctx, p.streamModerator(client))
+}
+
+// filterDelta passes a chunk of a choice through the stream filter and flushes
+// the choice when it is finished. It returns the text to send and true when the
+// response is blocked.
+func filterDelta(filter *output.Stream, index int, delta string, finished bool) (string, bool) {
+	if filter == nil {
+		return delta, false
+	}
+
+	text, blocked := filter.Write(index, delta)
+	if blocked || !finished {
+		return text, blocked
+	}
+	rest, blocked := filter.Close(index)
+	return text + rest, blocked
+}
+
+// writeChatBlocked ends every open choice of a blocked chat stream with the
+// block message
+func (p *ChatGPTProxy) writeChatBlocked(c *gin.Context, chunk openai.ChatCompletionStreamResponse, open map[int]bool, choices map[int]*openai.ChatCompletionChoice) {
+	chunk.Choices = nil
+	for _, index := range sortedIndexes(open) {
+		choice := openai.ChatCompletionStreamChoice{
+			Index:        index,
+			Delta:        openai.ChatCompletionStreamChoiceDelta{Content: p.output.BlockMessage()},
+			FinishReason: openai.FinishReasonContentFilter,
+		}
+		accumulateChatChoice(choices, choice)
+		chunk.Choices = append(chunk.Choices, choice)
+	}
+	writeStreamEvent(c, chunk)
+	endStream(c)
+}
+
+// writeCompletionBlocked ends every open choice of a blocked completion stream
+// with the block message
+func (p *ChatGPTProxy) writeCompletionBlocked(c *gin.Context, chunk openai.CompletionResponse, open map[int]bool, choices map[int]*openai.CompletionChoice) {
+	chunk.Choices = nil
+	for _, index := range sortedIndexes(open) {
+		choice := openai.CompletionChoice{
+			Index:        index,
+			Text:         p.output.BlockMessage(),
+			FinishReason: string(openai.FinishReasonContentFilter),
+		}
+		accumulateCompletionChoice(choices, choice)
+		chunk.Choices = append(chunk.Choices, choice)
+	}
+	writeStreamEvent(c, chunk)
+	endStream(c)
+}
+
+// accumulateChatChoice adds a streamed chunk to the choice of the response the
+// callback gets
+func accumulateChatChoice(choices map[int]*openai.ChatCompletionChoice, chunk openai.ChatCompletionStreamChoice) {
+	choice, ok := choices[chunk.Index]
+	if !ok {
+		choice = &openai.ChatCompletionChoice{
+			Index: chunk.Index,
+			Message: openai.ChatCompletionMessage{
+				Role: openai.ChatMessageRoleAssistant,
+			},
+		}
+		choices[chunk.Index] = choice
+	}
+
+	if len(chunk.Delta.Role) > 0 {
+		choice.Message.Role = chunk.Delta.Role
+	}
+	choice.Message.Content += chunk.Delta.Content
+	if chunk.Delta.FunctionCall != nil {
+		if choice.Message.FunctionCall == nil {
+			choice.Message.FunctionCall = &openai.FunctionCall{}
+		}
+		choice.Message.FunctionCall.Name += chunk.Delta.FunctionCall.Name
+		choice.Message.FunctionCall.Arguments += chunk.Delta.FunctionCall.Arguments
+	}
+	if len(chunk.FinishReason) > 0 {
+		choice.FinishReason = chunk.FinishReason
+	}
+}
+
+// accumulateCompletionChoice adds a streamed chunk to the choice of the
+// response the callback gets
+func accumulateCompletionChoice(choices map[int]*openai.CompletionChoice, chunk openai.CompletionChoice) {
+	choice, ok := choices[chunk.Index]
+	if !ok {
+		choice = &openai.CompletionChoice{
+			Index: chunk.Index,
+		}
+		choices[chunk.Index] = choice
+	}
+
+	choice.Text += chunk.Text
+	if len(chunk.FinishReason) > 0 {
+		choice.FinishReason = chunk.FinishReason
+	}
+}
+
+func sortedIndexes(set map[int]bool) []int {
+	indexes := make([]int, 0, len(set))
+	for index := range set {
+		indexes = append(indexes, index)
+	}
+	sort.Ints(indexes)
+	return indexes
+}
+
+// startStream writes the headers of a server-sent event stream
+func startStream(c *gin.Context) {
+	c.Header("Content-Type", streamContentType)
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Status(http.StatusOK)
+	c.Writer.Flush()
+}
+
+// writeStreamEvent writes one data event and flushes it to the client
+func writeStreamEvent(c *gin.Context, data interface{}) {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		klog.V(1).Infof("json.Marshal failed. Err: %v\n", err)
+		return
+	}
+	c.Writer.WriteString(streamDataPrefix + string(payload) + "\n\n")
+	c.Writer.Flush()
+}
+
+// writeStreamError tells the client the stream failed part way
+func writeStreamError(c *gin.Context, err error) {
+	writeStreamEvent(c, gin.H{"error": gin.H{"message": err.Error()}})
+}
+
+// endStream writes the event that ends the stream
+func endStream(c *gin.Context) {
+	c.Writer.WriteString(streamDataPrefix + streamDone + "\n\n")
+	c.Writer.Flush()
+}
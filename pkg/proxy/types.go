@@ -4,11 +4,36 @@
 package proxy
 
 import (
+	"context"
 	"net/http"
+	"strings"
+	"sync"
+	"time"
 
+	websocket "github.com/dvonthenen/websocket"
+	"github.com/gin-gonic/gin"
 	openai "github.com/sashabaranov/go-openai"
 
+	audio "github.com/dvonthenen/chat-gpeasy/pkg/audio"
+	personainterfaces "github.com/dvonthenen/chat-gpeasy/pkg/personas/interfaces"
+	auth "github.com/dvonthenen/chat-gpeasy/pkg/proxy/auth"
+	batch "github.com/dvonthenen/chat-gpeasy/pkg/proxy/batch"
+	breaker "github.com/dvonthenen/chat-gpeasy/pkg/proxy/breaker"
+	cache "github.com/dvonthenen/chat-gpeasy/pkg/proxy/cache"
+	coalesce "github.com/dvonthenen/chat-gpeasy/pkg/proxy/coalesce"
+	experiment "github.com/dvonthenen/chat-gpeasy/pkg/proxy/experiment"
+	guard "github.com/dvonthenen/chat-gpeasy/pkg/proxy/guard"
+	images "github.com/dvonthenen/chat-gpeasy/pkg/proxy/images"
 	interfaces "github.com/dvonthenen/chat-gpeasy/pkg/proxy/interfaces"
+	keypool "github.com/dvonthenen/chat-gpeasy/pkg/proxy/keypool"
+	legacy "github.com/dvonthenen/chat-gpeasy/pkg/proxy/legacy"
+	output "github.com/dvonthenen/chat-gpeasy/pkg/proxy/output"
+	policy "github.com/dvonthenen/chat-gpeasy/pkg/proxy/policy"
+	queue "github.com/dvonthenen/chat-gpeasy/pkg/proxy/queue"
+	sessions "github.com/dvonthenen/chat-gpeasy/pkg/proxy/sessions"
+	storage "github.com/dvonthenen/chat-gpeasy/pkg/proxy/storage"
+	usage "github.com/dvonthenen/chat-gpeasy/pkg/proxy/usage"
+	tokens "github.com/dvonthenen/chat-gpeasy/pkg/tokens"
 )
 
 // ProxyOptions for the main HTTP endpoint
@@ -17,6 +42,68 @@ type ProxyOptions struct {
 	CrtFile  string
 	KeyFile  string
 	BindPort int
+
+	// Batch enables the /v1/batches endpoints when set
+	Batch *batch.BatchOptions
+
+	// Cache enables the semantic cache for chat completions when set
+	Cache *cache.CacheOptions
+
+	// Images stores generated images locally and rewrites their URLs when set
+	Images *images.ImageStoreOptions
+
+	// Experiment mirrors or splits chat traffic to a candidate model when set
+	Experiment *experiment.ExperimentOptions
+
+	// Breaker puts a circuit breaker in front of every upstream route when set
+	Breaker *breaker.BreakerOptions
+
+	// Queue bounds the upstream calls in flight and queues the rest by priority
+	// when set
+	Queue *queue.QueueOptions
+
+	// Auth requires a bearer JWT from every client when set
+	Auth *auth.AuthOptions
+
+	// Policy rewrites the chat requests of matched callers when set
+	Policy *policy.PolicyOptions
+
+	// Guard screens prompts for jailbreaks and prompt injection when set
+	Guard *guard.GuardOptions
+
+	// Output redacts, denies and moderates chat and completion output when set
+	Output *output.OutputOptions
+
+	// Sessions keeps conversations on the proxy behind /v1/sessions when set
+	Sessions *sessions.SessionOptions
+
+	// ChatSocket enables the /v1/chat/ws WebSocket chat when set
+	ChatSocket *ChatSocketOptions
+
+	// Usage aggregates requests, tokens and cost behind /admin/usage when set
+	Usage *usage.UsageOptions
+
+	// KeyPool spreads OpenAI calls over a pool of API keys when set, the key
+	// from OPENAI_API_KEY is then only used to list models at startup
+	KeyPool *keypool.PoolOptions
+
+	// Coalesce makes one upstream call for identical concurrent requests when set
+	Coalesce *coalesce.CoalesceOptions
+
+	// Legacy answers completions and edits with a chat model when set
+	Legacy *legacy.LegacyOptions
+
+	// Audio tunes how long transcriptions are split, defaults are used when nil
+	Audio *audio.TranscriberOptions
+
+	// Storage keeps quota counters, sessions, usage and the semantic cache in
+	// one store when set. Options of those features that name their own store
+	// take precedence.
+	Storage *storage.StorageOptions
+
+	// Admin says who may use the admin routes, they refuse every request when
+	// nil
+	Admin *AdminOptions
 }
 
 type ChatGPTProxy struct {
@@ -25,10 +112,228 @@ type ChatGPTProxy struct {
 	// callback
 	callback *interfaces.ChatGPTCallback
 
+	// persistent state
+	storage storage.Store
+
 	// server
 	server *http.Server
 
+	// circuit breakers
+	breakers *breaker.Group
+
+	// concurrency limits
+	limiter *queue.Limiter
+
+	// client authentication
+	auth *auth.Authenticator
+
+	// admin routes, keyed by method and path
+	adminRoutes map[string]bool
+
+	// request policies
+	policies *policy.Engine
+
+	// prompt screening
+	guard *guard.Guard
+
+	// output filtering
+	output *output.Filter
+
+	// pooled api keys
+	keys *keypool.Pool
+
+	// completions and edits translated to chat
+	legacy *legacy.Translator
+
+	// request coalescing
+	coalescer      *coalesce.Group
+	coalesceRoutes map[string]bool
+
+	// batch jobs
+	batches *batch.Manager
+
+	// conversation sessions
+	sessions *sessions.Manager
+
+	// websocket chat
+	chatSocketUpgrader *websocket.Upgrader
+
+	// usage reporting
+	usage *usage.Tracker
+
+	// semantic cache
+	cache *cache.SemanticCache
+
+	// image store
+	images *images.Store
+
+	// shadow and split traffic
+	experiment *experiment.Experiment
+
+	// long audio
+	transcriber *audio.Transcriber
+
 	// openai
 	openAiApiKey  string
 	chatgptClient *openai.Client
 }
+
+// Estimate is returned by /v1/tokenize, /v1/estimate and dry runs
+type Estimate struct {
+	Object           string      `json:"object"`
+	Endpoint         string      `json:"endpoint"`
+	Model            string      `json:"model"`
+	PromptTokens     int         `json:"prompt_tokens"`
+	CompletionTokens int         `json:"completion_tokens"`
+	TotalTokens      int         `json:"total_tokens"`
+	InputTokens      []int       `json:"input_tokens,omitempty"`
+	ContextLimit     int         `json:"context_limit"`
+	Remaining        int         `json:"remaining"`
+	Cost             tokens.Cost `json:"cost"`
+}
+
+// CacheList is returned by GET /v1/cache
+type CacheList struct {
+	Object string        `json:"object"`
+	Stats  cache.Stats   `json:"stats"`
+	Data   []cache.Entry `json:"data"`
+}
+
+// BreakerList is returned by GET /v1/breakers
+type BreakerList struct {
+	Object string           `json:"object"`
+	Data   []breaker.Status `json:"data"`
+}
+
+// ProxyStatus is returned by GET /admin/status
+type ProxyStatus struct {
+	Object   string              `json:"object"`
+	Queues   []queue.Stats       `json:"queues"`
+	Breakers []breaker.Status    `json:"breakers,omitempty"`
+	Keys     []keypool.KeyStatus `json:"keys,omitempty"`
+
+	Coalescing *coalesce.Stats `json:"coalescing,omitempty"`
+	Guard      *guard.Stats    `json:"guard,omitempty"`
+	Output     *output.Stats   `json:"output,omitempty"`
+}
+
+// AdminOptions says who may use the admin routes, like /v1/cache. A caller is
+// an admin when it sends one of the Keys as its bearer token, or when client
+// authentication matched it to one of the AuthPolicies.
+type AdminOptions struct {
+	// Keys are admin API keys. They are accepted on the admin routes without a
+	// JWT when client authentication is enabled.
+	Keys []string
+
+	// AuthPolicies are names of client authentication policies whose callers
+	// are admins
+	AuthPolicies []string
+}
+
+// ChatSocketOptions for the /v1/chat/ws endpoint
+type ChatSocketOptions struct {
+	// AllowedOrigins are the browser origins allowed to connect, "*" allows any.
+	// Only the proxy's own origin is allowed when empty.
+	AllowedOrigins []string
+
+	// PingInterval is how often the connection is pinged to keep it alive,
+	// DefaultChatSocketPingInterval when zero
+	PingInterval time.Duration
+
+	// ReadLimit is the largest frame accepted from a client,
+	// DefaultChatSocketReadLimit when zero
+	ReadLimit int64
+}
+
+// ChatSocketFrame is a JSON frame sent either way on /v1/chat/ws
+type ChatSocketFrame struct {
+	Type string `json:"type"`
+	ID   string `json:"id,omitempty"`
+
+	// init and conversation
+	Model    string             `json:"model,omitempty"`
+	Skill    string             `json:"skill,omitempty"`
+	Messages []sessions.Message `json:"messages,omitempty"`
+
+	// query, edit, directive and token
+	Index   *int   `json:"index,omitempty"`
+	Content string `json:"content,omitempty"`
+
+	// done
+	FinishReason string `json:"finish_reason,omitempty"`
+
+	// error
+	Message    string                 `json:"message,omitempty"`
+	Detections []interfaces.Detection `json:"detections,omitempty"`
+}
+
+// chatSocket is a WebSocket connection bound to a streamed advanced persona
+type chatSocket struct {
+	proxy *ChatGPTProxy
+	c     *gin.Context
+	conn  *websocket.Conn
+
+	// the conversation
+	model        string
+	conversation []personainterfaces.CompletionMessage
+
+	// the running operation
+	busy       bool
+	cancelled  bool
+	stop       context.CancelFunc
+	completion *personainterfaces.StreamingCompletion
+	running    sync.WaitGroup
+
+	// housekeeping
+	mu      sync.Mutex
+	writeMu sync.Mutex
+}
+
+// chatSocketWriter sends the tokens of a streamed reply through the output filter
+type chatSocketWriter struct {
+	socket  *chatSocket
+	id      string
+	filter  *output.Stream
+	sent    strings.Builder
+	blocked bool
+}
+
+// completionStream is a streamed completion, from the completions API or
+// translated from the chat API
+type completionStream interface {
+	Recv() (openai.CompletionResponse, error)
+	Close()
+}
+
+// MultiCallbackOptions for the composite callback
+type MultiCallbackOptions struct {
+	// ErrorHandler is called for every member that fails
+	ErrorHandler func(name string, event *interfaces.Event, err error)
+}
+
+// MultiCallback fans every call out to a set of callbacks
+type MultiCallback struct {
+	options *MultiCallbackOptions
+	members []*multiMember
+
+	// housekeeping
+	mu sync.RWMutex
+}
+
+type multiMember struct {
+	name     string
+	callback interfaces.ChatGPTCallback
+	filters  []EventFilter
+}
+
+// MemberError is a failure of a single MultiCallback member
+type MemberError struct {
+	Name      string
+	EventType interfaces.EventType
+	Err       error
+}
+
+// MultiError collects the failures of all MultiCallback members for an event
+type MultiError struct {
+	Errors []MemberError
+}
@@ -0,0 +1,200 @@
+// Copyright 2023 dvonthenen ChatGPT Proxy contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache License 2.0
+
+package proxy
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	openai "github.com/sashabaranov/go-openai"
+	klog "k8s.io/klog/v2"
+
+	audio "github.com/dvonthenen/chat-gpeasy/pkg/audio"
+	breaker "github.com/dvonthenen/chat-gpeasy/pkg/proxy/breaker"
+	interfaces "github.com/dvonthenen/chat-gpeasy/pkg/proxy/interfaces"
+	keypool "github.com/dvonthenen/chat-gpeasy/pkg/proxy/keypool"
+	queue "github.com/dvonthenen/chat-gpeasy/pkg/proxy/queue"
+)
+
+// upstream makes a call to OpenAI. When concurrency limits are enabled the call
+// waits for a slot first. With a key pool the call is made with the key picked
+// for it, see client. When circuit breakers are enabled the call fails fast
+// with a *breaker.OpenError while the breaker of the route is open. Streamed
+// calls are timed to their first byte, see streamOpened.
+func (p *ChatGPTProxy) upstream(ctx context.Context, name, route string, call func(ctx context.Context) error) (err error) {
+	release, err := p.acquire(ctx, name)
+	if err != nil {
+		klog.V(3).Infof("upstream call not admitted. Err: %v\n", err)
+		return err
+	}
+	defer release()
+
+	if name == upstreamOpenAI && p.keys != nil {
+		member, pickErr := p.keys.Pick()
+		if pickErr != nil {
+			klog.V(3).Infof("keys.Pick failed. Err: %v\n", pickErr)
+			return pickErr
+		}
+		ctx = keypool.WithMember(ctx, member)
+		if info := requestInfoFrom(ctx); info != nil {
+			info.setUpstreamKey(member.Name())
+		}
+		defer func() {
+			p.keys.Done(member, upstreamStatus(err), upstreamFailure(err))
+		}()
+	}
+
+	if p.breakers == nil {
+		return call(ctx)
+	}
+
+	b := p.breakers.Get(name, route)
+	if err := b.Allow(); err != nil {
+		klog.V(3).Infof("upstream call rejected. Err: %v\n", err)
+		return err
+	}
+
+	timer := &upstreamTimer{
+		start: time.Now(),
+	}
+	err = call(context.WithValue(ctx, upstreamTimerKey{}, timer))
+	b.Record(upstreamFailure(err), timer.latency())
+
+	return err
+}
+
+// upstreamTimer times an upstream call for its breaker
+type upstreamTimer struct {
+	start     time.Time
+	firstByte time.Duration
+
+	// housekeeping
+	mu sync.Mutex
+}
+
+type upstreamTimerKey struct{}
+
+// streamOpened marks the stream of an upstream call as open. A stream lasts as
+// long as the reply takes to generate, so the breaker judges it by the time to
+// its first byte, not by how long it ran.
+func streamOpened(ctx context.Context) {
+	timer, ok := ctx.Value(upstreamTimerKey{}).(*upstreamTimer)
+	if !ok {
+		return
+	}
+
+	timer.mu.Lock()
+	defer timer.mu.Unlock()
+	if timer.firstByte == 0 {
+		timer.firstByte = time.Since(timer.start)
+	}
+}
+
+// latency is the time to the first byte of a stream, or the duration of the
+// call otherwise
+func (t *upstreamTimer) latency() time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.firstByte > 0 {
+		return t.firstByte
+	}
+	return time.Since(t.start)
+}
+
+// client returns the client an upstream call is made with, the pooled key
+// picked for the call or the proxy's own client
+func (p *ChatGPTProxy) client(ctx context.Context) *openai.Client {
+	if member := keypool.MemberFrom(ctx); member != nil {
+		return member.Client()
+	}
+	return p.chatgptClient
+}
+
+// abortUpstream writes the response for a failed upstream call
+func abortUpstream(c *gin.Context, err error, message string) {
+	var openErr *breaker.OpenError
+	if errors.As(err, &openErr) {
+		c.Header(HeaderRetryAfter, strconv.Itoa(int(math.Ceil(openErr.RetryAfter.Seconds()))))
+		c.IndentedJSON(http.StatusServiceUnavailable, gin.H{"message": "upstream unavailable"})
+		return
+	}
+	var keysErr *keypool.UnavailableError
+	if errors.As(err, &keysErr) {
+		c.Header(HeaderRetryAfter, strconv.Itoa(int(math.Ceil(keysErr.RetryAfter.Seconds()))))
+		c.IndentedJSON(http.StatusServiceUnavailable, gin.H{"message": "no upstream key available"})
+		return
+	}
+	switch {
+	case errors.Is(err, queue.ErrClientGone):
+		c.AbortWithStatus(statusClientClosedRequest)
+		return
+	case errors.Is(err, queue.ErrQueueFull), errors.Is(err, queue.ErrQueueTimeout):
+		c.Header(HeaderRetryAfter, strconv.Itoa(queueRetryAfter))
+		c.IndentedJSON(http.StatusServiceUnavailable, gin.H{"message": err.Error()})
+		return
+	}
+	c.IndentedJSON(http.StatusNotFound, gin.H{"message": message})
+}
+
+// localErrors are raised before a request reaches the upstream
+var localErrors = []error{
+	context.Canceled,
+	breaker.ErrOpen,
+	audio.ErrInvalidInput,
+	audio.ErrInvalidWAV,
+	audio.ErrUnsupportedEncoding,
+	audio.ErrTooLarge,
+	queue.ErrQueueFull,
+	queue.ErrQueueTimeout,
+	queue.ErrClientGone,
+	keypool.ErrNoKeys,
+}
+
+// upstreamFailure returns true for errors that say the upstream is unhealthy.
+// Rejected requests are the client's problem and do not count.
+func upstreamFailure(err error) bool {
+	if err == nil {
+		return false
+	}
+	for _, local := range localErrors {
+		if errors.Is(err, local) {
+			return false
+		}
+	}
+	status := upstreamStatus(err)
+	return status == 0 || status == http.StatusTooManyRequests || status >= http.StatusInternalServerError
+}
+
+// upstreamStatus is the HTTP status of an OpenAI error, 0 when no response was
+// received
+func upstreamStatus(err error) int {
+	var apiErr *openai.APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.HTTPStatusCode
+	}
+	var reqErr *openai.RequestError
+	if errors.As(err, &reqErr) {
+		return reqErr.HTTPStatusCode
+	}
+	return 0
+}
+
+// breakerStateChange passes state changes on to the callback
+func (p *ChatGPTProxy) breakerStateChange(change interfaces.BreakerStateChange) {
+	if p.callback == nil {
+		return
+	}
+
+	klog.V(6).Infof("CircuitBreaker Callback...\n")
+	err := interfaces.Dispatch(*p.callback, interfaces.NewEvent(interfaces.EventTypeCircuitBreaker, change, nil))
+	if err != nil {
+		klog.V(1).Infof("[CALLBACK] CircuitBreaker failed. Err: %v\n", err)
+	}
+}
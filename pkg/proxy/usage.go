@@ -0,0 +1,133 @@
+// Copyright 2023 dvonthenen ChatGPT Proxy contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache License 2.0
+
+package proxy
+
+import (
+	"context"
+	"time"
+
+	openai "github.com/sashabaranov/go-openai"
+
+	interfaces "github.com/dvonthenen/chat-gpeasy/pkg/proxy/interfaces"
+	usage "github.com/dvonthenen/chat-gpeasy/pkg/proxy/usage"
+	tokens "github.com/dvonthenen/chat-gpeasy/pkg/tokens"
+)
+
+// recordUsage adds a served request to the usage reports. Responses served from
+// the cache or shared by coalescing count as requests without tokens, the
+// upstream call was billed once. Streamed responses carry no usage, their
+// tokens are counted locally.
+func (p *ChatGPTProxy) recordUsage(ctx context.Context, request, response interface{}) {
+	if p.usage == nil {
+		return
+	}
+
+	record := usage.Record{
+		Time: time.Now(),
+	}
+	billed := true
+	if info := requestInfoFrom(ctx); info != nil {
+		info.mu.Lock()
+		record.Caller = info.caller
+		record.Route = info.route
+		record.Key = info.upstreamKey
+		record.Team = usageTeam(info.identity, p.usage.TeamClaim())
+		billed = !info.cached && !info.coalesced
+		info.mu.Unlock()
+	}
+
+	var promptTokens, completionTokens int
+	record.Model, promptTokens, completionTokens = usageTokens(request, response)
+
+	// an EditsResponse names no model, a translated edit is billed under the
+	// chat model that answered it
+	if req, ok := request.(openai.EditsRequest); ok && p.legacy != nil && p.legacy.Translates(editsModel(req)) {
+		record.Model = p.legacy.ChatModel()
+	}
+	if billed {
+		record.PromptTokens = promptTokens
+		record.CompletionTokens = completionTokens
+	}
+
+	p.usage.Record(record)
+}
+
+// usageTeam returns the caller's team from the claim that holds it
+func usageTeam(identity *interfaces.Identity, claim string) string {
+	if identity == nil || len(claim) == 0 {
+		return ""
+	}
+
+	switch value := identity.Claims[claim].(type) {
+	case string:
+		return value
+	case []interface{}:
+		for _, item := range value {
+			if s, ok := item.(string); ok {
+				return s
+			}
+		}
+	case []string:
+		if len(value) > 0 {
+			return value[0]
+		}
+	}
+	return ""
+}
+
+// usageTokens returns the model and tokens of a request, from the usage the
+// response reports or counted when it reports none
+func usageTokens(request, response interface{}) (string, int, int) {
+	switch resp := response.(type) {
+	case openai.ChatCompletionResponse:
+		req, _ := request.(openai.ChatCompletionRequest)
+		model := resp.Model
+		if len(model) == 0 {
+			model = req.Model
+		}
+		if resp.Usage.TotalTokens > 0 {
+			return model, resp.Usage.PromptTokens, resp.Usage.CompletionTokens
+		}
+
+		completionTokens := 0
+		for _, choice := range resp.Choices {
+			completionTokens += tokens.CountMessage(choice.Message)
+		}
+		return model, tokens.CountMessages(req.Messages), completionTokens
+	case openai.CompletionResponse:
+		req, _ := request.(openai.CompletionRequest)
+		model := resp.Model
+		if len(model) == 0 {
+			model = req.Model
+		}
+		if resp.Usage.TotalTokens > 0 {
+			return model, resp.Usage.PromptTokens, resp.Usage.CompletionTokens
+		}
+
+		promptTokens, completionTokens := 0, 0
+		for _, text := range promptTexts(req) {
+			promptTokens += tokens.Count(text)
+		}
+		for _, choice := range resp.Choices {
+			completionTokens += tokens.Count(choice.Text)
+		}
+		return model, promptTokens, completionTokens
+	case openai.EditsResponse:
+		model := ""
+		if req, ok := request.(openai.EditsRequest); ok && req.Model != nil {
+			model = *req.Model
+		}
+		return model, resp.Usage.PromptTokens, resp.Usage.CompletionTokens
+	case openai.EmbeddingResponse:
+		return resp.Model.String(), resp.Usage.PromptTokens, 0
+	}
+
+	switch req := request.(type) {
+	case openai.AudioRequest:
+		return req.Model, 0, 0
+	case openai.ModerationRequest:
+		return req.Model, 0, 0
+	}
+	return "", 0, 0
+}
@@ -0,0 +1,48 @@
+// Copyright 2023 dvonthenen ChatGPT Proxy contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache License 2.0
+
+package usage
+
+import (
+	"errors"
+	"time"
+)
+
+const (
+	// ReportObject object type returned by GET /admin/usage
+	ReportObject string = "usage_report"
+
+	// dimensions a report can be grouped by
+	GroupDay    string = "day"
+	GroupMonth  string = "month"
+	GroupCaller string = "caller"
+	GroupTeam   string = "team"
+	GroupKey    string = "key"
+	GroupModel  string = "model"
+	GroupRoute  string = "route"
+
+	// DefaultFlushInterval is how often usage is written to storage
+	DefaultFlushInterval time.Duration = time.Minute
+
+	// DayLayout and MonthLayout format the day and month of a row, in UTC
+	DayLayout   string = "2006-01-02"
+	MonthLayout string = "2006-01"
+
+	currency      string = "USD"
+	dataExtension string = ".json"
+
+	// storageComponent names the usage schema, keyPrefix its keys
+	storageComponent string = "usage"
+	keyPrefix        string = "usage/"
+)
+
+// DefaultGroupBy reports every row as recorded
+var DefaultGroupBy = []string{GroupDay, GroupCaller, GroupTeam, GroupKey, GroupModel, GroupRoute}
+
+var (
+	// ErrInvalidInput required input was not found
+	ErrInvalidInput = errors.New("required input was not found")
+
+	// ErrInvalidGroup the report can not be grouped by that dimension
+	ErrInvalidGroup = errors.New("invalid group by dimension")
+)
@@ -0,0 +1,48 @@
+// Copyright 2023 dvonthenen ChatGPT Proxy contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache License 2.0
+
+package usage
+
+import (
+	"encoding/csv"
+	"io"
+	"strconv"
+)
+
+// WriteCSV writes the report with a header, one line per row and a total line
+func WriteCSV(w io.Writer, report *Report) error {
+	writer := csv.NewWriter(w)
+
+	header := append([]string{}, report.GroupBy...)
+	header = append(header, "requests", "prompt_tokens", "completion_tokens", "total_tokens", "cost_"+report.Currency)
+	if err := writer.Write(header); err != nil {
+		return err
+	}
+
+	for _, row := range report.Data {
+		if err := writer.Write(csvLine(row.Values(report.GroupBy), row)); err != nil {
+			return err
+		}
+	}
+
+	total := make([]string, len(report.GroupBy))
+	if len(total) > 0 {
+		total[0] = "total"
+	}
+	if err := writer.Write(csvLine(total, report.Total)); err != nil {
+		return err
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+func csvLine(values []string, row Row) []string {
+	return append(values,
+		strconv.FormatInt(row.Requests, 10),
+		strconv.FormatInt(row.PromptTokens, 10),
+		strconv.FormatInt(row.CompletionTokens, 10),
+		strconv.FormatInt(row.TotalTokens, 10),
+		strconv.FormatFloat(row.Cost, 'f', 6, 64),
+	)
+}
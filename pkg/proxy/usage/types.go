@@ -0,0 +1,111 @@
+// Copyright 2023 dvonthenen ChatGPT Proxy contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache License 2.0
+
+package usage
+
+import (
+	"sync"
+	"time"
+
+	storage "github.com/dvonthenen/chat-gpeasy/pkg/proxy/storage"
+)
+
+// UsageOptions configures usage tracking
+type UsageOptions struct {
+	// Storage or Dir persist the usage, one JSON value or file per day. Usage
+	// is only kept in memory without either. With both, the files in Dir are
+	// imported into Storage once.
+	Storage storage.Store
+	Dir     string
+
+	// TeamClaim names the claim of the caller's token that holds its team, ie
+	// "team" or "groups". The first value is used for a list.
+	TeamClaim string
+
+	// FlushInterval is how often usage is written to Storage or Dir,
+	// DefaultFlushInterval when zero
+	FlushInterval time.Duration
+}
+
+// Record is the usage of a single request. Cost is computed from the tokens
+// with list prices.
+type Record struct {
+	Time   time.Time
+	Caller string
+	Team   string
+	Key    string
+	Model  string
+	Route  string
+
+	PromptTokens     int
+	CompletionTokens int
+}
+
+// Row is the usage aggregated over a group. Only the dimensions the report is
+// grouped by are set.
+type Row struct {
+	Day    string `json:"day,omitempty"`
+	Month  string `json:"month,omitempty"`
+	Caller string `json:"caller,omitempty"`
+	Team   string `json:"team,omitempty"`
+	Key    string `json:"key,omitempty"`
+	Model  string `json:"model,omitempty"`
+	Route  string `json:"route,omitempty"`
+
+	Requests         int64   `json:"requests"`
+	PromptTokens     int64   `json:"prompt_tokens"`
+	CompletionTokens int64   `json:"completion_tokens"`
+	TotalTokens      int64   `json:"total_tokens"`
+	Cost             float64 `json:"cost"`
+}
+
+// Query selects and groups usage. From and To are days, inclusive, and are
+// unbounded when zero. The other filters must match exactly when set.
+type Query struct {
+	From time.Time
+	To   time.Time
+
+	Caller string
+	Team   string
+	Key    string
+	Model  string
+	Route  string
+
+	// GroupBy dimensions, DefaultGroupBy when empty
+	GroupBy []string
+}
+
+// Report is returned by GET /admin/usage
+type Report struct {
+	Object   string   `json:"object"`
+	From     string   `json:"from,omitempty"`
+	To       string   `json:"to,omitempty"`
+	GroupBy  []string `json:"group_by"`
+	Currency string   `json:"currency"`
+	Data     []Row    `json:"data"`
+	Total    Row      `json:"total"`
+}
+
+// Tracker aggregates usage per day, caller, team, key, model and route
+type Tracker struct {
+	options *UsageOptions
+
+	// rows per day
+	days  map[string]map[rowKey]*Row
+	dirty map[string]bool
+
+	// flushing
+	stopChan chan struct{}
+	wg       sync.WaitGroup
+
+	// housekeeping
+	mu sync.Mutex
+}
+
+type rowKey struct {
+	caller string
+	team   string
+	key    string
+	model  string
+	route  string
+}
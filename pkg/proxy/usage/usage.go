@@ -0,0 +1,441 @@
+// Copyright 2023 dvonthenen ChatGPT Proxy contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache License 2.0
+
+package usage
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	klog "k8s.io/klog/v2"
+
+	storage "github.com/dvonthenen/chat-gpeasy/pkg/proxy/storage"
+	tokens "github.com/dvonthenen/chat-gpeasy/pkg/tokens"
+)
+
+func New(options UsageOptions) (*Tracker, error) {
+	if options.FlushInterval == 0 {
+		options.FlushInterval = DefaultFlushInterval
+	}
+	if options.FlushInterval < 0 {
+		return nil, ErrInvalidInput
+	}
+
+	t := &Tracker{
+		options:  &options,
+		days:     make(map[string]map[rowKey]*Row),
+		dirty:    make(map[string]bool),
+		stopChan: make(chan struct{}),
+	}
+
+	switch {
+	case options.Storage != nil:
+		if err := storage.Migrate(options.Storage, storageComponent, migrations(options.Dir)); err != nil {
+			klog.V(1).Infof("storage.Migrate failed. Err: %v\n", err)
+			return nil, err
+		}
+		if err := t.loadStorage(); err != nil {
+			klog.V(1).Infof("loadStorage failed. Err: %v\n", err)
+			return nil, err
+		}
+	case len(options.Dir) > 0:
+		if err := os.MkdirAll(options.Dir, 0700); err != nil {
+			klog.V(1).Infof("MkdirAll failed. Err: %v\n", err)
+			return nil, err
+		}
+		days, err := readDays(options.Dir)
+		if err != nil {
+			klog.V(1).Infof("readDays failed. Err: %v\n", err)
+			return nil, err
+		}
+		t.loadDays(days)
+	}
+
+	if t.persistent() {
+		t.wg.Add(1)
+		go t.flushLoop()
+	}
+
+	return t, nil
+}
+
+// TeamClaim is the claim that names the caller's team
+func (t *Tracker) TeamClaim() string {
+	return t.options.TeamClaim
+}
+
+// Record adds the usage of a request to its day
+func (t *Tracker) Record(record Record) {
+	if record.Time.IsZero() {
+		record.Time = time.Now()
+	}
+	day := record.Time.UTC().Format(DayLayout)
+	key := rowKey{
+		caller: record.Caller,
+		team:   record.Team,
+		key:    record.Key,
+		model:  record.Model,
+		route:  record.Route,
+	}
+	cost := tokens.EstimateCost(record.Model, record.PromptTokens, record.CompletionTokens)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	rows, ok := t.days[day]
+	if !ok {
+		rows = make(map[rowKey]*Row)
+		t.days[day] = rows
+	}
+	row, ok := rows[key]
+	if !ok {
+		row = &Row{
+			Day:    day,
+			Caller: key.caller,
+			Team:   key.team,
+			Key:    key.key,
+			Model:  key.model,
+			Route:  key.route,
+		}
+		rows[key] = row
+	}
+
+	row.Requests++
+	row.PromptTokens += int64(record.PromptTokens)
+	row.CompletionTokens += int64(record.CompletionTokens)
+	row.TotalTokens += int64(record.PromptTokens + record.CompletionTokens)
+	row.Cost += cost.Total
+
+	t.dirty[day] = true
+}
+
+// Report selects the rows of the query and sums them per group
+func (t *Tracker) Report(query Query) (*Report, error) {
+	groupBy := query.GroupBy
+	if len(groupBy) == 0 {
+		groupBy = DefaultGroupBy
+	}
+	for _, group := range groupBy {
+		switch group {
+		case GroupDay, GroupMonth, GroupCaller, GroupTeam, GroupKey, GroupModel, GroupRoute:
+		default:
+			return nil, ErrInvalidGroup
+		}
+	}
+
+	report := &Report{
+		Object:   ReportObject,
+		GroupBy:  groupBy,
+		Currency: currency,
+		Data:     make([]Row, 0),
+	}
+	var from, to string
+	if !query.From.IsZero() {
+		from = query.From.UTC().Format(DayLayout)
+		report.From = from
+	}
+	if !query.To.IsZero() {
+		to = query.To.UTC().Format(DayLayout)
+		report.To = to
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	groups := make(map[Row]*Row)
+	for day, rows := range t.days {
+		if (len(from) > 0 && day < from) || (len(to) > 0 && day > to) {
+			continue
+		}
+		for _, row := range rows {
+			if !query.matches(row) {
+				continue
+			}
+
+			group := groupOf(row, groupBy)
+			sum, ok := groups[group]
+			if !ok {
+				sum = &Row{}
+				*sum = group
+				groups[group] = sum
+			}
+			sum.add(row)
+			report.Total.add(row)
+		}
+	}
+
+	for _, row := range groups {
+		report.Data = append(report.Data, *row)
+	}
+	sort.Slice(report.Data, func(i, j int) bool {
+		return report.Data[i].less(report.Data[j], groupBy)
+	})
+
+	return report, nil
+}
+
+// Stop writes the usage not flushed yet
+func (t *Tracker) Stop() error {
+	if !t.persistent() {
+		return nil
+	}
+
+	close(t.stopChan)
+	t.wg.Wait()
+
+	return t.flush()
+}
+
+func (t *Tracker) flushLoop() {
+	defer t.wg.Done()
+
+	ticker := time.NewTicker(t.options.FlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-t.stopChan:
+			return
+		case <-ticker.C:
+			if err := t.flush(); err != nil {
+				klog.V(1).Infof("usage flush failed. Err: %v\n", err)
+			}
+		}
+	}
+}
+
+// flush writes every day that changed since the last flush
+func (t *Tracker) flush() error {
+	t.mu.Lock()
+	days := make(map[string][]Row, len(t.dirty))
+	for day := range t.dirty {
+		rows := make([]Row, 0, len(t.days[day]))
+		for _, row := range t.days[day] {
+			rows = append(rows, *row)
+		}
+		days[day] = rows
+	}
+	t.dirty = make(map[string]bool)
+	t.mu.Unlock()
+
+	var failed error
+	for day, rows := range days {
+		if err := t.writeDay(day, rows); err != nil {
+			klog.V(1).Infof("writeDay %s failed. Err: %v\n", day, err)
+			failed = err
+
+			// try again on the next flush
+			t.mu.Lock()
+			t.dirty[day] = true
+			t.mu.Unlock()
+		}
+	}
+	return failed
+}
+
+// persistent returns true when the usage is saved
+func (t *Tracker) persistent() bool {
+	return t.options.Storage != nil || len(t.options.Dir) > 0
+}
+
+func (t *Tracker) writeDay(day string, rows []Row) error {
+	if t.options.Storage != nil {
+		return storage.SetJSON(t.options.Storage, keyPrefix+day, rows, 0)
+	}
+
+	data, err := json.Marshal(rows)
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(t.options.Dir, day+dataExtension)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func (t *Tracker) loadStorage() error {
+	entries, err := t.options.Storage.List(keyPrefix)
+	if err != nil {
+		return err
+	}
+
+	days := make(map[string][]Row, len(entries))
+	for _, entry := range entries {
+		var rows []Row
+		if err := json.Unmarshal(entry.Value, &rows); err != nil {
+			klog.V(1).Infof("skipping usage %s. Err: %v\n", entry.Key, err)
+			continue
+		}
+		days[strings.TrimPrefix(entry.Key, keyPrefix)] = rows
+	}
+
+	t.loadDays(days)
+	return nil
+}
+
+// loadDays replaces the usage of the days. must be called before the tracker is
+// used.
+func (t *Tracker) loadDays(days map[string][]Row) {
+	for day, rows := range days {
+		loaded := make(map[rowKey]*Row, len(rows))
+		for i := range rows {
+			row := &rows[i]
+			row.Day = day
+			loaded[rowKey{caller: row.Caller, team: row.Team, key: row.Key, model: row.Model, route: row.Route}] = row
+		}
+		t.days[day] = loaded
+	}
+
+	klog.V(4).Infof("loaded usage of %d days\n", len(t.days))
+}
+
+// readDays reads the usage files in dir
+func readDays(dir string) (map[string][]Row, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	days := make(map[string][]Row)
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, dataExtension) {
+			continue
+		}
+		day := strings.TrimSuffix(name, dataExtension)
+		if _, err := time.Parse(DayLayout, day); err != nil {
+			continue
+		}
+
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+		var rows []Row
+		if err := json.Unmarshal(data, &rows); err != nil {
+			klog.V(1).Infof("skipping usage %s. Err: %v\n", name, err)
+			continue
+		}
+		days[day] = rows
+	}
+	return days, nil
+}
+
+// migrations of the usage kept in the proxy's storage
+func migrations(dir string) []storage.Migration {
+	return []storage.Migration{
+		{
+			Version:     1,
+			Description: "import the usage files",
+			Migrate: func(store storage.Store) error {
+				return importFiles(dir, store)
+			},
+		},
+	}
+}
+
+// importFiles copies the usage saved as files in dir. The files are left in
+// place.
+func importFiles(dir string, store storage.Store) error {
+	if len(dir) == 0 {
+		return nil
+	}
+	if _, err := os.Stat(dir); errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	days, err := readDays(dir)
+	if err != nil {
+		return err
+	}
+	for day, rows := range days {
+		if err := storage.SetJSON(store, keyPrefix+day, rows, 0); err != nil {
+			return err
+		}
+	}
+
+	klog.V(3).Infof("imported usage of %d days from %s\n", len(days), dir)
+	return nil
+}
+
+func (q Query) matches(row *Row) bool {
+	return (len(q.Caller) == 0 || q.Caller == row.Caller) &&
+		(len(q.Team) == 0 || q.Team == row.Team) &&
+		(len(q.Key) == 0 || q.Key == row.Key) &&
+		(len(q.Model) == 0 || q.Model == row.Model) &&
+		(len(q.Route) == 0 || q.Route == row.Route)
+}
+
+// groupOf returns the dimensions of the row the report is grouped by
+func groupOf(row *Row, groupBy []string) Row {
+	var group Row
+	for _, dimension := range groupBy {
+		switch dimension {
+		case GroupDay:
+			group.Day = row.Day
+		case GroupMonth:
+			group.Month = row.Day[:len(MonthLayout)]
+		case GroupCaller:
+			group.Caller = row.Caller
+		case GroupTeam:
+			group.Team = row.Team
+		case GroupKey:
+			group.Key = row.Key
+		case GroupModel:
+			group.Model = row.Model
+		case GroupRoute:
+			group.Route = row.Route
+		}
+	}
+	return group
+}
+
+func (r *Row) add(other *Row) {
+	r.Requests += other.Requests
+	r.PromptTokens += other.PromptTokens
+	r.CompletionTokens += other.CompletionTokens
+	r.TotalTokens += other.TotalTokens
+	r.Cost += other.Cost
+}
+
+// Values returns the row's dimensions in groupBy order
+func (r Row) Values(groupBy []string) []string {
+	values := make([]string, 0, len(groupBy))
+	for _, dimension := range groupBy {
+		switch dimension {
+		case GroupDay:
+			values = append(values, r.Day)
+		case GroupMonth:
+			values = append(values, r.Month)
+		case GroupCaller:
+			values = append(values, r.Caller)
+		case GroupTeam:
+			values = append(values, r.Team)
+		case GroupKey:
+			values = append(values, r.Key)
+		case GroupModel:
+			values = append(values, r.Model)
+		case GroupRoute:
+			values = append(values, r.Route)
+		}
+	}
+	return values
+}
+
+func (r Row) less(other Row, groupBy []string) bool {
+	a, b := r.Values(groupBy), other.Values(groupBy)
+	for i := range a {
+		if a[i] != b[i] {
+			return a[i] < b[i]
+		}
+	}
+	return false
+}
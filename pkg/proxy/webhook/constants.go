@@ -0,0 +1,48 @@
+// Copyright 2023 dvonthenen ChatGPT Proxy contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache License 2.0
+
+package webhook
+
+import (
+	"errors"
+	"time"
+)
+
+const (
+	// headers sent with every delivery
+	HeaderEvent     string = "X-Chat-Gpeasy-Event"
+	HeaderDelivery  string = "X-Chat-Gpeasy-Delivery"
+	HeaderTimestamp string = "X-Chat-Gpeasy-Timestamp"
+	HeaderSignature string = "X-Chat-Gpeasy-Signature"
+
+	// SignaturePrefix prefixes the hex encoded HMAC in HeaderSignature
+	SignaturePrefix string = "sha256="
+
+	DefaultMaxQueue       int           = 10000
+	DefaultMaxAttempts    int           = 8
+	DefaultWorkers        int           = 4
+	DefaultInitialBackoff time.Duration = 1 * time.Second
+	DefaultMaxBackoff     time.Duration = 5 * time.Minute
+	DefaultTimeout        time.Duration = 10 * time.Second
+
+	pendingDir    string = "pending"
+	deadLetterDir string = "dead"
+	fileExtension string = ".json"
+)
+
+var (
+	// ErrInvalidInput required input was not found
+	ErrInvalidInput = errors.New("required input was not found")
+
+	// ErrQueueFull the delivery queue has reached its limit
+	ErrQueueFull = errors.New("webhook delivery queue is full")
+
+	// ErrStopped the webhook callback has been stopped
+	ErrStopped = errors.New("webhook callback has been stopped")
+
+	// ErrDeliveryFailed the endpoint did not accept the delivery
+	ErrDeliveryFailed = errors.New("webhook delivery failed")
+
+	// ErrInvalidSignature the signature does not match the payload
+	ErrInvalidSignature = errors.New("invalid webhook signature")
+)
@@ -0,0 +1,324 @@
+// Copyright 2023 dvonthenen ChatGPT Proxy contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache License 2.0
+
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	klog "k8s.io/klog/v2"
+
+	interfaces "github.com/dvonthenen/chat-gpeasy/pkg/proxy/interfaces"
+)
+
+// enqueue creates one delivery per URL and persists them before returning
+func (wc *WebhookCallback) enqueue(event *interfaces.Event) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		klog.V(1).Infof("json.Marshal failed. Err: %v\n", err)
+		return err
+	}
+
+	wc.mu.Lock()
+	defer wc.mu.Unlock()
+
+	if wc.stopped {
+		return ErrStopped
+	}
+	if len(wc.pending)+len(wc.options.URLs) > wc.options.MaxQueue {
+		klog.V(1).Infof("webhook queue is full (%d)\n", len(wc.pending))
+		return ErrQueueFull
+	}
+
+	now := time.Now()
+	for _, url := range wc.options.URLs {
+		delivery := &Delivery{
+			ID:          interfaces.NewEventID(),
+			EventID:     event.ID,
+			EventType:   string(event.Type),
+			URL:         url,
+			Payload:     payload,
+			NextAttempt: now,
+		}
+
+		err := wc.persist(pendingDir, delivery)
+		if err != nil {
+			klog.V(1).Infof("persist failed. Err: %v\n", err)
+			return err
+		}
+		wc.pending[delivery.ID] = delivery
+	}
+
+	wc.signal()
+
+	return nil
+}
+
+// load restores pending deliveries left over from a previous run
+func (wc *WebhookCallback) load() error {
+	if len(wc.options.QueueDir) == 0 {
+		return nil
+	}
+
+	for _, dir := range []string{pendingDir, deadLetterDir} {
+		err := os.MkdirAll(filepath.Join(wc.options.QueueDir, dir), 0700)
+		if err != nil {
+			klog.V(1).Infof("os.MkdirAll failed. Err: %v\n", err)
+			return err
+		}
+	}
+
+	deliveries, err := wc.read(pendingDir)
+	if err != nil {
+		return err
+	}
+	for _, delivery := range deliveries {
+		wc.pending[delivery.ID] = delivery
+	}
+
+	klog.V(4).Infof("restored %d pending webhook deliveries\n", len(deliveries))
+	return nil
+}
+
+func (wc *WebhookCallback) read(dir string) ([]*Delivery, error) {
+	deliveries := make([]*Delivery, 0)
+	if len(wc.options.QueueDir) == 0 {
+		return deliveries, nil
+	}
+
+	files, err := os.ReadDir(filepath.Join(wc.options.QueueDir, dir))
+	if err != nil {
+		klog.V(1).Infof("os.ReadDir failed. Err: %v\n", err)
+		return nil, err
+	}
+
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), fileExtension) {
+			continue
+		}
+
+		data, err := os.ReadFile(filepath.Join(wc.options.QueueDir, dir, file.Name()))
+		if err != nil {
+			klog.V(1).Infof("os.ReadFile failed. Err: %v\n", err)
+			continue
+		}
+
+		var delivery Delivery
+		if err := json.Unmarshal(data, &delivery); err != nil {
+			klog.V(1).Infof("skipping corrupt delivery %s. Err: %v\n", file.Name(), err)
+			continue
+		}
+		deliveries = append(deliveries, &delivery)
+	}
+
+	return deliveries, nil
+}
+
+// persist atomically writes the delivery to dir
+func (wc *WebhookCallback) persist(dir string, delivery *Delivery) error {
+	if len(wc.options.QueueDir) == 0 {
+		return nil
+	}
+
+	data, err := json.Marshal(delivery)
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(wc.options.QueueDir, dir, delivery.ID+fileExtension)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func (wc *WebhookCallback) remove(dir string, delivery *Delivery) {
+	if len(wc.options.QueueDir) == 0 {
+		return
+	}
+
+	err := os.Remove(filepath.Join(wc.options.QueueDir, dir, delivery.ID+fileExtension))
+	if err != nil && !os.IsNotExist(err) {
+		klog.V(1).Infof("os.Remove failed. Err: %v\n", err)
+	}
+}
+
+// signal wakes up the dispatcher. must be called with the lock held
+func (wc *WebhookCallback) signal() {
+	select {
+	case wc.wake <- struct{}{}:
+	default:
+	}
+}
+
+// dispatch hands due deliveries to the workers
+func (wc *WebhookCallback) dispatch() {
+	defer wc.wg.Done()
+
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+
+	for {
+		due, next := wc.due()
+
+		for _, delivery := range due {
+			select {
+			case wc.work <- delivery:
+			case <-wc.stopChan:
+				return
+			}
+		}
+
+		wait := time.Until(next)
+		if next.IsZero() {
+			wait = time.Hour
+		}
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(wait)
+
+		select {
+		case <-wc.stopChan:
+			return
+		case <-wc.wake:
+		case <-timer.C:
+		}
+	}
+}
+
+// due returns the deliveries ready to be sent and when the next one becomes ready
+func (wc *WebhookCallback) due() ([]*Delivery, time.Time) {
+	wc.mu.Lock()
+	defer wc.mu.Unlock()
+
+	now := time.Now()
+	due := make([]*Delivery, 0)
+	var next time.Time
+
+	for id, delivery := range wc.pending {
+		if wc.inflight[id] {
+			continue
+		}
+		if !delivery.NextAttempt.After(now) {
+			wc.inflight[id] = true
+			due = append(due, delivery)
+			continue
+		}
+		if next.IsZero() || delivery.NextAttempt.Before(next) {
+			next = delivery.NextAttempt
+		}
+	}
+
+	return due, next
+}
+
+func (wc *WebhookCallback) worker() {
+	defer wc.wg.Done()
+
+	for {
+		select {
+		case <-wc.stopChan:
+			return
+		case delivery := <-wc.work:
+			err := wc.deliver(delivery)
+			wc.complete(delivery, err)
+		}
+	}
+}
+
+func (wc *WebhookCallback) deliver(delivery *Delivery) error {
+	ctx, cancel := context.WithTimeout(context.Background(), wc.options.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return err
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderEvent, delivery.EventType)
+	req.Header.Set(HeaderDelivery, delivery.ID)
+	req.Header.Set(HeaderTimestamp, timestamp)
+	if len(wc.options.Secret) > 0 {
+		req.Header.Set(HeaderSignature, Sign(wc.options.Secret, timestamp, delivery.Payload))
+	}
+
+	resp, err := wc.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body) //nolint:errcheck
+
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		return fmt.Errorf("%w: %s returned %d", ErrDeliveryFailed, delivery.URL, resp.StatusCode)
+	}
+
+	return nil
+}
+
+// complete removes a successful delivery or schedules the next attempt
+func (wc *WebhookCallback) complete(delivery *Delivery, err error) {
+	wc.mu.Lock()
+	defer wc.mu.Unlock()
+
+	delete(wc.inflight, delivery.ID)
+
+	if err == nil {
+		klog.V(5).Infof("delivered %s (%s) to %s\n", delivery.ID, delivery.EventType, delivery.URL)
+		delete(wc.pending, delivery.ID)
+		wc.remove(pendingDir, delivery)
+		return
+	}
+
+	delivery.Attempts++
+	delivery.LastError = err.Error()
+
+	if delivery.Attempts >= wc.options.MaxAttempts {
+		klog.V(1).Infof("dead lettering %s to %s after %d attempts. Err: %v\n", delivery.ID, delivery.URL, delivery.Attempts, err)
+		delete(wc.pending, delivery.ID)
+		if errPersist := wc.persist(deadLetterDir, delivery); errPersist != nil {
+			klog.V(1).Infof("persist dead letter failed. Err: %v\n", errPersist)
+		}
+		wc.remove(pendingDir, delivery)
+		return
+	}
+
+	delivery.NextAttempt = time.Now().Add(wc.backoff(delivery.Attempts))
+	klog.V(3).Infof("delivery %s to %s failed (attempt %d), retry at %v. Err: %v\n", delivery.ID, delivery.URL, delivery.Attempts, delivery.NextAttempt, err)
+
+	if errPersist := wc.persist(pendingDir, delivery); errPersist != nil {
+		klog.V(1).Infof("persist failed. Err: %v\n", errPersist)
+	}
+	wc.signal()
+}
+
+// backoff is exponential with up to 20% jitter
+func (wc *WebhookCallback) backoff(attempts int) time.Duration {
+	backoff := wc.options.InitialBackoff
+	for i := 1; i < attempts && backoff < wc.options.MaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > wc.options.MaxBackoff {
+		backoff = wc.options.MaxBackoff
+	}
+	jitter := time.Duration(rand.Int63n(int64(backoff)/5 + 1))
+	return backoff + jitter
+}
@@ -0,0 +1,34 @@
+// Copyright 2023 dvonthenen ChatGPT Proxy contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache License 2.0
+
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+)
+
+// Sign returns the value of HeaderSignature for a payload. The signed message is
+// the HeaderTimestamp value, a period and the raw request body.
+func Sign(secret, timestamp string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(payload)
+	return SignaturePrefix + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks a received HeaderSignature value. Receivers should also reject
+// timestamps that are too old to prevent replays.
+func Verify(secret, timestamp, signature string, payload []byte) error {
+	if !strings.HasPrefix(signature, SignaturePrefix) {
+		return ErrInvalidSignature
+	}
+	expected := Sign(secret, timestamp, payload)
+	if !hmac.Equal([]byte(expected), []byte(signature)) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+// Copyright 2023 dvonthenen ChatGPT Proxy contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache License 2.0
+
+package webhook
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// WebhookOptions configures where and how events are delivered
+type WebhookOptions struct {
+	// URLs receive a POST for every event
+	URLs []string
+
+	// Secret is the HMAC-SHA256 key used to sign each payload
+	Secret string
+
+	// QueueDir persists pending and dead-lettered deliveries
+	QueueDir string
+
+	MaxQueue       int
+	MaxAttempts    int
+	Workers        int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	Timeout        time.Duration
+
+	HTTPClient *http.Client
+}
+
+// Delivery is a single event destined for a single URL
+type Delivery struct {
+	ID          string          `json:"id"`
+	EventID     string          `json:"event_id"`
+	EventType   string          `json:"event_type"`
+	URL         string          `json:"url"`
+	Payload     json.RawMessage `json:"payload"`
+	Attempts    int             `json:"attempts"`
+	NextAttempt time.Time       `json:"next_attempt"`
+	LastError   string          `json:"last_error,omitempty"`
+}
+
+// WebhookCallback implements interfaces.ChatGPTCallback by POSTing every event
+// to the configured URLs
+type WebhookCallback struct {
+	options *WebhookOptions
+	client  *http.Client
+
+	// queue
+	pending  map[string]*Delivery
+	inflight map[string]bool
+	work     chan *Delivery
+	wake     chan struct{}
+
+	// housekeeping
+	stopChan chan struct{}
+	stopped  bool
+	wg       sync.WaitGroup
+	mu       sync.Mutex
+}
@@ -0,0 +1,205 @@
+// Copyright 2023 dvonthenen ChatGPT Proxy contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache License 2.0
+
+package webhook
+
+import (
+	"net/http"
+
+	openai "github.com/sashabaranov/go-openai"
+	klog "k8s.io/klog/v2"
+
+	interfaces "github.com/dvonthenen/chat-gpeasy/pkg/proxy/interfaces"
+)
+
+// New creates the webhook callback, restores any deliveries persisted in
+// QueueDir and starts delivering
+func New(options WebhookOptions) (*WebhookCallback, error) {
+	if len(options.URLs) == 0 {
+		klog.V(1).Infof("no webhook URLs provided\n")
+		return nil, ErrInvalidInput
+	}
+	if len(options.QueueDir) == 0 {
+		klog.V(3).Infof("QueueDir not set. pending deliveries will not survive a restart\n")
+	}
+	if options.MaxQueue <= 0 {
+		options.MaxQueue = DefaultMaxQueue
+	}
+	if options.MaxAttempts <= 0 {
+		options.MaxAttempts = DefaultMaxAttempts
+	}
+	if options.Workers <= 0 {
+		options.Workers = DefaultWorkers
+	}
+	if options.InitialBackoff <= 0 {
+		options.InitialBackoff = DefaultInitialBackoff
+	}
+	if options.MaxBackoff <= 0 {
+		options.MaxBackoff = DefaultMaxBackoff
+	}
+	if options.Timeout <= 0 {
+		options.Timeout = DefaultTimeout
+	}
+
+	client := options.HTTPClient
+	if client == nil {
+		client = &http.Client{}
+	}
+
+	wc := &WebhookCallback{
+		options:  &options,
+		client:   client,
+		pending:  make(map[string]*Delivery),
+		inflight: make(map[string]bool),
+		work:     make(chan *Delivery, options.Workers),
+		wake:     make(chan struct{}, 1),
+		stopChan: make(chan struct{}),
+	}
+
+	err := wc.load()
+	if err != nil {
+		klog.V(1).Infof("load failed. Err: %v\n", err)
+		return nil, err
+	}
+
+	wc.wg.Add(1 + options.Workers)
+	go wc.dispatch()
+	for i := 0; i < options.Workers; i++ {
+		go wc.worker()
+	}
+
+	return wc, nil
+}
+
+// Stop waits for in-flight deliveries to finish. Anything still pending stays
+// in QueueDir and is picked up by the next New.
+func (wc *WebhookCallback) Stop() error {
+	wc.mu.Lock()
+	if wc.stopped {
+		wc.mu.Unlock()
+		return nil
+	}
+	wc.stopped = true
+	close(wc.stopChan)
+	wc.mu.Unlock()
+
+	wc.wg.Wait()
+
+	klog.V(4).Infof("webhook stopped with %d pending deliveries\n", wc.Pending())
+	return nil
+}
+
+// Pending returns the number of deliveries waiting to be sent or retried
+func (wc *WebhookCallback) Pending() int {
+	wc.mu.Lock()
+	defer wc.mu.Unlock()
+	return len(wc.pending)
+}
+
+// DeadLetters returns the deliveries that exhausted MaxAttempts
+func (wc *WebhookCallback) DeadLetters() ([]*Delivery, error) {
+	return wc.read(deadLetterDir)
+}
+
+// OnEvent implements interfaces.ChatGPTEventCallback. The payload is the event
+// handed over by the proxy, so the caller identity, upstream key, detections
+// and event ID survive and the receiver can deduplicate on the event ID.
+func (wc *WebhookCallback) OnEvent(event *interfaces.Event) error {
+	return wc.enqueue(event)
+}
+
+func (wc *WebhookCallback) CreateTranscription(request openai.AudioRequest, response openai.AudioResponse) error {
+	return wc.enqueue(interfaces.NewEvent(interfaces.EventTypeCreateTranscription, request, response))
+}
+
+func (wc *WebhookCallback) CreateTranslation(request openai.AudioRequest, response openai.AudioResponse) error {
+	return wc.enqueue(interfaces.NewEvent(interfaces.EventTypeCreateTranslation, request, response))
+}
+
+func (wc *WebhookCallback) CreateCompletion(request openai.CompletionRequest, response openai.CompletionResponse) error {
+	return wc.enqueue(interfaces.NewEvent(interfaces.EventTypeCreateCompletion, request, response))
+}
+
+func (wc *WebhookCallback) CreateChatCompletion(request openai.ChatCompletionRequest, response openai.ChatCompletionResponse) error {
+	return wc.enqueue(interfaces.NewEvent(interfaces.EventTypeCreateChatCompletion, request, response))
+}
+
+func (wc *WebhookCallback) Edits(request openai.EditsRequest, response openai.EditsResponse) error {
+	return wc.enqueue(interfaces.NewEvent(interfaces.EventTypeEdits, request, response))
+}
+
+func (wc *WebhookCallback) CreateEmbeddings(request openai.EmbeddingRequest, response openai.EmbeddingResponse) error {
+	return wc.enqueue(interfaces.NewEvent(interfaces.EventTypeCreateEmbeddings, request, response))
+}
+
+func (wc *WebhookCallback) ListFiles(list openai.FilesList) error {
+	return wc.enqueue(interfaces.NewEvent(interfaces.EventTypeListFiles, nil, list))
+}
+
+func (wc *WebhookCallback) CreateFile(request openai.FileRequest, response openai.File) error {
+	return wc.enqueue(interfaces.NewEvent(interfaces.EventTypeCreateFile, request, response))
+}
+
+func (wc *WebhookCallback) DeleteFile(ID string) error {
+	return wc.enqueue(interfaces.NewEvent(interfaces.EventTypeDeleteFile, interfaces.IDRequest{ID: ID}, nil))
+}
+
+func (wc *WebhookCallback) GetFile(ID string, response openai.File) error {
+	return wc.enqueue(interfaces.NewEvent(interfaces.EventTypeGetFile, interfaces.IDRequest{ID: ID}, response))
+}
+
+// GetFileContent: Not implemented in Go SDK
+
+func (wc *WebhookCallback) CreateFineTune(request openai.FineTuneRequest, response openai.FineTune) error {
+	return wc.enqueue(interfaces.NewEvent(interfaces.EventTypeCreateFineTune, request, response))
+}
+
+func (wc *WebhookCallback) ListFineTunes(list openai.FineTuneList) error {
+	return wc.enqueue(interfaces.NewEvent(interfaces.EventTypeListFineTunes, nil, list))
+}
+
+func (wc *WebhookCallback) GetFineTune(ID string, response openai.FineTune) error {
+	return wc.enqueue(interfaces.NewEvent(interfaces.EventTypeGetFineTune, interfaces.IDRequest{ID: ID}, response))
+}
+
+func (wc *WebhookCallback) CancelFineTune(ID string, response openai.FineTune) error {
+	return wc.enqueue(interfaces.NewEvent(interfaces.EventTypeCancelFineTune, interfaces.IDRequest{ID: ID}, response))
+}
+
+func (wc *WebhookCallback) ListFineTuneEvents(ID string, response openai.FineTuneEventList) error {
+	return wc.enqueue(interfaces.NewEvent(interfaces.EventTypeListFineTuneEvents, interfaces.IDRequest{ID: ID}, response))
+}
+
+func (wc *WebhookCallback) DeleteFineTune(ID string) error {
+	return wc.enqueue(interfaces.NewEvent(interfaces.EventTypeDeleteFineTune, interfaces.IDRequest{ID: ID}, nil))
+}
+
+func (wc *WebhookCallback) CreateImage(request openai.ImageRequest, response openai.ImageResponse) error {
+	return wc.enqueue(interfaces.NewEvent(interfaces.EventTypeCreateImage, request, response))
+}
+
+func (wc *WebhookCallback) CreateEditImage(request openai.ImageEditRequest, response openai.ImageResponse) error {
+	return wc.enqueue(interfaces.NewEvent(interfaces.EventTypeCreateEditImage, request, response))
+}
+
+func (wc *WebhookCallback) CreateVariImage(request openai.ImageVariRequest, response openai.ImageResponse) error {
+	return wc.enqueue(interfaces.NewEvent(interfaces.EventTypeCreateVariImage, request, response))
+}
+
+func (wc *WebhookCallback) ListModels(list openai.ModelsList) error {
+	return wc.enqueue(interfaces.NewEvent(interfaces.EventTypeListModels, nil, list))
+}
+
+func (wc *WebhookCallback) Moderations(request openai.ModerationRequest, response openai.ModerationResponse) error {
+	return wc.enqueue(interfaces.NewEvent(interfaces.EventTypeModerations, request, response))
+}
+
+func (wc *WebhookCallback) CircuitBreaker(change interfaces.BreakerStateChange) error {
+	return wc.enqueue(interfaces.NewEvent(interfaces.EventTypeCircuitBreaker, change, nil))
+}
+
+func (wc *WebhookCallback) PromptBlocked(request interface{}, detections []interfaces.Detection) error {
+	event := interfaces.NewEvent(interfaces.EventTypePromptBlocked, request, nil)
+	event.Detections = detections
+	return wc.enqueue(event)
+}
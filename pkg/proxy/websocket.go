@@ -0,0 +1,563 @@
+// Copyright 2023 dvonthenen ChatGPT Proxy contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache License 2.0
+
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	websocket "github.com/dvonthenen/websocket"
+	openai "github.com/sashabaranov/go-openai"
+	klog "k8s.io/klog/v2"
+
+	personainterfaces "github.com/dvonthenen/chat-gpeasy/pkg/personas/interfaces"
+	streamadvanced "github.com/dvonthenen/chat-gpeasy/pkg/personas/stream/advanced"
+	breaker "github.com/dvonthenen/chat-gpeasy/pkg/proxy/breaker"
+	interfaces "github.com/dvonthenen/chat-gpeasy/pkg/proxy/interfaces"
+	keypool "github.com/dvonthenen/chat-gpeasy/pkg/proxy/keypool"
+	queue "github.com/dvonthenen/chat-gpeasy/pkg/proxy/queue"
+	sessions "github.com/dvonthenen/chat-gpeasy/pkg/proxy/sessions"
+)
+
+// newChatSocketUpgrader accepts WebSocket handshakes from the allowed origins
+func newChatSocketUpgrader(options ChatSocketOptions) *websocket.Upgrader {
+	upgrader := &websocket.Upgrader{}
+	if len(options.AllowedOrigins) == 0 {
+		return upgrader
+	}
+
+	allowed := make(map[string]bool)
+	for _, origin := range options.AllowedOrigins {
+		allowed[strings.ToLower(strings.TrimSuffix(origin, "/"))] = true
+	}
+	upgrader.CheckOrigin = func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		if len(origin) == 0 || allowed["*"] {
+			return true
+		}
+		u, err := url.Parse(origin)
+		if err != nil {
+			return false
+		}
+		return allowed[strings.ToLower(u.Scheme+"://"+u.Host)]
+	}
+	return upgrader
+}
+
+// serve reads the client's frames until the connection is closed. Queries and
+// edits stream in the background so a cancel frame can stop them.
+func (s *chatSocket) serve() {
+	options := *s.proxy.options.ChatSocket
+	if options.PingInterval == 0 {
+		options.PingInterval = DefaultChatSocketPingInterval
+	}
+	if options.ReadLimit == 0 {
+		options.ReadLimit = DefaultChatSocketReadLimit
+	}
+
+	s.conn.SetReadLimit(options.ReadLimit)
+	s.conn.SetReadDeadline(time.Now().Add(2 * options.PingInterval))
+	s.conn.SetPongHandler(func(string) error {
+		return s.conn.SetReadDeadline(time.Now().Add(2 * options.PingInterval))
+	})
+
+	stop := make(chan struct{})
+	go s.keepalive(options.PingInterval, stop)
+
+	defer func() {
+		close(stop)
+		s.cancel()
+		s.running.Wait()
+		s.conn.Close()
+	}()
+
+	for {
+		_, data, err := s.conn.ReadMessage()
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				klog.V(1).Infof("ReadMessage failed. Err: %v\n", err)
+			} else {
+				klog.V(3).Infof("chat socket closed. Err: %v\n", err)
+			}
+			return
+		}
+		s.conn.SetReadDeadline(time.Now().Add(2 * options.PingInterval))
+
+		var frame ChatSocketFrame
+		if err := json.Unmarshal(data, &frame); err != nil {
+			klog.V(1).Infof("json.Unmarshal failed. Err: %v\n", err)
+			s.sendError("", "invalid frame")
+			continue
+		}
+		s.handle(frame)
+	}
+}
+
+func (s *chatSocket) keepalive(interval time.Duration, stop chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			err := s.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(chatSocketWriteWait))
+			if err != nil {
+				klog.V(3).Infof("WriteControl failed. Err: %v\n", err)
+				return
+			}
+		}
+	}
+}
+
+// handle runs the operation of a frame, one at a time
+func (s *chatSocket) handle(frame ChatSocketFrame) {
+	klog.V(5).Infof("chat socket frame: %s (id: %s)\n", frame.Type, frame.ID)
+
+	switch frame.Type {
+	case FrameCancel:
+		s.cancel()
+		return
+	case FrameConversation:
+		s.sendConversation(frame.ID)
+		return
+	case FrameInit, FrameDirective, FrameQuery, FrameEdit:
+	default:
+		s.sendError(frame.ID, "unknown frame type")
+		return
+	}
+
+	if !s.begin() {
+		s.sendError(frame.ID, "an operation is already running")
+		return
+	}
+
+	switch frame.Type {
+	case FrameInit:
+		defer s.end()
+		s.init(frame)
+	case FrameDirective:
+		defer s.end()
+		s.directive(frame)
+	default:
+		go func() {
+			defer s.end()
+			s.query(frame)
+		}()
+	}
+}
+
+// init replaces the conversation, like AdvancedChat.InitWithProvided
+func (s *chatSocket) init(frame ChatSocketFrame) {
+	p := s.proxy
+	ctx := p.requestContext(s.c)
+
+	model := frame.Model
+	if len(model) == 0 {
+		model = s.model
+	}
+	if err := p.checkModel(s.c, model); err != nil {
+		s.sendError(frame.ID, err.Error())
+		return
+	}
+
+	skillName := frame.Skill
+	if len(skillName) == 0 && len(frame.Messages) == 0 {
+		skillName = defaultChatSkill
+	}
+
+	var messages []sessions.Message
+	if len(skillName) > 0 {
+		skill, ok := sessionSkills[skillName]
+		if !ok {
+			s.sendError(frame.ID, "unknown skill")
+			return
+		}
+		skillMessages, err := p.skillMessages(skill, model)
+		if err != nil {
+			klog.V(1).Infof("skillMessages failed. Err: %v\n", err)
+			s.sendError(frame.ID, err.Error())
+			return
+		}
+		messages = append(messages, skillMessages...)
+	}
+	for _, message := range frame.Messages {
+		if !validSessionRole(message.Role) || len(message.Content) == 0 {
+			s.sendError(frame.ID, "invalid message")
+			return
+		}
+	}
+	messages = append(messages, frame.Messages...)
+
+	if detections := p.screenPrompt(ctx, callerKey(s.c), routeChatSocket, sessionRequest(model, messages)); detections != nil {
+		s.sendBlocked(frame.ID, detections)
+		return
+	}
+
+	s.mu.Lock()
+	s.model = model
+	s.conversation = toCompletionMessages(messages)
+	s.mu.Unlock()
+
+	s.sendConversation(frame.ID)
+}
+
+// directive adds a system directive, like AdvancedChatStream.AddDirective
+func (s *chatSocket) directive(frame ChatSocketFrame) {
+	p := s.proxy
+	ctx := p.requestContext(s.c)
+
+	if len(frame.Content) == 0 {
+		s.sendError(frame.ID, "content is empty")
+		return
+	}
+
+	model, conversation := s.state()
+
+	directive := sessions.Message{Role: openai.ChatMessageRoleSystem, Content: frame.Content}
+	if detections := p.screenPrompt(ctx, callerKey(s.c), routeChatSocket, sessionRequest(model, []sessions.Message{directive})); detections != nil {
+		s.sendBlocked(frame.ID, detections)
+		return
+	}
+
+	persona, err := streamadvanced.New(p.chatgptClient)
+	if err == nil {
+		err = persona.DynamicInit(model, conversation)
+	}
+	if err == nil {
+		err = persona.AddDirective(frame.Content)
+	}
+	if err == nil {
+		conversation, err = persona.GetConversation()
+	}
+	if err != nil {
+		klog.V(1).Infof("AddDirective failed. Err: %v\n", err)
+		s.sendError(frame.ID, err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	s.conversation = conversation
+	s.mu.Unlock()
+
+	s.sendConversation(frame.ID)
+}
+
+// query streams the reply to a query or an edit, like AdvancedChatStream.Query
+// and AdvancedChatStream.EditConversation. The tokens pass through the output
+// filter and the reply is raised as a CreateChatCompletion event.
+func (s *chatSocket) query(frame ChatSocketFrame) {
+	p := s.proxy
+
+	ctx, stop := context.WithCancel(p.requestContext(s.c))
+	defer stop()
+
+	s.mu.Lock()
+	s.stop = stop
+	cancelled := s.cancelled
+	s.mu.Unlock()
+	if cancelled {
+		s.send(ChatSocketFrame{Type: FrameDone, ID: frame.ID, FinishReason: FinishReasonCancelled})
+		return
+	}
+
+	model, conversation := s.state()
+
+	if len(frame.Content) == 0 {
+		s.sendError(frame.ID, "content is empty")
+		return
+	}
+	role := openai.ChatMessageRoleUser
+	if frame.Type == FrameEdit {
+		// responses can not be edited, only the messages that led to them
+		if frame.Index == nil || *frame.Index < 0 || *frame.Index >= len(conversation) || conversation[*frame.Index].Role == openai.ChatMessageRoleAssistant {
+			s.sendError(frame.ID, "invalid index")
+			return
+		}
+		role = conversation[*frame.Index].Role
+	}
+
+	message := sessions.Message{Role: role, Content: frame.Content}
+	if detections := p.screenPrompt(ctx, callerKey(s.c), routeChatSocket, sessionRequest(model, []sessions.Message{message})); detections != nil {
+		s.sendBlocked(frame.ID, detections)
+		return
+	}
+
+	writer := &chatSocketWriter{
+		socket: s,
+		id:     frame.ID,
+	}
+
+	var updated []personainterfaces.CompletionMessage
+	var request openai.ChatCompletionRequest
+	err := p.upstream(ctx, upstreamOpenAI, routeChatCompletions, func(ctx context.Context) error {
+		client := p.client(ctx)
+		persona, err := streamadvanced.New(client)
+		if err != nil {
+			return err
+		}
+		if err := persona.DynamicInit(model, conversation); err != nil {
+			return err
+		}
+		persona.SetRequestHook(func(sent *openai.ChatCompletionRequest) {
+			p.applySystemPrompt(s.c, sent)
+			p.applyPolicies(ctx, s.c, sent)
+			request = *sent
+		})
+
+		var completion *personainterfaces.StreamingCompletion
+		if frame.Type == FrameEdit {
+			completion, err = persona.EditConversation(*frame.Index, frame.Content)
+		} else {
+			completion, err = persona.Query(ctx, frame.Content)
+		}
+		if err != nil {
+			return err
+		}
+		streamOpened(ctx)
+
+		writer.filter = p.newOutputStream(ctx, client)
+		if writer.filter != nil {
+			defer func() {
+				p.recordOutput(ctx, writer.filter.Finish())
+			}()
+		}
+
+		if !s.streaming(completion) {
+			(*completion).Close()
+			return context.Canceled
+		}
+		err = (*completion).Stream(writer)
+		(*completion).Close()
+
+		if errors.Is(err, errOutputBlocked) {
+			err = nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := writer.flush(); err != nil {
+			return err
+		}
+
+		updated, err = persona.GetConversation()
+		return err
+	})
+
+	s.mu.Lock()
+	cancelled = s.cancelled
+	s.completion = nil
+	s.mu.Unlock()
+
+	// a failed or cancelled operation leaves the conversation as it was
+	if err != nil || len(updated) == 0 {
+		if cancelled {
+			s.send(ChatSocketFrame{Type: FrameDone, ID: frame.ID, FinishReason: FinishReasonCancelled})
+			return
+		}
+		klog.V(1).Infof("chat socket %s failed. Err: %v\n", frame.Type, err)
+		s.sendError(frame.ID, upstreamMessage(err, "chat completion failed"))
+		return
+	}
+
+	// keep the reply the client saw
+	reply := writer.sent.String()
+	finishReason := FinishReasonStop
+	switch {
+	case writer.blocked:
+		reply = p.output.BlockMessage()
+		finishReason = FinishReasonContentFilter
+		s.send(ChatSocketFrame{Type: FrameToken, ID: frame.ID, Content: reply})
+	case cancelled:
+		finishReason = FinishReasonCancelled
+	}
+
+	last := len(updated) - 1
+	updated[last].Content = reply
+
+	s.mu.Lock()
+	s.conversation = updated
+	s.mu.Unlock()
+
+	resp := openai.ChatCompletionResponse{
+		Object:  chatCompletionObject,
+		Created: time.Now().Unix(),
+		Model:   model,
+		Choices: []openai.ChatCompletionChoice{
+			{
+				Message: openai.ChatCompletionMessage{
+					Role:    openai.ChatMessageRoleAssistant,
+					Content: reply,
+				},
+				FinishReason: openai.FinishReason(finishReason),
+			},
+		},
+	}
+
+	p.recordUsage(ctx, request, resp)
+
+	if p.callback != nil {
+		klog.V(6).Infof("CreateChatCompletion Callback...\n")
+		err = p.dispatch(ctx, interfaces.EventTypeCreateChatCompletion, request, resp)
+		if err != nil {
+			klog.V(1).Infof("[CALLBACK] CreateChatCompletion failed. Err: %v\n", err)
+		}
+	}
+
+	index := last
+	s.send(ChatSocketFrame{Type: FrameDone, ID: frame.ID, Index: &index, FinishReason: finishReason})
+}
+
+// begin claims the socket for an operation
+func (s *chatSocket) begin() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.busy {
+		return false
+	}
+	s.busy = true
+	s.cancelled = false
+	s.running.Add(1)
+	return true
+}
+
+func (s *chatSocket) end() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.busy = false
+	s.stop = nil
+	s.completion = nil
+	s.running.Done()
+}
+
+// streaming makes the completion the one a cancel frame closes. It returns
+// false when the operation was cancelled before the stream started.
+func (s *chatSocket) streaming(completion *personainterfaces.StreamingCompletion) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.cancelled {
+		return false
+	}
+	s.completion = completion
+	return true
+}
+
+// cancel stops the running operation with StreamingCompletion.Close, or by
+// cancelling its context when the stream has not started yet
+func (s *chatSocket) cancel() {
+	s.mu.Lock()
+	if !s.busy {
+		s.mu.Unlock()
+		return
+	}
+	s.cancelled = true
+	completion := s.completion
+	stop := s.stop
+	s.mu.Unlock()
+
+	if completion != nil {
+		(*completion).Close()
+	}
+	if stop != nil {
+		stop()
+	}
+}
+
+func (s *chatSocket) state() (string, []personainterfaces.CompletionMessage) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	conversation := make([]personainterfaces.CompletionMessage, len(s.conversation))
+	copy(conversation, s.conversation)
+	return s.model, conversation
+}
+
+func (s *chatSocket) send(frame ChatSocketFrame) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	s.conn.SetWriteDeadline(time.Now().Add(chatSocketWriteWait))
+	err := s.conn.WriteJSON(frame)
+	if err != nil {
+		klog.V(3).Infof("WriteJSON failed. Err: %v\n", err)
+	}
+	return err
+}
+
+func (s *chatSocket) sendConversation(id string) {
+	model, conversation := s.state()
+	s.send(ChatSocketFrame{
+		Type:     FrameConversation,
+		ID:       id,
+		Model:    model,
+		Messages: toSessionMessages(conversation),
+	})
+}
+
+func (s *chatSocket) sendError(id, message string) {
+	s.send(ChatSocketFrame{Type: FrameError, ID: id, Message: message})
+}
+
+func (s *chatSocket) sendBlocked(id string, detections []interfaces.Detection) {
+	s.send(ChatSocketFrame{Type: FrameError, ID: id, Message: "prompt blocked by policy", Detections: detections})
+}
+
+// Write sends the text the output filter lets through as token frames
+func (w *chatSocketWriter) Write(b []byte) (int, error) {
+	text, blocked := filterDelta(w.filter, 0, string(b), false)
+	if blocked {
+		w.blocked = true
+		return 0, errOutputBlocked
+	}
+	if err := w.token(text); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// flush sends the text the output filter held back
+func (w *chatSocketWriter) flush() error {
+	if w.blocked {
+		return nil
+	}
+	text, blocked := filterDelta(w.filter, 0, "", true)
+	if blocked {
+		w.blocked = true
+		return nil
+	}
+	return w.token(text)
+}
+
+func (w *chatSocketWriter) token(text string) error {
+	if len(text) == 0 {
+		return nil
+	}
+	w.sent.WriteString(text)
+	return w.socket.send(ChatSocketFrame{Type: FrameToken, ID: w.id, Content: text})
+}
+
+// upstreamMessage describes a failed upstream call to the client, see
+// abortUpstream
+func upstreamMessage(err error, message string) string {
+	var openErr *breaker.OpenError
+	var keysErr *keypool.UnavailableError
+	switch {
+	case errors.As(err, &openErr):
+		return "upstream unavailable"
+	case errors.As(err, &keysErr):
+		return "no upstream key available"
+	case errors.Is(err, queue.ErrQueueFull), errors.Is(err, queue.ErrQueueTimeout):
+		return err.Error()
+	}
+	return message
+}
@@ -0,0 +1,217 @@
+// Copyright 2023 dvonthenen ChatGPT Proxy contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache License 2.0
+
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	websocket "github.com/dvonthenen/websocket"
+	"github.com/gin-gonic/gin"
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// newTestUpstream streams tokens as chat completion chunks. With hang set the
+// first reply stops after its first token until the client goes away.
+func newTestUpstream(t *testing.T, tokens []string, hang bool) (*httptest.Server, *int32) {
+	t.Helper()
+
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		call := atomic.AddInt32(&calls, 1)
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		for i, token := range tokens {
+			chunk := openai.ChatCompletionStreamResponse{
+				ID:     "chatcmpl-test",
+				Object: chatCompletionChunkObject,
+				Model:  openai.GPT3Dot5Turbo,
+				Choices: []openai.ChatCompletionStreamChoice{
+					{Delta: openai.ChatCompletionStreamChoiceDelta{Content: token}},
+				},
+			}
+			data, _ := json.Marshal(chunk)
+			fmt.Fprintf(w, "data: %s\n\n", data)
+			w.(http.Flusher).Flush()
+
+			if hang && call == 1 && i == 0 {
+				<-r.Context().Done()
+				return
+			}
+		}
+		fmt.Fprintf(w, "data: [DONE]\n\n")
+	}))
+	t.Cleanup(server.Close)
+
+	return server, &calls
+}
+
+func newTestChatSocket(t *testing.T, upstream *httptest.Server) *websocket.Conn {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	config := openai.DefaultConfig("test")
+	config.BaseURL = upstream.URL + "/v1"
+
+	options := ChatSocketOptions{}
+	p := &ChatGPTProxy{
+		options:            &ProxyOptions{ChatSocket: &options},
+		chatgptClient:      openai.NewClientWithConfig(config),
+		chatSocketUpgrader: newChatSocketUpgrader(options),
+	}
+
+	router := gin.New()
+	router.GET(routeChatSocket, p.getChatSocket)
+	server := httptest.NewServer(router)
+	t.Cleanup(server.Close)
+
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + routeChatSocket + "?skill=expert"
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("Dial failed. Err: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return conn
+}
+
+func sendFrame(t *testing.T, conn *websocket.Conn, frame ChatSocketFrame) {
+	t.Helper()
+	if err := conn.WriteJSON(frame); err != nil {
+		t.Fatalf("WriteJSON failed. Err: %v", err)
+	}
+}
+
+func readFrame(t *testing.T, conn *websocket.Conn) ChatSocketFrame {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	var frame ChatSocketFrame
+	if err := conn.ReadJSON(&frame); err != nil {
+		t.Fatalf("ReadJSON failed. Err: %v", err)
+	}
+	return frame
+}
+
+// readReply reads the token frames of a reply up to its done frame
+func readReply(t *testing.T, conn *websocket.Conn, id string) (string, ChatSocketFrame) {
+	t.Helper()
+
+	var reply strings.Builder
+	for {
+		frame := readFrame(t, conn)
+		if frame.ID != id {
+			t.Fatalf("frame id = %q, want %q", frame.ID, id)
+		}
+		switch frame.Type {
+		case FrameToken:
+			reply.WriteString(frame.Content)
+		case FrameDone:
+			return reply.String(), frame
+		default:
+			t.Fatalf("unexpected frame %+v", frame)
+		}
+	}
+}
+
+func readConversation(t *testing.T, conn *websocket.Conn) ChatSocketFrame {
+	t.Helper()
+	sendFrame(t, conn, ChatSocketFrame{Type: FrameConversation, ID: "history"})
+	frame := readFrame(t, conn)
+	if frame.Type != FrameConversation {
+		t.Fatalf("frame type = %s, want %s", frame.Type, FrameConversation)
+	}
+	return frame
+}
+
+func TestChatSocketQueryAndEdit(t *testing.T) {
+	upstream, calls := newTestUpstream(t, []string{"Hello", " there"}, false)
+	conn := newTestChatSocket(t, upstream)
+
+	sendFrame(t, conn, ChatSocketFrame{Type: FrameQuery, ID: "q1", Content: "Hi"})
+	reply, done := readReply(t, conn, "q1")
+	if reply != "Hello there" {
+		t.Errorf("reply = %q, want %q", reply, "Hello there")
+	}
+	if done.FinishReason != FinishReasonStop || done.Index == nil || *done.Index != 2 {
+		t.Errorf("done = %+v, want stop at index 2", done)
+	}
+
+	sendFrame(t, conn, ChatSocketFrame{Type: FrameDirective, ID: "d1", Content: "Answer briefly."})
+	if frame := readFrame(t, conn); frame.Type != FrameConversation || len(frame.Messages) != 4 {
+		t.Fatalf("directive answered %+v, want a conversation of 4 messages", frame)
+	}
+
+	index := 1
+	sendFrame(t, conn, ChatSocketFrame{Type: FrameEdit, ID: "e1", Index: &index, Content: "Hello"})
+	if _, done := readReply(t, conn, "e1"); done.FinishReason != FinishReasonStop {
+		t.Errorf("edit finished with %s, want %s", done.FinishReason, FinishReasonStop)
+	}
+
+	conversation := readConversation(t, conn)
+	roles := make([]string, 0, len(conversation.Messages))
+	for _, message := range conversation.Messages {
+		roles = append(roles, message.Role)
+	}
+	// only a trailing response is regenerated, the directive stays
+	if got := strings.Join(roles, ","); got != "system,user,assistant,system,assistant" {
+		t.Errorf("roles after edit = %s, want system,user,assistant,system,assistant", got)
+	}
+	if conversation.Messages[1].Content != "Hello" || conversation.Messages[4].Content != "Hello there" {
+		t.Errorf("conversation after edit = %+v", conversation.Messages)
+	}
+
+	// responses can not be edited
+	index = 4
+	sendFrame(t, conn, ChatSocketFrame{Type: FrameEdit, ID: "e2", Index: &index, Content: "No"})
+	if frame := readFrame(t, conn); frame.Type != FrameError {
+		t.Errorf("editing a response answered %+v, want an error", frame)
+	}
+
+	if got := atomic.LoadInt32(calls); got != 2 {
+		t.Errorf("upstream calls = %d, want 2", got)
+	}
+}
+
+func TestChatSocketCancel(t *testing.T) {
+	upstream, _ := newTestUpstream(t, []string{"Partial", " reply"}, true)
+	conn := newTestChatSocket(t, upstream)
+
+	sendFrame(t, conn, ChatSocketFrame{Type: FrameQuery, ID: "q1", Content: "Hi"})
+	if frame := readFrame(t, conn); frame.Type != FrameToken || frame.Content != "Partial" {
+		t.Fatalf("first frame = %+v, want the first token", frame)
+	}
+
+	// one operation at a time
+	sendFrame(t, conn, ChatSocketFrame{Type: FrameQuery, ID: "q2", Content: "Again"})
+	if frame := readFrame(t, conn); frame.Type != FrameError || frame.ID != "q2" {
+		t.Fatalf("second query answered %+v, want an error", frame)
+	}
+
+	sendFrame(t, conn, ChatSocketFrame{Type: FrameCancel})
+	reply, done := readReply(t, conn, "q1")
+	if done.FinishReason != FinishReasonCancelled {
+		t.Fatalf("done = %+v, want %s", done, FinishReasonCancelled)
+	}
+	if reply != "" {
+		t.Errorf("tokens after cancel = %q, want none", reply)
+	}
+
+	// the partial reply is kept and the conversation goes on
+	sendFrame(t, conn, ChatSocketFrame{Type: FrameQuery, ID: "q3", Content: "Go on"})
+	if reply, _ := readReply(t, conn, "q3"); reply != "Partial reply" {
+		t.Errorf("reply after cancel = %q, want %q", reply, "Partial reply")
+	}
+
+	conversation := readConversation(t, conn)
+	if len(conversation.Messages) != 5 || conversation.Messages[2].Content != "Partial" {
+		t.Errorf("conversation after cancel = %+v", conversation.Messages)
+	}
+}
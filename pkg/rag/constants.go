@@ -0,0 +1,36 @@
+// Copyright 2023 dvonthenen ChatGPT Proxy contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache License 2.0
+
+package rag
+
+import (
+	"errors"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+const (
+	DefaultChunkTokens    int                   = 400
+	DefaultChunkOverlap   int                   = 50
+	DefaultTopK           int                   = 4
+	DefaultEmbedBatchSize int                   = 100
+	DefaultEmbeddingModel openai.EmbeddingModel = openai.AdaEmbeddingV2
+
+	// files written to StoreOptions.Dir
+	indexFile     string = "index.json"
+	documentsFile string = "documents.json"
+
+	// metadata keys on the vector index
+	metadataDocumentID string = "document_id"
+)
+
+var (
+	// ErrInvalidInput required input was not found
+	ErrInvalidInput = errors.New("required input was not found")
+
+	// ErrDocumentNotFound no document with that ID
+	ErrDocumentNotFound = errors.New("document not found")
+
+	// ErrEmbeddingMismatch the number of embeddings does not match the chunks
+	ErrEmbeddingMismatch = errors.New("number of embeddings does not match the chunks")
+)
@@ -0,0 +1,425 @@
+// Copyright 2023 dvonthenen ChatGPT Proxy contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache License 2.0
+
+package rag
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	openai "github.com/sashabaranov/go-openai"
+	klog "k8s.io/klog/v2"
+
+	tokens "github.com/dvonthenen/chat-gpeasy/pkg/tokens"
+	vector "github.com/dvonthenen/chat-gpeasy/pkg/vector"
+)
+
+// New creates a store. When Dir is set, documents ingested by a previous run
+// are loaded.
+func New(client *openai.Client, options StoreOptions) (*Store, error) {
+	if client == nil {
+		klog.V(1).Infof("client is nil\n")
+		return nil, ErrInvalidInput
+	}
+	if options.EmbeddingModel == openai.Unknown {
+		options.EmbeddingModel = DefaultEmbeddingModel
+	}
+	if options.EmbedBatchSize <= 0 {
+		options.EmbedBatchSize = DefaultEmbedBatchSize
+	}
+	if options.ChunkTokens <= 0 {
+		options.ChunkTokens = DefaultChunkTokens
+	}
+	if options.ChunkOverlap < 0 {
+		options.ChunkOverlap = 0
+	} else if options.ChunkOverlap == 0 {
+		options.ChunkOverlap = DefaultChunkOverlap
+	}
+
+	s := &Store{
+		client:    client,
+		options:   &options,
+		index:     vector.New(),
+		documents: make(map[string]*Document),
+		chunks:    make(map[string]*Chunk),
+	}
+
+	if len(options.Dir) > 0 {
+		err := s.load()
+		if err != nil {
+			klog.V(1).Infof("load failed. Err: %v\n", err)
+			return nil, err
+		}
+	}
+
+	return s, nil
+}
+
+// Ingest chunks and embeds a document. A document with the same ID is replaced.
+func (s *Store) Ingest(ctx context.Context, document Document) (*Document, error) {
+	klog.V(6).Infof("rag.Ingest ENTER\n")
+
+	if len(document.Text) == 0 {
+		klog.V(1).Infof("document text is empty\n")
+		klog.V(6).Infof("rag.Ingest LEAVE\n")
+		return nil, ErrInvalidInput
+	}
+	if len(document.ID) == 0 {
+		document.ID = "doc-" + newID()
+	}
+
+	texts := Split(document.Text, s.options.ChunkTokens, s.options.ChunkOverlap)
+	if len(texts) == 0 {
+		klog.V(1).Infof("document has no content\n")
+		klog.V(6).Infof("rag.Ingest LEAVE\n")
+		return nil, ErrInvalidInput
+	}
+
+	embeddings, err := s.embed(ctx, texts)
+	if err != nil {
+		klog.V(1).Infof("embed failed. Err: %v\n", err)
+		klog.V(6).Infof("rag.Ingest LEAVE\n")
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// the new chunks reuse the IDs of the old ones. keep the old chunks so they
+	// can be put back when an Add fails.
+	previous := s.documentChunks(document.ID)
+
+	added := make(map[string]bool, len(texts))
+	for i, text := range texts {
+		chunk := &Chunk{
+			ID:         fmt.Sprintf("%s#%d", document.ID, i),
+			DocumentID: document.ID,
+			Index:      i,
+			Text:       text,
+			Tokens:     tokens.Count(text),
+		}
+
+		err := s.index.Add(chunk.ID, embeddings[i], map[string]string{metadataDocumentID: document.ID})
+		if err != nil {
+			klog.V(1).Infof("index.Add failed. Err: %v\n", err)
+
+			for id := range added {
+				s.index.Delete(id)
+				delete(s.chunks, id)
+			}
+			s.restoreChunks(previous)
+			klog.V(6).Infof("rag.Ingest LEAVE\n")
+			return nil, err
+		}
+		s.chunks[chunk.ID] = chunk
+		added[chunk.ID] = true
+	}
+
+	// every chunk is in, drop the old chunks past the end of the new document
+	for _, old := range previous {
+		if !added[old.chunk.ID] {
+			s.index.Delete(old.chunk.ID)
+			delete(s.chunks, old.chunk.ID)
+		}
+	}
+
+	document.Chunks = len(texts)
+	document.CreatedAt = time.Now().UTC()
+	document.Text = ""
+	s.documents[document.ID] = &document
+
+	err = s.save()
+	if err != nil {
+		klog.V(1).Infof("save failed. Err: %v\n", err)
+		klog.V(6).Infof("rag.Ingest LEAVE\n")
+		return nil, err
+	}
+
+	klog.V(4).Infof("ingested %s (%d chunks)\n", document.ID, document.Chunks)
+	klog.V(6).Infof("rag.Ingest LEAVE\n")
+
+	copied := document
+	return &copied, nil
+}
+
+// IngestFile ingests a text file using the file name as the title and the path
+// as the source
+func (s *Store) IngestFile(ctx context.Context, path string) (*Document, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		klog.V(1).Infof("os.ReadFile failed. Err: %v\n", err)
+		return nil, err
+	}
+
+	return s.Ingest(ctx, Document{
+		ID:     path,
+		Title:  filepath.Base(path),
+		Source: path,
+		Text:   string(data),
+	})
+}
+
+// Delete removes a document and its chunks
+func (s *Store) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.documents[id]; !ok {
+		return ErrDocumentNotFound
+	}
+	s.remove(id)
+
+	return s.save()
+}
+
+// Documents lists the ingested documents
+func (s *Store) Documents() []Document {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	documents := make([]Document, 0, len(s.documents))
+	for _, document := range s.documents {
+		documents = append(documents, *document)
+	}
+	sort.Slice(documents, func(i, j int) bool {
+		return documents[i].ID < documents[j].ID
+	})
+
+	return documents
+}
+
+// Retrieve returns the k chunks most similar to the query, best first
+func (s *Store) Retrieve(ctx context.Context, query string, k int) ([]Match, error) {
+	klog.V(6).Infof("rag.Retrieve ENTER\n")
+
+	if len(query) == 0 {
+		klog.V(1).Infof("query is empty\n")
+		klog.V(6).Infof("rag.Retrieve LEAVE\n")
+		return nil, ErrInvalidInput
+	}
+	if k <= 0 {
+		k = DefaultTopK
+	}
+
+	if s.index.Len() == 0 {
+		klog.V(4).Infof("index is empty\n")
+		klog.V(6).Infof("rag.Retrieve LEAVE\n")
+		return []Match{}, nil
+	}
+
+	embeddings, err := s.embed(ctx, []string{query})
+	if err != nil {
+		klog.V(1).Infof("embed failed. Err: %v\n", err)
+		klog.V(6).Infof("rag.Retrieve LEAVE\n")
+		return nil, err
+	}
+
+	results, err := s.index.Search(embeddings[0], vector.SearchOptions{
+		K: k,
+	})
+	if err != nil {
+		klog.V(1).Infof("index.Search failed. Err: %v\n", err)
+		klog.V(6).Infof("rag.Retrieve LEAVE\n")
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	matches := make([]Match, 0, len(results))
+	for _, result := range results {
+		chunk, ok := s.chunks[result.ID]
+		if !ok {
+			continue
+		}
+
+		match := Match{
+			Chunk: *chunk,
+			Score: result.Score,
+		}
+		if document, ok := s.documents[chunk.DocumentID]; ok {
+			match.Title = document.Title
+			match.Source = document.Source
+		}
+		matches = append(matches, match)
+	}
+
+	klog.V(4).Infof("retrieved %d chunks\n", len(matches))
+	klog.V(6).Infof("rag.Retrieve LEAVE\n")
+
+	return matches, nil
+}
+
+// embed sends the texts to the embeddings API in batches
+func (s *Store) embed(ctx context.Context, texts []string) ([][]float32, error) {
+	embeddings := make([][]float32, 0, len(texts))
+
+	for start := 0; start < len(texts); start += s.options.EmbedBatchSize {
+		end := start + s.options.EmbedBatchSize
+		if end > len(texts) {
+			end = len(texts)
+		}
+
+		resp, err := s.client.CreateEmbeddings(ctx, openai.EmbeddingRequest{
+			Input: texts[start:end],
+			Model: s.options.EmbeddingModel,
+		})
+		if err != nil {
+			klog.V(1).Infof("CreateEmbeddings failed. Err: %v\n", err)
+			return nil, err
+		}
+		if len(resp.Data) != end-start {
+			klog.V(1).Infof("expected %d embeddings, got %d\n", end-start, len(resp.Data))
+			return nil, ErrEmbeddingMismatch
+		}
+
+		// the API does not promise to keep the input order
+		batch := make([][]float32, end-start)
+		for _, data := range resp.Data {
+			if data.Index < 0 || data.Index >= len(batch) {
+				return nil, ErrEmbeddingMismatch
+			}
+			batch[data.Index] = data.Embedding
+		}
+		embeddings = append(embeddings, batch...)
+	}
+
+	return embeddings, nil
+}
+
+// remove deletes a document and its chunks. must be called with mu held.
+func (s *Store) remove(id string) {
+	document, ok := s.documents[id]
+	if !ok {
+		return
+	}
+
+	for i := 0; i < document.Chunks; i++ {
+		chunkID := fmt.Sprintf("%s#%d", id, i)
+		delete(s.chunks, chunkID)
+	}
+	s.index.DeleteMatching(map[string]string{metadataDocumentID: id})
+	delete(s.documents, id)
+}
+
+// documentChunks returns the chunks of a document with their vectors. must be
+// called with mu held.
+func (s *Store) documentChunks(id string) []storedChunk {
+	document, ok := s.documents[id]
+	if !ok {
+		return nil
+	}
+
+	chunks := make([]storedChunk, 0, document.Chunks)
+	for i := 0; i < document.Chunks; i++ {
+		chunk, ok := s.chunks[fmt.Sprintf("%s#%d", id, i)]
+		if !ok {
+			continue
+		}
+		item, ok := s.index.Get(chunk.ID)
+		if !ok {
+			continue
+		}
+		chunks = append(chunks, storedChunk{chunk: chunk, item: item})
+	}
+
+	return chunks
+}
+
+// restoreChunks puts back chunks returned by documentChunks. must be called
+// with mu held.
+func (s *Store) restoreChunks(chunks []storedChunk) {
+	for _, stored := range chunks {
+		err := s.index.Add(stored.item.ID, stored.item.Vector, stored.item.Metadata)
+		if err != nil {
+			klog.V(1).Infof("index.Add failed restoring %s. Err: %v\n", stored.item.ID, err)
+			continue
+		}
+		s.chunks[stored.chunk.ID] = stored.chunk
+	}
+}
+
+// save persists the store when Dir is set. must be called with mu held.
+func (s *Store) save() error {
+	if len(s.options.Dir) == 0 {
+		return nil
+	}
+
+	err := s.index.Save(filepath.Join(s.options.Dir, indexFile))
+	if err != nil {
+		return err
+	}
+
+	state := persisted{
+		Documents: make([]*Document, 0, len(s.documents)),
+		Chunks:    make([]*Chunk, 0, len(s.chunks)),
+	}
+	for _, document := range s.documents {
+		state.Documents = append(state.Documents, document)
+	}
+	for _, chunk := range s.chunks {
+		state.Chunks = append(state.Chunks, chunk)
+	}
+
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(s.options.Dir, documentsFile)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func (s *Store) load() error {
+	err := os.MkdirAll(s.options.Dir, 0700)
+	if err != nil {
+		return err
+	}
+
+	data, err := os.ReadFile(filepath.Join(s.options.Dir, documentsFile))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var state persisted
+	if err := json.Unmarshal(data, &state); err != nil {
+		return err
+	}
+
+	err = s.index.Load(filepath.Join(s.options.Dir, indexFile))
+	if err != nil {
+		return err
+	}
+
+	for _, document := range state.Documents {
+		s.documents[document.ID] = document
+	}
+	for _, chunk := range state.Chunks {
+		s.chunks[chunk.ID] = chunk
+	}
+
+	klog.V(4).Infof("loaded %d documents (%d chunks)\n", len(s.documents), len(s.chunks))
+	return nil
+}
+
+func newID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%d", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
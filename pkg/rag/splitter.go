@@ -0,0 +1,155 @@
+// Copyright 2023 dvonthenen ChatGPT Proxy contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache License 2.0
+
+package rag
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+
+	tokens "github.com/dvonthenen/chat-gpeasy/pkg/tokens"
+)
+
+var (
+	paragraphBreak = regexp.MustCompile(`\n\s*\n`)
+)
+
+type segment struct {
+	text   string
+	tokens int
+}
+
+// Split breaks text into chunks of at most maxTokens. It prefers to break on
+// paragraphs, then sentences, then words. The last overlap tokens of a chunk
+// are repeated at the start of the next one.
+func Split(text string, maxTokens, overlap int) []string {
+	if maxTokens <= 0 {
+		maxTokens = DefaultChunkTokens
+	}
+	if overlap < 0 || overlap >= maxTokens {
+		overlap = 0
+	}
+
+	segments := make([]segment, 0)
+	for _, paragraph := range paragraphBreak.Split(text, -1) {
+		paragraph = strings.TrimSpace(paragraph)
+		if len(paragraph) == 0 {
+			continue
+		}
+		segments = append(segments, segmentText(paragraph, maxTokens)...)
+		// marks the end of a paragraph
+		segments[len(segments)-1].text += "\n\n"
+	}
+
+	chunks := make([]string, 0)
+	current := make([]segment, 0)
+	size := 0
+	carried := 0 // segments at the start of current already in the last chunk
+
+	for _, seg := range segments {
+		if size+seg.tokens > maxTokens && len(current) > carried {
+			chunks = append(chunks, strings.TrimSpace(join(current)))
+
+			// carry the tail of the chunk over, leaving room for seg
+			tail := len(current)
+			tailSize := 0
+			for tail > 0 {
+				next := current[tail-1].tokens
+				if tailSize+next > overlap || tailSize+next+seg.tokens > maxTokens {
+					break
+				}
+				tailSize += next
+				tail--
+			}
+			current = append([]segment{}, current[tail:]...)
+			size = tailSize
+			carried = len(current)
+		}
+		current = append(current, seg)
+		size += seg.tokens
+	}
+	if len(current) > carried {
+		chunks = append(chunks, strings.TrimSpace(join(current)))
+	}
+
+	return chunks
+}
+
+// segmentText splits a paragraph into sentences, and sentences into words,
+// until every segment fits in maxTokens
+func segmentText(text string, maxTokens int) []segment {
+	count := tokens.Count(text)
+	if count <= maxTokens {
+		return []segment{{text: text, tokens: count}}
+	}
+
+	sentences := splitSentences(text)
+	if len(sentences) > 1 {
+		segments := make([]segment, 0, len(sentences))
+		for _, sentence := range sentences {
+			segments = append(segments, segmentText(sentence, maxTokens)...)
+		}
+		return segments
+	}
+
+	// a single long sentence, fall back to words
+	segments := make([]segment, 0)
+	words := strings.Fields(text)
+	current := ""
+	size := 0
+	for _, word := range words {
+		wordTokens := tokens.Count(" " + word)
+		if size+wordTokens > maxTokens && size > 0 {
+			segments = append(segments, segment{text: current, tokens: size})
+			current = ""
+			size = 0
+		}
+		if len(current) > 0 {
+			current += " "
+		}
+		current += word
+		size += wordTokens
+	}
+	if size > 0 {
+		segments = append(segments, segment{text: current, tokens: size})
+	}
+
+	return segments
+}
+
+// splitSentences breaks after ., ! or ? followed by whitespace
+func splitSentences(text string) []string {
+	sentences := make([]string, 0)
+	runes := []rune(text)
+
+	start := 0
+	for i := 0; i < len(runes); i++ {
+		switch runes[i] {
+		case '.', '!', '?':
+			if i+1 < len(runes) && unicode.IsSpace(runes[i+1]) {
+				sentence := strings.TrimSpace(string(runes[start : i+1]))
+				if len(sentence) > 0 {
+					sentences = append(sentences, sentence)
+				}
+				start = i + 1
+			}
+		}
+	}
+	if sentence := strings.TrimSpace(string(runes[start:])); len(sentence) > 0 {
+		sentences = append(sentences, sentence)
+	}
+
+	return sentences
+}
+
+func join(segments []segment) string {
+	var sb strings.Builder
+	for i, seg := range segments {
+		if i > 0 && !strings.HasSuffix(segments[i-1].text, "\n") {
+			sb.WriteString(" ")
+		}
+		sb.WriteString(seg.text)
+	}
+	return sb.String()
+}
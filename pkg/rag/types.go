@@ -0,0 +1,81 @@
+// Copyright 2023 dvonthenen ChatGPT Proxy contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache License 2.0
+
+package rag
+
+import (
+	"sync"
+	"time"
+
+	openai "github.com/sashabaranov/go-openai"
+
+	vector "github.com/dvonthenen/chat-gpeasy/pkg/vector"
+)
+
+// StoreOptions configures chunking, embedding and persistence
+type StoreOptions struct {
+	// Dir persists the index and documents when set
+	Dir string
+
+	EmbeddingModel openai.EmbeddingModel
+	EmbedBatchSize int
+
+	// ChunkTokens is the target size of a chunk. ChunkOverlap tokens from the
+	// end of a chunk are repeated at the start of the next one.
+	ChunkTokens  int
+	ChunkOverlap int
+}
+
+// Document is the input to Ingest
+type Document struct {
+	ID       string            `json:"id"`
+	Title    string            `json:"title,omitempty"`
+	Source   string            `json:"source,omitempty"`
+	Text     string            `json:"-"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+
+	Chunks    int       `json:"chunks"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Chunk is a piece of a document that is embedded on its own
+type Chunk struct {
+	ID         string `json:"id"`
+	DocumentID string `json:"document_id"`
+	Index      int    `json:"index"`
+	Text       string `json:"text"`
+	Tokens     int    `json:"tokens"`
+}
+
+// Match is a chunk returned by Retrieve
+type Match struct {
+	Chunk
+	Title  string  `json:"title,omitempty"`
+	Source string  `json:"source,omitempty"`
+	Score  float32 `json:"score"`
+}
+
+// storedChunk is a chunk with its vector, kept while a document is replaced
+type storedChunk struct {
+	chunk *Chunk
+	item  *vector.Item
+}
+
+// Store ingests documents and retrieves the chunks most relevant to a query
+type Store struct {
+	client  *openai.Client
+	options *StoreOptions
+	index   *vector.Index
+
+	// state
+	documents map[string]*Document
+	chunks    map[string]*Chunk
+
+	// housekeeping
+	mu sync.RWMutex
+}
+
+type persisted struct {
+	Documents []*Document `json:"documents"`
+	Chunks    []*Chunk    `json:"chunks"`
+}
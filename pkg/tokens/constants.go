@@ -0,0 +1,27 @@
+// Copyright 2023 dvonthenen ChatGPT Proxy contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache License 2.0
+
+package tokens
+
+import (
+	"errors"
+)
+
+const (
+	// DefaultContextLimit is used for models that are not in the model table
+	DefaultContextLimit int = 4096
+
+	// tokens added per chat message and to prime the assistant reply
+	tokensPerMessage int = 3
+	tokensPerName    int = 1
+	tokensPerReply   int = 3
+
+	// average number of characters a single token covers
+	charsPerToken  int = 6
+	digitsPerToken int = 3
+)
+
+var (
+	// ErrUnknownModel model was not found in the model table
+	ErrUnknownModel = errors.New("unknown model")
+)
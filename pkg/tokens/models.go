@@ -0,0 +1,74 @@
+// Copyright 2023 dvonthenen ChatGPT Proxy contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache License 2.0
+
+package tokens
+
+import (
+	"strings"
+)
+
+// list prices as published by OpenAI. dated snapshots (ie gpt-4-0314) resolve
+// to the longest matching prefix.
+var models = []ModelInfo{
+	{Name: "gpt-4-32k", ContextLimit: 32768, PromptPrice: 0.06, CompletionPrice: 0.12},
+	{Name: "gpt-4", ContextLimit: 8192, PromptPrice: 0.03, CompletionPrice: 0.06},
+	{Name: "gpt-3.5-turbo-16k", ContextLimit: 16384, PromptPrice: 0.003, CompletionPrice: 0.004},
+	{Name: "gpt-3.5-turbo", ContextLimit: 4096, PromptPrice: 0.0015, CompletionPrice: 0.002},
+	{Name: "text-davinci-003", ContextLimit: 4097, PromptPrice: 0.02, CompletionPrice: 0.02},
+	{Name: "text-davinci-002", ContextLimit: 4097, PromptPrice: 0.02, CompletionPrice: 0.02},
+	{Name: "text-davinci-001", ContextLimit: 2049, PromptPrice: 0.02, CompletionPrice: 0.02},
+	{Name: "text-davinci-edit-001", ContextLimit: 2049, PromptPrice: 0.02, CompletionPrice: 0.02},
+	{Name: "code-davinci-edit-001", ContextLimit: 2049, PromptPrice: 0.02, CompletionPrice: 0.02},
+	{Name: "text-curie-001", ContextLimit: 2049, PromptPrice: 0.002, CompletionPrice: 0.002},
+	{Name: "text-babbage-001", ContextLimit: 2049, PromptPrice: 0.0005, CompletionPrice: 0.0005},
+	{Name: "text-ada-001", ContextLimit: 2049, PromptPrice: 0.0004, CompletionPrice: 0.0004},
+	{Name: "davinci", ContextLimit: 2049, PromptPrice: 0.02, CompletionPrice: 0.02},
+	{Name: "curie", ContextLimit: 2049, PromptPrice: 0.002, CompletionPrice: 0.002},
+	{Name: "babbage", ContextLimit: 2049, PromptPrice: 0.0005, CompletionPrice: 0.0005},
+	{Name: "ada", ContextLimit: 2049, PromptPrice: 0.0004, CompletionPrice: 0.0004},
+	{Name: "text-embedding-ada-002", ContextLimit: 8191, PromptPrice: 0.0001, CompletionPrice: 0},
+}
+
+// Lookup returns the limits and pricing for a model
+func Lookup(model string) (ModelInfo, error) {
+	model = strings.ToLower(strings.TrimSpace(model))
+
+	found := -1
+	for pos, info := range models {
+		if !strings.HasPrefix(model, info.Name) {
+			continue
+		}
+		if found == -1 || len(info.Name) > len(models[found].Name) {
+			found = pos
+		}
+	}
+	if found == -1 {
+		return ModelInfo{Name: model, ContextLimit: DefaultContextLimit}, ErrUnknownModel
+	}
+
+	info := models[found]
+	info.Name = model
+	return info, nil
+}
+
+// ContextLimit returns the context window of a model or DefaultContextLimit
+// when the model is unknown
+func ContextLimit(model string) int {
+	info, _ := Lookup(model)
+	return info.ContextLimit
+}
+
+// EstimateCost projects the spend for a request using list prices. Unknown
+// models are priced at zero.
+func EstimateCost(model string, promptTokens, completionTokens int) Cost {
+	info, _ := Lookup(model)
+
+	cost := Cost{
+		Prompt:     float64(promptTokens) / 1000 * info.PromptPrice,
+		Completion: float64(completionTokens) / 1000 * info.CompletionPrice,
+		Currency:   "USD",
+	}
+	cost.Total = cost.Prompt + cost.Completion
+
+	return cost
+}
@@ -0,0 +1,101 @@
+// Copyright 2023 dvonthenen ChatGPT Proxy contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache License 2.0
+
+/*
+Package tokens provides offline token counting, model limits and list pricing
+for OpenAI models.
+
+Counts are an estimate, not the cl100k/p50k BPE encodings. Text is split the
+same way the OpenAI pre-tokenizer splits it (words, numbers, punctuation and
+whitespace) and each piece is then sized by its length. The estimate is meant
+for budgeting a request without shipping the BPE ranks. The usage OpenAI
+reports is the exact count.
+*/
+package tokens
+
+import (
+	"regexp"
+	"unicode"
+	"unicode/utf8"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+var pieces = regexp.MustCompile(`'s|'t|'re|'ve|'m|'ll|'d| ?\p{L}+| ?\p{N}+| ?[^\s\p{L}\p{N}]+|\s+`)
+
+// Count returns the approximate number of tokens in text
+func Count(text string) int {
+	if len(text) == 0 {
+		return 0
+	}
+
+	total := 0
+	for _, piece := range pieces.FindAllString(text, -1) {
+		total += countPiece(piece)
+	}
+
+	return total
+}
+
+func countPiece(piece string) int {
+	if len(piece) > 1 && piece[0] == ' ' {
+		// leading spaces are merged into the following word
+		piece = piece[1:]
+	}
+
+	r, _ := utf8.DecodeRuneInString(piece)
+	length := utf8.RuneCountInString(piece)
+
+	switch {
+	case unicode.IsSpace(r):
+		return 1
+	case r > unicode.MaxASCII:
+		// non-latin scripts rarely merge beyond a single character
+		return length
+	case unicode.IsNumber(r):
+		return ceil(length, digitsPerToken)
+	case unicode.IsLetter(r):
+		return ceil(length, charsPerToken)
+	default:
+		return ceil(length, 2)
+	}
+}
+
+// CountStrings returns the approximate number of tokens for each input
+func CountStrings(inputs []string) []int {
+	counts := make([]int, 0, len(inputs))
+	for _, input := range inputs {
+		counts = append(counts, Count(input))
+	}
+	return counts
+}
+
+// CountMessage returns the approximate number of tokens a single chat message
+// adds to a prompt, including the per message framing
+func CountMessage(message openai.ChatCompletionMessage) int {
+	total := tokensPerMessage
+	total += Count(message.Role)
+	total += Count(message.Content)
+	if len(message.Name) > 0 {
+		total += Count(message.Name) + tokensPerName
+	}
+	return total
+}
+
+// CountMessages returns the approximate number of prompt tokens for a chat
+// completion request, including priming the assistant reply
+func CountMessages(messages []openai.ChatCompletionMessage) int {
+	if len(messages) == 0 {
+		return 0
+	}
+
+	total := tokensPerReply
+	for _, message := range messages {
+		total += CountMessage(message)
+	}
+	return total
+}
+
+func ceil(length, size int) int {
+	return (length + size - 1) / size
+}
@@ -0,0 +1,21 @@
+// Copyright 2023 dvonthenen ChatGPT Proxy contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache License 2.0
+
+package tokens
+
+// ModelInfo describes the limits and list price of a model. Prices are in USD
+// per 1K tokens.
+type ModelInfo struct {
+	Name            string  `json:"name"`
+	ContextLimit    int     `json:"context_limit"`
+	PromptPrice     float64 `json:"prompt_price"`
+	CompletionPrice float64 `json:"completion_price"`
+}
+
+// Cost is a projected or actual spend in USD
+type Cost struct {
+	Prompt     float64 `json:"prompt"`
+	Completion float64 `json:"completion"`
+	Total      float64 `json:"total"`
+	Currency   string  `json:"currency"`
+}
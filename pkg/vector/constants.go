@@ -0,0 +1,21 @@
+// Copyright 2023 dvonthenen ChatGPT Proxy contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache License 2.0
+
+package vector
+
+import (
+	"errors"
+)
+
+const (
+	// DefaultK number of matches returned when SearchOptions.K is not set
+	DefaultK int = 5
+)
+
+var (
+	// ErrInvalidInput required input was not found
+	ErrInvalidInput = errors.New("required input was not found")
+
+	// ErrDimensionMismatch the vector does not match the dimensions of the index
+	ErrDimensionMismatch = errors.New("vector dimensions do not match the index")
+)
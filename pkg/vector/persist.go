@@ -0,0 +1,80 @@
+// Copyright 2023 dvonthenen ChatGPT Proxy contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache License 2.0
+
+package vector
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+
+	klog "k8s.io/klog/v2"
+)
+
+type snapshot struct {
+	Dimensions int     `json:"dimensions"`
+	Items      []*Item `json:"items"`
+}
+
+// Save writes the index to path. The file is replaced atomically.
+func (i *Index) Save(path string) error {
+	i.mu.RLock()
+	snap := snapshot{
+		Dimensions: i.dimensions,
+		Items:      make([]*Item, 0, len(i.items)),
+	}
+	for _, item := range i.items {
+		snap.Items = append(snap.Items, item)
+	}
+	data, err := json.Marshal(snap)
+	i.mu.RUnlock()
+	if err != nil {
+		klog.V(1).Infof("json.Marshal failed. Err: %v\n", err)
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), 0700)
+	if err != nil {
+		klog.V(1).Infof("os.MkdirAll failed. Err: %v\n", err)
+		return err
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		klog.V(1).Infof("os.WriteFile failed. Err: %v\n", err)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Load replaces the contents of the index with the file written by Save
+func (i *Index) Load(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var snap snapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		klog.V(1).Infof("json.Unmarshal failed. Err: %v\n", err)
+		return err
+	}
+
+	items := make(map[string]*Item, len(snap.Items))
+	for _, item := range snap.Items {
+		if len(item.Vector) != snap.Dimensions {
+			klog.V(1).Infof("item %s has %d dimensions, index has %d\n", item.ID, len(item.Vector), snap.Dimensions)
+			return ErrDimensionMismatch
+		}
+		item.norm = norm(item.Vector)
+		items[item.ID] = item
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.dimensions = snap.Dimensions
+	i.items = items
+
+	return nil
+}
@@ -0,0 +1,45 @@
+// Copyright 2023 dvonthenen ChatGPT Proxy contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache License 2.0
+
+package vector
+
+import (
+	"sync"
+)
+
+// Item is a vector stored in the index
+type Item struct {
+	ID       string            `json:"id"`
+	Vector   []float32         `json:"vector"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+
+	norm float32
+}
+
+// Match is a search result
+type Match struct {
+	ID       string            `json:"id"`
+	Score    float32           `json:"score"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// SearchOptions narrows a search
+type SearchOptions struct {
+	// K is the maximum number of matches returned
+	K int
+
+	// MinScore drops matches with a lower cosine similarity
+	MinScore float32
+
+	// Filter only matches items whose metadata contains every key/value
+	Filter map[string]string
+}
+
+// Index is an in-memory vector index using exact cosine similarity
+type Index struct {
+	dimensions int
+	items      map[string]*Item
+
+	// housekeeping
+	mu sync.RWMutex
+}
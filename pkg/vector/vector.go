@@ -0,0 +1,198 @@
+// Copyright 2023 dvonthenen ChatGPT Proxy contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache License 2.0
+
+package vector
+
+import (
+	"math"
+	"sort"
+
+	klog "k8s.io/klog/v2"
+)
+
+// New creates an empty index. The dimensions are set by the first vector added.
+func New() *Index {
+	return &Index{
+		items: make(map[string]*Item),
+	}
+}
+
+// Add inserts or replaces a vector
+func (i *Index) Add(id string, vector []float32, metadata map[string]string) error {
+	if len(id) == 0 || len(vector) == 0 {
+		klog.V(1).Infof("id or vector is empty\n")
+		return ErrInvalidInput
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if i.dimensions == 0 {
+		i.dimensions = len(vector)
+	}
+	if len(vector) != i.dimensions {
+		klog.V(1).Infof("vector has %d dimensions, index has %d\n", len(vector), i.dimensions)
+		return ErrDimensionMismatch
+	}
+
+	i.items[id] = &Item{
+		ID:       id,
+		Vector:   vector,
+		Metadata: metadata,
+		norm:     norm(vector),
+	}
+
+	return nil
+}
+
+// Get returns a copy of the item
+func (i *Index) Get(id string) (*Item, bool) {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	item, ok := i.items[id]
+	if !ok {
+		return nil, false
+	}
+
+	copied := *item
+	return &copied, true
+}
+
+// Delete removes a vector. Returns false if it was not found.
+func (i *Index) Delete(id string) bool {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if _, ok := i.items[id]; !ok {
+		return false
+	}
+	delete(i.items, id)
+
+	return true
+}
+
+// DeleteMatching removes every vector whose metadata contains filter and
+// returns the number removed
+func (i *Index) DeleteMatching(filter map[string]string) int {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	removed := 0
+	for id, item := range i.items {
+		if hasMetadata(item.Metadata, filter) {
+			delete(i.items, id)
+			removed++
+		}
+	}
+
+	return removed
+}
+
+// Len returns the number of vectors in the index
+func (i *Index) Len() int {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	return len(i.items)
+}
+
+// Dimensions returns the vector size of the index or 0 if it is empty
+func (i *Index) Dimensions() int {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	return i.dimensions
+}
+
+// Search returns the most similar vectors, best first
+func (i *Index) Search(vector []float32, options SearchOptions) ([]Match, error) {
+	if len(vector) == 0 {
+		klog.V(1).Infof("vector is empty\n")
+		return nil, ErrInvalidInput
+	}
+	if options.K <= 0 {
+		options.K = DefaultK
+	}
+
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	if i.dimensions == 0 {
+		return []Match{}, nil
+	}
+	if len(vector) != i.dimensions {
+		klog.V(1).Infof("vector has %d dimensions, index has %d\n", len(vector), i.dimensions)
+		return nil, ErrDimensionMismatch
+	}
+
+	queryNorm := norm(vector)
+
+	matches := make([]Match, 0)
+	for _, item := range i.items {
+		if !hasMetadata(item.Metadata, options.Filter) {
+			continue
+		}
+
+		score := cosine(vector, queryNorm, item.Vector, item.norm)
+		if score < options.MinScore {
+			continue
+		}
+
+		matches = append(matches, Match{
+			ID:       item.ID,
+			Score:    score,
+			Metadata: item.Metadata,
+		})
+	}
+
+	sort.Slice(matches, func(a, b int) bool {
+		if matches[a].Score == matches[b].Score {
+			return matches[a].ID < matches[b].ID
+		}
+		return matches[a].Score > matches[b].Score
+	})
+	if len(matches) > options.K {
+		matches = matches[:options.K]
+	}
+
+	return matches, nil
+}
+
+// Cosine returns the cosine similarity of two vectors of the same size
+func Cosine(a, b []float32) float32 {
+	if len(a) != len(b) {
+		return 0
+	}
+	return cosine(a, norm(a), b, norm(b))
+}
+
+func cosine(a []float32, normA float32, b []float32, normB float32) float32 {
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+
+	var dot float32
+	for n := range a {
+		dot += a[n] * b[n]
+	}
+
+	return dot / (normA * normB)
+}
+
+func norm(v []float32) float32 {
+	var sum float64
+	for _, x := range v {
+		sum += float64(x) * float64(x)
+	}
+	return float32(math.Sqrt(sum))
+}
+
+func hasMetadata(metadata, filter map[string]string) bool {
+	for key, value := range filter {
+		if metadata[key] != value {
+			return false
+		}
+	}
+	return true
+}